@@ -21,10 +21,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/api/version"
 	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/engine/aclapi"
+	"github.com/tsuru/acl-api/engine/netpol"
 	"github.com/tsuru/acl-api/engine/operator"
+	_ "github.com/tsuru/acl-api/storage/memory"
 	_ "github.com/tsuru/acl-api/storage/mongodb"
+	_ "github.com/tsuru/acl-api/storage/postgres"
+	"github.com/tsuru/acl-api/webhook"
 )
 
 func handleSignals(fn func()) {
@@ -59,7 +65,9 @@ type PluginManifestMetadata struct {
 func setupEcho() *echo.Echo {
 	e := echo.New()
 	e.Use(middleware.Logger())
+	e.Use(middleware.RequestID())
 
+	e.Use(tokenAuthMiddleware)
 	e.Use(middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
 		Skipper: func(c echo.Context) bool {
 			if skip, _ := c.Get("skip-basic-auth").(bool); skip {
@@ -144,17 +152,68 @@ var allEngines = []func() engine.Engine{
 	func() engine.Engine {
 		return &operator.ACLOperatorEngine{}
 	},
+	func() engine.Engine {
+		return &netpol.NetworkPolicyEngine{}
+	},
+	func() engine.Engine {
+		return &aclapi.ACLAPIEngine{}
+	},
 }
 
-func setupEngine() {
+// configuredEngines returns the factories of the engines in the engines
+// config, engines whose name ends with engine.DryRunSuffix only render the
+// rules.
+func configuredEngines() []func() engine.Engine {
+	var factories []func() engine.Engine
 	enabledEngines := viper.GetStringSlice("engines")
 	for _, engineName := range enabledEngines {
-		for _, e := range allEngines {
-			if e().Name() == engineName {
-				engine.EnableEngine(e)
-			}
+		dryRun := strings.HasSuffix(engineName, engine.DryRunSuffix)
+		e := findEngine(strings.TrimSuffix(engineName, engine.DryRunSuffix))
+		switch {
+		case e == nil:
+		case dryRun:
+			factories = append(factories, func() engine.Engine {
+				return engine.DryRun(e())
+			})
+		default:
+			factories = append(factories, e)
 		}
 	}
+	return factories
+}
+
+// findEngine returns the factory of the engine with the given name, nil
+// when there is none.
+func findEngine(name string) func() engine.Engine {
+	for _, e := range allEngines {
+		if e().Name() == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func setupEngine() {
+	for _, e := range configuredEngines() {
+		engine.EnableEngine(e)
+	}
+}
+
+// ConfiguredEngines returns an instance of each engine in the engines config
+// without enabling them, for commands that only inspect their syncs.
+func ConfiguredEngines() []engine.Engine {
+	var engines []engine.Engine
+	for _, e := range configuredEngines() {
+		engines = append(engines, e())
+	}
+	return engines
+}
+
+// SyncRules syncs rules in the configured engines and waits for the syncs,
+// for commands changing rules without the API.
+func SyncRules(rules []types.Rule) {
+	setupEngine()
+	engine.SyncRules(rules, false)
 }
 
 func StartAPI() error {
@@ -165,9 +224,22 @@ func StartAPI() error {
 
 	setupEngine()
 
+	// the reconciliation runs in the worker command, running it in every
+	// API replica as well only repeats it
+	var w *worker
+	if viper.GetBool("api.run_worker") {
+		w = newWorker()
+		go w.run()
+	}
+
 	e := setupEcho()
+	stopped := make(chan struct{})
 	go handleSignals(func() {
+		defer close(stopped)
 		shutdownEcho(e)
+		if w != nil {
+			w.stop()
+		}
 	})
 
 	err := e.Start(fmt.Sprintf(":%d", viper.GetInt("port")))
@@ -175,6 +247,10 @@ func StartAPI() error {
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
+	// Start returns as soon as the shutdown begins, the in-flight requests
+	// and reconciliation must finish before the process exits
+	<-stopped
+	webhook.Wait()
 	return nil
 }
 
@@ -186,30 +262,47 @@ func configHandlers(e *echo.Echo) {
 	e.POST("/rules", addRule)
 	e.GET("/rules/:id/sync", getRuleSync)
 	e.GET("/rules/:id", getRule)
+	e.PUT("/rules/:id", updateRule)
+	e.PATCH("/rules/:id", updateRule)
+	e.GET("/rules/:id/history", getRuleHistory)
+	e.GET("/rules/:id/resolved", getRuleResolved)
+	e.GET("/rules/:id/preview", getRulePreview)
 	e.DELETE("/rules/:id", deleteRule)
 	e.GET("/rules/sync", latestSync)
+	e.GET("/sync-jobs/:id", getSyncJob)
+	e.GET("/drift", driftedSyncs)
+	e.GET("/rules/watch", watchRules)
+	e.GET("/rules/export", exportRules)
+	e.POST("/rules/import", importRules, requireAdmin)
+	e.POST("/apply", applyInstances, requireAdmin)
 	e.GET("/services", listServices)
-	e.POST("/resources", serviceCreate)
+	e.POST("/resources", serviceCreate, requireAdmin)
 	e.GET("/resources/plans", servicePlans)
 	e.GET("/resources/:instance", serviceInfo)
-	e.PUT("/resources/:instance", serviceUpdate)
-	e.DELETE("/resources/:instance", serviceDelete)
+	e.PUT("/resources/:instance", serviceUpdate, requireAdmin)
+	e.DELETE("/resources/:instance", serviceDelete, requireAdmin)
 	e.GET("/resources/:instance/status", serviceStatus)
-	e.POST("/resources/:instance/bind-app", serviceBindApp)
-	e.DELETE("/resources/:instance/bind-app", serviceUnbindApp)
-	e.PUT("/resources/:instance/binds/jobs/:job", serviceBindJob)
-	e.DELETE("/resources/:instance/binds/jobs/:job", serviceUnbindJob)
-	e.POST("/resources/:instance/bind", serviceBindUnit)
-	e.DELETE("/resources/:instance/bind", serviceUnbindUnit)
+	e.POST("/resources/:instance/bind-app", serviceBindApp, requireAdmin)
+	e.DELETE("/resources/:instance/bind-app", serviceUnbindApp, requireAdmin)
+	e.PUT("/resources/:instance/binds/jobs/:job", serviceBindJob, requireAdmin)
+	e.DELETE("/resources/:instance/binds/jobs/:job", serviceUnbindJob, requireAdmin)
+	e.POST("/resources/:instance/bind", serviceBindUnit, requireAdmin)
+	e.DELETE("/resources/:instance/bind", serviceUnbindUnit, requireAdmin)
 	e.GET("/resources/:instance/rule", serviceListRules)
-	e.POST("/resources/:instance/rule", serviceAddRule)
-	e.POST("/resources/:instance/sync", serviceForceSyncRule)
-	e.DELETE("/resources/:instance/rule/:rule", serviceRemoveRule)
+	e.POST("/resources/:instance/rule", serviceAddRule, requireAdmin)
+	e.POST("/resources/:instance/sync", serviceForceSyncRule, requireAdmin)
+	e.PUT("/resources/:instance/rule/:rule", serviceUpdateRule, requireAdmin)
+	e.DELETE("/resources/:instance/rule/:rule", serviceRemoveRule, requireAdmin)
 
 	e.GET("/apps/:app/rules", appRules)
 	e.POST("/apps/:app/sync", appForceSyncRule)
+	e.GET("/apps/:app/check", appCheck)
 
 	e.GET("/jobs/:job/rules", jobRules)
+	e.GET("/jobs/:job/check", jobCheck)
+
+	e.GET("/audit", listAudit, requireAdmin)
+	e.GET("/webhooks/deliveries", listWebhookDeliveries, requireAdmin)
 
 	e.GET("/healthcheck", healthcheck)
 }
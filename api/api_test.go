@@ -100,3 +100,13 @@ func TestAuthentication(t *testing.T) {
 		})
 	}
 }
+
+func Test_configuredEngines(t *testing.T) {
+	defer resetViper()
+	viper.Set("engines", []string{"network-policy", "aclapi:dry-run", "unknown", "unknown:dry-run"})
+	var names []string
+	for _, e := range ConfiguredEngines() {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"network-policy", "aclapi:dry-run"}, names)
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/apply"
+	"github.com/tsuru/acl-api/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+// applyInstances reconciles the service instances with the desired state in
+// the JSON or YAML body, dry-run=true only returns the plan, prune=true
+// deletes the instances managed by apply and missing from the body and
+// adopt=true takes over the existing instances not managed by apply.
+func applyInstances(c echo.Context) error {
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	var doc types.ApplyDocument
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid document: "+err.Error())
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry-run"))
+	prune, _ := strconv.ParseBool(c.QueryParam("prune"))
+	adopt, _ := strconv.ParseBool(c.QueryParam("adopt"))
+	plan, rules, err := apply.Apply(doc, apply.Options{DryRun: dryRun, Prune: prune, Adopt: adopt, User: requestActor(c)})
+	if !dryRun {
+		recordApply(c, plan)
+	}
+	if len(rules) > 0 {
+		job, _, syncErr := startSyncJob(c, rules, false)
+		if err == nil {
+			err = syncErr
+		}
+		plan.SyncJob = job.JobID
+	}
+	if _, ok := err.(*apply.ValidationError); ok {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	return writeDocument(c, http.StatusOK, plan)
+}
+
+// recordApply records the audit entries and sends the webhook events of the
+// changes made by an apply.
+func recordApply(c echo.Context, plan types.ApplyPlan) {
+	recordApplyChanges(requestActor(c), requestRecorder(c), plan)
+}
+
+// RecordApply is like recordApply for an apply made from the command line.
+func RecordApply(actor string, plan types.ApplyPlan) {
+	recordApplyChanges(actor, systemRecorder(actor), plan)
+}
+
+func recordApplyChanges(actor string, record auditRecorder, plan types.ApplyPlan) {
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case types.ApplyCreateInstance:
+			record(types.AuditEntry{Action: types.AuditServiceCreate, Instance: change.Instance}, nil, nil)
+		case types.ApplyDeleteInstance:
+			record(types.AuditEntry{Action: types.AuditServiceDelete, Instance: change.Instance}, nil, nil)
+		case types.ApplyAddRule:
+			record(types.AuditEntry{Action: types.AuditServiceRuleAdd, Instance: change.Instance, RuleID: change.RuleID}, nil, change.Rule)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleCreated, Actor: actor, Instance: change.Instance, Rule: change.Rule})
+		case types.ApplyRemoveRule:
+			record(types.AuditEntry{Action: types.AuditServiceRuleRemove, Instance: change.Instance, RuleID: change.RuleID}, change.Rule, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleRemoved, Actor: actor, Instance: change.Instance, Rule: change.Rule})
+		case types.ApplyUpdateRule:
+			record(types.AuditEntry{Action: types.AuditServiceRuleUpdate, Instance: change.Instance, RuleID: change.RuleID}, nil, change.Rule)
+		case types.ApplyBindApp:
+			record(types.AuditEntry{Action: types.AuditServiceBindApp, Instance: change.Instance, Target: change.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: actor, Instance: change.Instance, App: change.Target})
+		case types.ApplyUnbindApp:
+			record(types.AuditEntry{Action: types.AuditServiceUnbindApp, Instance: change.Instance, Target: change.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceUnbound, Actor: actor, Instance: change.Instance, App: change.Target})
+		case types.ApplyBindJob:
+			record(types.AuditEntry{Action: types.AuditServiceBindJob, Instance: change.Instance, Target: change.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: actor, Instance: change.Instance, Job: change.Target})
+		case types.ApplyUnbindJob:
+			record(types.AuditEntry{Action: types.AuditServiceUnbindJob, Instance: change.Instance, Target: change.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceUnbound, Actor: actor, Instance: change.Instance, Job: change.Target})
+		}
+	}
+}
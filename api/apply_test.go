@@ -0,0 +1,97 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/apply"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+)
+
+func Test_applyInstances(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	defer resetViper()
+	viper.Set("auth.user", "admin")
+	viper.Set("auth.password", "secret")
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	do := func(path, body string) *http.Response {
+		req, err := http.NewRequest("POST", srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.SetBasicAuth("admin", "secret")
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+	decodePlan := func(rsp *http.Response) types.ApplyPlan {
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var plan types.ApplyPlan
+		err := json.NewDecoder(rsp.Body).Decode(&plan)
+		require.Nil(t, err)
+		return plan
+	}
+
+	desired := `
+ServiceInstances:
+- InstanceName: inst1
+  BindApps: [app1]
+  BaseRules:
+  - Destination:
+      ExternalIP:
+        IP: 10.0.0.1
+        Ports:
+        - {Protocol: TCP, Port: 443}
+`
+	plan := decodePlan(do("/apply?dry-run=true", desired))
+	assert.True(t, plan.DryRun)
+	require.Len(t, plan.Changes, 3)
+	assert.Equal(t, types.ApplyCreateInstance, plan.Changes[0].Action)
+	assert.Equal(t, types.ApplyAddRule, plan.Changes[1].Action)
+	assert.Equal(t, types.ApplyBindApp, plan.Changes[2].Action)
+	_, err = service.GetService().Find("inst1")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+
+	plan = decodePlan(do("/apply", desired))
+	assert.False(t, plan.DryRun)
+	assert.Len(t, plan.Changes, 3)
+	assert.NotEmpty(t, plan.SyncJob)
+	instance, err := service.GetService().Find("inst1")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"app1"}, instance.BindApps)
+	assert.Equal(t, apply.ManagedByApply, instance.Metadata[apply.ManagedByKey])
+	assert.Equal(t, "admin", instance.Creator)
+
+	plan = decodePlan(do("/apply", desired))
+	assert.Empty(t, plan.Changes)
+	assert.Empty(t, plan.SyncJob)
+
+	plan = decodePlan(do("/apply?prune=true", `{"ServiceInstances": []}`))
+	require.Len(t, plan.Changes, 1)
+	assert.Equal(t, types.ApplyChange{Action: types.ApplyDeleteInstance, Instance: "inst1"}, plan.Changes[0])
+	_, err = service.GetService().Find("inst1")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+
+	rsp := do("/apply", `{"ServiceInstances": [{"InstanceName": "inst1", "BindApps": [""]}]}`)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
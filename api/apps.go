@@ -6,14 +6,24 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo"
-	"github.com/tsuru/acl-api/engine"
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
 	"github.com/tsuru/acl-api/rule"
 )
 
 func appForceSyncRule(c echo.Context) error {
 	app := c.Param("app")
+	err := newSourceAuthorizer(c).check(types.RuleType{
+		TsuruApp: &types.TsuruAppRule{AppName: app},
+	})
+	if err != nil {
+		return err
+	}
 	rulesSvc := rule.GetService()
 
 	rules, err := rulesSvc.FindBySourceTsuruApp(app)
@@ -22,9 +32,13 @@ func appForceSyncRule(c echo.Context) error {
 		return err
 	}
 
-	engine.SyncRules(rules, true)
+	job, _, err := startSyncJob(c, rules, true)
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditAppSync, Target: app}, nil, nil)
 
-	return c.JSON(http.StatusOK, map[string]int{"count": len(rules)})
+	return c.JSON(http.StatusAccepted, job)
 }
 
 func appRules(c echo.Context) error {
@@ -39,3 +53,44 @@ func appRules(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, rules)
 }
+
+func appCheck(c echo.Context) error {
+	target, err := checkTarget(c)
+	if err != nil {
+		return err
+	}
+	rulesSvc := rule.GetService()
+	result, err := rulesSvc.CheckApp(c.Param("app"), target)
+	if err != nil {
+		return checkError(err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func checkTarget(c echo.Context) (types.CheckTarget, error) {
+	target := types.CheckTarget{
+		Host:     strings.TrimSpace(c.QueryParam("host")),
+		Protocol: strings.ToLower(c.QueryParam("protocol")),
+	}
+	if target.Host == "" {
+		return target, echo.NewHTTPError(http.StatusBadRequest, "host is required")
+	}
+	if target.Protocol != "" && target.Protocol != "tcp" && target.Protocol != "udp" {
+		return target, echo.NewHTTPError(http.StatusBadRequest, "protocol must be tcp or udp")
+	}
+	if port := c.QueryParam("port"); port != "" {
+		value, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || value == 0 {
+			return target, echo.NewHTTPError(http.StatusBadRequest, "invalid port "+port)
+		}
+		target.Port = uint16(value)
+	}
+	return target, nil
+}
+
+func checkError(err error) error {
+	if httpErr, ok := errors.Cause(err).(*external.HTTPError); ok && httpErr.StatusCode == http.StatusNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	return err
+}
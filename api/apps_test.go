@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/acl-api/api/types"
@@ -99,3 +100,104 @@ func Test_getAppsRules(t *testing.T) {
 		require.Len(t, result, 0)
 	})
 }
+
+func Test_appCheck(t *testing.T) {
+	tsuruSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app1":
+			w.Write([]byte(`{"name": "app1", "pool": "p1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer tsuruSrv.Close()
+	defer viper.Set("tsuru.host", viper.Get("tsuru.host"))
+	viper.Set("tsuru.host", tsuruSrv.URL)
+
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	svc := rule.GetService()
+	err = svc.Save([]*types.Rule{
+		{
+			RuleID: "1",
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{
+					AppName: "app1",
+				},
+			},
+			Destination: types.RuleType{
+				ExternalDNS: &types.ExternalDNSRule{
+					Name:  ".example.com",
+					Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+				},
+			},
+		},
+	}, false)
+	require.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		allowed bool
+		reason  string
+	}{
+		{
+			name:    "allowed",
+			path:    "/apps/app1/check?host=api.example.com&port=443&protocol=tcp",
+			status:  http.StatusOK,
+			allowed: true,
+		},
+		{
+			name:   "port not allowed",
+			path:   "/apps/app1/check?host=api.example.com&port=80",
+			status: http.StatusOK,
+			reason: `1 rule(s) allow host "api.example.com", but none of them allow tcp:80`,
+		},
+		{
+			name:   "missing host",
+			path:   "/apps/app1/check?port=80",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid port",
+			path:   "/apps/app1/check?host=api.example.com&port=70000",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid protocol",
+			path:   "/apps/app1/check?host=api.example.com&protocol=icmp",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "app not found",
+			path:   "/apps/app2/check?host=api.example.com",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := setupEcho()
+			srv := httptest.NewServer(e.Server.Handler)
+			defer srv.Close()
+
+			rsp, err := http.Get(srv.URL + tt.path)
+			require.Nil(t, err)
+			defer rsp.Body.Close()
+
+			require.Equal(t, tt.status, rsp.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var result types.CheckResult
+			err = json.NewDecoder(rsp.Body).Decode(&result)
+			require.Nil(t, err)
+			assert.Equal(t, tt.allowed, result.Allowed)
+			assert.Equal(t, tt.reason, result.Reason)
+		})
+	}
+}
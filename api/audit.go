@@ -0,0 +1,154 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/audit"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const defaultAuditLimit = 100
+
+func listAudit(c echo.Context) error {
+	opts := storage.AuditFindOpts{
+		Actor:    c.QueryParam("actor"),
+		RuleID:   c.QueryParam("rule"),
+		Instance: c.QueryParam("instance"),
+		Limit:    defaultAuditLimit,
+	}
+	var err error
+	opts.Since, err = timeParam(c, "since")
+	if err != nil {
+		return err
+	}
+	opts.Until, err = timeParam(c, "until")
+	if err != nil {
+		return err
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		opts.Limit, err = strconv.Atoi(raw)
+		if err != nil || opts.Limit <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+	entries, err := audit.GetService().Find(opts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+func timeParam(c echo.Context, param string) (time.Time, error) {
+	raw := c.QueryParam(param)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be a RFC 3339 time", param))
+	}
+	return t, nil
+}
+
+// requestActor returns who is responsible for the request: the tsuru user
+// authenticated by a token, the tsuru user on whose behalf tsuru called the
+// service API or the basic auth user.
+func requestActor(c echo.Context) string {
+	if user := requestTsuruUser(c); user != nil {
+		return user.Email
+	}
+	if user := c.Request().Header.Get("X-Tsuru-User"); user != "" {
+		return user
+	}
+	if user := c.Get("user"); user != nil {
+		return fmt.Sprint(user)
+	}
+	return ""
+}
+
+// recordAudit adds the request details to entry and stores it, failures are
+// only logged as the mutation was already applied.
+func recordAudit(c echo.Context, entry types.AuditEntry, before, after interface{}) {
+	entry.Actor = requestActor(c)
+	entry.EventID = c.Request().Header.Get("X-Tsuru-Eventid")
+	if entry.EventID == "" {
+		entry.EventID = c.FormValue("eventid")
+	}
+	entry.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	storeAudit(entry, before, after)
+}
+
+// WorkerActor is the actor of the changes made by the worker.
+const WorkerActor = "acl-api-worker"
+
+// recordSystemAudit is like recordAudit for changes made without a request,
+// by the worker or the command line.
+func recordSystemAudit(actor string, entry types.AuditEntry, before, after interface{}) {
+	entry.Actor = actor
+	storeAudit(entry, before, after)
+}
+
+func storeAudit(entry types.AuditEntry, before, after interface{}) {
+	err := audit.GetService().Record(entry, before, after)
+	if err != nil {
+		logrus.WithError(err).WithField("action", entry.Action).Error("unable to record audit entry")
+	}
+}
+
+// auditRecorder records an audit entry on behalf of an actor, either from a
+// request or from the worker and the command line.
+type auditRecorder func(entry types.AuditEntry, before, after interface{})
+
+func requestRecorder(c echo.Context) auditRecorder {
+	return func(entry types.AuditEntry, before, after interface{}) {
+		recordAudit(c, entry, before, after)
+	}
+}
+
+func systemRecorder(actor string) auditRecorder {
+	return func(entry types.AuditEntry, before, after interface{}) {
+		recordSystemAudit(actor, entry, before, after)
+	}
+}
+
+// RecordPurge records the rules purged by the garbage collection.
+func RecordPurge(actor string, purged []types.Rule) {
+	for i := range purged {
+		recordSystemAudit(actor, types.AuditEntry{Action: types.AuditRulePurge, RuleID: purged[i].RuleID}, purged[i], nil)
+	}
+}
+
+// instanceSnapshot returns the stored instance to be recorded in the audit
+// log, it is nil when the instance cannot be found.
+func instanceSnapshot(instanceName string) *types.ServiceInstance {
+	instance, err := service.GetService().Find(instanceName)
+	if err != nil {
+		return nil
+	}
+	return &instance
+}
+
+// baseRuleSnapshot is like instanceSnapshot for a single base rule.
+func baseRuleSnapshot(instanceName, ruleID string) *types.ServiceRule {
+	instance := instanceSnapshot(instanceName)
+	if instance == nil {
+		return nil
+	}
+	for _, r := range instance.BaseRules {
+		if r.RuleID == ruleID {
+			return &r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+)
+
+func Test_audit(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	defer resetViper()
+	viper.Set("auth.user", "admin")
+	viper.Set("auth.password", "secret")
+
+	oldGetService := service.GetService
+	defer func() { service.GetService = oldGetService }()
+	mock := &serviceMock{instance: types.ServiceInstance{InstanceName: "inst1", BindApps: []string{"myapp"}}}
+	service.GetService = func() service.Service {
+		return mock
+	}
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	do := func(method, path, contentType, body string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.SetBasicAuth("admin", "secret")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	rsp := do("POST", "/rules", "application/json", `{
+		"source": {"tsuruapp": {"appname": "myapp"}},
+		"destination": {"externalip": {"ip": "10.0.0.1"}}
+	}`, map[string]string{"X-Request-ID": "req-1"})
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusCreated, rsp.StatusCode)
+	var r types.Rule
+	err = json.NewDecoder(rsp.Body).Decode(&r)
+	require.Nil(t, err)
+
+	rsp = do("DELETE", "/rules/"+r.RuleID, "", "", nil)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	deleteRequestID := rsp.Header.Get("X-Request-ID")
+	assert.NotEmpty(t, deleteRequestID)
+
+	rsp = do("POST", "/resources/inst1/bind-app", "application/x-www-form-urlencoded", "app-name=myapp", map[string]string{
+		"X-Tsuru-User":    "me@example.com",
+		"X-Tsuru-Eventid": "ev1",
+	})
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	find := func(query string) []types.AuditEntry {
+		rsp := do("GET", "/audit"+query, "", "", nil)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var entries []types.AuditEntry
+		err := json.NewDecoder(rsp.Body).Decode(&entries)
+		require.Nil(t, err)
+		return entries
+	}
+
+	entries := find("")
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, types.AuditServiceBindApp, entries[0].Action)
+	assert.Equal(t, "me@example.com", entries[0].Actor)
+	assert.Equal(t, "ev1", entries[0].EventID)
+	assert.Equal(t, "inst1", entries[0].Instance)
+	assert.Equal(t, "myapp", entries[0].Target)
+	assert.NotEmpty(t, entries[0].Before)
+	assert.NotEmpty(t, entries[0].After)
+
+	assert.Equal(t, types.AuditRuleDelete, entries[1].Action)
+	assert.Equal(t, "admin", entries[1].Actor)
+	assert.Equal(t, r.RuleID, entries[1].RuleID)
+	assert.Equal(t, deleteRequestID, entries[1].RequestID)
+	var before types.Rule
+	err = json.Unmarshal(entries[1].Before, &before)
+	require.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", before.Destination.ExternalIP.IP)
+	assert.Empty(t, entries[1].After)
+
+	assert.Equal(t, types.AuditRuleCreate, entries[2].Action)
+	assert.Equal(t, "req-1", entries[2].RequestID)
+	assert.Empty(t, entries[2].Before)
+	assert.NotEmpty(t, entries[2].After)
+
+	assert.Len(t, find("?rule="+r.RuleID), 2)
+	assert.Len(t, find("?actor=me@example.com"), 1)
+	assert.Len(t, find("?instance=inst1"), 1)
+	assert.Len(t, find("?limit=1"), 1)
+	assert.Len(t, find("?since="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))), 0)
+	assert.Len(t, find("?until="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))), 3)
+
+	for _, query := range []string{"?since=yesterday", "?until=1", "?limit=0", "?limit=x"} {
+		rsp = do("GET", "/audit"+query, "", "", nil)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode, query)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/external"
+)
+
+// tsuruUserKey is the context key holding the *external.TsuruUser
+// authenticated by a token.
+const tsuruUserKey = "tsuru-user"
+
+var errInvalidToken = errors.New("invalid token")
+
+type tokenCacheEntry struct {
+	user    *external.TsuruUser
+	err     error
+	expires time.Time
+}
+
+// tokenCache keeps the result of token validations, indexed by the token
+// hash, for auth.token_cache_ttl.
+type tokenCache struct {
+	sync.Mutex
+	entries map[[sha256.Size]byte]tokenCacheEntry
+}
+
+var tokens = &tokenCache{entries: map[[sha256.Size]byte]tokenCacheEntry{}}
+
+func (tc *tokenCache) validate(token string) (*external.TsuruUser, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+	tc.Lock()
+	entry, ok := tc.entries[key]
+	tc.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.user, entry.err
+	}
+
+	user, err := external.TsuruUserInfo(token)
+	if err != nil {
+		httpErr, ok := errors.Cause(err).(*external.HTTPError)
+		if !ok || (httpErr.StatusCode != http.StatusUnauthorized && httpErr.StatusCode != http.StatusForbidden) {
+			return nil, err
+		}
+		err = errInvalidToken
+	}
+
+	ttl := viper.GetDuration("auth.token_cache_ttl")
+	if ttl > 0 {
+		tc.Lock()
+		for k, e := range tc.entries {
+			if now.After(e.expires) {
+				delete(tc.entries, k)
+			}
+		}
+		tc.entries[key] = tokenCacheEntry{user: user, err: err, expires: now.Add(ttl)}
+		tc.Unlock()
+	}
+	return user, err
+}
+
+func (tc *tokenCache) reset() {
+	tc.Lock()
+	defer tc.Unlock()
+	tc.entries = map[[sha256.Size]byte]tokenCacheEntry{}
+}
+
+// tokenAuthMiddleware authenticates requests carrying a tsuru token in the
+// Authorization header, the user email becomes the request user. Requests
+// without a bearer token are left to basic auth.
+func tokenAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !viper.GetBool("auth.tsuru_token") || shouldSkipAuth(c.Path()) {
+			return next(c)
+		}
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if len(header) < len("bearer ") || !strings.EqualFold(header[:len("bearer ")], "bearer ") {
+			return next(c)
+		}
+		user, err := tokens.validate(strings.TrimSpace(header[len("bearer "):]))
+		if err == errInvalidToken {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to validate token")
+		}
+		c.Set("user", user.Email)
+		c.Set(tsuruUserKey, user)
+		c.Set("skip-basic-auth", true)
+		return next(c)
+	}
+}
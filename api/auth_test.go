@@ -0,0 +1,147 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+// mockTsuruAuth returns a tsuru API where each token in users belongs to the
+// user with the given /users/info response, the apps, jobs and pools know
+// about team1 and team2.
+func mockTsuruAuth(calls *int32, users map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/info":
+			atomic.AddInt32(calls, 1)
+			user, ok := users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(user))
+		case "/apps/myapp1":
+			w.Write([]byte(`{"name": "myapp1", "pool": "p1", "teamowner": "team1"}`))
+		case "/apps/myapp2":
+			w.Write([]byte(`{"name": "myapp2", "pool": "p1", "teamowner": "team2", "teams": ["team2", "team3"]}`))
+		case "/jobs/job1":
+			w.Write([]byte(`{"job": {"name": "job1", "pool": "p1", "teamowner": "team1"}}`))
+		case "/pools/p1":
+			w.Write([]byte(`{"name": "p1", "provisioner": "kubernetes", "allowed": {"team": ["team1", "team2"]}}`))
+		case "/pools/p2":
+			w.Write([]byte(`{"name": "p2", "provisioner": "kubernetes", "public": true, "allowed": {}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func Test_tokenAuth(t *testing.T) {
+	var calls int32
+	tsuruSrv := mockTsuruAuth(&calls, map[string]string{
+		"valid-token": `{"email": "me@example.com", "roles": [{"name": "team-member", "contexttype": "team", "contextvalue": "team1"}]}`,
+	})
+	defer tsuruSrv.Close()
+
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	defer resetViper()
+	viper.Set("tsuru.host", tsuruSrv.URL)
+	viper.Set("auth.user", "admin")
+	viper.Set("auth.password", "secret")
+	viper.Set("auth.tsuru_token", true)
+	viper.Set("auth.token_cache_ttl", time.Minute)
+	tokens.reset()
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	addRule := func(t *testing.T, setAuth func(req *http.Request)) *http.Response {
+		body := strings.NewReader(`{
+			"source": {"tsuruapp": {"appname": "myapp1"}},
+			"destination": {"externaldns": {"name": "a.b.com"}}
+		}`)
+		req, err := http.NewRequest("POST", srv.URL+"/rules", body)
+		require.Nil(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		setAuth(req)
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 2; i++ {
+			rsp := addRule(t, func(req *http.Request) {
+				req.Header.Set("Authorization", "bearer valid-token")
+			})
+			defer rsp.Body.Close()
+			require.Equal(t, http.StatusCreated, rsp.StatusCode)
+			var result types.Rule
+			err := json.NewDecoder(rsp.Body).Decode(&result)
+			require.Nil(t, err)
+			assert.Equal(t, "me@example.com", result.Creator)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 2; i++ {
+			rsp := addRule(t, func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer invalid-token")
+			})
+			rsp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		rsp := addRule(t, func(req *http.Request) {
+			req.SetBasicAuth("admin", "secret")
+		})
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusCreated, rsp.StatusCode)
+		var result types.Rule
+		err := json.NewDecoder(rsp.Body).Decode(&result)
+		require.Nil(t, err)
+		assert.Equal(t, "admin", result.Creator)
+
+		rsp = addRule(t, func(req *http.Request) {})
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		viper.Set("auth.tsuru_token", false)
+		defer viper.Set("auth.tsuru_token", true)
+		rsp := addRule(t, func(req *http.Request) {
+			req.Header.Set("Authorization", "bearer valid-token")
+		})
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+)
+
+// requestTsuruUser returns the tsuru user authenticated by a token, it is nil
+// for requests using basic auth. Basic auth credentials are shared with tsuru
+// itself and are never restricted.
+func requestTsuruUser(c echo.Context) *external.TsuruUser {
+	user, _ := c.Get(tsuruUserKey).(*external.TsuruUser)
+	return user
+}
+
+// isAdmin reports whether the user holds the tsuru root permission or is a
+// member of one of the teams in auth.admin_teams.
+func isAdmin(user *external.TsuruUser) bool {
+	for _, p := range user.Permissions {
+		if (p.Name == "" || p.Name == "*") && p.ContextType == "global" {
+			return true
+		}
+	}
+	teams := userTeams(user)
+	for _, team := range viper.GetStringSlice("auth.admin_teams") {
+		if _, ok := teams[team]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func userTeams(user *external.TsuruUser) map[string]struct{} {
+	teams := map[string]struct{}{}
+	for _, r := range user.Roles {
+		if r.ContextType == "team" {
+			teams[r.ContextValue] = struct{}{}
+		}
+	}
+	for _, p := range user.Permissions {
+		if p.ContextType == "team" {
+			teams[p.ContextValue] = struct{}{}
+		}
+	}
+	return teams
+}
+
+// requireAdmin restricts a route to basic auth credentials and tsuru admins.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := requestTsuruUser(c)
+		if user != nil && !isAdmin(user) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("user %q must be an admin to access %s", user.Email, c.Path()))
+		}
+		return next(c)
+	}
+}
+
+// sourceAuthorizer decides whether the request user may manage rules from a
+// given source, a single authorizer should be used for all the checks in a
+// request so tsuru is queried once per app, job or pool.
+type sourceAuthorizer struct {
+	user  *external.TsuruUser
+	admin bool
+	teams map[string]struct{}
+	cli   external.TsuruClient
+}
+
+func newSourceAuthorizer(c echo.Context) *sourceAuthorizer {
+	a := &sourceAuthorizer{user: requestTsuruUser(c)}
+	if a.user != nil {
+		a.admin = isAdmin(a.user)
+		a.teams = userTeams(a.user)
+		a.cli = external.NewTsuruClient()
+	}
+	return a
+}
+
+// check returns a 403 error when the user is not a member of any of the teams
+// owning source.
+func (a *sourceAuthorizer) check(source types.RuleType) error {
+	if a.user == nil || a.admin {
+		return nil
+	}
+	teams, desc, err := a.sourceTeams(source)
+	if err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if _, ok := a.teams[team]; ok {
+			return nil
+		}
+	}
+	return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("user %q is not a member of any team owning %s", a.user.Email, desc))
+}
+
+// allowed is like check but reports authorization errors as false.
+func (a *sourceAuthorizer) allowed(source types.RuleType) (bool, error) {
+	err := a.check(source)
+	if httpErr, ok := err.(*echo.HTTPError); ok && httpErr.Code == http.StatusForbidden {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// sourceTeams returns the teams owning source and its description, sources
+// that no longer exist in tsuru have no owners and may only be managed by
+// admins.
+func (a *sourceAuthorizer) sourceTeams(source types.RuleType) ([]string, string, error) {
+	var (
+		teams []string
+		desc  string
+		err   error
+	)
+	switch {
+	case source.TsuruApp != nil && source.TsuruApp.AppName != "":
+		desc = fmt.Sprintf("app %q", source.TsuruApp.AppName)
+		app, appErr := a.cli.AppInfo(source.TsuruApp.AppName)
+		if appErr == nil {
+			teams = append([]string{app.TeamOwner}, app.Teams...)
+		}
+		err = appErr
+	case source.TsuruApp != nil && source.TsuruApp.PoolName != "":
+		desc = fmt.Sprintf("pool %q", source.TsuruApp.PoolName)
+		teams, err = a.cli.PoolTeams(source.TsuruApp.PoolName)
+	case source.TsuruJob != nil:
+		desc = fmt.Sprintf("job %q", source.TsuruJob.JobName)
+		job, jobErr := a.cli.JobInfo(source.TsuruJob.JobName)
+		if jobErr == nil {
+			teams = append([]string{job.TeamOwner}, job.Teams...)
+		}
+		err = jobErr
+	default:
+		return nil, "the rule source", nil
+	}
+	if httpErr, ok := errors.Cause(err).(*external.HTTPError); ok && httpErr.StatusCode == http.StatusNotFound {
+		return nil, desc, nil
+	}
+	if err != nil {
+		return nil, desc, err
+	}
+	return teams, desc, nil
+}
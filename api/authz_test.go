@@ -0,0 +1,225 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+func Test_ruleAuthorization(t *testing.T) {
+	var calls int32
+	tsuruSrv := mockTsuruAuth(&calls, map[string]string{
+		"team1-token": `{"email": "team1@example.com", "roles": [{"name": "team-member", "contexttype": "team", "contextvalue": "team1"}]}`,
+		"team3-token": `{"email": "team3@example.com", "permissions": [{"name": "app", "contexttype": "team", "contextvalue": "team3"}]}`,
+		"admin-token": `{"email": "admin@example.com", "permissions": [{"name": "", "contexttype": "global"}]}`,
+		"ops-token":   `{"email": "ops@example.com", "roles": [{"name": "team-member", "contexttype": "team", "contextvalue": "ops"}]}`,
+	})
+	defer tsuruSrv.Close()
+
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	defer resetViper()
+	viper.Set("tsuru.host", tsuruSrv.URL)
+	viper.Set("auth.tsuru_token", true)
+	viper.Set("auth.token_cache_ttl", time.Minute)
+	viper.Set("auth.admin_teams", []string{"ops"})
+	tokens.reset()
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	do := func(t *testing.T, method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "bearer "+token)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	saveRules := func(t *testing.T) {
+		clearer.ClearAll()
+		err := rule.GetService().Save([]*types.Rule{
+			{
+				RuleID:      "r1",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "myapp1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			},
+			{
+				RuleID:      "r2",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "myapp2"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			},
+			{
+				RuleID:      "r3",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			},
+			{
+				RuleID:      "r4",
+				Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			},
+			{
+				RuleID:      "r5",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "deleted-app"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			},
+		}, false)
+		require.Nil(t, err)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		clearer.ClearAll()
+		tests := []struct {
+			token  string
+			source string
+			status int
+		}{
+			{token: "team1-token", source: `{"tsuruapp": {"appname": "myapp1"}}`, status: http.StatusCreated},
+			{token: "team1-token", source: `{"tsuruapp": {"appname": "myapp2"}}`, status: http.StatusForbidden},
+			{token: "team3-token", source: `{"tsuruapp": {"appname": "myapp2"}}`, status: http.StatusCreated},
+			{token: "team1-token", source: `{"tsuruapp": {"poolname": "p1"}}`, status: http.StatusCreated},
+			{token: "team1-token", source: `{"tsuruapp": {"poolname": "p2"}}`, status: http.StatusForbidden},
+			{token: "team1-token", source: `{"tsurujob": {"jobname": "job1"}}`, status: http.StatusCreated},
+			{token: "team3-token", source: `{"tsurujob": {"jobname": "job1"}}`, status: http.StatusForbidden},
+			{token: "team1-token", source: `{"tsuruapp": {"appname": "deleted-app"}}`, status: http.StatusForbidden},
+			{token: "admin-token", source: `{"tsuruapp": {"poolname": "p2"}}`, status: http.StatusCreated},
+			{token: "ops-token", source: `{"tsuruapp": {"appname": "myapp2"}}`, status: http.StatusCreated},
+		}
+		for _, tt := range tests {
+			rsp := do(t, "POST", "/rules", tt.token, `{
+				"source": `+tt.source+`,
+				"destination": {"externaldns": {"name": "a.b.com"}}
+			}`)
+			rsp.Body.Close()
+			assert.Equal(t, tt.status, rsp.StatusCode, "%s creating %s", tt.token, tt.source)
+		}
+	})
+
+	t.Run("forbidden message", func(t *testing.T) {
+		rsp := do(t, "POST", "/rules", "team1-token", `{
+			"source": {"tsuruapp": {"appname": "myapp2"}},
+			"destination": {"externaldns": {"name": "a.b.com"}}
+		}`)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		var result map[string]string
+		err := json.NewDecoder(rsp.Body).Decode(&result)
+		require.Nil(t, err)
+		assert.Equal(t, `user "team1@example.com" is not a member of any team owning app "myapp2"`, result["message"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		saveRules(t)
+		rsp := do(t, "DELETE", "/rules/r2", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "DELETE", "/rules/r5", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "DELETE", "/rules/r1", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp = do(t, "DELETE", "/rules/r5", "admin-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp = do(t, "DELETE", "/rules/r2", "", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		saveRules(t)
+		rsp := do(t, "PATCH", "/rules/r2", "team1-token", `{"destination": {"externaldns": {"name": "b.com"}}}`)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "PATCH", "/rules/r1", "team1-token", `{"source": {"tsuruapp": {"appname": "myapp2"}}}`)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "PATCH", "/rules/r1", "team1-token", `{"destination": {"externaldns": {"name": "b.com"}}}`)
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	})
+
+	t.Run("force sync", func(t *testing.T) {
+		saveRules(t)
+		rsp := do(t, "POST", "/rules/r2/sync", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "POST", "/rules/r4/sync", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+		rsp = do(t, "POST", "/apps/myapp2/sync", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "POST", "/apps/myapp1/sync", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	})
+
+	t.Run("list manageable", func(t *testing.T) {
+		saveRules(t)
+		ruleIDs := func(token, query string) []string {
+			rsp := do(t, "GET", "/rules"+query, token, "")
+			defer rsp.Body.Close()
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+			var rules []types.Rule
+			err := json.NewDecoder(rsp.Body).Decode(&rules)
+			require.Nil(t, err)
+			var ids []string
+			for _, r := range rules {
+				ids = append(ids, r.RuleID)
+			}
+			return ids
+		}
+		assert.Equal(t, []string{"r1", "r2", "r3", "r4", "r5"}, ruleIDs("team1-token", ""))
+		assert.Equal(t, []string{"r1", "r3", "r4"}, ruleIDs("team1-token", "?manageable=true"))
+		assert.Equal(t, []string{"r2"}, ruleIDs("team3-token", "?manageable=true"))
+		assert.Equal(t, []string{"r1", "r2", "r3", "r4", "r5"}, ruleIDs("admin-token", "?manageable=true"))
+		assert.Equal(t, []string{"r1", "r2", "r3", "r4", "r5"}, ruleIDs("", "?manageable=true"))
+	})
+
+	t.Run("service instances", func(t *testing.T) {
+		clearer.ClearAll()
+		rsp := do(t, "DELETE", "/resources/myinstance", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "GET", "/resources/myinstance/rule", "team1-token", "")
+		rsp.Body.Close()
+		assert.NotEqual(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "DELETE", "/resources/myinstance", "admin-token", "")
+		rsp.Body.Close()
+		assert.NotEqual(t, http.StatusForbidden, rsp.StatusCode)
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		rsp := do(t, "GET", "/audit", "team1-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+		rsp = do(t, "GET", "/audit", "admin-token", "")
+		rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/bulk"
+	"github.com/tsuru/acl-api/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+const yamlContentType = "application/yaml"
+
+// exportRules returns every rule and service instance as JSON or, with
+// format=yaml, as YAML.
+func exportRules(c echo.Context) error {
+	doc, err := bulk.Export()
+	if err != nil {
+		return err
+	}
+	return writeDocument(c, http.StatusOK, doc)
+}
+
+// importRules creates the rules and service instances of an exported JSON or
+// YAML document, with dry-run=true only the report is returned.
+func importRules(c echo.Context) error {
+	data, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	var doc types.ExportDocument
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid document: "+err.Error())
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry-run"))
+	report, rules, err := bulk.Import(doc, bulk.ImportOptions{DryRun: dryRun, User: requestActor(c)})
+	if errors.Cause(err) == bulk.ErrUnsupportedVersion {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		recordImport(c, report)
+		job, _, err := startSyncJob(c, rules, false)
+		if err != nil {
+			return err
+		}
+		report.SyncJob = job.JobID
+	}
+	return writeDocument(c, http.StatusOK, report)
+}
+
+// recordImport records the audit entries and sends the webhook events of
+// everything created by an import.
+func recordImport(c echo.Context, report types.ImportReport) {
+	recordImportItems(requestActor(c), requestRecorder(c), report)
+}
+
+// RecordImport is like recordImport for an import made from the command line.
+func RecordImport(actor string, report types.ImportReport) {
+	recordImportItems(actor, systemRecorder(actor), report)
+}
+
+func recordImportItems(actor string, record auditRecorder, report types.ImportReport) {
+	for _, item := range report.Items {
+		if item.Status != types.ImportCreated {
+			continue
+		}
+		switch item.Kind {
+		case types.ImportKindRule:
+			record(types.AuditEntry{Action: types.AuditRuleCreate, RuleID: item.RuleID}, nil, item.Rule)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleCreated, Actor: actor, Rule: item.Rule})
+		case types.ImportKindServiceInstance:
+			record(types.AuditEntry{Action: types.AuditServiceCreate, Instance: item.Instance}, nil, nil)
+		case types.ImportKindServiceRule:
+			record(types.AuditEntry{Action: types.AuditServiceRuleAdd, Instance: item.Instance, RuleID: item.RuleID}, nil, item.Rule)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleCreated, Actor: actor, Instance: item.Instance, Rule: item.Rule})
+		case types.ImportKindServiceApp:
+			record(types.AuditEntry{Action: types.AuditServiceBindApp, Instance: item.Instance, Target: item.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: actor, Instance: item.Instance, App: item.Target})
+		case types.ImportKindServiceJob:
+			record(types.AuditEntry{Action: types.AuditServiceBindJob, Instance: item.Instance, Target: item.Target}, nil, nil)
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: actor, Instance: item.Instance, Job: item.Target})
+		}
+	}
+}
+
+// writeDocument writes v as YAML when requested with format=yaml or an
+// Accept header asking for YAML, JSON is used otherwise.
+func writeDocument(c echo.Context, status int, v interface{}) error {
+	format := c.QueryParam("format")
+	if format == "" && strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "yaml") {
+		format = "yaml"
+	}
+	switch format {
+	case "", "json":
+		return c.JSON(status, v)
+	case "yaml":
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.Blob(status, yamlContentType, data)
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, "invalid format "+format+", valid values are: json, yaml")
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/audit"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+	"sigs.k8s.io/yaml"
+)
+
+func Test_exportImportRules(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	defer resetViper()
+	viper.Set("auth.user", "admin")
+	viper.Set("auth.password", "secret")
+	viper.Set("auth.read_only_user", "reader")
+	viper.Set("auth.read_only_password", "secret")
+
+	err = rule.GetService().Save([]*types.Rule{{
+		RuleID:      "r1",
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1", Ports: types.ProtoPorts{{Protocol: "TCP", Port: 8000, EndPort: 8080}}}},
+	}}, false)
+	require.Nil(t, err)
+	svc := service.GetService()
+	err = svc.Create(types.ServiceInstance{InstanceName: "inst1"})
+	require.Nil(t, err)
+	_, err = svc.AddRule("inst1", &types.ServiceRule{Rule: types.Rule{
+		RuleID:      "base1",
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+	}})
+	require.Nil(t, err)
+	_, err = svc.AddApp("inst1", "app2")
+	require.Nil(t, err)
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	do := func(method, path, user, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.SetBasicAuth(user, "secret")
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	rsp := do("GET", "/rules/export?format=yaml", "reader", "")
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "application/yaml", rsp.Header.Get("Content-Type"))
+	exported, err := ioutil.ReadAll(rsp.Body)
+	require.Nil(t, err)
+	var doc types.ExportDocument
+	err = yaml.Unmarshal(exported, &doc)
+	require.Nil(t, err)
+	assert.Equal(t, types.ExportVersion, doc.Version)
+	require.Len(t, doc.Rules, 1)
+	assert.Equal(t, types.ProtoPorts{{Protocol: "TCP", Port: 8000, EndPort: 8080}}, doc.Rules[0].Destination.ExternalIP.Ports)
+	require.Len(t, doc.ServiceInstances, 1)
+	assert.Equal(t, []string{"app2"}, doc.ServiceInstances[0].BindApps)
+
+	rsp = do("GET", "/rules/export?format=xml", "reader", "")
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	rsp = do("POST", "/rules/import", "reader", string(exported))
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	clearer.ClearAll()
+
+	rsp = do("POST", "/rules/import?dry-run=true", "admin", string(exported))
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var report types.ImportReport
+	err = json.NewDecoder(rsp.Body).Decode(&report)
+	require.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 4, report.Created)
+	rules, err := rule.GetService().FindAll()
+	require.Nil(t, err)
+	assert.Empty(t, rules)
+
+	jsonDoc, err := json.Marshal(doc)
+	require.Nil(t, err)
+	rsp = do("POST", "/rules/import", "admin", string(jsonDoc))
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	report = types.ImportReport{}
+	err = json.NewDecoder(rsp.Body).Decode(&report)
+	require.Nil(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 4, report.Created)
+	assert.NotEmpty(t, report.SyncJob)
+	assert.Equal(t, report.SyncJob, rsp.Header.Get(syncJobHeader))
+	r, err := rule.GetService().FindByID("r1")
+	require.Nil(t, err)
+	assert.Equal(t, doc.Rules[0].Destination, r.Destination)
+	_, err = rule.GetService().FindByID("base1-app2")
+	require.Nil(t, err)
+	entries, err := audit.GetService().Find(storage.AuditFindOpts{RuleID: "r1"})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, types.AuditRuleCreate, entries[0].Action)
+	assert.Equal(t, "admin", entries[0].Actor)
+
+	rsp = do("POST", "/rules/import", "admin", `{"Version": 2}`)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	rsp = do("POST", "/rules/import", "admin", `{"Version": [}`)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
@@ -23,3 +23,16 @@ func jobRules(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, rules)
 }
+
+func jobCheck(c echo.Context) error {
+	target, err := checkTarget(c)
+	if err != nil {
+		return err
+	}
+	rulesSvc := rule.GetService()
+	result, err := rulesSvc.CheckJob(c.Param("job"), target)
+	if err != nil {
+		return checkError(err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
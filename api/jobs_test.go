@@ -99,3 +99,45 @@ func Test_getJobsRules(t *testing.T) {
 		require.Len(t, result, 0)
 	})
 }
+
+func Test_jobCheck(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	svc := rule.GetService()
+	err = svc.Save([]*types.Rule{
+		{
+			RuleID: "1",
+			Source: types.RuleType{
+				TsuruJob: &types.TsuruJobRule{
+					JobName: "job1",
+				},
+			},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{
+					IP: "192.168.90.0/24",
+				},
+			},
+		},
+	}, false)
+	require.Nil(t, err)
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/jobs/job1/check?host=192.168.90.10&port=5432")
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var result types.CheckResult
+	err = json.NewDecoder(rsp.Body).Decode(&result)
+	require.Nil(t, err)
+	assert.True(t, result.Allowed)
+	require.Len(t, result.Matches, 1)
+	assert.Equal(t, "1", result.Matches[0].Rule.RuleID)
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/resolver"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type fakeResolver map[string][]resolver.Address
+
+func (r fakeResolver) Resolve(ctx context.Context, name string) ([]resolver.Address, error) {
+	return r[name], nil
+}
+
+func Test_getRuleResolved(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	oldResolver := resolver.GetResolver
+	defer func() { resolver.GetResolver = oldResolver }()
+	resolver.GetResolver = func() resolver.Resolver {
+		return fakeResolver{"example.com": {
+			{IP: net.ParseIP("10.0.0.1"), TTL: time.Hour},
+			{IP: net.ParseIP("10.0.0.2"), TTL: time.Hour},
+		}}
+	}
+	err = rule.GetService().Save([]*types.Rule{
+		{
+			RuleID:      "1",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: ".example.com", SyncWholeNetwork: true}},
+		},
+		{
+			RuleID:      "2",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1"}},
+		},
+	}, false)
+	require.Nil(t, err)
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/rules/1/resolved")
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var result types.ResolvedRule
+	err = json.NewDecoder(rsp.Body).Decode(&result)
+	require.Nil(t, err)
+	assert.Equal(t, "1", result.RuleID)
+	assert.Equal(t, ".example.com", result.Name)
+	assert.True(t, result.SyncWholeNetwork)
+	require.Len(t, result.Addresses, 2)
+	assert.Equal(t, "10.0.0.1", result.Addresses[0].IP)
+	assert.Equal(t, "10.0.0.2", result.Addresses[1].IP)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), result.Addresses[0].ValidUntil, time.Minute)
+	assert.Equal(t, []string{"10.0.0.0/24"}, result.Networks)
+
+	rsp, err = http.Get(srv.URL + "/rules/2/resolved")
+	require.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	rsp, err = http.Get(srv.URL + "/rules/404/resolved")
+	require.Nil(t, err)
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+}
@@ -5,6 +5,8 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -15,34 +17,163 @@ import (
 	"github.com/labstack/echo"
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/resolver"
 	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/service"
 	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/webhook"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// continueHeader holds the token to be sent as the continue param to get the
+// next page of a listing, it is missing on the last page.
+const continueHeader = "X-Continue"
+
+// continueToken is the position of the last item of a page, encoded along
+// with the order of the listing.
+type continueToken struct {
+	Sort storage.RuleSort `json:"s,omitempty"`
+	Time time.Time        `json:"t"`
+	ID   string           `json:"i"`
+}
+
+func setContinue(c echo.Context, sort storage.RuleSort, cursor *storage.Cursor) {
+	if cursor == nil {
+		return
+	}
+	data, _ := json.Marshal(continueToken{Sort: sort, Time: cursor.Time, ID: cursor.ID})
+	c.Response().Header().Set(continueHeader, base64.RawURLEncoding.EncodeToString(data))
+}
+
+// continueParam returns the cursor in the continue param, which must have
+// been returned by a listing in the same order.
+func continueParam(c echo.Context, sort storage.RuleSort) (*storage.Cursor, error) {
+	raw := c.QueryParam("continue")
+	if raw == "" {
+		return nil, nil
+	}
+	invalid := echo.NewHTTPError(http.StatusBadRequest, "invalid continue token")
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, invalid
+	}
+	var token continueToken
+	err = json.Unmarshal(data, &token)
+	if err != nil || token.ID == "" || token.Sort != sort {
+		return nil, invalid
+	}
+	return &storage.Cursor{Time: token.Time, ID: token.ID}, nil
+}
+
+// limitParam returns the limit param, 0 when it is missing.
+func limitParam(c echo.Context) (int, error) {
+	raw := c.QueryParam("limit")
+	if raw == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+	}
+	return limit, nil
+}
+
+// listRules returns the rules matching the filter in the query params, one
+// page at a time when limit is set.
 func listRules(c echo.Context) error {
+	var err error
+	opts := storage.FindOpts{Sort: storage.RuleSort(c.QueryParam("sort"))}
+	if !opts.Sort.Valid() {
+		return echo.NewHTTPError(http.StatusBadRequest, "sort must be one of id, -id, created or -created")
+	}
+	if raw := c.QueryParam("removed"); raw != "" {
+		removed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "removed must be a boolean")
+		}
+		opts.Removed = &removed
+	}
+	opts.CreatedSince, err = timeParam(c, "created-since")
+	if err != nil {
+		return err
+	}
+	opts.CreatedUntil, err = timeParam(c, "created-until")
+	if err != nil {
+		return err
+	}
+	opts.Limit, err = limitParam(c)
+	if err != nil {
+		return err
+	}
+	opts.After, err = continueParam(c, opts.Sort)
+	if err != nil {
+		return err
+	}
 	var filter types.Rule
 	d := form.NewDecoder(nil)
 	d.IgnoreCase(true)
 	d.IgnoreUnknownKeys(true)
-	err := d.DecodeValues(&filter, c.QueryParams())
+	err = d.DecodeValues(&filter, c.QueryParams())
 	if err != nil {
 		return err
 	}
 	svc := rule.GetService()
-	rules, err := svc.FindByRule(filter)
+	rules, next, err := svc.FindPage(filter, opts)
 	if err != nil {
 		return err
 	}
+	if manageable, _ := strconv.ParseBool(c.QueryParam("manageable")); manageable {
+		authz := newSourceAuthorizer(c)
+		var allowedRules []types.Rule
+		for _, r := range rules {
+			ok, err := authz.allowed(r.Source)
+			if err != nil {
+				return err
+			}
+			if ok {
+				allowedRules = append(allowedRules, r)
+			}
+		}
+		rules = allowedRules
+	}
+	setContinue(c, opts.Sort, next)
 	return c.JSON(http.StatusOK, rules)
 }
 
+// latestSync returns the syncs of the rule and engine params, newest first
+// and one page at a time when limit is set.
 func latestSync(c echo.Context) error {
+	return findSyncs(c, storage.SyncFindOpts{})
+}
+
+// driftedSyncs lists the syncs whose latest drift check found differences
+// between the actual state of the rule and the desired one.
+func driftedSyncs(c echo.Context) error {
+	return findSyncs(c, storage.SyncFindOpts{Drifted: true})
+}
+
+func findSyncs(c echo.Context, opts storage.SyncFindOpts) error {
+	if ruleIDs := c.QueryParams()["rule"]; len(ruleIDs) > 0 {
+		opts.RuleIDs = ruleIDs
+	}
+	if engines := c.QueryParams()["engine"]; len(engines) > 0 {
+		opts.Engines = engines
+	}
+	var err error
+	opts.Limit, err = limitParam(c)
+	if err != nil {
+		return err
+	}
+	opts.After, err = continueParam(c, "")
+	if err != nil {
+		return err
+	}
 	rulesSvc := rule.GetService()
-	rulesSyncs, err := rulesSvc.FindSyncs(nil)
+	rulesSyncs, next, err := rulesSvc.FindSyncPage(opts)
 	if err != nil {
 		return err
 	}
+	setContinue(c, "", next)
 	return c.JSON(http.StatusOK, rulesSyncs)
 }
 
@@ -53,12 +184,20 @@ func addRule(c echo.Context) error {
 		return err
 	}
 	r.RuleID = ""
+	err = r.ValidateExpiration(time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if r.RuleName != "" {
 		errs := validation.IsDNS1123Subdomain(r.RuleName)
 		if len(errs) > 0 {
 			return echo.NewHTTPError(http.StatusBadRequest, "RuleName: "+strings.Join(errs, "\n"))
 		}
 	}
+	err = newSourceAuthorizer(c).check(r.Source)
+	if err != nil {
+		return err
+	}
 	r.Created = time.Time{}
 	if user := c.Get("user"); user != nil {
 		r.Creator = fmt.Sprint(user)
@@ -69,29 +208,145 @@ func addRule(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusConflict, "RuleName: "+r.RuleName+" already in use")
 	}
 
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditRuleCreate, RuleID: r.RuleID}, nil, r)
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleCreated, Actor: requestActor(c), Rule: &r})
+	_, done, err := startSyncJob(c, []types.Rule{r}, false)
 	if err != nil {
 		return err
 	}
 	waitSync, _ := strconv.ParseBool(c.FormValue("wait-sync"))
 	if waitSync {
-		engine.SyncRules([]types.Rule{r}, false)
-	} else {
-		go engine.SyncRules([]types.Rule{r}, false)
+		<-done
 	}
 	return c.JSON(http.StatusCreated, r)
 }
 
+// updateRule replaces the rule source and destination on PUT, on PATCH only
+// the fields present in the body are changed.
+func updateRule(c echo.Context) error {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty rule id")
+	}
+	var update types.RuleUpdate
+	err := c.Bind(&update)
+	if err != nil {
+		return err
+	}
+	if c.Request().Method == http.MethodPut && (update.Source == nil || update.Destination == nil) {
+		return echo.NewHTTPError(http.StatusBadRequest, "source and destination are required")
+	}
+	if update.Source != nil {
+		err = update.Source.Validate()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "source: "+err.Error())
+		}
+	}
+	if update.Destination != nil {
+		err = update.Destination.Validate()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "destination: "+err.Error())
+		}
+	}
+	err = update.ValidateExpiration(time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	svc := rule.GetService()
+	current, err := svc.FindByID(id)
+	if err == storage.ErrRuleNotFound || current.Removed {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if current.Metadata["owner"] == service.OwnerAclFromHell {
+		return echo.NewHTTPError(http.StatusConflict, "rule is managed by service instance "+current.Metadata["instance-name"])
+	}
+	authz := newSourceAuthorizer(c)
+	err = authz.check(current.Source)
+	if err != nil {
+		return err
+	}
+	if update.Source != nil {
+		err = authz.check(*update.Source)
+		if err != nil {
+			return err
+		}
+	}
+	var user string
+	if u := c.Get("user"); u != nil {
+		user = fmt.Sprint(u)
+	}
+	r, err := svc.Update(current.RuleID, update, user)
+	if err == storage.ErrRuleNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditRuleUpdate, RuleID: r.RuleID}, current, r)
+	_, done, err := startSyncJob(c, []types.Rule{r}, true)
+	if err != nil {
+		return err
+	}
+	waitSync, _ := strconv.ParseBool(c.FormValue("wait-sync"))
+	if waitSync {
+		<-done
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+func getRuleHistory(c echo.Context) error {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty rule id")
+	}
+	svc := rule.GetService()
+	r, err := svc.FindByID(id)
+	if err == storage.ErrRuleNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	history, err := svc.FindHistory(r.RuleID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
 func deleteRule(c echo.Context) error {
 	id := strings.TrimSpace(c.Param("id"))
 	if id == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "empty rule id")
 	}
 	svc := rule.GetService()
-	err := svc.Delete(id)
+	r, err := svc.FindByID(id)
 	if err == storage.ErrRuleNotFound {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	err = newSourceAuthorizer(c).check(r.Source)
+	if err != nil {
+		return err
+	}
+	err = svc.Delete(id)
+	if err == storage.ErrRuleNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditRuleDelete, RuleID: r.RuleID}, r, nil)
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleRemoved, Actor: requestActor(c), Rule: &r})
+	return nil
 }
 
 func getRule(c echo.Context) error {
@@ -110,6 +365,81 @@ func getRule(c echo.Context) error {
 	return c.JSON(http.StatusOK, rule)
 }
 
+// getRuleResolved returns the addresses stored for the ExternalDNS
+// destination of a rule, names are resolved when no valid address is stored.
+func getRuleResolved(c echo.Context) error {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty rule id")
+	}
+	r, err := rule.GetService().FindByID(id)
+	if err == storage.ErrRuleNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	dns := r.Destination.ExternalDNS
+	if dns == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("rule %s does not have an ExternalDNS destination", id))
+	}
+	ips, err := resolver.Lookup(c.Request().Context(), dns.Name)
+	if err != nil {
+		return err
+	}
+	result := types.ResolvedRule{
+		RuleID:           r.RuleID,
+		Name:             dns.Name,
+		SyncWholeNetwork: dns.SyncWholeNetwork,
+		Addresses:        []types.ResolvedAddress{},
+		Networks:         resolver.Networks(ips, dns.SyncWholeNetwork),
+	}
+	for _, ip := range ips {
+		result.Addresses = append(result.Addresses, types.ResolvedAddress{IP: ip.IP.String(), ValidUntil: ip.ValidUntil})
+	}
+	if result.Networks == nil {
+		result.Networks = []string{}
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// getRulePreview renders what syncing the rule does in the engine given,
+// enabled or not, without applying anything.
+func getRulePreview(c echo.Context) error {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty rule id")
+	}
+	engineName := strings.TrimSuffix(c.QueryParam("engine"), engine.DryRunSuffix)
+	if engineName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "engine is required")
+	}
+	r, err := rule.GetService().FindByID(id)
+	if err == storage.ErrRuleNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	factory := findEngine(engineName)
+	if factory == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown engine %q", engineName))
+	}
+	renderEngine, ok := factory().(engine.EngineWithRender)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("engine %q does not support preview", engineName))
+	}
+	output, err := renderEngine.Render(rule.NewLogicCache(), r)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, types.RulePreview{
+		RuleID: r.RuleID,
+		Engine: engineName,
+		Output: output,
+	})
+}
+
 func forceRuleSync(c echo.Context) error {
 	id := strings.TrimSpace(c.Param("id"))
 	if id == "" {
@@ -123,8 +453,16 @@ func forceRuleSync(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	engine.SyncRules([]types.Rule{rule}, true)
-	return nil
+	err = newSourceAuthorizer(c).check(rule.Source)
+	if err != nil {
+		return err
+	}
+	job, _, err := startSyncJob(c, []types.Rule{rule}, true)
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditRuleSync, RuleID: rule.RuleID}, nil, nil)
+	return c.JSON(http.StatusAccepted, job)
 }
 
 func getRuleSync(c echo.Context) error {
@@ -20,6 +20,7 @@ import (
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/rule"
 	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
 	_ "github.com/tsuru/acl-api/storage/mongodb"
 )
 
@@ -268,6 +269,63 @@ func Test_addRule(t *testing.T) {
 		assert.True(t, strings.HasPrefix(result.Message, "RuleName: must be no more than 253 characters"), "received message: "+result.Message)
 	})
 
+	t.Run("with expiration", func(t *testing.T) {
+		clearer.ClearAll()
+		e := setupEcho()
+		srv := httptest.NewServer(e.Server.Handler)
+		defer srv.Close()
+
+		expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+		body := strings.NewReader(`{
+			"source": {"tsuruapp": {"appname": "myapp1"}},
+			"destination": {"externaldns": {"name": "a.b.com"}},
+			"expiresAt": "` + expiresAt.Format(time.RFC3339) + `"
+		}`)
+		req, err := http.NewRequest("POST", srv.URL+"/rules", body)
+		require.Nil(t, err)
+		req.Header.Add("Content-Type", "application/json")
+
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+		rsp, err = http.Get(srv.URL + "/rules")
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		var rules []types.Rule
+		err = json.NewDecoder(rsp.Body).Decode(&rules)
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		require.NotNil(t, rules[0].ExpiresAt)
+		assert.True(t, expiresAt.Equal(*rules[0].ExpiresAt))
+	})
+
+	t.Run("already expired", func(t *testing.T) {
+		clearer.ClearAll()
+		e := setupEcho()
+		srv := httptest.NewServer(e.Server.Handler)
+		defer srv.Close()
+
+		body := strings.NewReader(`{
+			"source": {"tsuruapp": {"appname": "myapp1"}},
+			"destination": {"externaldns": {"name": "a.b.com"}},
+			"expiresAt": "2020-01-01T00:00:00Z"
+		}`)
+		req, err := http.NewRequest("POST", srv.URL+"/rules", body)
+		require.Nil(t, err)
+		req.Header.Add("Content-Type", "application/json")
+
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		result := struct{ Message string }{}
+		err = json.NewDecoder(rsp.Body).Decode(&result)
+		require.NoError(t, err)
+		assert.Equal(t, "ExpiresAt must be in the future", result.Message)
+	})
 }
 
 func Test_listRules(t *testing.T) {
@@ -392,6 +450,10 @@ func Test_listRules(t *testing.T) {
 		{url: "/rules?source.tsuruapp.appname=app1", expected: []string{"1"}},
 		{url: "/rules?metadata.meta-a=a", expected: []string{"1", "2"}},
 		{url: "/rules?metadata.meta-a=a&source.tsuruapp.appname=app2", expected: []string{"2"}},
+		{url: "/rules?destination.externalip.ip=192.168.90.0/24", expected: []string{"1", "2"}},
+		{url: "/rules?destination.externalip.ip=192.168.91.0/24"},
+		{url: "/rules?removed=false&source.tsuruapp.appname=app1", expected: []string{"1"}},
+		{url: "/rules?removed=true"},
 	} {
 		t.Run("filtered "+tt.url, func(t *testing.T) {
 			e := setupEcho()
@@ -419,6 +481,199 @@ func Test_listRules(t *testing.T) {
 			assert.Equal(t, ruleIDs, tt.expected)
 		})
 	}
+
+	t.Run("paginated", func(t *testing.T) {
+		e := setupEcho()
+		srv := httptest.NewServer(e.Server.Handler)
+		defer srv.Close()
+
+		var pages [][]string
+		token := ""
+		for i := 0; i < 3; i++ {
+			url := srv.URL + "/rules?sort=-id&limit=1"
+			if token != "" {
+				url += "&continue=" + token
+			}
+			rsp, err := http.Get(url)
+			require.Nil(t, err)
+			defer rsp.Body.Close()
+			require.Equal(t, 200, rsp.StatusCode)
+			var result []types.Rule
+			err = json.NewDecoder(rsp.Body).Decode(&result)
+			require.Nil(t, err)
+			var ruleIDs []string
+			for _, r := range result {
+				ruleIDs = append(ruleIDs, r.RuleID)
+			}
+			pages = append(pages, ruleIDs)
+			token = rsp.Header.Get("X-Continue")
+			if token == "" {
+				break
+			}
+		}
+		assert.Equal(t, [][]string{{"2"}, {"1"}, nil}, pages)
+	})
+
+	for _, query := range []string{"sort=name", "limit=0", "continue=invalid", "removed=maybe", "created-since=yesterday"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			e := setupEcho()
+			srv := httptest.NewServer(e.Server.Handler)
+			defer srv.Close()
+
+			rsp, err := http.Get(srv.URL + "/rules?" + query)
+			require.Nil(t, err)
+			defer rsp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		})
+	}
+}
+
+func Test_latestSync(t *testing.T) {
+	stor, err := storage.GetSyncStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	for _, ruleID := range []string{"r1", "r2", "r3"} {
+		_, ruleSync, err := stor.StartSync(0, ruleID, "e1", false)
+		require.Nil(t, err)
+		err = stor.EndSync(*ruleSync, types.RuleSyncData{Successful: true})
+		require.Nil(t, err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	get := func(query string) ([]string, string) {
+		rsp, err := http.Get(srv.URL + "/rules/sync?" + query)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, 200, rsp.StatusCode)
+		var result []types.RuleSyncInfo
+		err = json.NewDecoder(rsp.Body).Decode(&result)
+		require.Nil(t, err)
+		var ruleIDs []string
+		for _, s := range result {
+			ruleIDs = append(ruleIDs, s.RuleID)
+		}
+		return ruleIDs, rsp.Header.Get("X-Continue")
+	}
+
+	ruleIDs, token := get("limit=2")
+	assert.Equal(t, []string{"r3", "r2"}, ruleIDs)
+	require.NotEmpty(t, token)
+	ruleIDs, token = get("limit=2&continue=" + token)
+	assert.Equal(t, []string{"r1"}, ruleIDs)
+	assert.Empty(t, token)
+	ruleIDs, _ = get("rule=r1&rule=r3")
+	assert.Equal(t, []string{"r3", "r1"}, ruleIDs)
+	ruleIDs, _ = get("engine=e2")
+	assert.Empty(t, ruleIDs)
+}
+
+func Test_driftedSyncs(t *testing.T) {
+	stor, err := storage.GetSyncStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	for _, ruleID := range []string{"r1", "r2", "r3"} {
+		for _, engine := range []string{"e1", "e2"} {
+			_, ruleSync, err := stor.StartSync(0, ruleID, engine, false)
+			require.Nil(t, err)
+			err = stor.EndSync(*ruleSync, types.RuleSyncData{Successful: true})
+			require.Nil(t, err)
+		}
+	}
+	now := time.Now().UTC()
+	err = stor.SetDrift("r1", "e1", types.RuleDrift{CheckTime: now, Drifted: true, Differences: []string{"missing"}})
+	require.Nil(t, err)
+	err = stor.SetDrift("r2", "e1", types.RuleDrift{CheckTime: now})
+	require.Nil(t, err)
+	err = stor.SetDrift("r3", "e2", types.RuleDrift{CheckTime: now, Drifted: true, Differences: []string{"outdated"}})
+	require.Nil(t, err)
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	get := func(query string) []types.RuleSyncInfo {
+		rsp, err := http.Get(srv.URL + "/drift?" + query)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, 200, rsp.StatusCode)
+		var result []types.RuleSyncInfo
+		err = json.NewDecoder(rsp.Body).Decode(&result)
+		require.Nil(t, err)
+		return result
+	}
+
+	result := get("")
+	require.Len(t, result, 2)
+	drifted := map[string][]string{}
+	for _, info := range result {
+		require.NotNil(t, info.Drift)
+		drifted[info.RuleID+"/"+info.Engine] = info.Drift.Differences
+	}
+	assert.Equal(t, map[string][]string{"r1/e1": {"missing"}, "r3/e2": {"outdated"}}, drifted)
+	result = get("engine=e1")
+	require.Len(t, result, 1)
+	assert.Equal(t, "r1", result[0].RuleID)
+	assert.Empty(t, get("rule=r2"))
+}
+
+func Test_getRulePreview(t *testing.T) {
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	defer resetViper()
+	viper.Set("aclapi.networks", map[string]interface{}{
+		"p1": []string{"10.0.0.0/24"},
+	})
+	err = rule.GetService().Save([]*types.Rule{
+		{
+			RuleID: "r1",
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{PoolName: "p1"},
+			},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: "192.168.0.1"},
+			},
+		},
+	}, false)
+	require.Nil(t, err)
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	get := func(path string) (int, types.RulePreview) {
+		rsp, err := http.Get(srv.URL + path)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		var preview types.RulePreview
+		if rsp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(rsp.Body).Decode(&preview)
+			require.Nil(t, err)
+		}
+		return rsp.StatusCode, preview
+	}
+
+	for _, engineName := range []string{"aclapi", "aclapi:dry-run"} {
+		code, preview := get("/rules/r1/preview?engine=" + engineName)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "r1", preview.RuleID)
+		assert.Equal(t, "aclapi", preview.Engine)
+		assert.Contains(t, preview.Output, "PUT /api/ipv4/acl/10.0.0.0/24\n")
+		assert.Contains(t, preview.Output, `"destination": "192.168.0.1/32"`)
+	}
+	code, _ := get("/rules/r1/preview")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/rules/r1/preview?engine=unknown")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/rules/r2/preview?engine=aclapi")
+	assert.Equal(t, http.StatusNotFound, code)
 }
 
 func Test_getRule(t *testing.T) {
@@ -643,3 +898,159 @@ func Test_deleteRule(t *testing.T) {
 		assert.Equal(t, 400, rsp.StatusCode)
 	})
 }
+
+func Test_updateRule(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	createRules := func() {
+		clearer.ClearAll()
+		svc := rule.GetService()
+		err = svc.Save([]*types.Rule{
+			{
+				RuleID: "1",
+				Source: types.RuleType{
+					TsuruApp: &types.TsuruAppRule{
+						AppName: "app1",
+					},
+				},
+				Destination: types.RuleType{
+					ExternalIP: &types.ExternalIPRule{
+						IP:    "192.168.90.0/24",
+						Ports: []types.ProtoPort{{Protocol: "tcp", Port: 80}},
+					},
+				},
+			},
+			{
+				RuleID: "2",
+				Source: types.RuleType{
+					TsuruApp: &types.TsuruAppRule{
+						AppName: "app1",
+					},
+				},
+				Destination: types.RuleType{
+					TsuruApp: &types.TsuruAppRule{
+						AppName: "app2",
+					},
+				},
+				Metadata: map[string]string{
+					"owner":         "aclfromhell",
+					"instance-name": "inst1",
+				},
+			},
+		}, false)
+		require.Nil(t, err)
+	}
+	doRequest := func(t *testing.T, method, path, body string) *http.Response {
+		e := setupEcho()
+		srv := httptest.NewServer(e.Server.Handler)
+		defer srv.Close()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.Header.Add("Content-Type", "application/json")
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	t.Run("patch destination", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PATCH", "/rules/1", `{"Destination": {"ExternalIP": {"IP": "192.168.90.0/24", "Ports": [{"Protocol": "tcp", "Port": 443}]}}}`)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var result types.Rule
+		err = json.NewDecoder(rsp.Body).Decode(&result)
+		require.Nil(t, err)
+		assert.Equal(t, "1", result.RuleID)
+		assert.Equal(t, types.ProtoPorts{{Protocol: "tcp", Port: 443}}, result.Destination.ExternalIP.Ports)
+		assert.Equal(t, "app1", result.Source.TsuruApp.AppName)
+
+		rsp = doRequest(t, "GET", "/rules/1/history", "")
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var history []types.RuleChange
+		err = json.NewDecoder(rsp.Body).Decode(&history)
+		require.Nil(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, 1, history[0].Version)
+		assert.Equal(t, []string{"Destination"}, history[0].ChangedFields)
+		assert.Equal(t, types.ProtoPorts{{Protocol: "tcp", Port: 80}}, history[0].PreviousDestination.ExternalIP.Ports)
+	})
+
+	t.Run("put requires source and destination", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PUT", "/rules/1", `{"Destination": {"ExternalIP": {"IP": "10.0.0.1"}}}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+		rsp = doRequest(t, "PUT", "/rules/1", `{"Source": {"TsuruApp": {"AppName": "app3"}}, "Destination": {"ExternalIP": {"IP": "10.0.0.1"}}}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		r, err := rule.GetService().FindByID("1")
+		require.Nil(t, err)
+		assert.Equal(t, "app3", r.Source.TsuruApp.AppName)
+		assert.Equal(t, "10.0.0.1", r.Destination.ExternalIP.IP)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PATCH", "/rules/1", `{"Destination": {"TsuruApp": {}}}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+		rsp = doRequest(t, "PATCH", "/rules/1", `{"ExpiresAt": "2020-01-01T00:00:00Z"}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+		rsp = doRequest(t, "PATCH", "/rules/1", `{"ExpiresAt": "2100-01-01T00:00:00Z", "ClearExpiresAt": true}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	})
+
+	t.Run("clear expiration", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PATCH", "/rules/1", `{"ExpiresAt": "2100-01-01T00:00:00Z"}`)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		r, err := rule.GetService().FindByID("1")
+		require.Nil(t, err)
+		require.NotNil(t, r.ExpiresAt)
+
+		rsp = doRequest(t, "PATCH", "/rules/1", `{"ClearExpiresAt": true}`)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		r, err = rule.GetService().FindByID("1")
+		require.Nil(t, err)
+		assert.Nil(t, r.ExpiresAt)
+
+		rsp = doRequest(t, "GET", "/rules/1/history", "")
+		defer rsp.Body.Close()
+		var history []types.RuleChange
+		err = json.NewDecoder(rsp.Body).Decode(&history)
+		require.Nil(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, []string{"ExpiresAt"}, history[1].ChangedFields)
+		require.NotNil(t, history[1].PreviousExpiresAt)
+		assert.Equal(t, 2100, history[1].PreviousExpiresAt.Year())
+	})
+
+	t.Run("service managed", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PATCH", "/rules/2", `{"Destination": {"TsuruApp": {"AppName": "app3"}}}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusConflict, rsp.StatusCode)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		createRules()
+		rsp := doRequest(t, "PATCH", "/rules/3", `{"Destination": {"TsuruApp": {"AppName": "app3"}}}`)
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+
+		rsp = doRequest(t, "GET", "/rules/3/history", "")
+		defer rsp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	})
+}
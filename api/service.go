@@ -14,10 +14,10 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/tsuru/acl-api/api/types"
-	"github.com/tsuru/acl-api/engine"
 	"github.com/tsuru/acl-api/rule"
 	"github.com/tsuru/acl-api/service"
 	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/webhook"
 )
 
 func serviceCreate(c echo.Context) error {
@@ -30,6 +30,7 @@ func serviceCreate(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceCreate, Instance: instance.InstanceName}, nil, instanceSnapshot(instance.InstanceName))
 	return c.String(http.StatusOK, "")
 }
 
@@ -54,11 +55,13 @@ func serviceUpdate(c echo.Context) error {
 
 func serviceDelete(c echo.Context) error {
 	instanceName := c.Param("instance")
+	before := instanceSnapshot(instanceName)
 	svc := service.GetService()
 	err := svc.Delete(instanceName)
 	if err != nil {
 		return err
 	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceDelete, Instance: instanceName}, before, nil)
 	return c.String(http.StatusOK, "")
 }
 
@@ -79,8 +82,14 @@ func serviceInfo(c echo.Context) error {
 		return err
 	}
 	var rulesStr []string
+	now := time.Now()
 	for _, r := range si.BaseRules {
 		val := fmt.Sprintf("Rule ID: %s - Destination: %s", r.RuleID, r.Destination.String())
+		if r.Expired(now) {
+			val += " - Expired"
+		} else if r.ExpiresAt != nil {
+			val += fmt.Sprintf(" - Expires in: %s", r.ExpiresAt.Sub(now).Round(time.Second))
+		}
 		rulesStr = append(rulesStr, val)
 	}
 	item := infoItem{
@@ -96,12 +105,18 @@ func serviceBindApp(c echo.Context) error {
 	if appName == "" {
 		c.String(http.StatusBadRequest, "app-name is required")
 	}
+	before := instanceSnapshot(instanceName)
 	svc := service.GetService()
 	rules, err := svc.AddApp(instanceName, appName)
 	if err != nil {
 		return err
 	}
-	go engine.SyncRules(rules, false)
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceBindApp, Instance: instanceName, Target: appName}, before, instanceSnapshot(instanceName))
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: requestActor(c), Instance: instanceName, App: appName})
+	_, _, err = startSyncJob(c, rules, false)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, map[string]string{})
 }
 
@@ -120,11 +135,14 @@ func serviceUnbindApp(c echo.Context) error {
 	if appName == "" {
 		c.String(http.StatusBadRequest, "app-name is required")
 	}
+	before := instanceSnapshot(instanceName)
 	svc := service.GetService()
 	err = svc.RemoveApp(instanceName, appName)
 	if err != nil {
 		return err
 	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceUnbindApp, Instance: instanceName, Target: appName}, before, instanceSnapshot(instanceName))
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceUnbound, Actor: requestActor(c), Instance: instanceName, App: appName})
 	return c.String(http.StatusOK, "")
 }
 
@@ -134,12 +152,18 @@ func serviceBindJob(c echo.Context) error {
 	if jobName == "" {
 		c.String(http.StatusBadRequest, "job is required")
 	}
+	before := instanceSnapshot(instanceName)
 	svc := service.GetService()
 	rules, err := svc.AddJob(instanceName, jobName)
 	if err != nil {
 		return err
 	}
-	go engine.SyncRules(rules, false)
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceBindJob, Instance: instanceName, Target: jobName}, before, instanceSnapshot(instanceName))
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Actor: requestActor(c), Instance: instanceName, Job: jobName})
+	_, _, err = startSyncJob(c, rules, false)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, map[string]string{})
 }
 
@@ -150,11 +174,14 @@ func serviceUnbindJob(c echo.Context) error {
 	if jobName == "" {
 		c.String(http.StatusBadRequest, "job-name is required")
 	}
+	before := instanceSnapshot(instanceName)
 	svc := service.GetService()
 	err := svc.RemoveJob(instanceName, jobName)
 	if err != nil {
 		return err
 	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceUnbindJob, Instance: instanceName, Target: jobName}, before, instanceSnapshot(instanceName))
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookServiceUnbound, Actor: requestActor(c), Instance: instanceName, Job: jobName})
 	return c.String(http.StatusOK, "")
 }
 
@@ -230,6 +257,11 @@ func serviceAddRule(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	err = r.ValidateExpiration(time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	svc := service.GetService()
 	rules, err := svc.AddRule(instanceName, r)
 	if err == service.ErrRuleAlreadyExists {
@@ -238,18 +270,69 @@ func serviceAddRule(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	go engine.SyncRules(rules, false)
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceRuleAdd, Instance: instanceName, RuleID: r.RuleID}, nil, r)
+	webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleCreated, Actor: requestActor(c), Instance: instanceName, Rule: &r.Rule})
+	_, _, err = startSyncJob(c, rules, false)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, r)
 }
 
+func serviceUpdateRule(c echo.Context) error {
+	instanceName := c.Param("instance")
+	ruleID := c.Param("rule")
+	var update types.RuleUpdate
+	err := c.Bind(&update)
+	if err != nil {
+		return err
+	}
+	if update.Destination != nil {
+		err = update.Destination.Validate()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	err = update.ValidateExpiration(time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	before := baseRuleSnapshot(instanceName, ruleID)
+	svc := service.GetService()
+	rules, err := svc.UpdateRule(instanceName, ruleID, update, c.Request().Header.Get("X-Tsuru-User"))
+	if err == storage.ErrRuleNotFound || err == storage.ErrInstanceNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err == service.ErrRuleAlreadyExists {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceRuleUpdate, Instance: instanceName, RuleID: ruleID}, before, baseRuleSnapshot(instanceName, ruleID))
+	_, _, err = startSyncJob(c, rules, true)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
 func serviceRemoveRule(c echo.Context) error {
 	instanceName := c.Param("instance")
 	ruleID := c.Param("rule")
+	before := baseRuleSnapshot(instanceName, ruleID)
 	svc := service.GetService()
 	err := svc.RemoveRule(instanceName, ruleID)
 	if err != nil {
 		return err
 	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceRuleRemove, Instance: instanceName, RuleID: ruleID}, before, nil)
+	event := types.WebhookEvent{Type: types.WebhookRuleRemoved, Actor: requestActor(c), Instance: instanceName}
+	if before != nil {
+		event.Rule = &before.Rule
+	}
+	webhook.Notify(event)
 	return c.String(http.StatusOK, "")
 }
 
@@ -265,9 +348,13 @@ func serviceForceSyncRule(c echo.Context) error {
 		return err
 	}
 
-	engine.SyncRules(rules, true)
+	job, _, err := startSyncJob(c, rules, true)
+	if err != nil {
+		return err
+	}
+	recordAudit(c, types.AuditEntry{Action: types.AuditServiceSync, Instance: instanceName}, nil, nil)
 
-	return nil
+	return c.JSON(http.StatusAccepted, job)
 }
 
 func servicePlans(c echo.Context) error {
@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
@@ -20,18 +21,20 @@ import (
 )
 
 type serviceMock struct {
-	bindAppCall   []map[string]string
-	bindJobCall   []map[string]string
-	removeAppCall []map[string]string
-	removeJobCall []map[string]string
-	addRuleCall   []*types.ServiceRule
+	bindAppCall    []map[string]string
+	bindJobCall    []map[string]string
+	removeAppCall  []map[string]string
+	removeJobCall  []map[string]string
+	addRuleCall    []*types.ServiceRule
+	updateRuleCall []map[string]interface{}
+	instance       types.ServiceInstance
 }
 
 func (s *serviceMock) Create(instance types.ServiceInstance) error {
 	return nil
 }
 func (s *serviceMock) Find(instanceName string) (types.ServiceInstance, error) {
-	return types.ServiceInstance{}, nil
+	return s.instance, nil
 }
 func (s *serviceMock) List() ([]types.ServiceInstance, error) {
 	return nil, nil
@@ -54,6 +57,15 @@ func (s *serviceMock) AddRule(instanceName string, r *types.ServiceRule) ([]type
 	}, nil
 
 }
+func (s *serviceMock) UpdateRule(instanceName string, ruleID string, update types.RuleUpdate, user string) ([]types.Rule, error) {
+	s.updateRuleCall = append(s.updateRuleCall, map[string]interface{}{
+		"instanceName": instanceName,
+		"ruleID":       ruleID,
+		"update":       update,
+		"user":         user,
+	})
+	return []types.Rule{}, nil
+}
 func (s *serviceMock) RemoveRule(instanceName string, ruleID string) error {
 	return nil
 }
@@ -86,6 +98,9 @@ func (s *serviceMock) RemoveJob(instanceName string, jobName string) error {
 	})
 	return nil
 }
+func (s *serviceMock) SetMetadata(instanceName string, metadata map[string]string) error {
+	return nil
+}
 
 func Test_serviceBindApp(t *testing.T) {
 	mock := &serviceMock{}
@@ -105,6 +120,7 @@ func Test_serviceBindApp(t *testing.T) {
 	require.Nil(t, err)
 	rsp.Body.Close()
 	assert.Equal(t, 200, rsp.StatusCode)
+	assert.NotEmpty(t, rsp.Header.Get(syncJobHeader))
 	assert.Equal(t, []map[string]string{
 		{
 			"instanceName": "testsvc",
@@ -155,6 +171,7 @@ func Test_serviceBindJob(t *testing.T) {
 	require.Nil(t, err)
 	rsp.Body.Close()
 	assert.Equal(t, 200, rsp.StatusCode)
+	assert.NotEmpty(t, rsp.Header.Get(syncJobHeader))
 	assert.Equal(t, []map[string]string{
 		{
 			"instanceName": "testsvc",
@@ -213,6 +230,7 @@ func Test_serviceRuleAdd(t *testing.T) {
 		body, _ := ioutil.ReadAll(rsp.Body)
 		assert.Fail(t, "body: "+string(body))
 	}
+	assert.NotEmpty(t, rsp.Header.Get(syncJobHeader))
 
 	outputRule := &types.ServiceRule{}
 	err = json.NewDecoder(rsp.Body).Decode(outputRule)
@@ -220,3 +238,125 @@ func Test_serviceRuleAdd(t *testing.T) {
 
 	assert.Equal(t, "fake-rule-id", outputRule.RuleID)
 }
+
+func Test_serviceRuleAddExpired(t *testing.T) {
+	mock := &serviceMock{}
+	service.GetService = func() service.Service {
+		return mock
+	}
+	e := echo.New()
+	configHandlers(e)
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	body := strings.NewReader(`{
+		"destination": {
+			"TsuruApp": {
+				"AppName": "myapp"
+			}
+		},
+		"ExpiresAt": "2020-01-01T00:00:00Z"
+	}`)
+	req, err := http.NewRequest("POST", srv.URL+"/resources/testsvc/rule", body)
+	require.Nil(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	rsp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, 400, rsp.StatusCode)
+	assert.Len(t, mock.addRuleCall, 0)
+}
+
+func Test_serviceRuleUpdate(t *testing.T) {
+	mock := &serviceMock{}
+	service.GetService = func() service.Service {
+		return mock
+	}
+	e := echo.New()
+	configHandlers(e)
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	body := strings.NewReader(`{
+		"destination": {
+			"TsuruApp": {
+				"AppName": "myapp"
+			}
+		}
+	}`)
+	req, err := http.NewRequest("PUT", srv.URL+"/resources/testsvc/rule/r1", body)
+	require.Nil(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Tsuru-User", "me@example.com")
+	rsp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, 200, rsp.StatusCode)
+	assert.NotEmpty(t, rsp.Header.Get(syncJobHeader))
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"instanceName": "testsvc",
+			"ruleID":       "r1",
+			"update": types.RuleUpdate{
+				Destination: &types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "myapp"}},
+			},
+			"user": "me@example.com",
+		},
+	}, mock.updateRuleCall)
+
+	body = strings.NewReader(`{"destination": {"TsuruApp": {}}}`)
+	req, err = http.NewRequest("PUT", srv.URL+"/resources/testsvc/rule/r1", body)
+	require.Nil(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	rsp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, 400, rsp.StatusCode)
+	assert.Len(t, mock.updateRuleCall, 1)
+
+	body = strings.NewReader(`{"ExpiresAt": "2100-01-01T00:00:00Z", "ClearExpiresAt": true}`)
+	req, err = http.NewRequest("PUT", srv.URL+"/resources/testsvc/rule/r1", body)
+	require.Nil(t, err)
+	req.Header.Add("Content-Type", "application/json")
+	rsp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, 400, rsp.StatusCode)
+	assert.Len(t, mock.updateRuleCall, 1)
+}
+
+func Test_serviceInfo(t *testing.T) {
+	expires := time.Now().Add(2*time.Hour + 30*time.Second)
+	expired := time.Now().Add(-time.Hour)
+	mock := &serviceMock{
+		instance: types.ServiceInstance{
+			InstanceName: "testsvc",
+			BaseRules: []types.ServiceRule{
+				{Rule: types.Rule{RuleID: "r1", Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}}},
+				{Rule: types.Rule{RuleID: "r2", Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}}, ExpiresAt: &expires}},
+				{Rule: types.Rule{RuleID: "r3", Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app3"}}, ExpiresAt: &expired}},
+			},
+		},
+	}
+	service.GetService = func() service.Service {
+		return mock
+	}
+	e := echo.New()
+	configHandlers(e)
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/resources/testsvc")
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, 200, rsp.StatusCode)
+	var items []infoItem
+	err = json.NewDecoder(rsp.Body).Decode(&items)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	lines := strings.Split(items[0].Value, "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "Rule ID: r1 - Destination: Tsuru APP: app1", lines[0])
+	assert.Regexp(t, `^Rule ID: r2 - Destination: Tsuru APP: app2 - Expires in: 2h0m(29|30)s$`, lines[1])
+	assert.Equal(t, "Rule ID: r3 - Destination: Tsuru APP: app3 - Expired", lines[2])
+}
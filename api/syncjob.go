@@ -0,0 +1,45 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/syncjob"
+)
+
+// syncJobHeader holds the id of the job syncing the rules changed by a
+// request.
+const syncJobHeader = "X-Sync-Job"
+
+// startSyncJob syncs rules in the background in a new job, setting its id
+// in the response headers.
+func startSyncJob(c echo.Context, rules []types.Rule, force bool) (types.SyncJob, <-chan struct{}, error) {
+	job, done, err := syncjob.Start(rules, force, requestActor(c))
+	if err != nil {
+		return job, nil, err
+	}
+	c.Response().Header().Set(syncJobHeader, job.JobID)
+	return job, done, nil
+}
+
+func getSyncJob(c echo.Context) error {
+	id := strings.TrimSpace(c.Param("id"))
+	if id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "empty sync job id")
+	}
+	job, err := syncjob.Find(id)
+	if err == storage.ErrSyncJobNotFound {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
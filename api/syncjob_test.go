@@ -0,0 +1,75 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+func Test_syncJobs(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	getJob := func(id string) (int, types.SyncJob) {
+		rsp, err := http.Get(srv.URL + "/sync-jobs/" + id)
+		require.Nil(t, err)
+		defer rsp.Body.Close()
+		var job types.SyncJob
+		if rsp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(rsp.Body).Decode(&job)
+			require.Nil(t, err)
+		}
+		return rsp.StatusCode, job
+	}
+
+	rsp, err := http.Post(srv.URL+"/rules?wait-sync=true", "application/json", strings.NewReader(`{
+		"Source": {"TsuruApp": {"AppName": "myapp1"}},
+		"Destination": {"ExternalDNS": {"Name": "a.com"}}
+	}`))
+	require.Nil(t, err)
+	var created types.Rule
+	err = json.NewDecoder(rsp.Body).Decode(&created)
+	rsp.Body.Close()
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	jobID := rsp.Header.Get(syncJobHeader)
+	require.NotEmpty(t, jobID)
+	code, job := getJob(jobID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, jobID, job.JobID)
+	assert.Equal(t, types.SyncJobDone, job.Status)
+	assert.False(t, job.Force)
+
+	rsp, err = http.Post(srv.URL+"/rules/"+created.RuleID+"/sync", "", nil)
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, rsp.StatusCode)
+	err = json.NewDecoder(rsp.Body).Decode(&job)
+	require.Nil(t, err)
+	assert.NotEqual(t, jobID, job.JobID)
+	assert.Equal(t, job.JobID, rsp.Header.Get(syncJobHeader))
+	assert.True(t, job.Force)
+	code, found := getJob(job.JobID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, job.JobID, found.JobID)
+
+	code, _ = getJob("invalid")
+	assert.Equal(t, http.StatusNotFound, code)
+}
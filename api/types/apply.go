@@ -0,0 +1,53 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+// ApplyDocument is the desired state of service instances, usually kept in
+// git. Base rules are matched to the existing ones by destination, so their
+// ids are optional.
+type ApplyDocument struct {
+	ServiceInstances []ServiceInstance
+}
+
+type ApplyAction string
+
+const (
+	ApplyCreateInstance ApplyAction = "create-instance"
+	ApplyDeleteInstance ApplyAction = "delete-instance"
+	ApplyAddRule        ApplyAction = "add-rule"
+	ApplyRemoveRule     ApplyAction = "remove-rule"
+	ApplyUpdateRule     ApplyAction = "update-rule"
+	ApplyBindApp        ApplyAction = "bind-app"
+	ApplyUnbindApp      ApplyAction = "unbind-app"
+	ApplyBindJob        ApplyAction = "bind-job"
+	ApplyUnbindJob      ApplyAction = "unbind-job"
+)
+
+// ApplyChange is a single change needed to reach the desired state, Target
+// is the app or job of bind and unbind changes and Update the expiration set
+// by update-rule changes.
+type ApplyChange struct {
+	Action   ApplyAction
+	Instance string
+	RuleID   string      `json:",omitempty"`
+	Target   string      `json:",omitempty"`
+	Rule     *Rule       `json:",omitempty"`
+	Update   *RuleUpdate `json:",omitempty"`
+}
+
+// ApplyPlan lists the changes made, or that would be made with DryRun.
+// Modified holds the instances managed by apply that were changed by other
+// means since they were last applied, Unmanaged the instances missing from
+// the desired state that prune left alone since apply does not manage them
+// and Adopted the existing instances that apply took over. SyncJob is the id
+// of the job syncing the changed rules.
+type ApplyPlan struct {
+	DryRun    bool
+	Changes   []ApplyChange
+	Modified  []string `json:",omitempty"`
+	Unmanaged []string `json:",omitempty"`
+	Adopted   []string `json:",omitempty"`
+	SyncJob   string   `json:",omitempty"`
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditRuleCreate AuditAction = "rule.create"
+	AuditRuleUpdate AuditAction = "rule.update"
+	AuditRuleDelete AuditAction = "rule.delete"
+	AuditRuleSync   AuditAction = "rule.sync"
+	AuditRulePurge  AuditAction = "rule.purge"
+	AuditAppSync    AuditAction = "app.sync"
+
+	AuditServiceCreate     AuditAction = "service.create"
+	AuditServiceDelete     AuditAction = "service.delete"
+	AuditServiceBindApp    AuditAction = "service.bind-app"
+	AuditServiceUnbindApp  AuditAction = "service.unbind-app"
+	AuditServiceBindJob    AuditAction = "service.bind-job"
+	AuditServiceUnbindJob  AuditAction = "service.unbind-job"
+	AuditServiceRuleAdd    AuditAction = "service.rule-add"
+	AuditServiceRuleUpdate AuditAction = "service.rule-update"
+	AuditServiceRuleRemove AuditAction = "service.rule-remove"
+	AuditServiceSync       AuditAction = "service.sync"
+)
+
+// AuditEntry records a single mutation, Before and After hold the JSON
+// representation of the changed object when it applies.
+type AuditEntry struct {
+	ID        string
+	Time      time.Time
+	Action    AuditAction
+	Actor     string
+	EventID   string          `json:",omitempty"`
+	RequestID string          `json:",omitempty"`
+	RuleID    string          `json:",omitempty"`
+	Instance  string          `json:",omitempty"`
+	Target    string          `json:",omitempty"`
+	Before    json.RawMessage `json:",omitempty"`
+	After     json.RawMessage `json:",omitempty"`
+}
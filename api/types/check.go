@@ -0,0 +1,28 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+// CheckTarget is the destination of a connectivity check. Host is either an
+// IP address or a DNS name, a zero Port matches any port.
+type CheckTarget struct {
+	Host     string
+	Port     uint16
+	Protocol string
+}
+
+// CheckMatch is a rule allowing the checked connection along with the latest
+// sync of the rule in each engine.
+type CheckMatch struct {
+	Rule        Rule
+	LatestSyncs map[string]RuleSyncData `json:",omitempty"`
+}
+
+// CheckResult is the outcome of a connectivity check, Reason explains why
+// no rule matched.
+type CheckResult struct {
+	Allowed bool
+	Matches []CheckMatch
+	Reason  string `json:",omitempty"`
+}
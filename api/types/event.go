@@ -0,0 +1,21 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+type RuleEventType string
+
+const (
+	RuleEventCreated RuleEventType = "created"
+	RuleEventUpdated RuleEventType = "updated"
+	RuleEventRemoved RuleEventType = "removed"
+)
+
+// RuleEvent is a change made to a rule. Revision is an opaque cursor, a
+// watch started from it receives only the events after this one.
+type RuleEvent struct {
+	Revision string
+	Type     RuleEventType
+	Rule     Rule
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import "time"
+
+// ExportVersion is the version of the export document written by this
+// version of acl-api, documents with other versions are rejected on import.
+const ExportVersion = 1
+
+// ExportDocument holds the rules and service instances of an acl-api
+// installation. Rules derived from service instances are not included in
+// Rules, they are created again from the instances on import.
+type ExportDocument struct {
+	Version          int
+	ExportedAt       time.Time
+	Rules            []Rule
+	ServiceInstances []ServiceInstance
+}
+
+type ImportKind string
+
+const (
+	ImportKindRule            ImportKind = "rule"
+	ImportKindServiceInstance ImportKind = "service-instance"
+	ImportKindServiceRule     ImportKind = "service-rule"
+	ImportKindServiceApp      ImportKind = "service-app"
+	ImportKindServiceJob      ImportKind = "service-job"
+)
+
+type ImportStatus string
+
+const (
+	ImportCreated   ImportStatus = "created"
+	ImportDuplicate ImportStatus = "duplicate"
+	ImportRejected  ImportStatus = "rejected"
+)
+
+// ImportItem is the outcome of importing one entry of an export document,
+// Target is the app or job bound for service-app and service-job items.
+type ImportItem struct {
+	Kind     ImportKind
+	Status   ImportStatus
+	RuleID   string `json:",omitempty"`
+	Instance string `json:",omitempty"`
+	Target   string `json:",omitempty"`
+	Reason   string `json:",omitempty"`
+	Rule     *Rule  `json:",omitempty"`
+}
+
+// ImportReport lists what was imported, with DryRun nothing is changed and
+// the report lists what would happen. SyncJob is the id of the job syncing
+// the imported rules.
+type ImportReport struct {
+	DryRun     bool
+	Created    int
+	Duplicates int
+	Rejected   int
+	Items      []ImportItem
+	SyncJob    string `json:",omitempty"`
+}
+
+func (r *ImportReport) Add(item ImportItem) {
+	switch item.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportDuplicate:
+		r.Duplicates++
+	case ImportRejected:
+		r.Rejected++
+	}
+	r.Items = append(r.Items, item)
+}
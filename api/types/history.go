@@ -0,0 +1,96 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RuleUpdate holds the fields that may be changed in an existing rule, nil
+// fields are left unchanged. ClearExpiresAt removes the expiration of the
+// rule, which a nil ExpiresAt cannot express.
+type RuleUpdate struct {
+	Source         *RuleType
+	Destination    *RuleType
+	ExpiresAt      *time.Time
+	ClearExpiresAt bool `json:",omitempty"`
+}
+
+// ValidateExpiration checks that the update sets an expiration in the future
+// or clears it, but not both.
+func (u RuleUpdate) ValidateExpiration(now time.Time) error {
+	if u.ExpiresAt != nil && u.ClearExpiresAt {
+		return errors.New("ExpiresAt and ClearExpiresAt are mutually exclusive")
+	}
+	if u.ExpiresAt != nil && !u.ExpiresAt.After(now) {
+		return errors.New("ExpiresAt must be in the future")
+	}
+	return nil
+}
+
+// Apply changes r and returns the name of the fields that were modified.
+func (u RuleUpdate) Apply(r *Rule) []string {
+	var changed []string
+	if u.Source != nil && !u.Source.equivalent(r.Source) {
+		r.Source = *u.Source
+		changed = append(changed, "Source")
+	}
+	if u.Destination != nil && !u.Destination.equivalent(r.Destination) {
+		r.Destination = *u.Destination
+		changed = append(changed, "Destination")
+	}
+	if u.ExpiresAt != nil && (r.ExpiresAt == nil || !u.ExpiresAt.Equal(*r.ExpiresAt)) {
+		expiresAt := *u.ExpiresAt
+		r.ExpiresAt = &expiresAt
+		changed = append(changed, "ExpiresAt")
+	}
+	if u.ClearExpiresAt && r.ExpiresAt != nil {
+		r.ExpiresAt = nil
+		changed = append(changed, "ExpiresAt")
+	}
+	return changed
+}
+
+// RuleChange is a versioned entry in the history of a rule, it records who
+// changed the rule and the values replaced by the change.
+type RuleChange struct {
+	RuleID              string
+	Version             int
+	Time                time.Time
+	User                string
+	ChangedFields       []string
+	PreviousSource      RuleType
+	PreviousDestination RuleType
+	PreviousExpiresAt   *time.Time `json:",omitempty"`
+}
+
+// equivalent compares rule types ignoring the difference between nil and
+// empty port lists, which storages do not preserve.
+func (rt RuleType) equivalent(other RuleType) bool {
+	a, errA := json.Marshal(rt.withPorts())
+	b, errB := json.Marshal(other.withPorts())
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(rt, other)
+	}
+	return string(a) == string(b)
+}
+
+func (rt RuleType) withPorts() RuleType {
+	if rt.ExternalDNS != nil && rt.ExternalDNS.Ports == nil {
+		dns := *rt.ExternalDNS
+		dns.Ports = ProtoPorts{}
+		rt.ExternalDNS = &dns
+	}
+	if rt.ExternalIP != nil && rt.ExternalIP.Ports == nil {
+		ip := *rt.ExternalIP
+		ip.Ports = ProtoPorts{}
+		rt.ExternalIP = &ip
+	}
+	return rt
+}
@@ -0,0 +1,22 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import "time"
+
+// ResolvedRule holds the addresses currently resolved for the ExternalDNS
+// destination of a rule and the networks engines enforce for them.
+type ResolvedRule struct {
+	RuleID           string
+	Name             string
+	SyncWholeNetwork bool
+	Addresses        []ResolvedAddress
+	Networks         []string
+}
+
+type ResolvedAddress struct {
+	IP         string
+	ValidUntil time.Time
+}
@@ -18,6 +18,14 @@ import (
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// maxIPv4NetworkPrefix and maxIPv6NetworkPrefix are the largest networks
+// allowed in ExternalIP rules without ports, a /56 is the usual IPv6
+// allocation for a whole site.
+const (
+	maxIPv4NetworkPrefix = 22
+	maxIPv6NetworkPrefix = 56
+)
+
 var tsuruNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,39}$`)
 
 type Rule struct {
@@ -29,17 +37,40 @@ type Rule struct {
 	Metadata    map[string]string
 	Created     time.Time
 	Creator     string
+	ExpiresAt   *time.Time `json:",omitempty"`
+	RemovedAt   *time.Time `json:",omitempty"`
+}
+
+// Expired reports whether the rule has an expiration time not after now.
+func (r *Rule) Expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !r.ExpiresAt.After(now)
+}
+
+// ValidateExpiration checks that a rule being created does not expire before
+// now.
+func (r *Rule) ValidateExpiration(now time.Time) error {
+	if r.Expired(now) {
+		return errors.New("ExpiresAt must be in the future")
+	}
+	return nil
 }
 
+// RuleSyncInfo holds the latest syncs of a rule in an engine. Attempts counts
+// the consecutive failed syncs and NextRetryTime, when set, is when the rule
+// is retried, regular syncs wait until then. Drift is the result of the latest
+// check of the rule in the engine, for engines able to verify it.
 type RuleSyncInfo struct {
-	SyncID    string
-	RuleID    string
-	Engine    string
-	StartTime time.Time
-	EndTime   time.Time
-	PingTime  time.Time
-	Running   bool
-	Syncs     []RuleSyncData
+	SyncID        string
+	RuleID        string
+	Engine        string
+	StartTime     time.Time
+	EndTime       time.Time
+	PingTime      time.Time
+	Running       bool
+	Syncs         []RuleSyncData
+	Attempts      int        `json:",omitempty"`
+	NextRetryTime time.Time  `json:",omitempty"`
+	Drift         *RuleDrift `json:",omitempty"`
 }
 
 func (rsi RuleSyncInfo) LatestSync() *RuleSyncData {
@@ -49,12 +80,32 @@ func (rsi RuleSyncInfo) LatestSync() *RuleSyncData {
 	return &rsi.Syncs[len(rsi.Syncs)-1]
 }
 
+// RuleDrift compares the actual state of a rule in an engine with the desired
+// one, Differences lists what does not match and Error is set when the actual
+// state could not be checked.
+type RuleDrift struct {
+	CheckTime   time.Time
+	Drifted     bool
+	Differences []string `json:",omitempty"`
+	Error       string   `json:",omitempty"`
+}
+
+// RulePreview is what syncing a rule does in an engine, rendered without
+// applying it. Output is empty when the rule is ignored by the engine.
+type RulePreview struct {
+	RuleID string
+	Engine string
+	Output string
+}
+
 type RuleSyncData struct {
 	StartTime  time.Time
 	EndTime    time.Time
 	Successful bool
 	Removed    bool
 	Error      string
+	// Permanent is set when Error is not expected to go away by retrying.
+	Permanent  bool `json:",omitempty"`
 	SyncResult string
 }
 
@@ -135,26 +186,18 @@ func (r *RuleType) Validate() error {
 		if r.ExternalIP.IP == "" {
 			return errors.New("cannot have empty external ip address")
 		}
-		ipToValidate := r.ExternalIP.IP
-		if !strings.Contains(ipToValidate, "/") {
-			if strings.Contains(ipToValidate, ":") {
-				ipToValidate += "/128"
-			} else if strings.Contains(ipToValidate, ".") {
-				ipToValidate += "/32"
-			}
-		}
-		_, ipNet, err := net.ParseCIDR(ipToValidate)
+		ipNet, err := ParseExternalIP(r.ExternalIP.IP)
 		if err != nil {
 			return errors.New("IP Rule: Invalid IP, " + err.Error())
 		}
 
 		ones, bits := ipNet.Mask.Size()
+		maxPrefix := maxIPv4NetworkPrefix
 		if bits == 128 {
-			return errors.New("IP Rule: Invalid IP, IPv6 is not supported yet")
+			maxPrefix = maxIPv6NetworkPrefix
 		}
-
-		if ones < 22 && bits == 32 && len(r.ExternalIP.Ports) == 0 {
-			return errors.New("IP Rule: Large CIDR, the maximum size of network without ports is /22")
+		if ones < maxPrefix && len(r.ExternalIP.Ports) == 0 {
+			return errors.Errorf("IP Rule: Large CIDR, the maximum size of network without ports is /%d", maxPrefix)
 		}
 		countSet++
 	}
@@ -225,6 +268,9 @@ func validatePorts(ports []ProtoPort) error {
 		if p.Port == 0 {
 			return errors.Errorf("invalid port number 0")
 		}
+		if p.EndPort != 0 && p.EndPort < p.Port {
+			return errors.Errorf("invalid port range %d-%d, end port must not be lower than the port", p.Port, p.EndPort)
+		}
 		if _, isValid := validProtos[strings.ToUpper(p.Protocol)]; isValid {
 			continue
 		}
@@ -244,12 +290,38 @@ func validateTsuruName(name string) bool {
 
 type ProtoPorts []ProtoPort
 
+// ProtoPort allows a single port or, when EndPort is set, every port from
+// Port to EndPort inclusive, like endPort in Kubernetes NetworkPolicies.
 type ProtoPort struct {
 	Protocol string
 	Port     uint16
+	EndPort  uint16 `json:",omitempty" bson:",omitempty"`
+}
+
+// Range returns the first and last ports allowed.
+func (p ProtoPort) Range() (uint16, uint16) {
+	if p.EndPort < p.Port {
+		return p.Port, p.Port
+	}
+	return p.Port, p.EndPort
+}
+
+// IsRange reports whether more than one port is allowed.
+func (p ProtoPort) IsRange() bool {
+	start, end := p.Range()
+	return start != end
+}
+
+// Contains reports whether port is in the allowed ports.
+func (p ProtoPort) Contains(port uint16) bool {
+	start, end := p.Range()
+	return port >= start && port <= end
 }
 
 func (p ProtoPort) String() string {
+	if p.IsRange() {
+		return fmt.Sprintf("%s:%d-%d", p.Protocol, p.Port, p.EndPort)
+	}
 	return fmt.Sprintf("%s:%d", p.Protocol, p.Port)
 }
 
@@ -295,7 +367,7 @@ func prettyPorts(ports []ProtoPort) string {
 	}
 	strs := make([]string, len(ports))
 	for i, p := range ports {
-		strs[i] = fmt.Sprintf("%s:%d", p.Protocol, p.Port)
+		strs[i] = p.String()
 	}
 	sort.Strings(strs)
 	return fmt.Sprintf(", Ports: %s", strings.Join(strs, ", "))
@@ -377,7 +449,7 @@ func (t *ExternalIPRule) Equals(other *ExternalIPRule) bool {
 	if other == nil {
 		return false
 	}
-	if t.IP != other.IP {
+	if NormalizeIP(t.IP) != NormalizeIP(other.IP) {
 		return false
 	}
 
@@ -392,34 +464,61 @@ func (t *ExternalIPRule) Equals(other *ExternalIPRule) bool {
 	return true
 }
 
+// ParseExternalIP parses an IPv4 or IPv6 address or CIDR, addresses are
+// returned as single address networks.
+func ParseExternalIP(ip string) (*net.IPNet, error) {
+	if !strings.Contains(ip, "/") {
+		if strings.Contains(ip, ":") {
+			ip += "/128"
+		} else if strings.Contains(ip, ".") {
+			ip += "/32"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(ip)
+	return ipNet, err
+}
+
+// NormalizeIP returns the canonical CIDR of an address or network, so
+// equivalent representations like 2001:DB8::1 and 2001:db8:0::1/128 are
+// equal. Invalid values are returned unchanged.
+func NormalizeIP(ip string) string {
+	ipNet, err := ParseExternalIP(strings.TrimSpace(ip))
+	if err != nil {
+		return ip
+	}
+	return ipNet.String()
+}
+
 func (p ProtoPorts) Equals(other ProtoPorts) bool {
 	if len(p) != len(other) {
 		return false
 	}
 
-	originTCPPorts := make(map[uint16]struct{})
-	originUDPPorts := make(map[uint16]struct{})
+	originTCPPorts := make(map[[2]uint16]struct{})
+	originUDPPorts := make(map[[2]uint16]struct{})
 
-	otherTCPPorts := make(map[uint16]struct{})
-	otherUDPPorts := make(map[uint16]struct{})
+	otherTCPPorts := make(map[[2]uint16]struct{})
+	otherUDPPorts := make(map[[2]uint16]struct{})
 
 	for _, port := range p {
+		start, end := port.Range()
 		if strings.ToLower(port.Protocol) == "tcp" {
-			originTCPPorts[port.Port] = struct{}{}
+			originTCPPorts[[2]uint16{start, end}] = struct{}{}
 		}
 
 		if strings.ToLower(port.Protocol) == "udp" {
-			originUDPPorts[port.Port] = struct{}{}
+			originUDPPorts[[2]uint16{start, end}] = struct{}{}
 		}
 	}
 
 	for _, port := range other {
+		start, end := port.Range()
 		if strings.ToLower(port.Protocol) == "tcp" {
-			otherTCPPorts[port.Port] = struct{}{}
+			otherTCPPorts[[2]uint16{start, end}] = struct{}{}
 		}
 
 		if strings.ToLower(port.Protocol) == "udp" {
-			otherUDPPorts[port.Port] = struct{}{}
+			otherUDPPorts[[2]uint16{start, end}] = struct{}{}
 		}
 	}
 
@@ -5,7 +5,9 @@
 package types
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -107,7 +109,58 @@ func TestValidateRuleType(t *testing.T) {
 					},
 				},
 			},
-			expected: `IP Rule: Invalid IP, IPv6 is not supported yet`,
+		},
+		{
+			rt: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8:a0b:12f0::1/32",
+				},
+			},
+			expected: `IP Rule: Large CIDR, the maximum size of network without ports is /56`,
+		},
+		{
+			rt: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8:a0b:1200::/56",
+				},
+			},
+		},
+		{
+			rt: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:DB8::1",
+				},
+			},
+		},
+		{
+			rt: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8::zz",
+				},
+			},
+			expected: `IP Rule: Invalid IP, invalid CIDR address: 2001:db8::zz/128`,
+		},
+		{
+			rt: RuleType{
+				ExternalDNS: &ExternalDNSRule{
+					Name: "a.com",
+					Ports: []ProtoPort{
+						{Protocol: "TCP", Port: 8000, EndPort: 8080},
+						{Protocol: "UDP", Port: 53, EndPort: 53},
+					},
+				},
+			},
+		},
+		{
+			rt: RuleType{
+				ExternalDNS: &ExternalDNSRule{
+					Name: "a.com",
+					Ports: []ProtoPort{
+						{Protocol: "TCP", Port: 8080, EndPort: 8000},
+					},
+				},
+			},
+			expected: `invalid port range 8080-8000, end port must not be lower than the port`,
 		},
 		{
 			rt: RuleType{
@@ -237,6 +290,62 @@ func TestEqualRuleType(t *testing.T) {
 			expected: false,
 		},
 
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:DB8:0::1",
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8::1/128",
+				},
+			},
+			expected: true,
+		},
+
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8::/64",
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8:0:0:ffff::/64",
+				},
+			},
+			expected: true,
+		},
+
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1/32",
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1",
+				},
+			},
+			expected: true,
+		},
+
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8::1",
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "2001:db8::2",
+				},
+			},
+			expected: false,
+		},
+
 		{
 			rt1: RuleType{
 				ExternalDNS: &ExternalDNSRule{
@@ -294,6 +403,47 @@ func TestEqualRuleType(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1",
+					Ports: ProtoPorts{
+						{Protocol: "TCP", Port: 80, EndPort: 80},
+						{Protocol: "TCP", Port: 8000, EndPort: 8080},
+					},
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1",
+					Ports: ProtoPorts{
+						{Protocol: "tcp", Port: 8000, EndPort: 8080},
+						{Protocol: "tcp", Port: 80},
+					},
+				},
+			},
+			expected: true,
+		},
+
+		{
+			rt1: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1",
+					Ports: ProtoPorts{
+						{Protocol: "TCP", Port: 8000, EndPort: 8080},
+					},
+				},
+			},
+			rt2: RuleType{
+				ExternalIP: &ExternalIPRule{
+					IP: "10.0.0.1",
+					Ports: ProtoPorts{
+						{Protocol: "TCP", Port: 8000, EndPort: 8081},
+					},
+				},
+			},
+			expected: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -302,3 +452,125 @@ func TestEqualRuleType(t *testing.T) {
 		})
 	}
 }
+
+func TestRuleExpired(t *testing.T) {
+	now := time.Date(2023, 5, 10, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Second)
+	future := now.Add(time.Second)
+	tests := []struct {
+		expiresAt *time.Time
+		expired   bool
+	}{
+		{expiresAt: nil, expired: false},
+		{expiresAt: &past, expired: true},
+		{expiresAt: &now, expired: true},
+		{expiresAt: &future, expired: false},
+	}
+	for _, tt := range tests {
+		r := Rule{ExpiresAt: tt.expiresAt}
+		assert.Equal(t, tt.expired, r.Expired(now))
+		err := r.ValidateExpiration(now)
+		if tt.expired {
+			assert.EqualError(t, err, "ExpiresAt must be in the future")
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestRuleUpdateApply(t *testing.T) {
+	expiresAt := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	r := Rule{
+		RuleID: "r1",
+		Source: RuleType{TsuruApp: &TsuruAppRule{AppName: "app1"}},
+		Destination: RuleType{
+			ExternalIP: &ExternalIPRule{IP: "10.0.0.1", Ports: ProtoPorts{}},
+		},
+	}
+	changed := RuleUpdate{
+		Source:      &RuleType{TsuruApp: &TsuruAppRule{AppName: "app1"}},
+		Destination: &RuleType{ExternalIP: &ExternalIPRule{IP: "10.0.0.1"}},
+	}.Apply(&r)
+	assert.Len(t, changed, 0)
+
+	changed = RuleUpdate{
+		Destination: &RuleType{ExternalIP: &ExternalIPRule{IP: "10.0.0.2"}},
+		ExpiresAt:   &expiresAt,
+	}.Apply(&r)
+	assert.Equal(t, []string{"Destination", "ExpiresAt"}, changed)
+	assert.Equal(t, "10.0.0.2", r.Destination.ExternalIP.IP)
+	assert.Equal(t, "app1", r.Source.TsuruApp.AppName)
+	assert.Equal(t, &expiresAt, r.ExpiresAt)
+
+	changed = RuleUpdate{ClearExpiresAt: true}.Apply(&r)
+	assert.Equal(t, []string{"ExpiresAt"}, changed)
+	assert.Nil(t, r.ExpiresAt)
+	changed = RuleUpdate{ClearExpiresAt: true}.Apply(&r)
+	assert.Len(t, changed, 0)
+}
+
+func TestRuleUpdateValidateExpiration(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+	assert.NoError(t, RuleUpdate{}.ValidateExpiration(now))
+	assert.NoError(t, RuleUpdate{ExpiresAt: &future}.ValidateExpiration(now))
+	assert.NoError(t, RuleUpdate{ClearExpiresAt: true}.ValidateExpiration(now))
+	assert.EqualError(t, RuleUpdate{ExpiresAt: &past}.ValidateExpiration(now), "ExpiresAt must be in the future")
+	assert.EqualError(t, RuleUpdate{ExpiresAt: &future, ClearExpiresAt: true}.ValidateExpiration(now), "ExpiresAt and ClearExpiresAt are mutually exclusive")
+}
+
+func TestProtoPortRange(t *testing.T) {
+	tests := []struct {
+		port     ProtoPort
+		str      string
+		json     string
+		contains []uint16
+		excludes []uint16
+	}{
+		{
+			port:     ProtoPort{Protocol: "TCP", Port: 80},
+			str:      "TCP:80",
+			json:     `{"Protocol":"TCP","Port":80}`,
+			contains: []uint16{80},
+			excludes: []uint16{79, 81},
+		},
+		{
+			port:     ProtoPort{Protocol: "TCP", Port: 80, EndPort: 80},
+			str:      "TCP:80",
+			json:     `{"Protocol":"TCP","Port":80,"EndPort":80}`,
+			contains: []uint16{80},
+			excludes: []uint16{81},
+		},
+		{
+			port:     ProtoPort{Protocol: "UDP", Port: 8000, EndPort: 8080},
+			str:      "UDP:8000-8080",
+			json:     `{"Protocol":"UDP","Port":8000,"EndPort":8080}`,
+			contains: []uint16{8000, 8042, 8080},
+			excludes: []uint16{7999, 8081},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			assert.Equal(t, tt.str, tt.port.String())
+			data, err := json.Marshal(tt.port)
+			require.NoError(t, err)
+			assert.Equal(t, tt.json, string(data))
+			var decoded ProtoPort
+			err = json.Unmarshal(data, &decoded)
+			require.NoError(t, err)
+			assert.Equal(t, tt.port, decoded)
+			for _, p := range tt.contains {
+				assert.True(t, tt.port.Contains(p), "expected %v to contain %d", tt.port, p)
+			}
+			for _, p := range tt.excludes {
+				assert.False(t, tt.port.Contains(p), "expected %v not to contain %d", tt.port, p)
+			}
+		})
+	}
+	rt := RuleType{ExternalDNS: &ExternalDNSRule{Name: "a.com", Ports: ProtoPorts{
+		{Protocol: "TCP", Port: 8000, EndPort: 8080},
+		{Protocol: "TCP", Port: 443},
+	}}}
+	assert.Equal(t, "DNS: a.com, Ports: TCP:443, TCP:8000-8080", rt.String())
+}
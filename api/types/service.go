@@ -21,4 +21,5 @@ type ServiceInstance struct {
 	BindApps     []string
 	BindJobs     []string
 	BaseRules    []ServiceRule
+	Metadata     map[string]string `json:",omitempty"`
 }
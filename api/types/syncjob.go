@@ -0,0 +1,69 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import "time"
+
+type SyncJobStatus string
+
+const (
+	SyncJobQueued  SyncJobStatus = "queued"
+	SyncJobRunning SyncJobStatus = "running"
+	SyncJobDone    SyncJobStatus = "done"
+	// SyncJobSkipped marks targets not synced since the rule was being
+	// synced by another worker or its next sync was not due yet.
+	SyncJobSkipped SyncJobStatus = "skipped"
+)
+
+// SyncJob tracks the sync of a set of rules triggered by a request, with a
+// target for each rule in each enabled engine.
+type SyncJob struct {
+	JobID   string
+	Created time.Time
+	Creator string `json:",omitempty"`
+	Force   bool
+	Status  SyncJobStatus
+	Failed  int
+	Targets []SyncJobTarget
+}
+
+// SyncJobTarget is the sync of a rule in an engine, Error is set when the
+// sync is done and failed.
+type SyncJobTarget struct {
+	RuleID    string
+	Engine    string
+	Status    SyncJobStatus
+	Error     string     `json:",omitempty"`
+	StartTime *time.Time `json:",omitempty"`
+	EndTime   *time.Time `json:",omitempty"`
+}
+
+// Summarize sets the job status from its targets, the job is queued until a
+// target starts and done when every target is done or skipped.
+func (j *SyncJob) Summarize() {
+	j.Failed = 0
+	queued, done := 0, 0
+	for _, t := range j.Targets {
+		switch t.Status {
+		case SyncJobQueued:
+			queued++
+		case SyncJobSkipped:
+			done++
+		case SyncJobDone:
+			done++
+			if t.Error != "" {
+				j.Failed++
+			}
+		}
+	}
+	switch {
+	case done == len(j.Targets):
+		j.Status = SyncJobDone
+	case queued == len(j.Targets):
+		j.Status = SyncJobQueued
+	default:
+		j.Status = SyncJobRunning
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package types
+
+import "time"
+
+type WebhookEventType string
+
+const (
+	WebhookRuleCreated    WebhookEventType = "rule.created"
+	WebhookRuleRemoved    WebhookEventType = "rule.removed"
+	WebhookServiceBound   WebhookEventType = "service.bound"
+	WebhookServiceUnbound WebhookEventType = "service.unbound"
+	WebhookSyncFailed     WebhookEventType = "sync.failed"
+)
+
+// WebhookEvent is the body sent to webhooks, only the fields related to the
+// event type are filled.
+type WebhookEvent struct {
+	ID       string
+	Type     WebhookEventType
+	Time     time.Time
+	Actor    string `json:",omitempty"`
+	Rule     *Rule  `json:",omitempty"`
+	Instance string `json:",omitempty"`
+	App      string `json:",omitempty"`
+	Job      string `json:",omitempty"`
+	Engine   string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// WebhookDelivery is the outcome of sending an event to a webhook, after all
+// attempts were made.
+type WebhookDelivery struct {
+	ID         string
+	EventID    string
+	EventType  WebhookEventType
+	URL        string
+	Time       time.Time
+	Attempts   int
+	Successful bool
+	StatusCode int    `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
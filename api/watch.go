@@ -0,0 +1,141 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+// watchKeepAlive is the interval of the comments sent to keep idle watch
+// connections open through proxies.
+var watchKeepAlive = 30 * time.Second
+
+type watchResult struct {
+	event types.RuleEvent
+	err   error
+}
+
+// watchRules streams rule events as Server-Sent Events. The stream starts
+// with a bookmark event holding the current revision, clients resume from
+// the last event received with the revision query parameter or the
+// Last-Event-ID header.
+func watchRules(c echo.Context) error {
+	opts := storage.FindOpts{
+		Creator:         c.QueryParam("creator"),
+		SourceTsuruApp:  c.QueryParam("app"),
+		SourceTsuruJob:  c.QueryParam("job"),
+		SourceTsuruPool: c.QueryParam("pool"),
+	}
+	for key, values := range c.QueryParams() {
+		if !strings.HasPrefix(key, "metadata.") || len(values) == 0 {
+			continue
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]string{}
+		}
+		opts.Metadata[strings.TrimPrefix(key, "metadata.")] = values[0]
+	}
+	revision := c.QueryParam("revision")
+	if revision == "" {
+		revision = c.Request().Header.Get("Last-Event-ID")
+	}
+
+	svc := rule.GetService()
+	watch, err := svc.Watch(opts, revision)
+	if err == storage.ErrInvalidRevision {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err == storage.ErrRevisionExpired {
+		return echo.NewHTTPError(http.StatusGone, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	defer watch.Close()
+
+	rsp := c.Response()
+	rsp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	rsp.Header().Set("Cache-Control", "no-cache")
+	rsp.WriteHeader(http.StatusOK)
+	err = writeEvent(rsp, watch.Revision(), "bookmark", map[string]string{"Revision": watch.Revision()})
+	if err != nil {
+		return nil
+	}
+
+	// the reader must be gone before the deferred Close, it may be blocked
+	// in Next when writing to the client fails
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	results := make(chan watchResult)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		for {
+			event, err := watch.Next(ctx)
+			select {
+			case results <- watchResult{event: event, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive.C:
+			_, err = io.WriteString(rsp, ": keepalive\n\n")
+			rsp.Flush()
+		case result := <-results:
+			if result.err != nil {
+				writeEvent(rsp, "", "error", map[string]string{"message": result.err.Error()})
+				return nil
+			}
+			err = writeEvent(rsp, result.event.Revision, string(result.event.Type), result.event)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+func writeEvent(rsp *echo.Response, id, event string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, err = fmt.Fprintf(rsp, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(rsp, "event: %s\ndata: %s\n\n", event, body)
+	if err != nil {
+		return err
+	}
+	rsp.Flush()
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if ev.event != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func Test_watchRules(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	oldKeepAlive := watchKeepAlive
+	defer func() { watchKeepAlive = oldKeepAlive }()
+	watchKeepAlive = 50 * time.Millisecond
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	newRule := func(id, app string) *types.Rule {
+		return &types.Rule{
+			RuleID: id,
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: app},
+			},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1"},
+			},
+			Metadata: map[string]string{"team": "t1"},
+		}
+	}
+
+	rsp, err := http.Get(srv.URL + "/rules/watch?app=app1&metadata.team=t1")
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(rsp.Body)
+
+	bookmark := readEvent(t, reader)
+	assert.Equal(t, "bookmark", bookmark.event)
+	assert.NotEmpty(t, bookmark.id)
+
+	svc := rule.GetService()
+	err = svc.Save([]*types.Rule{newRule("r1", "app2"), newRule("r2", "app1")}, false)
+	require.Nil(t, err)
+	err = svc.Delete("r2")
+	require.Nil(t, err)
+
+	ev := readEvent(t, reader)
+	assert.Equal(t, "created", ev.event)
+	var event types.RuleEvent
+	err = json.Unmarshal([]byte(ev.data), &event)
+	require.Nil(t, err)
+	assert.Equal(t, "r2", event.Rule.RuleID)
+	assert.Equal(t, types.RuleEventCreated, event.Type)
+	assert.Equal(t, ev.id, event.Revision)
+	created := ev.id
+
+	ev = readEvent(t, reader)
+	assert.Equal(t, "removed", ev.event)
+
+	req, err := http.NewRequest("GET", srv.URL+"/rules/watch", nil)
+	require.Nil(t, err)
+	req.Header.Set("Last-Event-ID", created)
+	resumed, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resumed.Body.Close()
+	resumedReader := bufio.NewReader(resumed.Body)
+	assert.Equal(t, "bookmark", readEvent(t, resumedReader).event)
+	ev = readEvent(t, resumedReader)
+	assert.Equal(t, "removed", ev.event)
+
+	line, err := resumedReader.ReadString('\n')
+	require.Nil(t, err)
+	assert.Equal(t, ": keepalive\n", line)
+}
+
+func Test_watchRulesInvalidRevision(t *testing.T) {
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/rules/watch?revision=invalid")
+	require.Nil(t, err)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const defaultDeliveriesLimit = 100
+
+func listWebhookDeliveries(c echo.Context) error {
+	opts := storage.WebhookDeliveryFindOpts{
+		EventID:   c.QueryParam("event-id"),
+		EventType: types.WebhookEventType(c.QueryParam("event")),
+		URL:       c.QueryParam("url"),
+		Limit:     defaultDeliveriesLimit,
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		var err error
+		opts.Limit, err = strconv.Atoi(raw)
+		if err != nil || opts.Limit <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+	stor, err := storage.GetWebhookDeliveryStorage()
+	if err != nil {
+		return err
+	}
+	deliveries, err := stor.Find(opts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, deliveries)
+}
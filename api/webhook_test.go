@@ -0,0 +1,110 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/webhook"
+)
+
+func Test_webhooks(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+
+	var (
+		mu     sync.Mutex
+		events []types.WebhookEvent
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, webhook.Sign("s3cret", body), r.Header.Get(webhook.SignatureHeader))
+		var event types.WebhookEvent
+		assert.Nil(t, json.Unmarshal(body, &event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	defer resetViper()
+	viper.Set("auth.user", "admin")
+	viper.Set("auth.password", "secret")
+	viper.Set("webhook.retry_interval", time.Millisecond)
+	viper.Set("webhooks", []map[string]interface{}{
+		{"url": receiver.URL, "secret": "s3cret", "events": []string{"rule.created", "rule.removed"}},
+	})
+
+	e := setupEcho()
+	srv := httptest.NewServer(e.Server.Handler)
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.Nil(t, err)
+		req.SetBasicAuth("admin", "secret")
+		req.Header.Set("Content-Type", "application/json")
+		rsp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return rsp
+	}
+
+	rsp := do("POST", "/rules", `{
+		"source": {"tsuruapp": {"appname": "myapp"}},
+		"destination": {"externalip": {"ip": "10.0.0.1"}}
+	}`)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusCreated, rsp.StatusCode)
+	var r types.Rule
+	err = json.NewDecoder(rsp.Body).Decode(&r)
+	require.Nil(t, err)
+	rsp = do("DELETE", "/rules/"+r.RuleID, "")
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	webhook.Wait()
+
+	mu.Lock()
+	require.Len(t, events, 2)
+	ruleIDs := map[types.WebhookEventType]string{}
+	for _, ev := range events {
+		require.NotNil(t, ev.Rule)
+		ruleIDs[ev.Type] = ev.Rule.RuleID
+		assert.Equal(t, "admin", ev.Actor)
+	}
+	mu.Unlock()
+	assert.Len(t, ruleIDs, 2)
+	assert.Equal(t, r.RuleID, ruleIDs["rule.created"])
+	assert.Equal(t, r.RuleID, ruleIDs["rule.removed"])
+
+	rsp = do("GET", "/webhooks/deliveries?event=rule.removed", "")
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var deliveries []types.WebhookDelivery
+	err = json.NewDecoder(rsp.Body).Decode(&deliveries)
+	require.Nil(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, receiver.URL, deliveries[0].URL)
+	assert.True(t, deliveries[0].Successful)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+
+	rsp = do("GET", "/webhooks/deliveries?limit=0", "")
+	rsp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
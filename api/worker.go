@@ -5,9 +5,165 @@
 package api
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/google/gops/agent"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/gc"
+	"github.com/tsuru/acl-api/resolver"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/syncjob"
+	"github.com/tsuru/acl-api/webhook"
 )
 
+// worker periodically feeds every stored rule through the enabled engines,
+// retrying syncs that were missed or failed when triggered by the API. Rules
+// past their expiration time are removed before each reconciliation and
+// rules whose ExternalDNS addresses changed are synced first, forcibly.
+// Every gcInterval removed rules whose removal was synced are purged, along
+// with old sync jobs, and every driftInterval the actual state of the rules is
+// checked in the engines able to verify it.
+type worker struct {
+	interval   time.Duration
+	ruleSvc    rule.EngineRuleService
+	syncFn     func(rules []types.Rule, force bool)
+	expireFn   func(now time.Time) ([]types.Rule, error)
+	resolveFn  func(rules []types.Rule) ([]types.Rule, error)
+	gcInterval time.Duration
+	gcFn       func(now time.Time) ([]types.Rule, error)
+	lastGC     time.Time
+
+	driftInterval time.Duration
+	driftFn       func(rules []types.Rule) error
+	lastDrift     time.Time
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+}
+
+func newWorker() *worker {
+	interval := viper.GetDuration("sync.interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &worker{
+		interval: interval,
+		ruleSvc:  rule.GetServiceForEngine(),
+		syncFn:   engine.ReconcileRules,
+		expireFn: rule.GetService().DeleteExpired,
+		resolveFn: func(rules []types.Rule) ([]types.Rule, error) {
+			return resolver.Refresh(context.TODO(), rules)
+		},
+		gcInterval: viper.GetDuration("gc.interval"),
+		gcFn: func(now time.Time) ([]types.Rule, error) {
+			err := syncjob.Purge(now.Add(-viper.GetDuration("sync.job_retention")))
+			if err != nil {
+				return nil, err
+			}
+			return gc.Purge(engine.Enabled(), now, gc.Options{Retention: viper.GetDuration("gc.retention")})
+		},
+		driftInterval: viper.GetDuration("drift.interval"),
+		driftFn:       engine.CheckDrift,
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.doneCh)
+	logger := logrus.WithField("source", "worker")
+	for {
+		w.reconcile(logger)
+		select {
+		case <-w.stopCh:
+			logger.Info("worker stopped")
+			return
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+func (w *worker) reconcile(logger *logrus.Entry) {
+	expired, err := w.expireFn(time.Now().UTC())
+	if err != nil {
+		logger.Errorf("unable to remove expired rules: %v", err)
+	}
+	if len(expired) > 0 {
+		logger.Infof("removed %d expired rules", len(expired))
+	}
+	for i := range expired {
+		recordSystemAudit(WorkerActor, types.AuditEntry{Action: types.AuditRuleDelete, RuleID: expired[i].RuleID}, expired[i], nil)
+		webhook.Notify(types.WebhookEvent{Type: types.WebhookRuleRemoved, Actor: WorkerActor, Rule: &expired[i]})
+	}
+	// expired rules are returned as removed and synced with the others
+	rules, err := w.ruleSvc.FindAll()
+	if err != nil {
+		logger.Errorf("unable to list rules: %v", err)
+		return
+	}
+	changed, err := w.resolveFn(rules)
+	if err != nil {
+		logger.Errorf("unable to resolve rule names: %v", err)
+	}
+	if len(changed) > 0 {
+		logger.Infof("syncing %d rules with changed addresses", len(changed))
+		w.syncFn(changed, true)
+	}
+	logger.Infof("reconciling %d rules", len(rules))
+	w.syncFn(rules, false)
+	w.purge(logger)
+	w.checkDrift(logger, rules)
+}
+
+// purge runs the garbage collection of removed rules when gcInterval has
+// passed since the last run, a zero gcInterval disables it.
+func (w *worker) purge(logger *logrus.Entry) {
+	now := time.Now().UTC()
+	if w.gcInterval <= 0 || now.Sub(w.lastGC) < w.gcInterval {
+		return
+	}
+	w.lastGC = now
+	purged, err := w.gcFn(now)
+	if err != nil {
+		logger.Errorf("unable to purge removed rules: %v", err)
+		return
+	}
+	if len(purged) > 0 {
+		logger.Infof("purged %d removed rules", len(purged))
+	}
+	RecordPurge(WorkerActor, purged)
+}
+
+// checkDrift compares the actual state of rules with the desired one when
+// driftInterval has passed since the last check, a zero driftInterval
+// disables it.
+func (w *worker) checkDrift(logger *logrus.Entry, rules []types.Rule) {
+	now := time.Now().UTC()
+	if w.driftInterval <= 0 || now.Sub(w.lastDrift) < w.driftInterval {
+		return
+	}
+	w.lastDrift = now
+	err := w.driftFn(rules)
+	if err != nil {
+		logger.Errorf("unable to check rules drift: %v", err)
+	}
+}
+
+// stop signals the worker to exit and waits for the in-flight reconciliation
+// to finish.
+func (w *worker) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	<-w.doneCh
+}
+
 func StartWorker() error {
 	if err := agent.Listen(agent.Options{}); err != nil {
 		return err
@@ -15,5 +171,10 @@ func StartWorker() error {
 	defer agent.Close()
 
 	setupEngine()
+
+	w := newWorker()
+	go handleSignals(w.stop)
+	w.run()
+	webhook.Wait()
 	return nil
 }
@@ -0,0 +1,192 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/audit"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type fakeEngineRuleService struct {
+	rules []types.Rule
+	err   error
+}
+
+func (s *fakeEngineRuleService) FindAll() ([]types.Rule, error) {
+	return s.rules, s.err
+}
+
+func (s *fakeEngineRuleService) SyncStart(after time.Duration, ruleID, engine string, force bool) (time.Duration, *types.RuleSyncInfo, error) {
+	return 0, nil, errors.New("not implemented")
+}
+
+func (s *fakeEngineRuleService) SyncEnd(ruleSync types.RuleSyncInfo, syncData types.RuleSyncData) error {
+	return errors.New("not implemented")
+}
+
+func Test_worker_run(t *testing.T) {
+	var mu sync.Mutex
+	var calls [][]types.Rule
+	var forced []bool
+	w := newWorker()
+	w.interval = 50 * time.Millisecond
+	w.ruleSvc = &fakeEngineRuleService{
+		rules: []types.Rule{{RuleID: "r1"}, {RuleID: "r2"}},
+	}
+	w.syncFn = func(rules []types.Rule, force bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, rules)
+		forced = append(forced, force)
+	}
+	go w.run()
+	time.Sleep(175 * time.Millisecond)
+	w.stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(calls), 3)
+	for i := range calls {
+		assert.Equal(t, []types.Rule{{RuleID: "r1"}, {RuleID: "r2"}}, calls[i])
+		assert.False(t, forced[i])
+	}
+}
+
+func Test_worker_runListError(t *testing.T) {
+	called := false
+	w := newWorker()
+	w.interval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{err: errors.New("storage down")}
+	w.syncFn = func(rules []types.Rule, force bool) {
+		called = true
+	}
+	go w.run()
+	w.stop()
+	assert.False(t, called)
+}
+
+func Test_worker_stopTwice(t *testing.T) {
+	w := newWorker()
+	w.interval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{}
+	w.syncFn = func(rules []types.Rule, force bool) {}
+	go w.run()
+	w.stop()
+	w.stop()
+}
+
+func Test_worker_runRemovesExpired(t *testing.T) {
+	expired := types.Rule{RuleID: "worker-expired", Removed: true}
+	var expireCalls int
+	var synced [][]types.Rule
+	w := newWorker()
+	w.interval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{
+		rules: []types.Rule{expired, {RuleID: "r2"}},
+	}
+	w.expireFn = func(now time.Time) ([]types.Rule, error) {
+		expireCalls++
+		return []types.Rule{expired}, nil
+	}
+	w.syncFn = func(rules []types.Rule, force bool) {
+		synced = append(synced, rules)
+	}
+	go w.run()
+	w.stop()
+	assert.Equal(t, 1, expireCalls)
+	assert.Equal(t, [][]types.Rule{{expired, {RuleID: "r2"}}}, synced)
+	entries, err := audit.GetService().Find(storage.AuditFindOpts{RuleID: "worker-expired"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, types.AuditRuleDelete, entries[0].Action)
+	assert.Equal(t, WorkerActor, entries[0].Actor)
+}
+
+func Test_worker_runSyncsResolvedChanges(t *testing.T) {
+	r1 := types.Rule{RuleID: "r1", Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}}}
+	r2 := types.Rule{RuleID: "r2"}
+	var synced [][]types.Rule
+	var forced []bool
+	w := newWorker()
+	w.interval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{
+		rules: []types.Rule{r1, r2},
+	}
+	w.resolveFn = func(rules []types.Rule) ([]types.Rule, error) {
+		assert.Equal(t, []types.Rule{r1, r2}, rules)
+		return []types.Rule{r1}, nil
+	}
+	w.syncFn = func(rules []types.Rule, force bool) {
+		synced = append(synced, rules)
+		forced = append(forced, force)
+	}
+	go w.run()
+	w.stop()
+	assert.Equal(t, [][]types.Rule{{r1}, {r1, r2}}, synced)
+	assert.Equal(t, []bool{true, false}, forced)
+}
+
+func Test_worker_runPurgesRemoved(t *testing.T) {
+	var purgeCalls int
+	w := newWorker()
+	w.interval = time.Hour
+	w.gcInterval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{}
+	w.syncFn = func(rules []types.Rule, force bool) {}
+	w.expireFn = func(now time.Time) ([]types.Rule, error) { return nil, nil }
+	w.gcFn = func(now time.Time) ([]types.Rule, error) {
+		purgeCalls++
+		return []types.Rule{{RuleID: "r1", Removed: true}}, nil
+	}
+	logger := logrus.WithField("source", "test")
+	w.reconcile(logger)
+	w.reconcile(logger)
+	assert.Equal(t, 1, purgeCalls)
+
+	w.lastGC = w.lastGC.Add(-time.Hour)
+	w.reconcile(logger)
+	assert.Equal(t, 2, purgeCalls)
+
+	w.gcInterval = 0
+	w.lastGC = time.Time{}
+	w.reconcile(logger)
+	assert.Equal(t, 2, purgeCalls)
+}
+
+func Test_worker_runChecksDrift(t *testing.T) {
+	var checked [][]types.Rule
+	w := newWorker()
+	w.interval = time.Hour
+	w.driftInterval = time.Hour
+	w.ruleSvc = &fakeEngineRuleService{rules: []types.Rule{{RuleID: "r1"}}}
+	w.syncFn = func(rules []types.Rule, force bool) {}
+	w.expireFn = func(now time.Time) ([]types.Rule, error) { return nil, nil }
+	w.driftFn = func(rules []types.Rule) error {
+		checked = append(checked, rules)
+		return nil
+	}
+	logger := logrus.WithField("source", "test")
+	w.reconcile(logger)
+	w.reconcile(logger)
+	assert.Equal(t, [][]types.Rule{{{RuleID: "r1"}}}, checked)
+
+	w.lastDrift = w.lastDrift.Add(-time.Hour)
+	w.reconcile(logger)
+	assert.Len(t, checked, 2)
+
+	w.driftInterval = 0
+	w.lastDrift = time.Time{}
+	w.reconcile(logger)
+	assert.Len(t, checked, 2)
+}
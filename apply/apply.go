@@ -0,0 +1,411 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package apply reconciles service instances with a desired state, making
+// only the changes needed to reach it.
+package apply
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const (
+	// ManagedByKey marks instances created or changed by apply.
+	ManagedByKey   = "managed-by"
+	ManagedByApply = "acl-api-apply"
+
+	// AppliedSpecKey holds a hash of the instance as left by the last apply,
+	// used to detect changes made by other means.
+	AppliedSpecKey = "applied-spec"
+)
+
+// Options changes how the desired state is applied. With Prune instances
+// managed by apply and missing from the desired state are deleted, with Adopt
+// existing instances not managed by apply are taken over instead of refused,
+// User is the creator of the instances and rules created.
+type Options struct {
+	DryRun bool
+	Prune  bool
+	Adopt  bool
+	User   string
+}
+
+// ValidationError is returned when the desired state is invalid, nothing is
+// changed in this case.
+type ValidationError struct {
+	Errors []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid desired state: " + strings.Join(e.Errors, "; ")
+}
+
+// Apply changes the service instances to match doc and returns the changes
+// made, along with the rules that must be synced. When it fails midway the
+// changes already made and their rules are returned with the error.
+func Apply(doc types.ApplyDocument, opts Options) (types.ApplyPlan, []types.Rule, error) {
+	plan := types.ApplyPlan{DryRun: opts.DryRun, Changes: []types.ApplyChange{}}
+	now := time.Now()
+	err := validate(doc, now)
+	if err != nil {
+		return plan, nil, err
+	}
+	stor, err := storage.GetServiceStorage()
+	if err != nil {
+		return plan, nil, err
+	}
+	instances, err := stor.List()
+	if err != nil {
+		return plan, nil, err
+	}
+	current := map[string]types.ServiceInstance{}
+	for _, instance := range instances {
+		current[instance.InstanceName] = instance
+	}
+
+	desired := append([]types.ServiceInstance{}, doc.ServiceInstances...)
+	sort.Slice(desired, func(i, j int) bool {
+		return desired[i].InstanceName < desired[j].InstanceName
+	})
+	var unmanaged []string
+	for _, instance := range desired {
+		existing, exists := current[instance.InstanceName]
+		if exists && existing.Metadata[ManagedByKey] != ManagedByApply {
+			unmanaged = append(unmanaged, instance.InstanceName)
+		}
+	}
+	if len(unmanaged) > 0 {
+		if !opts.Adopt {
+			var errs []string
+			for _, name := range unmanaged {
+				errs = append(errs, fmt.Sprintf("instance %s: not managed by apply, adopt it to take it over", name))
+			}
+			return plan, nil, &ValidationError{Errors: errs}
+		}
+		plan.Adopted = unmanaged
+	}
+
+	var synced []types.Rule
+	for _, instance := range desired {
+		existing, exists := current[instance.InstanceName]
+		if exists && existing.Metadata[ManagedByKey] == ManagedByApply && existing.Metadata[AppliedSpecKey] != specHash(existing) {
+			plan.Modified = append(plan.Modified, instance.InstanceName)
+		}
+		changes := diff(instance, existing, exists, now)
+		if opts.DryRun {
+			plan.Changes = append(plan.Changes, changes...)
+			continue
+		}
+		done, rules, err := execute(instance.InstanceName, changes, opts)
+		plan.Changes = append(plan.Changes, done...)
+		synced = mergeRules(synced, rules)
+		if err != nil {
+			return plan, synced, err
+		}
+		err = markApplied(instance.InstanceName, existing.Metadata)
+		if err != nil {
+			return plan, synced, err
+		}
+	}
+
+	if opts.Prune {
+		desiredNames := map[string]struct{}{}
+		for _, instance := range desired {
+			desiredNames[instance.InstanceName] = struct{}{}
+		}
+		for _, instance := range instances {
+			if _, ok := desiredNames[instance.InstanceName]; ok {
+				continue
+			}
+			if instance.Metadata[ManagedByKey] != ManagedByApply {
+				plan.Unmanaged = append(plan.Unmanaged, instance.InstanceName)
+				continue
+			}
+			change := types.ApplyChange{Action: types.ApplyDeleteInstance, Instance: instance.InstanceName}
+			if !opts.DryRun {
+				err = service.GetService().Delete(instance.InstanceName)
+				if err != nil {
+					return plan, synced, err
+				}
+			}
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	return plan, synced, nil
+}
+
+func validate(doc types.ApplyDocument, now time.Time) error {
+	var errs []string
+	seen := map[string]struct{}{}
+	for i, instance := range doc.ServiceInstances {
+		name := instance.InstanceName
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Sprintf("instance %d: empty instance name", i))
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			errs = append(errs, fmt.Sprintf("instance %s: declared more than once", name))
+		}
+		seen[name] = struct{}{}
+		for j, r := range instance.BaseRules {
+			err := r.Destination.Validate()
+			if err == nil {
+				err = r.ValidateExpiration(now)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("instance %s: rule %d: %v", name, j, err))
+			}
+		}
+		for _, appName := range instance.BindApps {
+			if strings.TrimSpace(appName) == "" {
+				errs = append(errs, fmt.Sprintf("instance %s: empty app name", name))
+			}
+		}
+		for _, jobName := range instance.BindJobs {
+			if strings.TrimSpace(jobName) == "" {
+				errs = append(errs, fmt.Sprintf("instance %s: empty job name", name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// diff returns the changes turning existing into desired, in the order they
+// must be made.
+func diff(desired, existing types.ServiceInstance, exists bool, now time.Time) []types.ApplyChange {
+	name := desired.InstanceName
+	var changes []types.ApplyChange
+	if !exists {
+		changes = append(changes, types.ApplyChange{Action: types.ApplyCreateInstance, Instance: name})
+	}
+
+	var active []types.ServiceRule
+	for _, r := range existing.BaseRules {
+		if !r.Removed && !r.Expired(now) {
+			active = append(active, r)
+		}
+	}
+	usedIDs := map[string]struct{}{}
+	for _, r := range existing.BaseRules {
+		usedIDs[r.RuleID] = struct{}{}
+	}
+	matched := make([]bool, len(active))
+	var unmatched []types.ServiceRule
+	for _, r := range desired.BaseRules {
+		storage.NormalizeRule(&r.Rule)
+		found := false
+		for i := range active {
+			if !matched[i] && sameDestination(active[i], r) && sameExpiration(active[i], r) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, r)
+		}
+	}
+	// rules whose destination is kept and only the expiration changed are
+	// updated in place, keeping their ids
+	var updated []types.ApplyChange
+	var added []types.ServiceRule
+	for _, r := range unmatched {
+		found := false
+		for i := range active {
+			if !matched[i] && sameDestination(active[i], r) {
+				matched[i] = true
+				found = true
+				update := types.RuleUpdate{ExpiresAt: r.ExpiresAt, ClearExpiresAt: r.ExpiresAt == nil}
+				current := active[i].Rule
+				current.ExpiresAt = r.ExpiresAt
+				updated = append(updated, types.ApplyChange{Action: types.ApplyUpdateRule, Instance: name, RuleID: current.RuleID, Rule: &current, Update: &update})
+				break
+			}
+		}
+		if !found {
+			if _, ok := usedIDs[r.RuleID]; ok {
+				// ids are only kept when they are not taken, rules are
+				// matched by destination
+				r.RuleID = ""
+			}
+			added = append(added, r)
+		}
+	}
+	for i, r := range active {
+		if !matched[i] {
+			r := r
+			changes = append(changes, types.ApplyChange{Action: types.ApplyRemoveRule, Instance: name, RuleID: r.RuleID, Rule: &r.Rule})
+		}
+	}
+	changes = append(changes, updated...)
+	for _, r := range added {
+		r := r
+		changes = append(changes, types.ApplyChange{Action: types.ApplyAddRule, Instance: name, RuleID: r.RuleID, Rule: &r.Rule})
+	}
+
+	removedApps, addedApps := diffNames(existing.BindApps, desired.BindApps)
+	for _, appName := range removedApps {
+		changes = append(changes, types.ApplyChange{Action: types.ApplyUnbindApp, Instance: name, Target: appName})
+	}
+	for _, appName := range addedApps {
+		changes = append(changes, types.ApplyChange{Action: types.ApplyBindApp, Instance: name, Target: appName})
+	}
+	removedJobs, addedJobs := diffNames(existing.BindJobs, desired.BindJobs)
+	for _, jobName := range removedJobs {
+		changes = append(changes, types.ApplyChange{Action: types.ApplyUnbindJob, Instance: name, Target: jobName})
+	}
+	for _, jobName := range addedJobs {
+		changes = append(changes, types.ApplyChange{Action: types.ApplyBindJob, Instance: name, Target: jobName})
+	}
+	return changes
+}
+
+func sameDestination(a, b types.ServiceRule) bool {
+	return a.Equals(&b) && b.Equals(&a)
+}
+
+func sameExpiration(a, b types.ServiceRule) bool {
+	if a.ExpiresAt == nil || b.ExpiresAt == nil {
+		return a.ExpiresAt == nil && b.ExpiresAt == nil
+	}
+	return a.ExpiresAt.Equal(*b.ExpiresAt)
+}
+
+// diffNames returns the names only in current and the ones only in desired,
+// sorted.
+func diffNames(current, desired []string) ([]string, []string) {
+	currentSet := map[string]struct{}{}
+	for _, name := range current {
+		currentSet[name] = struct{}{}
+	}
+	desiredSet := map[string]struct{}{}
+	for _, name := range desired {
+		desiredSet[name] = struct{}{}
+	}
+	var removed, added []string
+	for name := range currentSet {
+		if _, ok := desiredSet[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for name := range desiredSet {
+		if _, ok := currentSet[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return removed, added
+}
+
+// execute makes changes in order and returns the ones made and the rules to
+// sync, stopping at the first error.
+func execute(instanceName string, changes []types.ApplyChange, opts Options) ([]types.ApplyChange, []types.Rule, error) {
+	svc := service.GetService()
+	var synced []types.Rule
+	for i, change := range changes {
+		var rules []types.Rule
+		var err error
+		switch change.Action {
+		case types.ApplyCreateInstance:
+			err = svc.Create(types.ServiceInstance{InstanceName: instanceName, Creator: opts.User})
+		case types.ApplyRemoveRule:
+			err = svc.RemoveRule(instanceName, change.RuleID)
+		case types.ApplyUpdateRule:
+			rules, err = svc.UpdateRule(instanceName, change.RuleID, *change.Update, opts.User)
+		case types.ApplyAddRule:
+			r := &types.ServiceRule{Rule: *change.Rule, Creator: opts.User}
+			rules, err = svc.AddRule(instanceName, r)
+			changes[i].RuleID = r.RuleID
+		case types.ApplyUnbindApp:
+			err = svc.RemoveApp(instanceName, change.Target)
+		case types.ApplyBindApp:
+			rules, err = svc.AddApp(instanceName, change.Target)
+		case types.ApplyUnbindJob:
+			err = svc.RemoveJob(instanceName, change.Target)
+		case types.ApplyBindJob:
+			rules, err = svc.AddJob(instanceName, change.Target)
+		}
+		if err != nil {
+			return changes[:i], synced, err
+		}
+		synced = mergeRules(synced, rules)
+	}
+	return changes, synced, nil
+}
+
+// mergeRules adds rules to synced, replacing the rules with the same id.
+func mergeRules(synced, rules []types.Rule) []types.Rule {
+	for _, r := range rules {
+		replaced := false
+		for i := range synced {
+			if synced[i].RuleID == r.RuleID {
+				synced[i] = r
+				replaced = true
+			}
+		}
+		if !replaced {
+			synced = append(synced, r)
+		}
+	}
+	return synced
+}
+
+// markApplied records the instance as managed by apply along with the hash
+// of its current state, keeping other metadata.
+func markApplied(instanceName string, metadata map[string]string) error {
+	svc := service.GetService()
+	instance, err := svc.Find(instanceName)
+	if err != nil {
+		return err
+	}
+	newMetadata := map[string]string{}
+	for k, v := range metadata {
+		newMetadata[k] = v
+	}
+	newMetadata[ManagedByKey] = ManagedByApply
+	newMetadata[AppliedSpecKey] = specHash(instance)
+	return svc.SetMetadata(instanceName, newMetadata)
+}
+
+// specHash returns a hash of the base rules and bindings of instance, which
+// changes whenever any of them is added or removed.
+func specHash(instance types.ServiceInstance) string {
+	var spec struct {
+		Rules []string
+		Apps  []string
+		Jobs  []string
+	}
+	for _, r := range instance.BaseRules {
+		if r.Removed {
+			continue
+		}
+		rule := r.Destination.String()
+		if r.ExpiresAt != nil {
+			rule += " until " + r.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		spec.Rules = append(spec.Rules, rule)
+	}
+	spec.Apps = append(spec.Apps, instance.BindApps...)
+	spec.Jobs = append(spec.Jobs, instance.BindJobs...)
+	sort.Strings(spec.Rules)
+	sort.Strings(spec.Apps)
+	sort.Strings(spec.Jobs)
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
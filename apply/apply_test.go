@@ -0,0 +1,289 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package apply
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-apply")
+}
+
+func clearStorage(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+}
+
+func dnsRule(name string) types.ServiceRule {
+	return types.ServiceRule{Rule: types.Rule{
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: name}},
+	}}
+}
+
+func actions(plan types.ApplyPlan) []string {
+	var ret []string
+	for _, c := range plan.Changes {
+		s := string(c.Action) + " " + c.Instance
+		if c.Target != "" {
+			s += " " + c.Target
+		}
+		if c.Rule != nil {
+			s += " " + c.Rule.Destination.ExternalDNS.Name
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func TestApply(t *testing.T) {
+	clearStorage(t)
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{
+			InstanceName: "inst1",
+			BaseRules:    []types.ServiceRule{dnsRule("a.com"), dnsRule("b.com")},
+			BindApps:     []string{"app1"},
+			BindJobs:     []string{"job1"},
+		},
+	}}
+
+	plan, rules, err := Apply(doc, Options{DryRun: true})
+	require.Nil(t, err)
+	assert.True(t, plan.DryRun)
+	assert.Empty(t, rules)
+	assert.Equal(t, []string{
+		"create-instance inst1",
+		"add-rule inst1 a.com",
+		"add-rule inst1 b.com",
+		"bind-app inst1 app1",
+		"bind-job inst1 job1",
+	}, actions(plan))
+	_, err = service.GetService().Find("inst1")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+
+	plan, rules, err = Apply(doc, Options{User: "me@example.com"})
+	require.Nil(t, err)
+	assert.Len(t, plan.Changes, 5)
+	assert.NotEmpty(t, plan.Changes[1].RuleID)
+	assert.Len(t, rules, 4)
+	instance, err := service.GetService().Find("inst1")
+	require.Nil(t, err)
+	assert.Equal(t, "me@example.com", instance.Creator)
+	assert.Equal(t, ManagedByApply, instance.Metadata[ManagedByKey])
+	assert.NotEmpty(t, instance.Metadata[AppliedSpecKey])
+	derived, err := rule.GetService().FindMetadata(map[string]string{"instance-name": "inst1"})
+	require.Nil(t, err)
+	assert.Len(t, derived, 4)
+
+	plan, _, err = Apply(doc, Options{})
+	require.Nil(t, err)
+	assert.Empty(t, plan.Changes)
+	assert.Empty(t, plan.Modified)
+
+	doc.ServiceInstances[0].BaseRules = []types.ServiceRule{dnsRule("b.com"), dnsRule("c.com")}
+	doc.ServiceInstances[0].BindApps = []string{"app2"}
+	doc.ServiceInstances[0].BindJobs = nil
+	plan, _, err = Apply(doc, Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"remove-rule inst1 a.com",
+		"add-rule inst1 c.com",
+		"unbind-app inst1 app1",
+		"bind-app inst1 app2",
+		"unbind-job inst1 job1",
+	}, actions(plan))
+	instance, err = service.GetService().Find("inst1")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"app2"}, instance.BindApps)
+	assert.Empty(t, instance.BindJobs)
+	var names []string
+	for _, r := range instance.BaseRules {
+		names = append(names, r.Destination.ExternalDNS.Name)
+	}
+	assert.ElementsMatch(t, []string{"b.com", "c.com"}, names)
+}
+
+func TestApplyDetectsManualChanges(t *testing.T) {
+	clearStorage(t)
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "inst1", BaseRules: []types.ServiceRule{dnsRule("a.com")}},
+	}}
+	_, _, err := Apply(doc, Options{})
+	require.Nil(t, err)
+
+	_, err = service.GetService().AddApp("inst1", "manual-app")
+	require.Nil(t, err)
+
+	plan, _, err := Apply(doc, Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"inst1"}, plan.Modified)
+	assert.Equal(t, []string{"unbind-app inst1 manual-app"}, actions(plan))
+
+	_, _, err = Apply(doc, Options{})
+	require.Nil(t, err)
+	plan, _, err = Apply(doc, Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Empty(t, plan.Modified)
+	assert.Empty(t, plan.Changes)
+}
+
+func TestApplyExpirationChange(t *testing.T) {
+	clearStorage(t)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	r := dnsRule("a.com")
+	r.ExpiresAt = &expiresAt
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "inst1", BaseRules: []types.ServiceRule{r}},
+	}}
+	_, _, err := Apply(doc, Options{})
+	require.Nil(t, err)
+	instance, err := service.GetService().Find("inst1")
+	require.Nil(t, err)
+	require.Len(t, instance.BaseRules, 1)
+	ruleID := instance.BaseRules[0].RuleID
+
+	later := expiresAt.Add(time.Hour)
+	doc.ServiceInstances[0].BaseRules[0].ExpiresAt = &later
+	plan, _, err := Apply(doc, Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"update-rule inst1 a.com"}, actions(plan))
+	require.NotNil(t, plan.Changes[0].Update)
+	assert.Equal(t, ruleID, plan.Changes[0].RuleID)
+	instance, err = service.GetService().Find("inst1")
+	require.Nil(t, err)
+	require.Len(t, instance.BaseRules, 1)
+	assert.Equal(t, ruleID, instance.BaseRules[0].RuleID)
+	assert.True(t, later.Equal(*instance.BaseRules[0].ExpiresAt))
+
+	doc.ServiceInstances[0].BaseRules[0].ExpiresAt = nil
+	plan, _, err = Apply(doc, Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"update-rule inst1 a.com"}, actions(plan))
+	assert.True(t, plan.Changes[0].Update.ClearExpiresAt)
+	instance, err = service.GetService().Find("inst1")
+	require.Nil(t, err)
+	assert.Nil(t, instance.BaseRules[0].ExpiresAt)
+
+	plan, _, err = Apply(doc, Options{DryRun: true})
+	require.Nil(t, err)
+	assert.Empty(t, plan.Changes)
+	assert.Empty(t, plan.Modified)
+}
+
+func TestApplyAdopt(t *testing.T) {
+	clearStorage(t)
+	svc := service.GetService()
+	err := svc.Create(types.ServiceInstance{InstanceName: "manual"})
+	require.Nil(t, err)
+	_, err = svc.AddRule("manual", &types.ServiceRule{Rule: dnsRule("a.com").Rule})
+	require.Nil(t, err)
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "manual", BaseRules: []types.ServiceRule{dnsRule("b.com")}},
+		{InstanceName: "new", BaseRules: []types.ServiceRule{dnsRule("c.com")}},
+	}}
+
+	plan, rules, err := Apply(doc, Options{})
+	require.IsType(t, &ValidationError{}, err)
+	assert.Contains(t, err.Error(), "instance manual: not managed by apply")
+	assert.Empty(t, plan.Changes)
+	assert.Empty(t, rules)
+	_, err = svc.Find("new")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+	instance, err := svc.Find("manual")
+	require.Nil(t, err)
+	assert.Empty(t, instance.Metadata[ManagedByKey])
+
+	plan, _, err = Apply(doc, Options{Adopt: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"manual"}, plan.Adopted)
+	assert.Equal(t, []string{
+		"remove-rule manual a.com",
+		"add-rule manual b.com",
+		"create-instance new",
+		"add-rule new c.com",
+	}, actions(plan))
+	instance, err = svc.Find("manual")
+	require.Nil(t, err)
+	assert.Equal(t, ManagedByApply, instance.Metadata[ManagedByKey])
+
+	plan, _, err = Apply(doc, Options{})
+	require.Nil(t, err)
+	assert.Empty(t, plan.Adopted)
+	assert.Empty(t, plan.Changes)
+}
+
+func TestApplyPrune(t *testing.T) {
+	clearStorage(t)
+	svc := service.GetService()
+	err := svc.Create(types.ServiceInstance{InstanceName: "manual"})
+	require.Nil(t, err)
+	_, err = svc.AddRule("manual", &types.ServiceRule{Rule: dnsRule("a.com").Rule})
+	require.Nil(t, err)
+	plan, _, err := Apply(types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "inst1"},
+		{InstanceName: "old"},
+	}}, Options{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"create-instance inst1", "create-instance old"}, actions(plan))
+	assert.Empty(t, plan.Unmanaged)
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "inst1"},
+	}}
+
+	plan, _, err = Apply(doc, Options{Prune: true, DryRun: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"delete-instance old"}, actions(plan))
+	assert.Equal(t, []string{"manual"}, plan.Unmanaged)
+	_, err = svc.Find("old")
+	require.Nil(t, err)
+
+	plan, _, err = Apply(doc, Options{Prune: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"delete-instance old"}, actions(plan))
+	assert.Equal(t, []string{"manual"}, plan.Unmanaged)
+	_, err = svc.Find("old")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+	_, err = svc.Find("manual")
+	assert.Nil(t, err)
+}
+
+func TestApplyInvalid(t *testing.T) {
+	clearStorage(t)
+	doc := types.ApplyDocument{ServiceInstances: []types.ServiceInstance{
+		{InstanceName: "inst1", BaseRules: []types.ServiceRule{dnsRule("")}},
+		{InstanceName: "inst1", BindApps: []string{""}},
+		{},
+	}}
+	_, _, err := Apply(doc, Options{})
+	require.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, []string{
+		"instance inst1: rule 0: cannot have empty external dns name",
+		"instance inst1: declared more than once",
+		"instance inst1: empty app name",
+		"instance 2: empty instance name",
+	}, err.(*ValidationError).Errors)
+	instances, err := service.GetService().List()
+	require.Nil(t, err)
+	assert.Empty(t, instances)
+}
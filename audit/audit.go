@@ -0,0 +1,65 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit records who changed rules and service instances.
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type Service interface {
+	Record(entry types.AuditEntry, before, after interface{}) error
+	Find(opts storage.AuditFindOpts) ([]types.AuditEntry, error)
+}
+
+type auditServiceImpl struct{}
+
+// Record stores entry with the JSON representation of before and after, nil
+// values are not recorded.
+func (s *auditServiceImpl) Record(entry types.AuditEntry, before, after interface{}) error {
+	stor, err := storage.GetAuditStorage()
+	if err != nil {
+		return err
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.Before, err = payload(before)
+	if err != nil {
+		return err
+	}
+	entry.After, err = payload(after)
+	if err != nil {
+		return err
+	}
+	return stor.Add(&entry)
+}
+
+func (s *auditServiceImpl) Find(opts storage.AuditFindOpts) ([]types.AuditEntry, error) {
+	stor, err := storage.GetAuditStorage()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Find(opts)
+}
+
+func payload(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return data, nil
+}
+
+var GetService = func() Service {
+	return &auditServiceImpl{}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-audit")
+}
+
+func Test_Service_Record(t *testing.T) {
+	stor, err := storage.GetAuditStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+
+	svc := GetService()
+	var nilRule *types.Rule
+	err = svc.Record(types.AuditEntry{
+		Action: types.AuditRuleCreate,
+		Actor:  "me@example.com",
+		RuleID: "r1",
+	}, nilRule, types.Rule{RuleID: "r1", Creator: "me@example.com"})
+	require.Nil(t, err)
+
+	entries, err := svc.Find(storage.AuditFindOpts{RuleID: "r1"})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	assert.NotEmpty(t, entries[0].ID)
+	assert.WithinDuration(t, time.Now(), entries[0].Time, time.Minute)
+	assert.Equal(t, types.AuditRuleCreate, entries[0].Action)
+	assert.Empty(t, entries[0].Before)
+	var after types.Rule
+	err = json.Unmarshal(entries[0].After, &after)
+	require.Nil(t, err)
+	assert.Equal(t, "r1", after.RuleID)
+	assert.Equal(t, "me@example.com", after.Creator)
+}
@@ -0,0 +1,386 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bulk exports every rule and service instance to a single document
+// and imports such documents, creating only what does not exist yet.
+package bulk
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported export document version")
+
+// ImportOptions changes how documents are imported, User is the creator of
+// imported rules without one.
+type ImportOptions struct {
+	DryRun bool
+	User   string
+}
+
+// Export returns the active rules and service instances, rules derived from
+// service instances are only exported as part of their instance. Expired
+// rules not removed yet are left out, as importing them would fail.
+func Export() (types.ExportDocument, error) {
+	doc := types.ExportDocument{
+		Version:          types.ExportVersion,
+		ExportedAt:       time.Now().UTC(),
+		Rules:            []types.Rule{},
+		ServiceInstances: []types.ServiceInstance{},
+	}
+	rules, err := rule.GetService().FindAll()
+	if err != nil {
+		return doc, err
+	}
+	for _, r := range rules {
+		if r.Removed || r.Expired(doc.ExportedAt) || r.Metadata["owner"] == service.OwnerAclFromHell {
+			continue
+		}
+		doc.Rules = append(doc.Rules, r)
+	}
+	sort.Slice(doc.Rules, func(i, j int) bool {
+		return doc.Rules[i].RuleID < doc.Rules[j].RuleID
+	})
+	instances, err := service.GetService().List()
+	if err != nil {
+		return doc, err
+	}
+	for _, instance := range instances {
+		var baseRules []types.ServiceRule
+		for _, r := range instance.BaseRules {
+			if !r.Removed && !r.Expired(doc.ExportedAt) {
+				baseRules = append(baseRules, r)
+			}
+		}
+		instance.BaseRules = baseRules
+		doc.ServiceInstances = append(doc.ServiceInstances, instance)
+	}
+	sort.Slice(doc.ServiceInstances, func(i, j int) bool {
+		return doc.ServiceInstances[i].InstanceName < doc.ServiceInstances[j].InstanceName
+	})
+	return doc, nil
+}
+
+// Import creates the rules and service instances of doc which do not exist
+// yet. Invalid entries are rejected and reported without stopping the
+// import. The returned rules were created or changed and must be synced.
+func Import(doc types.ExportDocument, opts ImportOptions) (types.ImportReport, []types.Rule, error) {
+	report := types.ImportReport{DryRun: opts.DryRun, Items: []types.ImportItem{}}
+	if doc.Version != types.ExportVersion {
+		return report, nil, errors.Wrapf(ErrUnsupportedVersion, "version %d, expected %d", doc.Version, types.ExportVersion)
+	}
+	imp := importer{opts: opts, report: &report, now: time.Now()}
+	err := imp.importRules(doc.Rules)
+	if err != nil {
+		return report, nil, err
+	}
+	for _, instance := range doc.ServiceInstances {
+		err = imp.importInstance(instance)
+		if err != nil {
+			return report, nil, err
+		}
+	}
+	return report, imp.synced, nil
+}
+
+type importer struct {
+	opts   ImportOptions
+	report *types.ImportReport
+	now    time.Time
+	synced []types.Rule
+}
+
+func (imp *importer) addSynced(rules []types.Rule) {
+	for _, r := range rules {
+		replaced := false
+		for i := range imp.synced {
+			if imp.synced[i].RuleID == r.RuleID {
+				imp.synced[i] = r
+				replaced = true
+			}
+		}
+		if !replaced {
+			imp.synced = append(imp.synced, r)
+		}
+	}
+}
+
+func (imp *importer) importRules(rules []types.Rule) error {
+	ruleSvc := rule.GetService()
+	existing, err := ruleSvc.FindAll()
+	if err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	var active []types.Rule
+	for _, r := range existing {
+		if r.Removed {
+			removed[r.RuleID] = true
+		} else {
+			active = append(active, r)
+		}
+	}
+	for _, r := range rules {
+		r := r
+		item := types.ImportItem{Kind: types.ImportKindRule, RuleID: r.RuleID, Rule: &r}
+		if reason := imp.rejectRule(r); reason != "" {
+			item.Status = types.ImportRejected
+			item.Reason = reason
+			imp.report.Add(item)
+			continue
+		}
+		if duplicate := findDuplicateRule(r, active); duplicate != nil {
+			item.Status = types.ImportDuplicate
+			item.Reason = "same as rule " + duplicate.RuleID
+			imp.report.Add(item)
+			continue
+		}
+		if nameInUse(r, active) {
+			item.Status = types.ImportRejected
+			item.Reason = "RuleName: " + r.RuleName + " already in use"
+			imp.report.Add(item)
+			continue
+		}
+		if r.Creator == "" {
+			r.Creator = imp.opts.User
+		}
+		if !imp.opts.DryRun {
+			// soft deleted rules keep their id, importing them again revives
+			// the rule
+			err = ruleSvc.Save([]*types.Rule{&r}, removed[r.RuleID])
+			if err == storage.ErrInstanceAlreadyExists {
+				item.Status = types.ImportRejected
+				item.Reason = "rule id or name already in use"
+				imp.report.Add(item)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			item.RuleID = r.RuleID
+			imp.addSynced([]types.Rule{r})
+		}
+		item.Status = types.ImportCreated
+		imp.report.Add(item)
+		active = append(active, r)
+	}
+	return nil
+}
+
+func (imp *importer) rejectRule(r types.Rule) string {
+	if r.Metadata["owner"] == service.OwnerAclFromHell {
+		return "rule is managed by service instance " + r.Metadata["instance-name"] + ", import the instance instead"
+	}
+	if r.Removed {
+		return "rule is removed"
+	}
+	err := r.Source.Validate()
+	if err != nil {
+		return "source: " + err.Error()
+	}
+	err = r.Destination.Validate()
+	if err != nil {
+		return "destination: " + err.Error()
+	}
+	err = r.ValidateExpiration(imp.now)
+	if err != nil {
+		return err.Error()
+	}
+	if r.RuleName != "" {
+		errs := validation.IsDNS1123Subdomain(r.RuleName)
+		if len(errs) > 0 {
+			return "RuleName: " + strings.Join(errs, "\n")
+		}
+	}
+	return ""
+}
+
+func nameInUse(r types.Rule, active []types.Rule) bool {
+	if r.RuleName == "" {
+		return false
+	}
+	for _, other := range active {
+		if other.RuleName == r.RuleName && other.RuleID != r.RuleID {
+			return true
+		}
+	}
+	return false
+}
+
+// findDuplicateRule returns the active rule with the same id or the same
+// source and destination as r.
+func findDuplicateRule(r types.Rule, active []types.Rule) *types.Rule {
+	imported := types.ServiceRule{Rule: r}
+	for i, other := range active {
+		if r.RuleID != "" && other.RuleID == r.RuleID {
+			return &active[i]
+		}
+		if reflect.DeepEqual(r.Source, other.Source) && imported.Equals(&types.ServiceRule{Rule: other}) {
+			return &active[i]
+		}
+	}
+	return nil
+}
+
+func (imp *importer) importInstance(instance types.ServiceInstance) error {
+	svc := service.GetService()
+	item := types.ImportItem{Kind: types.ImportKindServiceInstance, Instance: instance.InstanceName}
+	if strings.TrimSpace(instance.InstanceName) == "" {
+		item.Status = types.ImportRejected
+		item.Reason = "empty instance name"
+		imp.report.Add(item)
+		return nil
+	}
+	current, err := svc.Find(instance.InstanceName)
+	switch {
+	case err == storage.ErrInstanceNotFound:
+		if !imp.opts.DryRun {
+			err = svc.Create(types.ServiceInstance{
+				InstanceName: instance.InstanceName,
+				Creator:      instance.Creator,
+				EventID:      instance.EventID,
+				Metadata:     instance.Metadata,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		current = types.ServiceInstance{InstanceName: instance.InstanceName}
+		item.Status = types.ImportCreated
+	case err != nil:
+		return err
+	default:
+		item.Status = types.ImportDuplicate
+	}
+	imp.report.Add(item)
+
+	for _, r := range instance.BaseRules {
+		if r.Removed {
+			continue
+		}
+		r := r
+		err = imp.importServiceRule(&current, r)
+		if err != nil {
+			return err
+		}
+	}
+	for _, appName := range instance.BindApps {
+		err = imp.importBind(&current, types.ImportKindServiceApp, appName)
+		if err != nil {
+			return err
+		}
+	}
+	for _, jobName := range instance.BindJobs {
+		err = imp.importBind(&current, types.ImportKindServiceJob, jobName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *importer) importServiceRule(current *types.ServiceInstance, r types.ServiceRule) error {
+	item := types.ImportItem{
+		Kind:     types.ImportKindServiceRule,
+		Instance: current.InstanceName,
+		RuleID:   r.RuleID,
+		Rule:     &r.Rule,
+	}
+	reject := func(reason string) {
+		item.Status = types.ImportRejected
+		item.Reason = reason
+		imp.report.Add(item)
+	}
+	err := r.Destination.Validate()
+	if err != nil {
+		reject("destination: " + err.Error())
+		return nil
+	}
+	err = r.ValidateExpiration(imp.now)
+	if err != nil {
+		reject(err.Error())
+		return nil
+	}
+	for _, baseRule := range current.BaseRules {
+		if baseRule.Removed || baseRule.Expired(imp.now) {
+			continue
+		}
+		if baseRule.Equals(&r) {
+			item.Status = types.ImportDuplicate
+			item.Reason = "same as rule " + baseRule.RuleID
+			imp.report.Add(item)
+			return nil
+		}
+		if r.RuleID != "" && baseRule.RuleID == r.RuleID {
+			reject(fmt.Sprintf("rule id %s already in use", r.RuleID))
+			return nil
+		}
+	}
+	if !imp.opts.DryRun {
+		rules, err := service.GetService().AddRule(current.InstanceName, &r)
+		if err == service.ErrRuleAlreadyExists {
+			item.Status = types.ImportDuplicate
+			imp.report.Add(item)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item.RuleID = r.RuleID
+		imp.addSynced(rules)
+	}
+	current.BaseRules = append(current.BaseRules, r)
+	item.Status = types.ImportCreated
+	imp.report.Add(item)
+	return nil
+}
+
+func (imp *importer) importBind(current *types.ServiceInstance, kind types.ImportKind, name string) error {
+	item := types.ImportItem{Kind: kind, Instance: current.InstanceName, Target: name}
+	if strings.TrimSpace(name) == "" {
+		item.Status = types.ImportRejected
+		item.Reason = "empty name"
+		imp.report.Add(item)
+		return nil
+	}
+	bound := &current.BindApps
+	if kind == types.ImportKindServiceJob {
+		bound = &current.BindJobs
+	}
+	for _, other := range *bound {
+		if other == name {
+			item.Status = types.ImportDuplicate
+			imp.report.Add(item)
+			return nil
+		}
+	}
+	if !imp.opts.DryRun {
+		var rules []types.Rule
+		var err error
+		if kind == types.ImportKindServiceJob {
+			rules, err = service.GetService().AddJob(current.InstanceName, name)
+		} else {
+			rules, err = service.GetService().AddApp(current.InstanceName, name)
+		}
+		if err != nil {
+			return err
+		}
+		imp.addSynced(rules)
+	}
+	*bound = append(*bound, name)
+	item.Status = types.ImportCreated
+	imp.report.Add(item)
+	return nil
+}
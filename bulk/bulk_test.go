@@ -0,0 +1,267 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bulk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/service"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-bulk")
+}
+
+func clearStorage(t *testing.T) {
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+}
+
+func setupData(t *testing.T) {
+	clearStorage(t)
+	err := rule.GetService().Save([]*types.Rule{
+		{
+			RuleID:      "r1",
+			RuleName:    "my-rule",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1", Ports: types.ProtoPorts{{Protocol: "TCP", Port: 443}}}},
+			Creator:     "me@example.com",
+		},
+		{
+			RuleID:      "r2",
+			Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+			Removed:     true,
+		},
+	}, false)
+	require.Nil(t, err)
+	svc := service.GetService()
+	err = svc.Create(types.ServiceInstance{InstanceName: "inst1", Creator: "me@example.com"})
+	require.Nil(t, err)
+	_, err = svc.AddRule("inst1", &types.ServiceRule{
+		Rule: types.Rule{
+			RuleID:      "base1",
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "b.com"}},
+		},
+	})
+	require.Nil(t, err)
+	_, err = svc.AddApp("inst1", "app2")
+	require.Nil(t, err)
+	_, err = svc.AddJob("inst1", "job2")
+	require.Nil(t, err)
+}
+
+func TestExport(t *testing.T) {
+	setupData(t)
+	doc, err := Export()
+	require.Nil(t, err)
+	assert.Equal(t, types.ExportVersion, doc.Version)
+	require.Len(t, doc.Rules, 1)
+	assert.Equal(t, "r1", doc.Rules[0].RuleID)
+	assert.Equal(t, "my-rule", doc.Rules[0].RuleName)
+	require.Len(t, doc.ServiceInstances, 1)
+	instance := doc.ServiceInstances[0]
+	assert.Equal(t, "inst1", instance.InstanceName)
+	assert.Equal(t, []string{"app2"}, instance.BindApps)
+	assert.Equal(t, []string{"job2"}, instance.BindJobs)
+	require.Len(t, instance.BaseRules, 1)
+	assert.Equal(t, "base1", instance.BaseRules[0].RuleID)
+}
+
+func TestExportSkipsExpired(t *testing.T) {
+	setupData(t)
+	past := time.Now().Add(-time.Minute)
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	err = stor.Save([]*types.Rule{{
+		RuleID:      "expired",
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "expired.com"}},
+		ExpiresAt:   &past,
+	}}, false)
+	require.Nil(t, err)
+	serviceStor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	err = serviceStor.AddRule("inst1", &types.ServiceRule{Rule: types.Rule{
+		RuleID:      "base-expired",
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "expired.com"}},
+		ExpiresAt:   &past,
+	}})
+	require.Nil(t, err)
+
+	doc, err := Export()
+	require.Nil(t, err)
+	require.Len(t, doc.Rules, 1)
+	assert.Equal(t, "r1", doc.Rules[0].RuleID)
+	require.Len(t, doc.ServiceInstances, 1)
+	require.Len(t, doc.ServiceInstances[0].BaseRules, 1)
+	assert.Equal(t, "base1", doc.ServiceInstances[0].BaseRules[0].RuleID)
+
+	clearStorage(t)
+	report, _, err := Import(doc, ImportOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 0, report.Rejected)
+}
+
+func TestImport(t *testing.T) {
+	setupData(t)
+	doc, err := Export()
+	require.Nil(t, err)
+	clearStorage(t)
+
+	report, rules, err := Import(doc, ImportOptions{User: "importer"})
+	require.Nil(t, err)
+	assert.Equal(t, 5, report.Created)
+	assert.Equal(t, 0, report.Duplicates)
+	assert.Equal(t, 0, report.Rejected)
+	var ids []string
+	for _, r := range rules {
+		ids = append(ids, r.RuleID)
+	}
+	assert.ElementsMatch(t, []string{"r1", "base1-app2", "job-base1-job2"}, ids)
+
+	r, err := rule.GetService().FindByID("r1")
+	require.Nil(t, err)
+	assert.Equal(t, "my-rule", r.RuleName)
+	assert.Equal(t, "me@example.com", r.Creator)
+	instance, err := service.GetService().Find("inst1")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"app2"}, instance.BindApps)
+	assert.Equal(t, []string{"job2"}, instance.BindJobs)
+	require.Len(t, instance.BaseRules, 1)
+	assert.Equal(t, "base1", instance.BaseRules[0].RuleID)
+	_, err = rule.GetService().FindByID("base1-app2")
+	require.Nil(t, err)
+
+	report, rules, err = Import(doc, ImportOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 0, report.Created)
+	assert.Equal(t, 5, report.Duplicates)
+	assert.Empty(t, rules)
+}
+
+func TestImportDryRun(t *testing.T) {
+	setupData(t)
+	doc, err := Export()
+	require.Nil(t, err)
+	clearStorage(t)
+	doc.Rules = append(doc.Rules, types.Rule{
+		RuleID:      "r3",
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+	})
+	doc.ServiceInstances[0].BindApps = append(doc.ServiceInstances[0].BindApps, "app2")
+
+	report, rules, err := Import(doc, ImportOptions{DryRun: true})
+	require.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Empty(t, rules)
+	assert.Equal(t, 5, report.Created)
+	assert.Equal(t, 2, report.Duplicates)
+	assert.Equal(t, types.ImportItem{
+		Kind:   types.ImportKindRule,
+		Status: types.ImportDuplicate,
+		RuleID: "r3",
+		Reason: "same as rule r1",
+		Rule:   &doc.Rules[1],
+	}, report.Items[1])
+
+	all, err := rule.GetService().FindAll()
+	require.Nil(t, err)
+	assert.Empty(t, all)
+	instances, err := service.GetService().List()
+	require.Nil(t, err)
+	assert.Empty(t, instances)
+}
+
+func TestImportRejected(t *testing.T) {
+	clearStorage(t)
+	past := time.Now().Add(-time.Hour)
+	doc := types.ExportDocument{
+		Version: types.ExportVersion,
+		Rules: []types.Rule{
+			{
+				RuleID:      "invalid-destination",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/8"}},
+			},
+			{
+				RuleID:      "expired",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+				ExpiresAt:   &past,
+			},
+			{
+				RuleID:      "derived",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "b.com"}},
+				Metadata:    map[string]string{"owner": service.OwnerAclFromHell, "instance-name": "inst1"},
+			},
+			{
+				RuleID:      "named1",
+				RuleName:    "same-name",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "c.com"}},
+			},
+			{
+				RuleID:      "named2",
+				RuleName:    "same-name",
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "d.com"}},
+			},
+		},
+		ServiceInstances: []types.ServiceInstance{
+			{
+				InstanceName: "inst1",
+				BindApps:     []string{""},
+				BaseRules: []types.ServiceRule{
+					{Rule: types.Rule{RuleID: "base1", Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{}}}},
+				},
+			},
+		},
+	}
+	report, _, err := Import(doc, ImportOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 6, report.Rejected)
+	var reasons []string
+	for _, item := range report.Items {
+		if item.Status == types.ImportRejected {
+			reasons = append(reasons, item.Reason)
+		}
+	}
+	assert.Equal(t, []string{
+		"destination: IP Rule: Large CIDR, the maximum size of network without ports is /22",
+		"ExpiresAt must be in the future",
+		"rule is managed by service instance inst1, import the instance instead",
+		"RuleName: same-name already in use",
+		"destination: cannot have empty external dns name",
+		"empty name",
+	}, reasons)
+}
+
+func TestImportUnsupportedVersion(t *testing.T) {
+	clearStorage(t)
+	_, _, err := Import(types.ExportDocument{Version: 2}, ImportOptions{})
+	assert.Equal(t, ErrUnsupportedVersion, errors.Cause(err))
+}
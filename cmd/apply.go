@@ -0,0 +1,68 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/apply"
+	"github.com/tsuru/acl-api/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+func makeApplyCmd() *cobra.Command {
+	var file, format, user string
+	var dryRun, prune, adopt bool
+	var applyCmd = &cobra.Command{
+		Use:   "apply -f <file>",
+		Short: "Reconcile service instances with the desired state in a YAML or JSON file, use - to read from stdin",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if file == "-" {
+				data, err = ioutil.ReadAll(cmd.InOrStdin())
+			} else {
+				data, err = ioutil.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			var doc types.ApplyDocument
+			err = yaml.Unmarshal(data, &doc)
+			if err != nil {
+				return errors.Wrap(err, "invalid document")
+			}
+			plan, rules, err := apply.Apply(doc, apply.Options{DryRun: dryRun, Prune: prune, Adopt: adopt, User: user})
+			if !dryRun {
+				api.RecordApply(cliActor(user), plan)
+				defer webhook.Wait()
+			}
+			if len(rules) > 0 {
+				api.SyncRules(rules)
+			}
+			if err != nil {
+				// the changes made before the failure are kept
+				if len(plan.Changes) > 0 {
+					writeFormat(cmd.OutOrStdout(), format, plan)
+				}
+				return err
+			}
+			return writeFormat(cmd.OutOrStdout(), format, plan)
+		},
+	}
+	applyCmd.Flags().StringVarP(&file, "file", "f", "", "File with the desired service instances")
+	applyCmd.MarkFlagRequired("file")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the plan without changing anything")
+	applyCmd.Flags().BoolVar(&prune, "prune", false, "Delete service instances managed by apply and missing from the file")
+	applyCmd.Flags().BoolVar(&adopt, "adopt", false, "Take over existing service instances not managed by apply")
+	applyCmd.Flags().StringVar(&user, "user", "", "Creator of the instances and rules created")
+	applyCmd.Flags().StringVar(&format, "format", "yaml", "Plan format, yaml or json")
+	return applyCmd
+}
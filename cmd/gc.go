@@ -0,0 +1,49 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/gc"
+)
+
+func makeGCCmd() *cobra.Command {
+	var dryRun bool
+	var format string
+	var retention time.Duration
+	var gcCmd = &cobra.Command{
+		Use:   "gc",
+		Short: "Purge removed rules whose removal was synced by every enabled engine",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("retention") {
+				retention = viper.GetDuration("gc.retention")
+			}
+			purged, err := gc.Purge(api.ConfiguredEngines(), time.Now().UTC(), gc.Options{
+				Retention: retention,
+				DryRun:    dryRun,
+			})
+			if !dryRun {
+				api.RecordPurge(cliActor(""), purged)
+			}
+			if err != nil {
+				return err
+			}
+			if purged == nil {
+				purged = []types.Rule{}
+			}
+			return writeFormat(cmd.OutOrStdout(), format, purged)
+		},
+	}
+	gcCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the rules that would be purged without deleting them")
+	gcCmd.Flags().DurationVar(&retention, "retention", 0, "How long removed rules are kept (default is gc.retention)")
+	gcCmd.Flags().StringVar(&format, "format", "yaml", "Output format, yaml or json")
+	return gcCmd
+}
@@ -7,6 +7,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	osuser "os/user"
 	"strings"
 	"time"
 
@@ -36,7 +37,7 @@ func makeCmds() *cobra.Command {
 
 	var apiCmd = &cobra.Command{
 		Use:   "api",
-		Short: "Run acl-api API and worker",
+		Short: "Run acl-api API, along with the worker when api.run_worker is set",
 		RunE:  rootRun,
 	}
 
@@ -76,6 +77,9 @@ func makeCmds() *cobra.Command {
 	rootCmd.AddCommand(apiCmd)
 	rootCmd.AddCommand(workerCmd)
 	rootCmd.AddCommand(checkRules)
+	rootCmd.AddCommand(makeRulesCmd())
+	rootCmd.AddCommand(makeApplyCmd())
+	rootCmd.AddCommand(makeGCCmd())
 
 	return rootCmd
 }
@@ -96,8 +100,8 @@ func initRootCmd(rootCmd *cobra.Command) error {
 
 	flags.Bool("debug", false, "Debug mode")
 	flags.String("loglevel", "info", "Logrus log level")
-	flags.String("storage", "", "Storage address")
-	flags.StringSlice("engines", []string{"acl-operator"}, "Enabled syncing engines")
+	flags.String("storage", "", "Storage address, mongodb://host/database, postgres://user@host/database or memory://")
+	flags.StringSlice("engines", []string{"acl-operator"}, "Enabled syncing engines, acl-operator, network-policy or aclapi, with the :dry-run suffix they only render rules")
 	flags.String("tsuru.host", "", "Tsuru URL")
 	flags.String("tsuru.token", "", "Tsuru Token")
 
@@ -105,13 +109,38 @@ func initRootCmd(rootCmd *cobra.Command) error {
 	flags.String("auth.password", "", "Auth Password")
 	flags.String("auth.read_only_user", "", "Auth Read only User")
 	flags.String("auth.read_only_password", "", "Auth Read only Password")
+	flags.Bool("auth.tsuru_token", false, "Accept tsuru user tokens, validated against the tsuru API")
+	flags.Duration("auth.token_cache_ttl", time.Minute, "How long token validations are cached")
+	flags.StringSlice("auth.admin_teams", nil, "Teams whose members may manage every rule when authenticated by a tsuru token")
+
+	flags.String("aclapi.url", "", "Network ACL API URL used by the aclapi engine")
+	flags.String("aclapi.user", "", "Network ACL API user")
+	flags.String("aclapi.password", "", "Network ACL API password")
+
+	flags.Duration("resolver.ttl", 5*time.Minute, "How long resolved ExternalDNS addresses are valid when the resolver does not report a TTL")
+	flags.Int("resolver.ipv4_prefix", 24, "Prefix length of the network synced for IPv4 addresses of rules with SyncWholeNetwork")
+	flags.Int("resolver.ipv6_prefix", 64, "Prefix length of the network synced for IPv6 addresses of rules with SyncWholeNetwork")
 
 	flags.String("kubernetes.namespace", "tsuru", "Default Kubernetes namespace for tsuru")
 
 	flags.Bool("tls.insecure", false, "Trust Any TLS Certificate")
 	flags.Int("port", 8888, "Port to listen")
 	flags.Duration("sync.interval", time.Minute, "Rules sync interval")
+	flags.Bool("api.run_worker", false, "Run the worker reconciliation in the API process too, it is meant for single process deployments")
+	flags.Int("sync.workers", 4, "Number of rules synced in parallel by each engine")
+	flags.Float64("sync.cluster_rate", 0, "Maximum rule syncs per second in each kubernetes cluster, 0 disables the limit")
+	flags.Int("sync.cluster_burst", 5, "Rule syncs allowed in a burst above sync.cluster_rate")
+	flags.Duration("sync.retry_interval", 5*time.Second, "Interval before retrying a failed rule sync, doubled on each attempt")
+	flags.Duration("sync.retry_max_interval", 10*time.Minute, "Maximum interval between retries of a failed rule sync")
+	flags.Int("sync.retry_max_attempts", 10, "Maximum attempts to sync a rule before leaving it to the periodic reconciliation, 0 disables retries")
+	flags.Duration("gc.interval", time.Hour, "Interval between purges of removed rules by the worker, 0 disables them")
+	flags.Duration("gc.retention", 7*24*time.Hour, "How long removed rules are kept before being purged")
+	flags.Duration("sync.job_retention", 24*time.Hour, "How long sync jobs are kept before being purged")
+	flags.Duration("drift.interval", 10*time.Minute, "Interval between checks of the actual state of synced rules by the worker, 0 disables them")
 	flags.Duration("http.timeout", time.Minute, "Default HTTP timeout")
+	flags.Int("webhook.max_attempts", 5, "Maximum number of attempts to deliver a webhook event")
+	flags.Duration("webhook.retry_interval", time.Second, "Interval before the first webhook retry, doubled on each retry")
+	flags.Duration("webhook.timeout", 10*time.Second, "Webhook request timeout")
 
 	initConfig(rootCmd)
 	initLogging()
@@ -159,3 +188,14 @@ func initLogging() {
 	logrus.SetLevel(level)
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 }
+
+// cliActor is the actor recorded in the audit log for changes made from the
+// command line, user defaults to the user running the command.
+func cliActor(user string) string {
+	if user == "" {
+		if current, err := osuser.Current(); err == nil {
+			user = current.Username
+		}
+	}
+	return "cli:" + user
+}
@@ -4,9 +4,12 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
@@ -14,6 +17,9 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/audit"
+	"github.com/tsuru/acl-api/storage"
 )
 
 func TestExecute_flagParsing(t *testing.T) {
@@ -95,3 +101,191 @@ func TestExecute_flagParsing(t *testing.T) {
 		})
 	}
 }
+
+func TestRulesCmd_exportImport(t *testing.T) {
+	viper.Reset()
+	viper.Set("storage", "memory://acltest-cmd-rules")
+	defer viper.Reset()
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "rules.yaml")
+	err = ioutil.WriteFile(name, []byte(`
+Version: 1
+Rules:
+- RuleID: r1
+  Source:
+    TsuruApp:
+      AppName: app1
+  Destination:
+    ExternalIP:
+      IP: 10.0.0.1
+      Ports:
+      - Protocol: TCP
+        Port: 8000
+        EndPort: 8080
+- RuleID: r2
+  Source:
+    TsuruApp:
+      AppName: app1
+  Destination:
+    ExternalIP:
+      IP: 10.0.0.0/8
+`), 0600)
+	require.NoError(t, err)
+
+	run := func(args ...string) string {
+		cmd := makeRulesCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.NoError(t, err)
+		return out.String()
+	}
+
+	out := run("import", "--dry-run", "--format", "json", name)
+	var report types.ImportReport
+	err = json.Unmarshal([]byte(out), &report)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Rejected)
+
+	viper.Set("engines", []string{"aclapi:dry-run"})
+	run("import", "--user", "me@example.com", name)
+	entries, err := audit.GetService().Find(storage.AuditFindOpts{RuleID: "r1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, types.AuditRuleCreate, entries[0].Action)
+	assert.Equal(t, "cli:me@example.com", entries[0].Actor)
+	syncStor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+	syncs, err := syncStor.Find(storage.SyncFindOpts{RuleIDs: []string{"r1"}})
+	require.NoError(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, "aclapi:dry-run", syncs[0].Engine)
+
+	exported := filepath.Join(dir, "exported.yaml")
+	run("export", "-o", exported)
+	data, err := ioutil.ReadFile(exported)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "RuleID: r1")
+	assert.Contains(t, string(data), "EndPort: 8080")
+	assert.NotContains(t, string(data), "RuleID: r2")
+}
+
+func TestApplyCmd(t *testing.T) {
+	viper.Reset()
+	viper.Set("storage", "memory://acltest-cmd-apply")
+	defer viper.Reset()
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "instances.yaml")
+	err = ioutil.WriteFile(name, []byte(`
+ServiceInstances:
+- InstanceName: inst1
+  BindJobs: [job1]
+  BaseRules:
+  - Destination:
+      ExternalDNS:
+        Name: a.com
+`), 0600)
+	require.NoError(t, err)
+
+	run := func(args ...string) types.ApplyPlan {
+		cmd := makeApplyCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		require.NoError(t, err)
+		var plan types.ApplyPlan
+		err = json.Unmarshal(out.Bytes(), &plan)
+		require.NoError(t, err)
+		return plan
+	}
+
+	viper.Set("engines", []string{"aclapi:dry-run"})
+	syncStor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+
+	plan := run("-f", name, "--dry-run", "--format", "json")
+	assert.True(t, plan.DryRun)
+	assert.Len(t, plan.Changes, 3)
+	syncs, err := syncStor.Find(storage.SyncFindOpts{})
+	require.NoError(t, err)
+	assert.Empty(t, syncs)
+	plan = run("-f", name, "--format", "json")
+	assert.Len(t, plan.Changes, 3)
+	syncs, err = syncStor.Find(storage.SyncFindOpts{})
+	require.NoError(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, "aclapi:dry-run", syncs[0].Engine)
+	plan = run("-f", name, "--format", "json")
+	assert.Empty(t, plan.Changes)
+	entries, err := audit.GetService().Find(storage.AuditFindOpts{Instance: "inst1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	for _, entry := range entries {
+		assert.True(t, strings.HasPrefix(entry.Actor, "cli:"), entry.Actor)
+	}
+}
+
+func TestGCCmd(t *testing.T) {
+	viper.Reset()
+	viper.Set("storage", "memory://acltest-cmd-gc")
+	defer viper.Reset()
+
+	stor, err := storage.GetRuleStorage()
+	require.NoError(t, err)
+	err = stor.Save([]*types.Rule{
+		{
+			RuleID:      "r1",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}},
+		},
+		{
+			RuleID:      "r2",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "b.com"}},
+		},
+	}, false)
+	require.NoError(t, err)
+	err = stor.Delete(storage.DeleteOpts{ID: "r1"})
+	require.NoError(t, err)
+
+	run := func(args ...string) []types.Rule {
+		cmd := makeGCCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetArgs(append(args, "--format", "json"))
+		err := cmd.Execute()
+		require.NoError(t, err)
+		var purged []types.Rule
+		err = json.Unmarshal(out.Bytes(), &purged)
+		require.NoError(t, err)
+		return purged
+	}
+
+	assert.Empty(t, run("--retention", "1h"))
+	purged := run("--retention", "0s", "--dry-run")
+	require.Len(t, purged, 1)
+	assert.Equal(t, "r1", purged[0].RuleID)
+	_, err = stor.Find("r1")
+	require.NoError(t, err)
+	purged = run("--retention", "0s")
+	require.Len(t, purged, 1)
+	_, err = stor.Find("r1")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	entries, err := audit.GetService().Find(storage.AuditFindOpts{RuleID: "r1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, types.AuditRulePurge, entries[0].Action)
+	assert.True(t, strings.HasPrefix(entries[0].Actor, "cli:"), entries[0].Actor)
+	_, err = stor.Find("r2")
+	assert.NoError(t, err)
+}
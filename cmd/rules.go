@@ -0,0 +1,117 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/bulk"
+	"github.com/tsuru/acl-api/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+func makeRulesCmd() *cobra.Command {
+	var rulesCmd = &cobra.Command{
+		Use:   "rules",
+		Short: "Export and import rules and service instances",
+	}
+
+	var format, output string
+	var exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export every rule and service instance",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			doc, err := bulk.Export()
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return writeFormat(w, format, doc)
+		},
+	}
+	exportCmd.Flags().StringVar(&format, "format", "yaml", "Output format, yaml or json")
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "Output file (default is stdout)")
+
+	var dryRun bool
+	var user string
+	var importCmd = &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import rules and service instances exported as YAML or JSON, use - to read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if args[0] == "-" {
+				data, err = ioutil.ReadAll(cmd.InOrStdin())
+			} else {
+				data, err = ioutil.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+			var doc types.ExportDocument
+			err = yaml.Unmarshal(data, &doc)
+			if err != nil {
+				return errors.Wrap(err, "invalid document")
+			}
+			report, rules, err := bulk.Import(doc, bulk.ImportOptions{DryRun: dryRun, User: user})
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				api.RecordImport(cliActor(user), report)
+				defer webhook.Wait()
+			}
+			if !dryRun && len(rules) > 0 {
+				api.SyncRules(rules)
+			}
+			return writeFormat(cmd.OutOrStdout(), format, report)
+		},
+	}
+	importCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be imported without changing anything")
+	importCmd.Flags().StringVar(&user, "user", "", "Creator of imported rules without one")
+	importCmd.Flags().StringVar(&format, "format", "yaml", "Report format, yaml or json")
+
+	rulesCmd.AddCommand(exportCmd)
+	rulesCmd.AddCommand(importCmd)
+	return rulesCmd
+}
+
+func writeFormat(w io.Writer, format string, v interface{}) error {
+	var data []byte
+	var err error
+	switch format {
+	case "yaml":
+		data, err = yaml.Marshal(v)
+	case "json":
+		data, err = json.MarshalIndent(v, "", "  ")
+	default:
+		return errors.Errorf("invalid format %q, valid values are: json, yaml", format)
+	}
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		data = append(data, '\n')
+	}
+	_, err = w.Write(data)
+	return err
+}
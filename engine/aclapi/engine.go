@@ -0,0 +1,495 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package aclapi implements an engine pushing rules to a legacy network ACL
+// HTTP API. ACLs are created in the networks of the source pool, configured
+// in aclapi.networks, with:
+//
+//	PUT /api/ipv4/acl/<network>          {"kind": "default#acl", "rules": [...]}
+//	DELETE /api/ipv4/acl/<network>/<id>
+//
+// The PUT response holds the ids of the rules in the same order as the
+// request, creating a rule identical to an existing one must return the
+// existing id. IPv6 networks use /api/ipv6 instead. Since rules may share
+// an id, an ACL is only deleted when no other rule holds it.
+package aclapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/external"
+	"github.com/tsuru/acl-api/resolver"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var (
+	_ engine.Engine           = &ACLAPIEngine{}
+	_ engine.EngineWithHooks  = &ACLAPIEngine{}
+	_ engine.EngineWithRender = &ACLAPIEngine{}
+
+	engineName = "aclapi"
+
+	logger = logrus.WithField("engine", engineName)
+
+	// networkLockExpire bounds how long the lock of a network is kept by a
+	// process that died while syncing, networkLockWait how long a sync
+	// waits for a lock held by another sync.
+	networkLockExpire = 5 * time.Minute
+	networkLockWait   = time.Minute
+	networkLockPoll   = 100 * time.Millisecond
+)
+
+type aclRule struct {
+	Action      string     `json:"action"`
+	Protocol    string     `json:"protocol"`
+	Source      string     `json:"source"`
+	Destination string     `json:"destination"`
+	Description string     `json:"description,omitempty"`
+	L4Options   *l4Options `json:"l4-options,omitempty"`
+}
+
+type l4Options struct {
+	DestPortOp    string `json:"dest-port-op"`
+	DestPortStart string `json:"dest-port-start"`
+	DestPortEnd   string `json:"dest-port-end,omitempty"`
+}
+
+type aclRequest struct {
+	Kind  string    `json:"kind"`
+	Rules []aclRule `json:"rules"`
+}
+
+type aclResponse struct {
+	Rules []struct {
+		ID string `json:"id"`
+	} `json:"rules"`
+}
+
+// syncResult is stored as the result of each rule sync. Released holds the
+// ACLs no longer needed by the rule but kept for other rules sharing them.
+type syncResult struct {
+	ACLIds   []storage.ACLIdPair `json:",omitempty"`
+	Removed  []storage.ACLIdPair `json:",omitempty"`
+	Released []storage.ACLIdPair `json:",omitempty"`
+}
+
+// lockNetworks serializes the syncs changing each network, a rule deleting
+// an ACL must not race with another rule creating the same one. The locks
+// are kept in storage so syncs running in every process are serialized, they
+// are taken in a stable order and released by the function returned.
+func lockNetworks(stor storage.ACLAPIStorage, networks []string) (func(), error) {
+	networks = append([]string{}, networks...)
+	sort.Strings(networks)
+	owner := newLockOwner()
+	var held []string
+	unlock := func() {
+		for _, network := range held {
+			err := stor.UnlockNetwork(network, owner)
+			if err != nil {
+				logger.WithError(err).Errorf("unable to unlock network %s", network)
+			}
+		}
+	}
+	for i, network := range networks {
+		if i > 0 && network == networks[i-1] {
+			continue
+		}
+		deadline := time.Now().Add(networkLockWait)
+		for {
+			err := stor.LockNetwork(network, owner, networkLockExpire)
+			if err == nil {
+				break
+			}
+			if err != storage.ErrNetworkLocked || time.Now().After(deadline) {
+				unlock()
+				return nil, errors.Wrapf(err, "unable to lock network %s", network)
+			}
+			time.Sleep(networkLockPoll)
+		}
+		held = append(held, network)
+	}
+	return unlock, nil
+}
+
+func newLockOwner() string {
+	var id [12]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+type ACLAPIEngine struct {
+	mu          sync.Mutex
+	tsuruClient external.TsuruClient
+}
+
+func (e *ACLAPIEngine) Name() string {
+	return engineName
+}
+
+func (e *ACLAPIEngine) BeforeSync(logicCache rule.LogicCache) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tsuruClient = external.NewTsuruClient()
+	return nil
+}
+
+func (e *ACLAPIEngine) AfterSync() error {
+	return nil
+}
+
+func (e *ACLAPIEngine) client() *external.BaseHTTPClient {
+	return &external.BaseHTTPClient{
+		URL:      viper.GetString("aclapi.url"),
+		User:     viper.GetString("aclapi.user"),
+		Password: viper.GetString("aclapi.password"),
+		Logger:   logger.WithField("http-client", engineName),
+	}
+}
+
+// Sync creates the ACLs of r in the firewall API and deletes the ones
+// previously created for r that are no longer needed, every ACL is removed
+// when r is removed. ACLs still held by other rules are only released.
+func (e *ACLAPIEngine) Sync(r types.Rule) (interface{}, error) {
+	ctx := context.TODO()
+	log := logger.WithField("ruleid", r.RuleID)
+
+	stor, err := storage.GetACLAPIStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	var acls map[string][]aclRule
+	if !r.Removed {
+		acls, err = e.ruleACLs(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	synced, err := stor.Find(r.RuleID)
+	if err != nil && err != storage.ErrACLAPISyncedRuleNotFound {
+		return nil, err
+	}
+	if len(acls) == 0 && len(synced.ACLIds) == 0 {
+		log.Debugf("Ignoring rule, no ACLs for source and destination")
+		return nil, nil
+	}
+
+	networks := sortedNetworks(acls)
+	for _, pair := range synced.ACLIds {
+		networks = append(networks, pair.NetworkID)
+	}
+	unlock, err := lockNetworks(stor, networks)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// reloaded with the locks held, a concurrent sync of r may have
+	// changed it
+	synced, err = stor.Find(r.RuleID)
+	if err != nil && err != storage.ErrACLAPISyncedRuleNotFound {
+		return nil, err
+	}
+
+	cli := e.client()
+	var result syncResult
+	for _, network := range sortedNetworks(acls) {
+		ids, err := putACLs(ctx, cli, network, acls[network])
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			result.ACLIds = append(result.ACLIds, storage.ACLIdPair{NetworkID: network, ACLRuleID: id})
+		}
+	}
+	if len(result.ACLIds) > 0 {
+		err = stor.Add(r.RuleID, result.ACLIds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []string
+	for _, pair := range synced.ACLIds {
+		if containsPair(result.ACLIds, pair) {
+			continue
+		}
+		holders, err := otherHolders(stor, r.RuleID, pair)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if len(holders) > 0 {
+			log.Infof("Keeping ACL %s from network %s, used by rules %s", pair.ACLRuleID, pair.NetworkID, strings.Join(holders, ", "))
+			result.Released = append(result.Released, pair)
+			continue
+		}
+		err = deleteACL(ctx, cli, pair)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		log.Infof("Removed ACL %s from network %s", pair.ACLRuleID, pair.NetworkID)
+		result.Removed = append(result.Removed, pair)
+	}
+	if len(result.Removed) > 0 || len(result.Released) > 0 {
+		err = stor.Remove(r.RuleID, append(append([]storage.ACLIdPair{}, result.Removed...), result.Released...))
+		if err != nil {
+			return result, err
+		}
+	}
+	if len(errs) > 0 {
+		return result, errors.Errorf("unable to remove ACLs: %s", strings.Join(errs, ", "))
+	}
+	return result, nil
+}
+
+// Render returns the requests made to the firewall API to create the ACLs of
+// r, for removed rules the deletion of the ACLs created for them and not held
+// by other rules. ACLs no longer needed by rules not removed are only known
+// after the creation.
+func (e *ACLAPIEngine) Render(logicCache rule.LogicCache, r types.Rule) (string, error) {
+	var buf strings.Builder
+	if r.Removed {
+		stor, err := storage.GetACLAPIStorage()
+		if err != nil {
+			return "", err
+		}
+		synced, err := stor.Find(r.RuleID)
+		if err != nil && err != storage.ErrACLAPISyncedRuleNotFound {
+			return "", err
+		}
+		for _, pair := range synced.ACLIds {
+			holders, err := otherHolders(stor, r.RuleID, pair)
+			if err != nil {
+				return "", err
+			}
+			if len(holders) > 0 {
+				fmt.Fprintf(&buf, "no %s of %s/%s, used by rules %s\n", http.MethodDelete, aclPath(pair.NetworkID), pair.ACLRuleID, strings.Join(holders, ", "))
+				continue
+			}
+			fmt.Fprintf(&buf, "%s %s/%s\n", http.MethodDelete, aclPath(pair.NetworkID), pair.ACLRuleID)
+		}
+		return buf.String(), nil
+	}
+	acls, err := e.ruleACLs(context.TODO(), r)
+	if err != nil {
+		return "", err
+	}
+	for _, network := range sortedNetworks(acls) {
+		body, err := json.MarshalIndent(aclRequest{Kind: "default#acl", Rules: acls[network]}, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "%s %s\n%s\n", http.MethodPut, aclPath(network), body)
+	}
+	return buf.String(), nil
+}
+
+func sortedNetworks(acls map[string][]aclRule) []string {
+	networks := make([]string, 0, len(acls))
+	for network := range acls {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// ruleACLs returns the ACLs for r grouped by the source network where they
+// must be created.
+func (e *ACLAPIEngine) ruleACLs(ctx context.Context, r types.Rule) (map[string][]aclRule, error) {
+	sources, err := e.sourceNetworks(r.Source)
+	if err != nil || len(sources) == 0 {
+		return nil, err
+	}
+	destinations, ports, err := destinationNetworks(ctx, r.Destination)
+	if err != nil || len(destinations) == 0 {
+		return nil, err
+	}
+	acls := map[string][]aclRule{}
+	for _, src := range sources {
+		for _, dst := range destinations {
+			if (src.IP.To4() == nil) != (dst.IP.To4() == nil) {
+				continue
+			}
+			for _, acl := range portRules(ports) {
+				acl.Action = "permit"
+				acl.Source = src.String()
+				acl.Destination = dst.String()
+				acl.Description = fmt.Sprintf("acl-api rule %s", r.RuleID)
+				acls[src.String()] = append(acls[src.String()], acl)
+			}
+		}
+	}
+	return acls, nil
+}
+
+// sourceNetworks returns the networks configured for the pool of the source
+// app or job.
+func (e *ACLAPIEngine) sourceNetworks(source types.RuleType) ([]*net.IPNet, error) {
+	e.mu.Lock()
+	if e.tsuruClient == nil {
+		e.tsuruClient = external.NewTsuruClient()
+	}
+	cli := e.tsuruClient
+	e.mu.Unlock()
+
+	var pool string
+	switch {
+	case source.TsuruApp != nil && source.TsuruApp.AppName != "":
+		app, err := cli.AppInfo(source.TsuruApp.AppName)
+		if err != nil {
+			return nil, err
+		}
+		pool = app.Pool
+	case source.TsuruApp != nil:
+		pool = source.TsuruApp.PoolName
+	case source.TsuruJob != nil:
+		job, err := cli.JobInfo(source.TsuruJob.JobName)
+		if err != nil {
+			return nil, err
+		}
+		pool = job.Pool
+	default:
+		return nil, nil
+	}
+	var networks []*net.IPNet
+	for _, cidr := range viper.GetStringMapStringSlice("aclapi.networks")[pool] {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid network for pool %q", pool)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// destinationNetworks returns the networks of ExternalIP and ExternalDNS
+// destinations, names use the addresses tracked by the resolver.
+func destinationNetworks(ctx context.Context, destination types.RuleType) ([]*net.IPNet, types.ProtoPorts, error) {
+	switch {
+	case destination.ExternalIP != nil:
+		network, err := types.ParseExternalIP(destination.ExternalIP.IP)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*net.IPNet{network}, destination.ExternalIP.Ports, nil
+	case destination.ExternalDNS != nil:
+		ips, err := resolver.Lookup(ctx, destination.ExternalDNS.Name)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to resolve %q", destination.ExternalDNS.Name)
+		}
+		var networks []*net.IPNet
+		for _, cidr := range resolver.Networks(ips, destination.ExternalDNS.SyncWholeNetwork) {
+			network, err := types.ParseExternalIP(cidr)
+			if err != nil {
+				return nil, nil, err
+			}
+			networks = append(networks, network)
+		}
+		return networks, destination.ExternalDNS.Ports, nil
+	}
+	return nil, nil, nil
+}
+
+func portRules(ports types.ProtoPorts) []aclRule {
+	if len(ports) == 0 {
+		return []aclRule{{Protocol: "ip"}}
+	}
+	var acls []aclRule
+	for _, p := range ports {
+		acl := aclRule{Protocol: strings.ToLower(p.Protocol)}
+		switch {
+		case p.IsRange():
+			acl.L4Options = &l4Options{DestPortOp: "range", DestPortStart: strconv.Itoa(int(p.Port)), DestPortEnd: strconv.Itoa(int(p.EndPort))}
+		case p.Port != 0:
+			acl.L4Options = &l4Options{DestPortOp: "eq", DestPortStart: strconv.Itoa(int(p.Port))}
+		}
+		acls = append(acls, acl)
+	}
+	return acls
+}
+
+func aclPath(network string) string {
+	version := "ipv4"
+	if strings.Contains(network, ":") {
+		version = "ipv6"
+	}
+	return fmt.Sprintf("/api/%s/acl/%s", version, network)
+}
+
+func putACLs(ctx context.Context, cli *external.BaseHTTPClient, network string, acls []aclRule) ([]string, error) {
+	body, err := json.Marshal(aclRequest{Kind: "default#acl", Rules: acls})
+	if err != nil {
+		return nil, err
+	}
+	data, err := cli.DoRequestDataCtx(ctx, http.MethodPut, aclPath(network), bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json",
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create ACLs in network %s", network)
+	}
+	var rsp aclResponse
+	err = json.Unmarshal(data, &rsp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse ACLs created in network %s", network)
+	}
+	if len(rsp.Rules) != len(acls) {
+		return nil, errors.Errorf("expected %d ACLs created in network %s, got %d", len(acls), network, len(rsp.Rules))
+	}
+	ids := make([]string, len(rsp.Rules))
+	for i, r := range rsp.Rules {
+		ids[i] = r.ID
+	}
+	return ids, nil
+}
+
+func deleteACL(ctx context.Context, cli *external.BaseHTTPClient, pair storage.ACLIdPair) error {
+	_, err := cli.DoRequestDataCtx(ctx, http.MethodDelete, aclPath(pair.NetworkID)+"/"+pair.ACLRuleID, nil, nil)
+	if httpErr, ok := errors.Cause(err).(*external.HTTPError); ok && httpErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// otherHolders returns the rules other than ruleID holding pair.
+func otherHolders(stor storage.ACLAPIStorage, ruleID string, pair storage.ACLIdPair) ([]string, error) {
+	ruleIDs, err := stor.FindByACLId(pair)
+	if err != nil {
+		return nil, err
+	}
+	var holders []string
+	for _, id := range ruleIDs {
+		if id != ruleID {
+			holders = append(holders, id)
+		}
+	}
+	return holders, nil
+}
+
+func containsPair(pairs []storage.ACLIdPair, pair storage.ACLIdPair) bool {
+	for _, p := range pairs {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,452 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aclapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/resolver"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-engine-aclapi")
+}
+
+// fakeFirewall is a stand-in for the network ACL API, identical rules in a
+// network share the same id, descriptions are not compared.
+type fakeFirewall struct {
+	sync.Mutex
+	nextID  int
+	acls    map[string]map[string]aclRule
+	deletes []string
+}
+
+func (f *fakeFirewall) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "fw-user" || pass != "fw-pass" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	parts := strings.SplitN(path, "/acl/", 2)
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		network := parts[1]
+		var req aclRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Kind != "default#acl" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if f.acls[network] == nil {
+			f.acls[network] = map[string]aclRule{}
+		}
+		var rsp aclResponse
+		for _, acl := range req.Rules {
+			id := ""
+			for existingID, existing := range f.acls[network] {
+				existing.Description = acl.Description
+				if assert.ObjectsAreEqual(existing, acl) {
+					id = existingID
+				}
+			}
+			if id == "" {
+				f.nextID++
+				id = fmt.Sprintf("%d", f.nextID)
+				f.acls[network][id] = acl
+			}
+			rsp.Rules = append(rsp.Rules, struct {
+				ID string `json:"id"`
+			}{ID: id})
+		}
+		json.NewEncoder(w).Encode(rsp)
+	case http.MethodDelete:
+		idx := strings.LastIndex(parts[1], "/")
+		network, id := parts[1][:idx], parts[1][idx+1:]
+		f.deletes = append(f.deletes, network+" "+id)
+		if _, ok := f.acls[network][id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.acls[network], id)
+	}
+}
+
+type fakeResolver func(ctx context.Context, name string) ([]resolver.Address, error)
+
+func (f fakeResolver) Resolve(ctx context.Context, name string) ([]resolver.Address, error) {
+	return f(ctx, name)
+}
+
+func setupEngine(t *testing.T) (*ACLAPIEngine, *fakeFirewall, storage.ACLAPIStorage) {
+	stor, err := storage.GetACLAPIStorage()
+	require.NoError(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+
+	tsuruSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app1":
+			w.Write([]byte(`{"name": "app1", "pool": "p1"}`))
+		case "/jobs/job1":
+			w.Write([]byte(`{"job": {"name": "job1", "pool": "p2"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(tsuruSrv.Close)
+	fw := &fakeFirewall{acls: map[string]map[string]aclRule{}}
+	fwSrv := httptest.NewServer(fw)
+	t.Cleanup(fwSrv.Close)
+
+	viper.Set("tsuru.host", tsuruSrv.URL)
+	viper.Set("aclapi.url", fwSrv.URL)
+	viper.Set("aclapi.user", "fw-user")
+	viper.Set("aclapi.password", "fw-pass")
+	viper.Set("aclapi.networks", map[string]interface{}{
+		"p1": []string{"10.0.0.0/24", "2001:db8::/64"},
+		"p2": []string{"10.1.0.0/24"},
+	})
+	t.Cleanup(func() {
+		viper.Set("aclapi.networks", nil)
+	})
+
+	e := &ACLAPIEngine{}
+	err = e.BeforeSync(rule.NewLogicCache())
+	require.NoError(t, err)
+	return e, fw, stor
+}
+
+func TestACLAPIEngine_SyncExternalIP(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{
+			IP:    "192.168.0.1",
+			Ports: types.ProtoPorts{{Protocol: "TCP", Port: 443}, {Protocol: "udp", Port: 53}},
+		}},
+	}
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	expectedIDs := []storage.ACLIdPair{
+		{NetworkID: "10.0.0.0/24", ACLRuleID: "1"},
+		{NetworkID: "10.0.0.0/24", ACLRuleID: "2"},
+	}
+	assert.Equal(t, syncResult{ACLIds: expectedIDs}, result)
+	assert.Equal(t, map[string]map[string]aclRule{
+		"10.0.0.0/24": {
+			"1": {Action: "permit", Protocol: "tcp", Source: "10.0.0.0/24", Destination: "192.168.0.1/32", Description: "acl-api rule r1", L4Options: &l4Options{DestPortOp: "eq", DestPortStart: "443"}},
+			"2": {Action: "permit", Protocol: "udp", Source: "10.0.0.0/24", Destination: "192.168.0.1/32", Description: "acl-api rule r1", L4Options: &l4Options{DestPortOp: "eq", DestPortStart: "53"}},
+		},
+	}, fw.acls)
+	synced, err := stor.Find("r1")
+	require.NoError(t, err)
+	assert.Equal(t, expectedIDs, synced.ACLIds)
+
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{ACLIds: expectedIDs}, result)
+	assert.Empty(t, fw.deletes)
+
+	r.Destination.ExternalIP.Ports = nil
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{
+		ACLIds:  []storage.ACLIdPair{{NetworkID: "10.0.0.0/24", ACLRuleID: "3"}},
+		Removed: expectedIDs,
+	}, result)
+	assert.Equal(t, map[string]aclRule{
+		"3": {Action: "permit", Protocol: "ip", Source: "10.0.0.0/24", Destination: "192.168.0.1/32", Description: "acl-api rule r1"},
+	}, fw.acls["10.0.0.0/24"])
+
+	r.Removed = true
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{Removed: []storage.ACLIdPair{{NetworkID: "10.0.0.0/24", ACLRuleID: "3"}}}, result)
+	assert.Empty(t, fw.acls["10.0.0.0/24"])
+	synced, err = stor.Find("r1")
+	require.NoError(t, err)
+	assert.Empty(t, synced.ACLIds)
+
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestACLAPIEngine_SyncSharedACL(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	newRule := func(id string) types.Rule {
+		return types.Rule{
+			RuleID: id,
+			Source: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{
+				IP:    "192.168.0.1",
+				Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+			}},
+		}
+	}
+	r1, r2 := newRule("r1"), newRule("r2")
+	shared := storage.ACLIdPair{NetworkID: "10.0.0.0/24", ACLRuleID: "1"}
+	for _, r := range []types.Rule{r1, r2} {
+		result, err := e.Sync(r)
+		require.NoError(t, err)
+		assert.Equal(t, syncResult{ACLIds: []storage.ACLIdPair{shared}}, result)
+	}
+
+	r1.Removed = true
+	rendered, err := e.Render(rule.NewLogicCache(), r1)
+	require.NoError(t, err)
+	assert.Equal(t, "no DELETE of /api/ipv4/acl/10.0.0.0/24/1, used by rules r2\n", rendered)
+	result, err := e.Sync(r1)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{Released: []storage.ACLIdPair{shared}}, result)
+	assert.Empty(t, fw.deletes)
+	assert.Len(t, fw.acls["10.0.0.0/24"], 1)
+	synced, err := stor.Find("r1")
+	require.NoError(t, err)
+	assert.Empty(t, synced.ACLIds)
+
+	r2.Removed = true
+	rendered, err = e.Render(rule.NewLogicCache(), r2)
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE /api/ipv4/acl/10.0.0.0/24/1\n", rendered)
+	result, err = e.Sync(r2)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{Removed: []storage.ACLIdPair{shared}}, result)
+	assert.Equal(t, []string{"10.0.0.0/24 1"}, fw.deletes)
+	assert.Empty(t, fw.acls["10.0.0.0/24"])
+}
+
+func TestACLAPIEngine_SyncPortRange(t *testing.T) {
+	e, fw, _ := setupEngine(t)
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{
+			IP:    "192.168.0.1",
+			Ports: types.ProtoPorts{{Protocol: "TCP", Port: 8000, EndPort: 8080}, {Protocol: "TCP", Port: 443, EndPort: 443}},
+		}},
+	}
+	_, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]aclRule{
+		"10.0.0.0/24": {
+			"1": {Action: "permit", Protocol: "tcp", Source: "10.0.0.0/24", Destination: "192.168.0.1/32", Description: "acl-api rule r1", L4Options: &l4Options{DestPortOp: "range", DestPortStart: "8000", DestPortEnd: "8080"}},
+			"2": {Action: "permit", Protocol: "tcp", Source: "10.0.0.0/24", Destination: "192.168.0.1/32", Description: "acl-api rule r1", L4Options: &l4Options{DestPortOp: "eq", DestPortStart: "443"}},
+		},
+	}, fw.acls)
+}
+
+func TestACLAPIEngine_SyncExternalDNS(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	addrs := []string{"192.168.0.1", "2001:db8:1::1"}
+	oldResolver := resolver.GetResolver
+	defer func() { resolver.GetResolver = oldResolver }()
+	resolver.GetResolver = func() resolver.Resolver {
+		return fakeResolver(func(ctx context.Context, name string) ([]resolver.Address, error) {
+			assert.Equal(t, "a.example.com", name)
+			var ret []resolver.Address
+			for _, addr := range addrs {
+				ret = append(ret, resolver.Address{IP: net.ParseIP(addr), TTL: time.Nanosecond})
+			}
+			return ret, nil
+		})
+	}
+
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p1"}},
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{
+			Name:  "a.example.com",
+			Ports: types.ProtoPorts{{Protocol: "tcp", Port: 80}},
+		}},
+	}
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{ACLIds: []storage.ACLIdPair{
+		{NetworkID: "10.0.0.0/24", ACLRuleID: "1"},
+		{NetworkID: "2001:db8::/64", ACLRuleID: "2"},
+	}}, result)
+	assert.Equal(t, "2001:db8:1::1/128", fw.acls["2001:db8::/64"]["2"].Destination)
+
+	addrs = []string{"192.168.0.2"}
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{
+		ACLIds: []storage.ACLIdPair{{NetworkID: "10.0.0.0/24", ACLRuleID: "3"}},
+		Removed: []storage.ACLIdPair{
+			{NetworkID: "10.0.0.0/24", ACLRuleID: "1"},
+			{NetworkID: "2001:db8::/64", ACLRuleID: "2"},
+		},
+	}, result)
+	assert.Equal(t, []string{"10.0.0.0/24 1", "2001:db8::/64 2"}, fw.deletes)
+	synced, err := stor.Find("r1")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ACLIdPair{{NetworkID: "10.0.0.0/24", ACLRuleID: "3"}}, synced.ACLIds)
+}
+
+func TestACLAPIEngine_SyncIgnored(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	rules := []types.Rule{
+		{
+			RuleID:      "pool-without-networks",
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p3"}},
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "192.168.0.1"}},
+		},
+		{
+			RuleID:      "unsupported-destination",
+			Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+			Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		},
+		{
+			RuleID:      "ipv6-destination",
+			Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "2001:db8::1"}},
+		},
+	}
+	for _, r := range rules {
+		result, err := e.Sync(r)
+		require.NoError(t, err, r.RuleID)
+		assert.Nil(t, result, r.RuleID)
+		_, err = stor.Find(r.RuleID)
+		assert.Equal(t, storage.ErrACLAPISyncedRuleNotFound, err, r.RuleID)
+	}
+	assert.Empty(t, fw.acls)
+}
+
+func TestACLAPIEngine_SyncErrors(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	r := types.Rule{
+		RuleID:      "r1",
+		Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "192.168.0.0/16"}},
+	}
+	viper.Set("aclapi.password", "wrong")
+	_, err := e.Sync(r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to create ACLs in network 10.1.0.0/24")
+	assert.Contains(t, err.Error(), "invalid status code 401")
+
+	viper.Set("aclapi.password", "fw-pass")
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, syncResult{ACLIds: []storage.ACLIdPair{{NetworkID: "10.1.0.0/24", ACLRuleID: "1"}}}, result)
+	assert.Equal(t, "192.168.0.0/16", fw.acls["10.1.0.0/24"]["1"].Destination)
+
+	err = stor.Add("r1", []storage.ACLIdPair{{NetworkID: "10.1.0.0/24", ACLRuleID: "999"}})
+	require.NoError(t, err)
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ACLIdPair{{NetworkID: "10.1.0.0/24", ACLRuleID: "999"}}, result.(syncResult).Removed)
+	synced, err := stor.Find("r1")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ACLIdPair{{NetworkID: "10.1.0.0/24", ACLRuleID: "1"}}, synced.ACLIds)
+}
+
+func TestACLAPIEngine_SyncNetworkLocked(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	oldWait, oldPoll := networkLockWait, networkLockPoll
+	networkLockWait, networkLockPoll = 50*time.Millisecond, 10*time.Millisecond
+	defer func() {
+		networkLockWait, networkLockPoll = oldWait, oldPoll
+	}()
+	r := types.Rule{
+		RuleID:      "r1",
+		Source:      types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "192.168.0.1"}},
+	}
+	err := stor.LockNetwork("10.1.0.0/24", "other-process", time.Minute)
+	require.NoError(t, err)
+	_, err = e.Sync(r)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to lock network 10.1.0.0/24")
+	assert.Empty(t, fw.acls)
+
+	err = stor.UnlockNetwork("10.1.0.0/24", "other-process")
+	require.NoError(t, err)
+	_, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Len(t, fw.acls["10.1.0.0/24"], 1)
+	err = stor.LockNetwork("10.1.0.0/24", "other-process", time.Minute)
+	assert.NoError(t, err, "the lock is released after the sync")
+}
+
+func TestACLAPIEngine_Render(t *testing.T) {
+	e, fw, stor := setupEngine(t)
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{
+			IP:    "192.168.0.1",
+			Ports: types.ProtoPorts{{Protocol: "TCP", Port: 443}},
+		}},
+	}
+	rendered, err := e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, `PUT /api/ipv4/acl/10.0.0.0/24
+{
+  "kind": "default#acl",
+  "rules": [
+    {
+      "action": "permit",
+      "protocol": "tcp",
+      "source": "10.0.0.0/24",
+      "destination": "192.168.0.1/32",
+      "description": "acl-api rule r1",
+      "l4-options": {
+        "dest-port-op": "eq",
+        "dest-port-start": "443"
+      }
+    }
+  ]
+}
+`, rendered)
+	assert.Empty(t, fw.acls)
+
+	err = stor.Add("r1", []storage.ACLIdPair{{NetworkID: "10.0.0.0/24", ACLRuleID: "7"}})
+	require.NoError(t, err)
+	r.Removed = true
+	rendered, err = e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE /api/ipv4/acl/10.0.0.0/24/7\n", rendered)
+	assert.Empty(t, fw.deletes)
+	synced, err := stor.Find("r1")
+	require.NoError(t, err)
+	assert.Len(t, synced.ACLIds, 1)
+
+	r.Removed = false
+	r.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p3"}}
+	rendered, err = e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Empty(t, rendered)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var driftedRules = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: promNamespace,
+	Subsystem: promSubsystem,
+	Name:      "drifted_rules",
+	Help:      "The number of rules whose actual state differs from the synced one in the latest drift check",
+}, []string{"engine"})
+
+// EngineWithVerify is implemented by engines able to check the actual state
+// of a rule. Verify returns the differences from the state expected after a
+// successful sync of r, none when the rule is current.
+type EngineWithVerify interface {
+	Verify(logicCache rule.LogicCache, r types.Rule) ([]string, error)
+}
+
+// CheckDrift verifies rules in every enabled engine implementing
+// EngineWithVerify, storing the result with their syncs. Only rules whose
+// latest sync in the engine succeeded are checked, the others are going to be
+// synced again anyway.
+func CheckDrift(rules []types.Rule) error {
+	stor, err := storage.GetSyncStorage()
+	if err != nil {
+		return err
+	}
+	logicCache := rule.NewLogicCache()
+	for _, eFactory := range enabledEngines {
+		e := eFactory()
+		verifyEngine, ok := e.(EngineWithVerify)
+		if !ok {
+			continue
+		}
+		err = checkEngineDrift(stor, logicCache, e, verifyEngine, rules, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkEngineDrift(stor storage.SyncStorage, logicCache rule.LogicCache, e Engine, verifyEngine EngineWithVerify, rules []types.Rule, now time.Time) error {
+	log := logrus.WithField("engine", e.Name())
+	syncs, err := stor.Find(storage.SyncFindOpts{Engines: []string{e.Name()}})
+	if err != nil {
+		return err
+	}
+	ruleSyncs := make(map[string]types.RuleSyncInfo, len(syncs))
+	for _, info := range syncs {
+		ruleSyncs[info.RuleID] = info
+	}
+	drifted := 0
+	for _, r := range rules {
+		info, ok := ruleSyncs[r.RuleID]
+		if !ok || info.Running {
+			continue
+		}
+		if latestSync := info.LatestSync(); latestSync == nil || !latestSync.Successful {
+			continue
+		}
+		if filterEngine, ok := e.(EngineWithFilter); ok {
+			allowed, err := filterEngine.Allowed(r)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				continue
+			}
+		}
+		drift := types.RuleDrift{CheckTime: now}
+		differences, err := verifyEngine.Verify(logicCache, r)
+		if err != nil {
+			log.WithField("ruleid", r.RuleID).Warnf("unable to verify rule: %v", err)
+			drift.Error = err.Error()
+		} else if len(differences) > 0 {
+			log.WithField("ruleid", r.RuleID).Warnf("rule drifted: %v", differences)
+			drift.Drifted = true
+			drift.Differences = differences
+			drifted++
+		}
+		err = stor.SetDrift(r.RuleID, e.Name(), drift)
+		if err != nil {
+			// the other rules are still checked and counted
+			log.WithField("ruleid", r.RuleID).Errorf("unable to store drift: %v", err)
+		}
+	}
+	driftedRules.WithLabelValues(e.Name()).Set(float64(drifted))
+	return nil
+}
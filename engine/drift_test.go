@@ -0,0 +1,114 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+)
+
+type verifyEngine struct {
+	failingEngine
+	differences map[string][]string
+	verified    []string
+}
+
+func (e *verifyEngine) Verify(logicCache rule.LogicCache, r types.Rule) ([]string, error) {
+	e.verified = append(e.verified, r.RuleID)
+	if r.RuleID == "r4" {
+		return nil, errors.New("cluster unavailable")
+	}
+	return e.differences[r.RuleID], nil
+}
+
+type failingDriftStorage struct {
+	storage.SyncStorage
+	failRuleID string
+}
+
+func (s *failingDriftStorage) SetDrift(ruleID, engine string, drift types.RuleDrift) error {
+	if ruleID == s.failRuleID {
+		return errors.New("storage unavailable")
+	}
+	return s.SyncStorage.SetDrift(ruleID, engine, drift)
+}
+
+func Test_checkEngineDrift(t *testing.T) {
+	viper.Set("storage", "memory://acltest-pkg-engine")
+	defer viper.Reset()
+	stor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	e := &verifyEngine{differences: map[string][]string{"r2": {"annotation outdated"}}}
+	for ruleID, successful := range map[string]bool{"r1": true, "r2": true, "r3": false, "r4": true} {
+		_, ruleSync, err := stor.StartSync(0, ruleID, e.Name(), true)
+		require.NoError(t, err)
+		err = stor.EndSync(*ruleSync, types.RuleSyncData{Successful: successful})
+		require.NoError(t, err)
+	}
+	_, _, err = stor.StartSync(0, "r5", e.Name(), true)
+	require.NoError(t, err)
+	rules := []types.Rule{{RuleID: "r1"}, {RuleID: "r2"}, {RuleID: "r3"}, {RuleID: "r4"}, {RuleID: "r5"}, {RuleID: "r6"}}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err = checkEngineDrift(stor, rule.NewLogicCache(), e, e, rules, now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r2", "r4"}, e.verified)
+	assert.Equal(t, 1.0, testutil.ToFloat64(driftedRules.WithLabelValues(e.Name())))
+
+	syncs, err := stor.Find(storage.SyncFindOpts{Drifted: true})
+	require.NoError(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, "r2", syncs[0].RuleID)
+	assert.Equal(t, &types.RuleDrift{CheckTime: now, Drifted: true, Differences: []string{"annotation outdated"}}, syncs[0].Drift)
+	syncs, err = stor.Find(storage.SyncFindOpts{RuleIDs: []string{"r1", "r3", "r4"}})
+	require.NoError(t, err)
+	drifts := map[string]*types.RuleDrift{}
+	for _, info := range syncs {
+		drifts[info.RuleID] = info.Drift
+	}
+	assert.Equal(t, &types.RuleDrift{CheckTime: now}, drifts["r1"])
+	assert.Nil(t, drifts["r3"])
+	assert.Equal(t, &types.RuleDrift{CheckTime: now, Error: "cluster unavailable"}, drifts["r4"])
+}
+
+func Test_checkEngineDrift_setDriftError(t *testing.T) {
+	viper.Set("storage", "memory://acltest-pkg-engine")
+	defer viper.Reset()
+	stor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	e := &verifyEngine{differences: map[string][]string{"r2": {"annotation outdated"}}}
+	for _, ruleID := range []string{"r1", "r2"} {
+		_, ruleSync, err := stor.StartSync(0, ruleID, e.Name(), true)
+		require.NoError(t, err)
+		err = stor.EndSync(*ruleSync, types.RuleSyncData{Successful: true})
+		require.NoError(t, err)
+	}
+	driftedRules.WithLabelValues(e.Name()).Set(0)
+
+	err = checkEngineDrift(&failingDriftStorage{SyncStorage: stor, failRuleID: "r1"}, rule.NewLogicCache(), e, e, []types.Rule{{RuleID: "r1"}, {RuleID: "r2"}}, time.Now().UTC())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r2"}, e.verified)
+	assert.Equal(t, 1.0, testutil.ToFloat64(driftedRules.WithLabelValues(e.Name())))
+	syncs, err := stor.Find(storage.SyncFindOpts{Drifted: true})
+	require.NoError(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, "r2", syncs[0].RuleID)
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+)
+
+// DryRunSuffix is appended to the name of engines in dry-run mode, both in
+// the engines config and in their syncs.
+const DryRunSuffix = ":dry-run"
+
+// EngineWithRender is implemented by engines able to show what syncing a rule
+// does without applying it. Render returns the artifacts the sync of r
+// creates, like a manifest or a request body, or an empty string when r is
+// ignored by the engine.
+type EngineWithRender interface {
+	Render(logicCache rule.LogicCache, r types.Rule) (string, error)
+}
+
+var (
+	_ Engine           = &dryRunEngine{}
+	_ EngineWithHooks  = &dryRunEngine{}
+	_ EngineWithFilter = &dryRunEngine{}
+)
+
+// dryRunEngine syncs rules by rendering them with the wrapped engine, the
+// rendered output is stored as the sync result and nothing is applied.
+type dryRunEngine struct {
+	engine     Engine
+	logicCache rule.LogicCache
+}
+
+// DryRun wraps e so syncing a rule only renders it, e must implement
+// EngineWithRender.
+func DryRun(e Engine) Engine {
+	return &dryRunEngine{engine: e}
+}
+
+func (e *dryRunEngine) Name() string {
+	return e.engine.Name() + DryRunSuffix
+}
+
+func (e *dryRunEngine) BeforeSync(logicCache rule.LogicCache) error {
+	e.logicCache = logicCache
+	return nil
+}
+
+// AfterSync does nothing, the hooks of the wrapped engine apply changes.
+func (e *dryRunEngine) AfterSync() error {
+	return nil
+}
+
+func (e *dryRunEngine) Allowed(r types.Rule) (bool, error) {
+	if filterEngine, ok := e.engine.(EngineWithFilter); ok {
+		return filterEngine.Allowed(r)
+	}
+	return true, nil
+}
+
+func (e *dryRunEngine) Sync(r types.Rule) (interface{}, error) {
+	renderEngine, ok := e.engine.(EngineWithRender)
+	if !ok {
+		return nil, Permanent(errors.Errorf("engine %s does not support dry-run", e.engine.Name()))
+	}
+	logicCache := e.logicCache
+	if logicCache == nil {
+		logicCache = rule.NewLogicCache()
+	}
+	rendered, err := renderEngine.Render(logicCache, r)
+	if err != nil || rendered == "" {
+		return nil, err
+	}
+	return rendered, nil
+}
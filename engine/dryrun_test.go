@@ -0,0 +1,89 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+)
+
+type renderEngine struct {
+	failingEngine
+	synced     []string
+	afterSyncs int
+}
+
+func (e *renderEngine) Sync(r types.Rule) (interface{}, error) {
+	e.synced = append(e.synced, r.RuleID)
+	return nil, nil
+}
+
+func (e *renderEngine) BeforeSync(logicCache rule.LogicCache) error {
+	return nil
+}
+
+func (e *renderEngine) AfterSync() error {
+	e.afterSyncs++
+	return nil
+}
+
+func (e *renderEngine) Allowed(r types.Rule) (bool, error) {
+	return r.RuleID != "skipped", nil
+}
+
+func (e *renderEngine) Render(logicCache rule.LogicCache, r types.Rule) (string, error) {
+	switch r.RuleID {
+	case "ignored":
+		return "", nil
+	case "invalid":
+		return "", errors.New("invalid rule")
+	}
+	return "create " + r.RuleID + "\n", nil
+}
+
+func TestDryRun(t *testing.T) {
+	wrapped := &renderEngine{}
+	e := DryRun(wrapped)
+	assert.Equal(t, "failing:dry-run", e.Name())
+	hooksEngine := e.(EngineWithHooks)
+	require.NoError(t, hooksEngine.BeforeSync(rule.NewLogicCache()))
+
+	svc := &fakeRuleSvc{}
+	log := logrus.WithField("test", t.Name())
+	err := syncRule(log, svc, e, types.Rule{RuleID: "r1"}, syncOpts{})
+	require.NoError(t, err)
+	require.Len(t, svc.data, 1)
+	assert.True(t, svc.data[0].Successful)
+	assert.Equal(t, `"create r1\n"`, svc.data[0].SyncResult)
+
+	err = syncRule(log, svc, e, types.Rule{RuleID: "ignored"}, syncOpts{})
+	require.NoError(t, err)
+	require.Len(t, svc.data, 2)
+	assert.Empty(t, svc.data[1].SyncResult)
+
+	err = syncRule(log, svc, e, types.Rule{RuleID: "invalid"}, syncOpts{})
+	require.Error(t, err)
+	require.Len(t, svc.data, 3)
+	assert.Equal(t, "invalid rule", svc.data[2].Error)
+
+	err = syncRule(log, svc, e, types.Rule{RuleID: "skipped"}, syncOpts{})
+	require.NoError(t, err)
+	assert.Len(t, svc.data, 3)
+
+	require.NoError(t, hooksEngine.AfterSync())
+	assert.Empty(t, wrapped.synced)
+	assert.Equal(t, 0, wrapped.afterSyncs)
+
+	e = DryRun(&failingEngine{})
+	_, err = e.Sync(types.Rule{RuleID: "r1"})
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+}
@@ -6,6 +6,7 @@ package engine
 
 import (
 	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/rule"
 	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/webhook"
 )
 
 const (
@@ -64,9 +66,22 @@ type EngineWithHooks interface {
 
 var (
 	enabledEngines []func() Engine
+
+	// ErrSyncSkipped is returned when the sync did not run, the rule is being
+	// synced by another worker or its next sync is not due yet.
+	ErrSyncSkipped = errors.New("sync skipped, rule being synced or not due yet")
 )
 
-func syncRule(log *logrus.Entry, ruleSvc rule.EngineRuleService, e Engine, r types.Rule, force bool) (err error) {
+// syncOpts controls how rules are synced, retries of failed syncs ignore
+// sync.interval but still wait for the scheduled retry time.
+type syncOpts struct {
+	force    bool
+	retry    bool
+	priority Priority
+	listener SyncListener
+}
+
+func syncRule(log *logrus.Entry, ruleSvc rule.EngineRuleService, e Engine, r types.Rule, opts syncOpts) (err error) {
 	if filterEngine, ok := e.(EngineWithFilter); ok {
 		var allowed bool
 		allowed, err = filterEngine.Allowed(r)
@@ -79,10 +94,13 @@ func syncRule(log *logrus.Entry, ruleSvc rule.EngineRuleService, e Engine, r typ
 		}
 	}
 	syncInterval := viper.GetDuration("sync.interval")
-	_, ruleSync, err := ruleSvc.SyncStart(syncInterval, r.RuleID, e.Name(), force)
+	if opts.retry {
+		syncInterval = 0
+	}
+	_, ruleSync, err := ruleSvc.SyncStart(syncInterval, r.RuleID, e.Name(), opts.force)
 	if err != nil {
 		if err == storage.ErrSyncStorageLocked {
-			return nil
+			return ErrSyncSkipped
 		}
 		return err
 	}
@@ -94,10 +112,23 @@ func syncRule(log *logrus.Entry, ruleSvc rule.EngineRuleService, e Engine, r typ
 		syncData.Removed = r.Removed
 		if err != nil {
 			syncData.Error = err.Error()
+			syncData.Permanent = IsPermanent(err)
 		}
+		retry := nextRetry(ruleSync, err, syncData.EndTime)
 		syncEndErr := ruleSvc.SyncEnd(*ruleSync, syncData)
 		if syncEndErr != nil {
 			log.Errorf("unable to mark sync end for rule %v: %v", r.String(), syncEndErr)
+			return
+		}
+		if err != nil && ruleSync.Attempts == 1 {
+			// only the first failure is notified, retries and
+			// reconciliations of a rule still failing are not
+			failed := r
+			webhook.Notify(types.WebhookEvent{Type: types.WebhookSyncFailed, Rule: &failed, Engine: e.Name(), Error: err.Error()})
+		}
+		if retry {
+			log.Infof("sync retry %d scheduled for %v", ruleSync.Attempts, ruleSync.NextRetryTime)
+			retries.schedule(e.Name(), r.RuleID, ruleSync.NextRetryTime)
 		}
 	}()
 	syncData.StartTime = time.Now().UTC()
@@ -118,7 +149,9 @@ func syncRule(log *logrus.Entry, ruleSvc rule.EngineRuleService, e Engine, r typ
 	return nil
 }
 
-func engineSync(e Engine, rules []types.Rule, logicCache rule.LogicCache, force bool) {
+// engineSync runs the hooks of e around the sync of rules, which are
+// synced in parallel by the queue of the engine.
+func engineSync(e Engine, rules []types.Rule, logicCache rule.LogicCache, opts syncOpts) {
 	log := logrus.WithField("engine", e.Name())
 	fullTimer := prometheus.NewTimer(fullSyncDuration.WithLabelValues(e.Name()))
 	defer fullTimer.ObserveDuration()
@@ -130,17 +163,23 @@ func engineSync(e Engine, rules []types.Rule, logicCache rule.LogicCache, force
 		}
 	}
 	ruleSvc := rule.GetServiceForEngine()
+	q := queueFor(e.Name())
+	var wg sync.WaitGroup
+	wg.Add(len(rules))
 	for _, r := range rules {
-		ruleLog := log.WithField("ruleid", r.RuleID)
-		ruleLog.Info("Starting single rule sync")
-		ruleTimer := prometheus.NewTimer(ruleSyncDuration.WithLabelValues(e.Name()))
-		err := syncRule(ruleLog, ruleSvc, e, r, force)
-		ruleTimer.ObserveDuration()
-		if err != nil {
-			ruleSyncFailuresTotal.WithLabelValues(e.Name()).Inc()
-			ruleLog.Errorf("error syncing rule %v: %v", r.String(), err)
+		it := &syncItem{
+			rule:    r,
+			opts:    opts,
+			engine:  e,
+			log:     log,
+			ruleSvc: ruleSvc,
 		}
+		if opts.listener != nil {
+			it.listeners = []SyncListener{opts.listener}
+		}
+		q.add(it, &wg)
 	}
+	wg.Wait()
 	if hooksEngine != nil {
 		err := hooksEngine.AfterSync()
 		if err != nil {
@@ -153,7 +192,34 @@ func EnableEngine(eng func() Engine) {
 	enabledEngines = append(enabledEngines, eng)
 }
 
+// Enabled returns an instance of each enabled engine.
+func Enabled() []Engine {
+	engines := make([]Engine, 0, len(enabledEngines))
+	for _, eFactory := range enabledEngines {
+		engines = append(engines, eFactory())
+	}
+	return engines
+}
+
+// SyncRules syncs rules in every enabled engine, ahead of the background
+// reconciliation, and returns once they are synced.
 func SyncRules(rules []types.Rule, force bool) {
+	syncRules(rules, syncOpts{force: force, priority: PriorityUser})
+}
+
+// SyncRulesWithListener is SyncRules notifying l about the sync of each rule
+// in each engine.
+func SyncRulesWithListener(rules []types.Rule, force bool, l SyncListener) {
+	syncRules(rules, syncOpts{force: force, priority: PriorityUser, listener: l})
+}
+
+// ReconcileRules syncs rules in every enabled engine with background
+// priority, used by the periodic reconciliation.
+func ReconcileRules(rules []types.Rule, force bool) {
+	syncRules(rules, syncOpts{force: force, priority: PriorityBackground})
+}
+
+func syncRules(rules []types.Rule, opts syncOpts) {
 	logicCache := rule.NewLogicCache()
 	wg := sync.WaitGroup{}
 	for _, eFactory := range enabledEngines {
@@ -161,7 +227,7 @@ func SyncRules(rules []types.Rule, force bool) {
 		wg.Add(1)
 		go func(e Engine) {
 			defer wg.Done()
-			engineSync(e, rules, logicCache, force)
+			engineSync(e, rules, logicCache, opts)
 		}(e)
 	}
 	wg.Wait()
@@ -0,0 +1,309 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netpol implements an engine rendering rules directly as kubernetes
+// egress NetworkPolicies, without depending on an external operator.
+package netpol
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	aclKube "github.com/tsuru/acl-api/kubernetes"
+	"github.com/tsuru/acl-api/rule"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	_ engine.Engine            = &NetworkPolicyEngine{}
+	_ engine.EngineWithHooks   = &NetworkPolicyEngine{}
+	_ engine.EngineWithCluster = &NetworkPolicyEngine{}
+	_ engine.EngineWithRender  = &NetworkPolicyEngine{}
+
+	engineName = "network-policy"
+
+	logger = logrus.WithField("engine", engineName)
+)
+
+const (
+	policyPrefix = "acl-api-"
+
+	ruleIDLabel    = "acl-api.tsuru.io/rule-id"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "acl-api"
+
+	appNameLabel       = "tsuru.io/app-name"
+	appPoolLabel       = "tsuru.io/app-pool"
+	jobNameLabel       = "tsuru.io/job-name"
+	rpaasInstanceLabel = "rpaas.extensions.tsuru.io/instance-name"
+	rpaasServiceLabel  = "rpaas.extensions.tsuru.io/service-name"
+)
+
+// target is a namespace where policies were handled in the current sync,
+// AfterSync looks for policies of removed rules in every target.
+type target struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+type NetworkPolicyEngine struct {
+	mu         sync.Mutex
+	logicCache rule.LogicCache
+}
+
+func (e *NetworkPolicyEngine) Name() string {
+	return engineName
+}
+
+func (e *NetworkPolicyEngine) BeforeSync(logicCache rule.LogicCache) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logicCache = logicCache
+	return nil
+}
+
+// AfterSync does nothing, the policies of removed rules are deleted by Sync
+// so the removal is only recorded as synced once the policy is gone.
+func (e *NetworkPolicyEngine) AfterSync() error {
+	return nil
+}
+
+// Cluster returns the kubernetes API address of the rule source.
+func (e *NetworkPolicyEngine) Cluster(r types.Rule) (string, error) {
+	return engine.RuleCluster(e.logicCache, r)
+}
+
+func (e *NetworkPolicyEngine) Sync(r types.Rule) (interface{}, error) {
+	ctx := context.TODO()
+	log := logger.WithField("ruleid", r.RuleID)
+
+	if sourceSelector(r.Source) == nil {
+		log.Debugf("Ignoring rule, source not supported by network policies")
+		return nil, nil
+	}
+
+	e.mu.Lock()
+	logicCache := e.logicCache
+	e.mu.Unlock()
+	t, _, err := sourceTarget(ctx, logicCache, r)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		log.Debugf("Ignoring rule, not a kubernetes source")
+		return nil, nil
+	}
+
+	if r.Removed {
+		name := policyPrefix + r.RuleID
+		err = t.client.NetworkingV1().NetworkPolicies(t.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "unable to remove network policy %s/%s", t.namespace, name)
+		}
+		log.Infof("Removed network policy %s/%s", t.namespace, name)
+		return "network policy removed", nil
+	}
+
+	policy := rulePolicy(r, t.namespace)
+	if policy == nil {
+		log.Debugf("Ignoring rule, destination not supported by network policies")
+		return nil, nil
+	}
+	return applyPolicy(ctx, t.client, policy)
+}
+
+// Render returns the NetworkPolicy of r as YAML, removed rules render the
+// policy deleted.
+func (e *NetworkPolicyEngine) Render(logicCache rule.LogicCache, r types.Rule) (string, error) {
+	if sourceSelector(r.Source) == nil {
+		return "", nil
+	}
+	t, _, err := sourceTarget(context.TODO(), logicCache, r)
+	if err != nil || t == nil {
+		return "", err
+	}
+	if r.Removed {
+		return fmt.Sprintf("delete networkpolicy %s/%s%s\n", t.namespace, policyPrefix, r.RuleID), nil
+	}
+	policy := rulePolicy(r, t.namespace)
+	if policy == nil {
+		return "", nil
+	}
+	policy.TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"}
+	data, err := yaml.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// rulePolicy returns the policy allowing the egress traffic of r, it returns
+// nil when the destination is not supported.
+func rulePolicy(r types.Rule, namespace string) *networkingv1.NetworkPolicy {
+	egress := egressRule(r.Destination)
+	if egress == nil {
+		return nil
+	}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      policyPrefix + r.RuleID,
+			Namespace: namespace,
+			Labels: map[string]string{
+				ruleIDLabel:    r.RuleID,
+				managedByLabel: managedByValue,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: sourceSelector(r.Source)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      []networkingv1.NetworkPolicyEgressRule{*egress},
+		},
+	}
+}
+
+// sourceTarget returns where the policy for r must be created, along with the
+// pool of the source, it returns nil when the source is not running on
+// kubernetes.
+func sourceTarget(ctx context.Context, logicCache rule.LogicCache, r types.Rule) (*target, string, error) {
+	source, err := logicCache.LogicFromRule(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if source == nil {
+		return nil, "", nil
+	}
+
+	restConfig, pool, err := source.KubernetesRestConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if restConfig == nil {
+		return nil, "", nil
+	}
+
+	client, err := aclKube.GetClientWithRestConfig(restConfig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	namespace := "tsuru-" + pool
+	if r.Source.TsuruApp != nil && r.Source.TsuruApp.AppName != "" {
+		tsuruClient, err := aclKube.GetTsuruClientWithRestConfig(restConfig)
+		if err != nil {
+			return nil, "", err
+		}
+		appCR, err := tsuruClient.TsuruV1().Apps(aclKube.DefaultNamespace()).Get(ctx, r.Source.TsuruApp.AppName, metav1.GetOptions{})
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return nil, "", err
+		}
+		if err == nil && appCR.Spec.NamespaceName != "" {
+			namespace = appCR.Spec.NamespaceName
+		}
+	}
+
+	return &target{client: client, namespace: namespace}, pool, nil
+}
+
+func applyPolicy(ctx context.Context, client kubernetes.Interface, policy *networkingv1.NetworkPolicy) (interface{}, error) {
+	policies := client.NetworkingV1().NetworkPolicies(policy.Namespace)
+	existing, err := policies.Get(ctx, policy.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			return nil, err
+		}
+		_, err = policies.Create(ctx, policy, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return "network policy created", nil
+	}
+
+	if equality.Semantic.DeepEqual(existing.Spec, policy.Spec) &&
+		equality.Semantic.DeepEqual(existing.Labels, policy.Labels) {
+		return "network policy up to date", nil
+	}
+	existing.Labels = policy.Labels
+	existing.Spec = policy.Spec
+	_, err = policies.Update(ctx, existing, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return "network policy updated", nil
+}
+
+func sourceSelector(source types.RuleType) map[string]string {
+	switch {
+	case source.TsuruApp != nil && source.TsuruApp.AppName != "":
+		return map[string]string{appNameLabel: source.TsuruApp.AppName}
+	case source.TsuruApp != nil && source.TsuruApp.PoolName != "":
+		return map[string]string{appPoolLabel: source.TsuruApp.PoolName}
+	case source.TsuruJob != nil:
+		return map[string]string{jobNameLabel: source.TsuruJob.JobName}
+	}
+	return nil
+}
+
+// egressRule returns the rule allowing traffic to destination, it returns nil
+// for destinations that cannot be expressed as a NetworkPolicy peer.
+func egressRule(destination types.RuleType) *networkingv1.NetworkPolicyEgressRule {
+	var (
+		peer  networkingv1.NetworkPolicyPeer
+		ports []types.ProtoPort
+	)
+	allNamespaces := &metav1.LabelSelector{}
+	switch {
+	case destination.ExternalIP != nil:
+		peer.IPBlock = &networkingv1.IPBlock{CIDR: types.NormalizeIP(destination.ExternalIP.IP)}
+		ports = destination.ExternalIP.Ports
+	case destination.TsuruApp != nil:
+		peer.NamespaceSelector = allNamespaces
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: sourceSelector(destination)}
+	case destination.TsuruJob != nil:
+		peer.NamespaceSelector = allNamespaces
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: sourceSelector(destination)}
+	case destination.RpaasInstance != nil:
+		peer.NamespaceSelector = allNamespaces
+		peer.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{
+			rpaasServiceLabel:  destination.RpaasInstance.ServiceName,
+			rpaasInstanceLabel: destination.RpaasInstance.Instance,
+		}}
+	default:
+		return nil
+	}
+	return &networkingv1.NetworkPolicyEgressRule{
+		To:    []networkingv1.NetworkPolicyPeer{peer},
+		Ports: policyPorts(ports),
+	}
+}
+
+func policyPorts(ports []types.ProtoPort) []networkingv1.NetworkPolicyPort {
+	var ret []networkingv1.NetworkPolicyPort
+	for _, p := range ports {
+		protocol := corev1.Protocol(strings.ToUpper(p.Protocol))
+		port := intstr.FromInt(int(p.Port))
+		policyPort := networkingv1.NetworkPolicyPort{
+			Protocol: &protocol,
+			Port:     &port,
+		}
+		if p.IsRange() {
+			endPort := int32(p.EndPort)
+			policyPort.EndPort = &endPort
+		}
+		ret = append(ret, policyPort)
+	}
+	return ret
+}
@@ -0,0 +1,423 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netpol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/gc"
+	aclKube "github.com/tsuru/acl-api/kubernetes"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+	v1 "github.com/tsuru/tsuru/provision/kubernetes/pkg/apis/tsuru/v1"
+	tsuruv1clientset "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned"
+	faketsuru "github.com/tsuru/tsuru/provision/kubernetes/pkg/client/clientset/versioned/fake"
+	"github.com/tsuru/tsuru/types/provision"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	fakeK8s "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-engine-netpol")
+}
+
+func mockClients() (k8sClient *fakeK8s.Clientset, tsuruClient tsuruv1clientset.Interface, undo func()) {
+	oldClientWithRestConfig := aclKube.GetClientWithRestConfig
+	oldTsuruClient := aclKube.GetTsuruClientWithRestConfig
+	oldRestConfig := aclKube.RestConfig
+	k8sClient = fakeK8s.NewSimpleClientset()
+	tsuruClient = faketsuru.NewSimpleClientset()
+
+	aclKube.GetClientWithRestConfig = func(config *rest.Config) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+
+	aclKube.GetTsuruClientWithRestConfig = func(config *rest.Config) (tsuruv1clientset.Interface, error) {
+		return tsuruClient, nil
+	}
+
+	aclKube.RestConfig = func(cluster provision.Cluster) (*rest.Config, error) {
+		return &rest.Config{}, nil
+	}
+
+	return k8sClient, tsuruClient, func() {
+		aclKube.GetClientWithRestConfig = oldClientWithRestConfig
+		aclKube.GetTsuruClientWithRestConfig = oldTsuruClient
+		aclKube.RestConfig = oldRestConfig
+	}
+}
+
+func mockTsuruAPI() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app1":
+			w.Write([]byte(`{"name": "app1", "pool": "p1"}`))
+		case "/pools/p1":
+			w.Write([]byte(`{"name": "p1", "provisioner": "kubernetes"}`))
+		case "/provisioner/clusters":
+			w.Write([]byte(`[{"name": "c1", "default": true, "provisioner": "kubernetes"}]`))
+		case "/jobs/job1":
+			w.Write([]byte(`{"job": {"name": "job1", "pool": "p1"}}`))
+		default:
+			panic("URL " + r.URL.Path + " is not mocked")
+		}
+	}))
+}
+
+func setupEngine(t *testing.T) (*NetworkPolicyEngine, *fakeK8s.Clientset, func()) {
+	ctx := context.TODO()
+	k8sCli, tsuruCli, undo := mockClients()
+
+	_, err := tsuruCli.TsuruV1().Apps("default").Create(ctx, &v1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app1",
+		},
+		Spec: v1.AppSpec{
+			NamespaceName: "app-ns",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	srv := mockTsuruAPI()
+	viper.Set("tsuru.host", srv.URL)
+	viper.Set("kubernetes.namespace", "default")
+
+	e := &NetworkPolicyEngine{}
+	err = e.BeforeSync(rule.NewLogicCache())
+	require.NoError(t, err)
+
+	return e, k8sCli, func() {
+		srv.Close()
+		undo()
+	}
+}
+
+func TestNetworkPolicyEngine_SyncExternalIP(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			ExternalIP: &types.ExternalIPRule{
+				IP:    "10.0.0.1",
+				Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+			},
+		},
+	}
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy created", result)
+
+	policy, err := k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	tcp := corev1.ProtocolTCP
+	port := intstr.FromInt(443)
+	assert.Equal(t, map[string]string{
+		"acl-api.tsuru.io/rule-id":     "r1",
+		"app.kubernetes.io/managed-by": "acl-api",
+	}, policy.Labels)
+	assert.Equal(t, networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tsuru.io/app-name": "app1"}},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			{
+				To:    []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.1/32"}}},
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+			},
+		},
+	}, policy.Spec)
+
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy up to date", result)
+
+	r.Destination.ExternalIP.Ports = types.ProtoPorts{{Protocol: "udp", Port: 53}}
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy updated", result)
+
+	policy, err = k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, policy.Spec.Egress[0].Ports, 1)
+	assert.Equal(t, corev1.ProtocolUDP, *policy.Spec.Egress[0].Ports[0].Protocol)
+	assert.Equal(t, 53, policy.Spec.Egress[0].Ports[0].Port.IntValue())
+	assert.Nil(t, policy.Spec.Egress[0].Ports[0].EndPort)
+
+	r.Destination.ExternalIP.Ports = types.ProtoPorts{{Protocol: "tcp", Port: 8000, EndPort: 8080}}
+	result, err = e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy updated", result)
+
+	policy, err = k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, policy.Spec.Egress[0].Ports, 1)
+	assert.Equal(t, 8000, policy.Spec.Egress[0].Ports[0].Port.IntValue())
+	require.NotNil(t, policy.Spec.Egress[0].Ports[0].EndPort)
+	assert.Equal(t, int32(8080), *policy.Spec.Egress[0].Ports[0].EndPort)
+}
+
+func TestNetworkPolicyEngine_SyncExternalIPv6(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			ExternalIP: &types.ExternalIPRule{IP: "2001:DB8:0::1"},
+		},
+	}
+	_, err := e.Sync(r)
+	require.NoError(t, err)
+	policy, err := k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "2001:db8::1/128"}}}, policy.Spec.Egress[0].To)
+
+	r.Destination.ExternalIP.IP = "2001:db8:0:0:1::/64"
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy updated", result)
+	policy, err = k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "2001:db8::/64"}}}, policy.Spec.Egress[0].To)
+}
+
+func TestNetworkPolicyEngine_SyncJob(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	result, err := e.Sync(types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruJob: &types.TsuruJobRule{JobName: "job1"},
+		},
+		Destination: types.RuleType{
+			RpaasInstance: &types.RpaasInstanceRule{ServiceName: "rpaasv2", Instance: "my-instance"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "network policy created", result)
+
+	policy, err := k8sCli.NetworkingV1().NetworkPolicies("tsuru-p1").Get(ctx, "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tsuru.io/job-name": "job1"}, policy.Spec.PodSelector.MatchLabels)
+	assert.Equal(t, []networkingv1.NetworkPolicyPeer{
+		{
+			NamespaceSelector: &metav1.LabelSelector{},
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{
+				"rpaas.extensions.tsuru.io/service-name":  "rpaasv2",
+				"rpaas.extensions.tsuru.io/instance-name": "my-instance",
+			}},
+		},
+	}, policy.Spec.Egress[0].To)
+}
+
+func TestNetworkPolicyEngine_SyncUnsupportedDestination(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	result, err := e.Sync(types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			ExternalDNS: &types.ExternalDNSRule{Name: "example.com"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	policies, err := k8sCli.NetworkingV1().NetworkPolicies("app-ns").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, policies.Items, 0)
+}
+
+func TestNetworkPolicyEngine_SyncRemovesPolicies(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	newRule := func(id string) types.Rule {
+		return types.Rule{
+			RuleID: id,
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+			},
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{PoolName: "p2"},
+			},
+		}
+	}
+	for _, id := range []string{"r1", "r2"} {
+		_, err := e.Sync(newRule(id))
+		require.NoError(t, err)
+	}
+	err := e.AfterSync()
+	require.NoError(t, err)
+
+	err = e.BeforeSync(rule.NewLogicCache())
+	require.NoError(t, err)
+	removed := newRule("r1")
+	removed.Removed = true
+	result, err := e.Sync(removed)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy removed", result)
+
+	policies, err := k8sCli.NetworkingV1().NetworkPolicies("app-ns").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, policies.Items, 1)
+	assert.Equal(t, "acl-api-r2", policies.Items[0].Name)
+	assert.Equal(t, map[string]string{"tsuru.io/app-pool": "p2"}, policies.Items[0].Spec.Egress[0].To[0].PodSelector.MatchLabels)
+
+	result, err = e.Sync(removed)
+	require.NoError(t, err)
+	assert.Equal(t, "network policy removed", result)
+}
+
+func TestNetworkPolicyEngine_FailedRemovalNotPurged(t *testing.T) {
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+	ruleStor, err := storage.GetRuleStorage()
+	require.NoError(t, err)
+	ruleStor.(interface {
+		ClearAll()
+	}).ClearAll()
+
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{PoolName: "p2"},
+		},
+	}
+	_, err = e.Sync(r)
+	require.NoError(t, err)
+
+	removedAt := time.Now().UTC().Add(-time.Hour)
+	r.Removed = true
+	r.RemovedAt = &removedAt
+	err = ruleStor.Save([]*types.Rule{&r}, false)
+	require.NoError(t, err)
+	failDelete := true
+	k8sCli.PrependReactor("delete", "networkpolicies", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if failDelete {
+			return true, nil, errors.New("api unavailable")
+		}
+		return false, nil, nil
+	})
+	engine.EnableEngine(func() engine.Engine { return e })
+
+	engine.SyncRules([]types.Rule{r}, true)
+	purged, err := gc.Purge([]engine.Engine{e}, time.Now().UTC(), gc.Options{})
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	_, err = k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(context.TODO(), "acl-api-r1", metav1.GetOptions{})
+	require.NoError(t, err)
+
+	failDelete = false
+	engine.SyncRules([]types.Rule{r}, true)
+	purged, err = gc.Purge([]engine.Engine{e}, time.Now().UTC(), gc.Options{})
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	assert.Equal(t, "r1", purged[0].RuleID)
+	_, err = k8sCli.NetworkingV1().NetworkPolicies("app-ns").Get(context.TODO(), "acl-api-r1", metav1.GetOptions{})
+	assert.True(t, k8sErrors.IsNotFound(err))
+}
+
+func TestNetworkPolicyEngine_Render(t *testing.T) {
+	ctx := context.TODO()
+	e, k8sCli, undo := setupEngine(t)
+	defer undo()
+
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			ExternalIP: &types.ExternalIPRule{
+				IP:    "10.0.0.1",
+				Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+			},
+		},
+	}
+	rendered, err := e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  creationTimestamp: null
+  labels:
+    acl-api.tsuru.io/rule-id: r1
+    app.kubernetes.io/managed-by: acl-api
+  name: acl-api-r1
+  namespace: app-ns
+spec:
+  egress:
+  - ports:
+    - port: 443
+      protocol: TCP
+    to:
+    - ipBlock:
+        cidr: 10.0.0.1/32
+  podSelector:
+    matchLabels:
+      tsuru.io/app-name: app1
+  policyTypes:
+  - Egress
+`, rendered)
+	policies, err := k8sCli.NetworkingV1().NetworkPolicies("app-ns").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, policies.Items)
+
+	r.Removed = true
+	rendered, err = e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, "delete networkpolicy app-ns/acl-api-r1\n", rendered)
+
+	r.Removed = false
+	r.Destination = types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}}
+	rendered, err = e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Empty(t, rendered)
+}
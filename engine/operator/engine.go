@@ -7,6 +7,7 @@ package operator
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
@@ -21,8 +22,11 @@ import (
 )
 
 var (
-	_ engine.Engine          = &ACLOperatorEngine{}
-	_ engine.EngineWithHooks = &ACLOperatorEngine{}
+	_ engine.Engine            = &ACLOperatorEngine{}
+	_ engine.EngineWithHooks   = &ACLOperatorEngine{}
+	_ engine.EngineWithCluster = &ACLOperatorEngine{}
+	_ engine.EngineWithVerify  = &ACLOperatorEngine{}
+	_ engine.EngineWithRender  = &ACLOperatorEngine{}
 
 	engineName = "acl-operator"
 
@@ -50,6 +54,11 @@ func (e *ACLOperatorEngine) AfterSync() error {
 	return nil
 }
 
+// Cluster returns the kubernetes API address of the rule source.
+func (e *ACLOperatorEngine) Cluster(r types.Rule) (string, error) {
+	return engine.RuleCluster(e.logicCache, r)
+}
+
 func (e *ACLOperatorEngine) Sync(r types.Rule) (interface{}, error) {
 	if r.Source.TsuruApp != nil {
 		return e.SyncApp(r)
@@ -105,28 +114,12 @@ func (e *ACLOperatorEngine) SyncApp(r types.Rule) (interface{}, error) {
 		return "", err
 	}
 
-	lastUpdatedStr := appCR.Annotations[lastUpdatedAnnotation]
-	var lastUpdated time.Time
-	needsUpdate := false
-
-	if lastUpdatedStr == "" {
-		needsUpdate = true
-	} else {
-		lastUpdated, err = time.Parse(time.RFC3339, lastUpdatedStr)
-		if err != nil {
-			return "", err
-		}
-
-		if r.Created.UTC().Add(time.Minute).After(lastUpdated) {
-			needsUpdate = true
-		}
-
-		if time.Now().UTC().After(lastUpdated.Add(time.Minute)) {
-			needsUpdate = true
-		}
+	update, err := needsUpdate(appCR.Annotations[lastUpdatedAnnotation], ruleChangeTime(r), time.Now().UTC())
+	if err != nil {
+		return "", err
 	}
 
-	if needsUpdate {
+	if update {
 		if appCR.Annotations == nil {
 			appCR.Annotations = map[string]string{}
 		}
@@ -196,28 +189,12 @@ func (e *ACLOperatorEngine) SyncJob(r types.Rule) (interface{}, error) {
 		return "", err
 	}
 
-	lastUpdatedStr := cronJobCRD.Annotations[lastUpdatedAnnotation]
-	var lastUpdated time.Time
-	needsUpdate := false
-
-	if lastUpdatedStr == "" {
-		needsUpdate = true
-	} else {
-		lastUpdated, err = time.Parse(time.RFC3339, lastUpdatedStr)
-		if err != nil {
-			return "", err
-		}
-
-		if r.Created.UTC().Add(time.Minute).After(lastUpdated) {
-			needsUpdate = true
-		}
-
-		if time.Now().UTC().After(lastUpdated.Add(time.Minute)) {
-			needsUpdate = true
-		}
+	update, err := needsUpdate(cronJobCRD.Annotations[lastUpdatedAnnotation], ruleChangeTime(r), time.Now().UTC())
+	if err != nil {
+		return "", err
 	}
 
-	if needsUpdate {
+	if update {
 		if cronJobCRD.Annotations == nil {
 			cronJobCRD.Annotations = map[string]string{}
 		}
@@ -243,3 +220,124 @@ func (e *ACLOperatorEngine) SyncJob(r types.Rule) (interface{}, error) {
 
 	return "triggered acl-operator in the last minute", nil
 }
+
+// ruleChangeTime returns when the rule was last changed, its creation or
+// removal.
+func ruleChangeTime(r types.Rule) time.Time {
+	changed := r.Created
+	if r.RemovedAt != nil && r.RemovedAt.After(changed) {
+		changed = *r.RemovedAt
+	}
+	return changed.UTC()
+}
+
+// needsUpdate reports whether the last updated annotation must be set again
+// to trigger acl-operator, which happens when it was not set after the rule
+// changed and at most once a minute otherwise.
+func needsUpdate(lastUpdatedStr string, changed, now time.Time) (bool, error) {
+	if lastUpdatedStr == "" {
+		return true, nil
+	}
+	lastUpdated, err := time.Parse(time.RFC3339, lastUpdatedStr)
+	if err != nil {
+		return false, err
+	}
+	return changed.UTC().Add(time.Minute).After(lastUpdated) || now.After(lastUpdated.Add(time.Minute)), nil
+}
+
+// sourceAnnotations returns a description of the App CR or CronJob of the
+// rule source and its annotations, found is false when there is none.
+func sourceAnnotations(ctx context.Context, logicCache rule.LogicCache, r types.Rule) (object string, annotations map[string]string, found bool, err error) {
+	if r.Source.TsuruApp == nil && r.Source.TsuruJob == nil {
+		return "", nil, false, nil
+	}
+	source, err := logicCache.LogicFromRule(r)
+	if err != nil || source == nil {
+		return "", nil, false, err
+	}
+	restConfig, pool, err := source.KubernetesRestConfig()
+	if err != nil || restConfig == nil {
+		return "", nil, false, err
+	}
+
+	if r.Source.TsuruApp != nil {
+		tsuruClient, err := aclKube.GetTsuruClientWithRestConfig(restConfig)
+		if err != nil {
+			return "", nil, false, err
+		}
+		object = "app " + r.Source.TsuruApp.AppName
+		appCR, err := tsuruClient.TsuruV1().Apps(aclKube.DefaultNamespace()).Get(ctx, r.Source.TsuruApp.AppName, metav1.GetOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				return object, nil, false, nil
+			}
+			return object, nil, false, err
+		}
+		return object, appCR.Annotations, true, nil
+	}
+
+	k8sClient, err := aclKube.GetClientWithRestConfig(restConfig)
+	if err != nil {
+		return "", nil, false, err
+	}
+	object = "cronjob " + r.Source.TsuruJob.JobName
+	cronJob, err := k8sClient.BatchV1().CronJobs("tsuru-"+pool).Get(ctx, r.Source.TsuruJob.JobName, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return object, nil, false, nil
+		}
+		return object, nil, false, err
+	}
+	return object, cronJob.Annotations, true, nil
+}
+
+// Verify checks that the App CR or CronJob of the rule source was annotated
+// after the latest change of the rule, which means acl-operator was triggered
+// to apply it.
+func (e *ACLOperatorEngine) Verify(logicCache rule.LogicCache, r types.Rule) ([]string, error) {
+	object, annotations, found, err := sourceAnnotations(context.TODO(), logicCache, r)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	lastUpdatedStr := annotations[lastUpdatedAnnotation]
+	if lastUpdatedStr == "" {
+		return []string{fmt.Sprintf("%s: missing %s annotation", object, lastUpdatedAnnotation)}, nil
+	}
+	lastUpdated, err := time.Parse(time.RFC3339, lastUpdatedStr)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: invalid %s annotation %q", object, lastUpdatedAnnotation, lastUpdatedStr)}, nil
+	}
+	// the annotation has a resolution of seconds
+	changed := ruleChangeTime(r).Truncate(time.Second)
+	if lastUpdated.Before(changed) {
+		return []string{fmt.Sprintf("%s: %s annotation %s is older than the rule change at %s", object, lastUpdatedAnnotation, lastUpdatedStr, changed.Format(time.RFC3339))}, nil
+	}
+	return nil, nil
+}
+
+// Render returns the merge patch applied to the App CR or CronJob of the rule
+// source to trigger acl-operator.
+func (e *ACLOperatorEngine) Render(logicCache rule.LogicCache, r types.Rule) (string, error) {
+	object, annotations, found, err := sourceAnnotations(context.TODO(), logicCache, r)
+	if err != nil || !found {
+		return "", err
+	}
+	now := time.Now().UTC()
+	update, err := needsUpdate(annotations[lastUpdatedAnnotation], ruleChangeTime(r), now)
+	if err != nil {
+		return "", err
+	}
+	if !update {
+		return fmt.Sprintf("no patch to %s, acl-operator triggered in the last minute\n", object), nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{lastUpdatedAnnotation: now.Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("PATCH %s\n%s\n", object, patch), nil
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -282,6 +283,64 @@ func TestACLOperatorEngine_SyncAppRecentCreated(t *testing.T) {
 	assert.NotEqual(t, lastUpdated, app.Annotations["acl-api.tsuru.io/last-updated"])
 }
 
+func TestACLOperatorEngine_SyncAppRecentRemoved(t *testing.T) {
+	ctx := context.TODO()
+	tsuruCli, undo := mockTsuruClient()
+	defer undo()
+
+	lastUpdated := time.Now().UTC().Add(time.Second * -30).Format(time.RFC3339)
+
+	tsuruCli.TsuruV1().Apps("default").Create(ctx, &v1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app1",
+			Annotations: map[string]string{
+				"acl-api.tsuru.io/last-updated": lastUpdated,
+			},
+		},
+		Spec: v1.AppSpec{
+			NamespaceName: "default",
+		},
+	}, metav1.CreateOptions{})
+
+	srv := mockTsuruAPI()
+	defer srv.Close()
+
+	viper.Set("tsuru.host", srv.URL)
+	viper.Set("kubernetes.namespace", "default")
+
+	e := &ACLOperatorEngine{
+		logicCache: rule.NewLogicCache(),
+	}
+	removedAt := time.Now().UTC()
+	r := types.Rule{
+		RuleID: "1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app1",
+			},
+		},
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app2",
+			},
+		},
+		Created:   time.Now().UTC().Add(-time.Hour),
+		Removed:   true,
+		RemovedAt: &removedAt,
+	}
+	rendered, err := e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rendered, "PATCH app app1\n"), rendered)
+
+	result, err := e.Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "triggered acl-operator", result)
+
+	app, err := tsuruCli.TsuruV1().Apps("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, lastUpdated, app.Annotations["acl-api.tsuru.io/last-updated"])
+}
+
 // Jobs
 
 func TestACLOperatorEngine_SyncJob(t *testing.T) {
@@ -474,3 +533,184 @@ func TestACLOperatorEngine_SyncJobRecentCreated(t *testing.T) {
 	require.NoError(t, err)
 	assert.NotEqual(t, lastUpdated, job.Annotations["acl-api.tsuru.io/last-updated"])
 }
+
+func TestACLOperatorEngine_VerifyApp(t *testing.T) {
+	ctx := context.TODO()
+	tsuruCli, undo := mockTsuruClient()
+	defer undo()
+
+	srv := mockTsuruAPI()
+	defer srv.Close()
+
+	viper.Set("tsuru.host", srv.URL)
+	viper.Set("kubernetes.namespace", "default")
+
+	created := time.Now().UTC().Add(-10 * time.Minute)
+	r := types.Rule{
+		RuleID:  "1",
+		Created: created,
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app1",
+			},
+		},
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app2",
+			},
+		},
+	}
+	e := &ACLOperatorEngine{}
+
+	differences, err := e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Empty(t, differences)
+
+	app, err := tsuruCli.TsuruV1().Apps("default").Create(ctx, &v1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app1",
+		},
+		Spec: v1.AppSpec{
+			NamespaceName: "default",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app app1: missing acl-api.tsuru.io/last-updated annotation"}, differences)
+
+	stale := created.Add(-time.Minute).Format(time.RFC3339)
+	app.Annotations = map[string]string{"acl-api.tsuru.io/last-updated": stale}
+	app, err = tsuruCli.TsuruV1().Apps("default").Update(ctx, app, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	require.Len(t, differences, 1)
+	assert.Contains(t, differences[0], "annotation "+stale+" is older than the rule change")
+
+	app.Annotations["acl-api.tsuru.io/last-updated"] = created.Format(time.RFC3339)
+	_, err = tsuruCli.TsuruV1().Apps("default").Update(ctx, app, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Empty(t, differences)
+
+	removedAt := created.Add(5 * time.Minute)
+	r.Removed = true
+	r.RemovedAt = &removedAt
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Len(t, differences, 1)
+}
+
+func TestACLOperatorEngine_VerifyJob(t *testing.T) {
+	ctx := context.TODO()
+	k8sCli, undo := mockK8sClient()
+	defer undo()
+
+	srv := mockTsuruAPI()
+	defer srv.Close()
+
+	viper.Set("tsuru.host", srv.URL)
+	viper.Set("kubernetes.namespace", "default")
+
+	created := time.Now().UTC().Add(-10 * time.Minute)
+	cronJob, err := k8sCli.BatchV1().CronJobs("tsuru-p1").Create(ctx, &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "job1",
+			Annotations: map[string]string{
+				"acl-api.tsuru.io/last-updated": "yesterday",
+			},
+		},
+		Spec: batchv1.CronJobSpec{},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	r := types.Rule{
+		RuleID:  "1",
+		Created: created,
+		Source: types.RuleType{
+			TsuruJob: &types.TsuruJobRule{
+				JobName: "job1",
+			},
+		},
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app2",
+			},
+		},
+	}
+	e := &ACLOperatorEngine{}
+	differences, err := e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`cronjob job1: invalid acl-api.tsuru.io/last-updated annotation "yesterday"`}, differences)
+
+	cronJob.Annotations["acl-api.tsuru.io/last-updated"] = created.Add(-time.Hour).Format(time.RFC3339)
+	_, err = k8sCli.BatchV1().CronJobs("tsuru-p1").Update(ctx, cronJob, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Len(t, differences, 1)
+	result, err := (&ACLOperatorEngine{logicCache: rule.NewLogicCache()}).Sync(r)
+	require.NoError(t, err)
+	assert.Equal(t, "triggered acl-operator", result)
+	differences, err = e.Verify(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Empty(t, differences)
+}
+
+func TestACLOperatorEngine_Render(t *testing.T) {
+	ctx := context.TODO()
+	tsuruCli, undo := mockTsuruClient()
+	defer undo()
+
+	srv := mockTsuruAPI()
+	defer srv.Close()
+
+	viper.Set("tsuru.host", srv.URL)
+	viper.Set("kubernetes.namespace", "default")
+
+	lastUpdated := time.Now().UTC().Add(-30 * time.Minute).Format(time.RFC3339)
+	_, err := tsuruCli.TsuruV1().Apps("default").Create(ctx, &v1.App{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app1",
+			Annotations: map[string]string{
+				"acl-api.tsuru.io/last-updated": lastUpdated,
+			},
+		},
+		Spec: v1.AppSpec{
+			NamespaceName: "default",
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	r := types.Rule{
+		RuleID:  "1",
+		Created: time.Now().UTC().Add(-time.Hour),
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app1",
+			},
+		},
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app2",
+			},
+		},
+	}
+	e := &ACLOperatorEngine{}
+	rendered, err := e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Regexp(t, `^PATCH app app1\n\{"metadata":\{"annotations":\{"acl-api.tsuru.io/last-updated":"[^"]+"\}\}\}\n$`, rendered)
+
+	app, err := tsuruCli.TsuruV1().Apps("default").Get(ctx, "app1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, lastUpdated, app.Annotations["acl-api.tsuru.io/last-updated"])
+
+	app.Annotations["acl-api.tsuru.io/last-updated"] = time.Now().UTC().Format(time.RFC3339)
+	_, err = tsuruCli.TsuruV1().Apps("default").Update(ctx, app, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	rendered, err = e.Render(rule.NewLogicCache(), r)
+	require.NoError(t, err)
+	assert.Equal(t, "no patch to app app1, acl-operator triggered in the last minute\n", rendered)
+}
@@ -0,0 +1,306 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/rule"
+	"golang.org/x/time/rate"
+)
+
+const defaultSyncWorkers = 4
+
+var (
+	syncQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "sync_queue_depth",
+		Help:      "The number of rule syncs waiting in the queue",
+	}, []string{"engine", "priority"})
+
+	syncQueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "sync_queue_wait_seconds",
+		Help:      "Time rule syncs wait in the queue",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 3, 10),
+	}, []string{"engine", "priority"})
+)
+
+// Priority orders the syncs waiting in a queue, syncs triggered by users are
+// always taken before the ones from the background reconciliation.
+type Priority int
+
+const (
+	PriorityBackground Priority = iota
+	PriorityUser
+)
+
+func (p Priority) String() string {
+	if p == PriorityUser {
+		return "user"
+	}
+	return "background"
+}
+
+// EngineWithCluster is implemented by engines applying rules to kubernetes
+// clusters, syncs are rate limited per cluster when sync.cluster_rate is set.
+type EngineWithCluster interface {
+	Cluster(r types.Rule) (string, error)
+}
+
+// RuleCluster returns the address of the kubernetes API where the source of
+// r runs, empty when r has no kubernetes source.
+func RuleCluster(logicCache rule.LogicCache, r types.Rule) (string, error) {
+	source, err := logicCache.LogicFromRule(r)
+	if err != nil || source == nil {
+		return "", err
+	}
+	restConfig, _, err := source.KubernetesRestConfig()
+	if err != nil || restConfig == nil {
+		return "", err
+	}
+	return restConfig.Host, nil
+}
+
+// SyncListener is notified when the sync of each rule starts and ends in an
+// engine, err is the sync error.
+type SyncListener interface {
+	SyncStarted(ruleID, engine string)
+	SyncEnded(ruleID, engine string, err error)
+}
+
+// syncItem is a rule waiting to be synced by an engine. Requests for a rule
+// already waiting are merged into the existing item, which keeps the latest
+// version of the rule, the highest priority and the force and retry flags.
+type syncItem struct {
+	rule      types.Rule
+	opts      syncOpts
+	queued    time.Time
+	engine    Engine
+	log       *logrus.Entry
+	ruleSvc   rule.EngineRuleService
+	waiters   []*sync.WaitGroup
+	listeners []SyncListener
+}
+
+// syncQueue runs the syncs of one engine with a fixed number of workers.
+type syncQueue struct {
+	name     string
+	workers  int
+	limiters *clusterLimiters
+	syncFn   func(it *syncItem) error
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string]*syncItem
+	lists   [PriorityUser + 1][]*syncItem
+	started bool
+	closed  bool
+}
+
+func newSyncQueue(name string, workers int, limiters *clusterLimiters) *syncQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &syncQueue{
+		name:     name,
+		workers:  workers,
+		limiters: limiters,
+		pending:  map[string]*syncItem{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.syncFn = q.sync
+	return q
+}
+
+// add queues it, starting the workers on first use, and calls Done in wg
+// once the rule is synced.
+func (q *syncQueue) add(it *syncItem, wg *sync.WaitGroup) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.started {
+		q.started = true
+		for i := 0; i < q.workers; i++ {
+			go q.work()
+		}
+	}
+	if existing, ok := q.pending[it.rule.RuleID]; ok {
+		existing.rule = it.rule
+		existing.opts.force = existing.opts.force || it.opts.force
+		existing.opts.retry = existing.opts.retry || it.opts.retry
+		existing.waiters = append(existing.waiters, wg)
+		existing.listeners = append(existing.listeners, it.listeners...)
+		if it.opts.priority > existing.opts.priority {
+			// the item is left behind in the lower list and skipped there
+			q.setDepth(existing.opts.priority, -1)
+			existing.opts.priority = it.opts.priority
+			q.lists[it.opts.priority] = append(q.lists[it.opts.priority], existing)
+			q.setDepth(it.opts.priority, 1)
+			q.cond.Signal()
+		}
+		return
+	}
+	it.queued = time.Now()
+	it.waiters = []*sync.WaitGroup{wg}
+	q.pending[it.rule.RuleID] = it
+	q.lists[it.opts.priority] = append(q.lists[it.opts.priority], it)
+	q.setDepth(it.opts.priority, 1)
+	q.cond.Signal()
+}
+
+func (q *syncQueue) setDepth(p Priority, delta float64) {
+	syncQueueDepth.WithLabelValues(q.name, p.String()).Add(delta)
+}
+
+// next blocks until an item is available, returning nil when the queue is
+// closed.
+func (q *syncQueue) next() *syncItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.closed {
+			return nil
+		}
+		for p := PriorityUser; p >= PriorityBackground; p-- {
+			for len(q.lists[p]) > 0 {
+				it := q.lists[p][0]
+				q.lists[p] = q.lists[p][1:]
+				if it.opts.priority != p {
+					continue
+				}
+				delete(q.pending, it.rule.RuleID)
+				q.setDepth(p, -1)
+				return it
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *syncQueue) work() {
+	for {
+		it := q.next()
+		if it == nil {
+			return
+		}
+		syncQueueWait.WithLabelValues(q.name, it.opts.priority.String()).Observe(time.Since(it.queued).Seconds())
+		for _, l := range it.listeners {
+			l.SyncStarted(it.rule.RuleID, q.name)
+		}
+		err := q.syncFn(it)
+		for _, l := range it.listeners {
+			l.SyncEnded(it.rule.RuleID, q.name, err)
+		}
+		for _, wg := range it.waiters {
+			wg.Done()
+		}
+	}
+}
+
+// close stops the workers once they finish the current syncs, items still
+// waiting are not synced.
+func (q *syncQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *syncQueue) sync(it *syncItem) error {
+	log := it.log.WithField("ruleid", it.rule.RuleID)
+	if clusterEngine, ok := it.engine.(EngineWithCluster); ok {
+		cluster, err := clusterEngine.Cluster(it.rule)
+		if err != nil {
+			log.Warnf("unable to find cluster for rule %v, syncing without rate limit: %v", it.rule.String(), err)
+		}
+		q.limiters.wait(cluster)
+	}
+	log.Info("Starting single rule sync")
+	ruleTimer := prometheus.NewTimer(ruleSyncDuration.WithLabelValues(q.name))
+	err := syncRule(log, it.ruleSvc, it.engine, it.rule, it.opts)
+	ruleTimer.ObserveDuration()
+	if err == ErrSyncSkipped {
+		log.Debugf("skipped rule %v: %v", it.rule.String(), err)
+		return err
+	}
+	if err != nil {
+		ruleSyncFailuresTotal.WithLabelValues(q.name).Inc()
+		log.Errorf("error syncing rule %v: %v", it.rule.String(), err)
+	}
+	return err
+}
+
+// clusterLimiters holds a token bucket for each cluster, shared by all
+// engines. A zero rate disables the limit.
+type clusterLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newClusterLimiters(limit float64, burst int) *clusterLimiters {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &clusterLimiters{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+func (l *clusterLimiters) wait(cluster string) {
+	if cluster == "" || l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	limiter, ok := l.limiters[cluster]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[cluster] = limiter
+	}
+	l.mu.Unlock()
+	limiter.Wait(context.Background())
+}
+
+var (
+	queuesMu sync.Mutex
+	queues   = map[string]*syncQueue{}
+	limiters *clusterLimiters
+)
+
+// queueFor returns the queue of the named engine, workers are set by
+// sync.engine_workers.<engine>, falling back to sync.workers.
+func queueFor(name string) *syncQueue {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	if q, ok := queues[name]; ok {
+		return q
+	}
+	if limiters == nil {
+		limiters = newClusterLimiters(viper.GetFloat64("sync.cluster_rate"), viper.GetInt("sync.cluster_burst"))
+	}
+	workers := viper.GetInt("sync.workers")
+	if workers <= 0 {
+		workers = defaultSyncWorkers
+	}
+	if n := viper.GetInt("sync.engine_workers." + name); n > 0 {
+		workers = n
+	}
+	q := newSyncQueue(name, workers, limiters)
+	queues[name] = q
+	return q
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+type blockingSync struct {
+	mu      sync.Mutex
+	running int
+	max     int
+	synced  []syncItem
+	release chan struct{}
+}
+
+func (b *blockingSync) sync(it *syncItem) error {
+	b.mu.Lock()
+	b.running++
+	if b.running > b.max {
+		b.max = b.running
+	}
+	b.mu.Unlock()
+	<-b.release
+	b.mu.Lock()
+	b.running--
+	b.synced = append(b.synced, *it)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *blockingSync) waitRunning(t *testing.T, n int) {
+	assert.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.running == n
+	}, time.Second, time.Millisecond)
+}
+
+func newTestQueue(workers int) (*syncQueue, *blockingSync) {
+	b := &blockingSync{release: make(chan struct{})}
+	q := newSyncQueue("test", workers, newClusterLimiters(0, 0))
+	q.syncFn = b.sync
+	return q, b
+}
+
+func item(id string, priority Priority, force bool) *syncItem {
+	return &syncItem{rule: types.Rule{RuleID: id}, opts: syncOpts{priority: priority, force: force}}
+}
+
+func Test_syncQueue_parallelism(t *testing.T) {
+	q, b := newTestQueue(3)
+	defer q.close()
+	var wg sync.WaitGroup
+	wg.Add(6)
+	for _, id := range []string{"r1", "r2", "r3", "r4", "r5", "r6"} {
+		q.add(item(id, PriorityUser, false), &wg)
+	}
+	b.waitRunning(t, 3)
+	close(b.release)
+	wg.Wait()
+	assert.Equal(t, 3, b.max)
+	assert.Len(t, b.synced, 6)
+}
+
+func Test_syncQueue_deduplicates(t *testing.T) {
+	q, b := newTestQueue(1)
+	defer q.close()
+	var wg1, wg2 sync.WaitGroup
+	wg1.Add(2)
+	wg2.Add(2)
+	q.add(item("r1", PriorityBackground, false), &wg1)
+	b.waitRunning(t, 1)
+	q.add(item("r2", PriorityBackground, false), &wg1)
+	q.add(item("r1", PriorityBackground, false), &wg2)
+	r2 := item("r2", PriorityBackground, true)
+	r2.rule.Removed = true
+	q.add(r2, &wg2)
+	close(b.release)
+	wg1.Wait()
+	wg2.Wait()
+	var ids []string
+	for _, it := range b.synced {
+		ids = append(ids, it.rule.RuleID)
+	}
+	assert.Equal(t, []string{"r1", "r2", "r1"}, ids)
+	assert.True(t, b.synced[1].opts.force)
+	assert.True(t, b.synced[1].rule.Removed)
+	assert.Len(t, b.synced[1].waiters, 2)
+}
+
+func Test_syncQueue_priority(t *testing.T) {
+	q, b := newTestQueue(1)
+	defer q.close()
+	var wg sync.WaitGroup
+	wg.Add(5)
+	q.add(item("r0", PriorityBackground, false), &wg)
+	b.waitRunning(t, 1)
+	q.add(item("b1", PriorityBackground, false), &wg)
+	q.add(item("b2", PriorityBackground, false), &wg)
+	q.add(item("u1", PriorityUser, false), &wg)
+	q.add(item("b2", PriorityUser, false), &wg)
+	close(b.release)
+	wg.Wait()
+	var ids []string
+	for _, it := range b.synced {
+		ids = append(ids, it.rule.RuleID)
+	}
+	assert.Equal(t, []string{"r0", "u1", "b2", "b1"}, ids)
+	assert.Equal(t, PriorityUser, b.synced[2].opts.priority)
+}
+
+func Test_clusterLimiters_wait(t *testing.T) {
+	l := newClusterLimiters(20, 1)
+	start := time.Now()
+	l.wait("")
+	l.wait("https://c1")
+	l.wait("https://c2")
+	assert.Less(t, int64(time.Since(start)), int64(40*time.Millisecond))
+	l.wait("https://c1")
+	l.wait("https://c1")
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(90*time.Millisecond))
+}
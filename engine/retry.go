@@ -0,0 +1,174 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+	"github.com/tsuru/acl-api/rule"
+	"github.com/tsuru/acl-api/storage"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	defaultRetryInterval    = 5 * time.Second
+	defaultRetryMaxInterval = 10 * time.Minute
+)
+
+type permanentError struct {
+	error
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.error
+}
+
+// Permanent marks err as permanent, rules failing with it are not retried
+// before the next reconciliation.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{error: err}
+}
+
+// IsPermanent reports whether retrying a sync failed with err is useless
+// until something else changes, like an app not found in tsuru. Client
+// errors from tsuru and kubernetes are permanent, except for timeouts,
+// conflicts and throttling, anything else is transient.
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permErr *permanentError
+	if errors.As(err, &permErr) {
+		return true
+	}
+	switch errors.Cause(err) {
+	case external.ErrNotKubernetesPool, external.ErrClusterNotFound:
+		return true
+	}
+	var httpErr *external.HTTPError
+	if errors.As(err, &httpErr) {
+		return isPermanentStatus(httpErr.StatusCode)
+	}
+	var statusErr k8sErrors.APIStatus
+	if errors.As(err, &statusErr) {
+		return isPermanentStatus(int(statusErr.Status().Code))
+	}
+	return false
+}
+
+func isPermanentStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
+
+// retryDelay returns the wait before the given failed attempt is retried,
+// sync.retry_interval doubled on each attempt up to sync.retry_max_interval,
+// with a random jitter of up to half of it.
+func retryDelay(attempt int) time.Duration {
+	base := viper.GetDuration("sync.retry_interval")
+	if base <= 0 {
+		base = defaultRetryInterval
+	}
+	max := viper.GetDuration("sync.retry_max_interval")
+	if max <= 0 {
+		max = defaultRetryMaxInterval
+	}
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// nextRetry updates the retry state in ruleSync after a sync ended with err,
+// returning whether a retry was scheduled. Permanent errors and rules out of
+// attempts, limited by sync.retry_max_attempts, are left to the periodic
+// reconciliation.
+func nextRetry(ruleSync *types.RuleSyncInfo, err error, now time.Time) bool {
+	ruleSync.NextRetryTime = time.Time{}
+	if err == nil {
+		ruleSync.Attempts = 0
+		return false
+	}
+	ruleSync.Attempts++
+	if IsPermanent(err) || ruleSync.Attempts >= viper.GetInt("sync.retry_max_attempts") {
+		return false
+	}
+	ruleSync.NextRetryTime = now.Add(retryDelay(ruleSync.Attempts))
+	return true
+}
+
+// retrier keeps a timer for each rule with a scheduled retry.
+type retrier struct {
+	retryFn func(engineName, ruleID string)
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func (r *retrier) schedule(engineName, ruleID string, at time.Time) {
+	key := ruleID + "\x00" + engineName
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timers == nil {
+		r.timers = map[string]*time.Timer{}
+	}
+	if timer, ok := r.timers[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(at), func() {
+		r.mu.Lock()
+		if r.timers[key] == timer {
+			delete(r.timers, key)
+		}
+		r.mu.Unlock()
+		r.retryFn(engineName, ruleID)
+	})
+	r.timers[key] = timer
+}
+
+var retries = &retrier{}
+
+func init() {
+	retries.retryFn = retryRule
+}
+
+// retryRule syncs the current version of the rule again in the named engine.
+func retryRule(engineName, ruleID string) {
+	log := logrus.WithFields(logrus.Fields{"engine": engineName, "ruleid": ruleID})
+	r, err := rule.GetService().FindByID(ruleID)
+	if err != nil {
+		if err != storage.ErrRuleNotFound {
+			log.Errorf("unable to find rule to retry its sync: %v", err)
+		}
+		return
+	}
+	for _, eFactory := range enabledEngines {
+		e := eFactory()
+		if e.Name() != engineName {
+			continue
+		}
+		log.Info("Retrying failed rule sync")
+		engineSync(e, []types.Rule{r}, rule.NewLogicCache(), syncOpts{retry: true, priority: PriorityBackground})
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/webhook"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsPermanent(t *testing.T) {
+	gr := schema.GroupResource{Resource: "networkpolicies"}
+	tests := []struct {
+		err       error
+		permanent bool
+	}{
+		{err: nil},
+		{err: errors.New("connection refused")},
+		{err: Permanent(errors.New("bad rule")), permanent: true},
+		{err: errors.Wrap(Permanent(errors.New("bad rule")), "sync"), permanent: true},
+		{err: errors.WithStack(external.ErrClusterNotFound), permanent: true},
+		{err: errors.WithStack(&external.HTTPError{StatusCode: http.StatusNotFound}), permanent: true},
+		{err: errors.Wrap(&external.HTTPError{StatusCode: http.StatusBadRequest}, "tsuru"), permanent: true},
+		{err: errors.WithStack(&external.HTTPError{StatusCode: http.StatusTooManyRequests})},
+		{err: errors.WithStack(&external.HTTPError{StatusCode: http.StatusBadGateway})},
+		{err: k8sErrors.NewForbidden(gr, "p1", errors.New("denied")), permanent: true},
+		{err: k8sErrors.NewTimeoutError("timeout", 1)},
+		{err: k8sErrors.NewServerTimeout(gr, "create", 1)},
+		{err: k8sErrors.NewConflict(gr, "p1", errors.New("changed"))},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.permanent, IsPermanent(tt.err), "%v", tt.err)
+	}
+}
+
+func Test_retryDelay(t *testing.T) {
+	viper.Set("sync.retry_interval", time.Second)
+	viper.Set("sync.retry_max_interval", 10*time.Second)
+	defer viper.Reset()
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := retryDelay(attempt)
+			assert.GreaterOrEqual(t, int64(delay), int64(max/2), "attempt %d", attempt)
+			assert.LessOrEqual(t, int64(delay), int64(max), "attempt %d", attempt)
+		}
+	}
+}
+
+func Test_nextRetry(t *testing.T) {
+	viper.Set("sync.retry_max_attempts", 3)
+	defer viper.Reset()
+	now := time.Now().UTC()
+	ruleSync := &types.RuleSyncInfo{}
+	transient := errors.New("timeout")
+	assert.True(t, nextRetry(ruleSync, transient, now))
+	assert.Equal(t, 1, ruleSync.Attempts)
+	assert.True(t, ruleSync.NextRetryTime.After(now))
+	assert.True(t, nextRetry(ruleSync, transient, now))
+	assert.Equal(t, 2, ruleSync.Attempts)
+	assert.False(t, nextRetry(ruleSync, transient, now))
+	assert.Equal(t, 3, ruleSync.Attempts)
+	assert.True(t, ruleSync.NextRetryTime.IsZero())
+
+	assert.False(t, nextRetry(ruleSync, nil, now))
+	assert.Equal(t, 0, ruleSync.Attempts)
+	assert.False(t, nextRetry(ruleSync, Permanent(transient), now))
+	assert.Equal(t, 1, ruleSync.Attempts)
+	assert.True(t, ruleSync.NextRetryTime.IsZero())
+}
+
+func Test_retrier_schedule(t *testing.T) {
+	var mu sync.Mutex
+	var retried []string
+	r := &retrier{retryFn: func(engineName, ruleID string) {
+		mu.Lock()
+		defer mu.Unlock()
+		retried = append(retried, engineName+"/"+ruleID)
+	}}
+	r.schedule("e1", "r1", time.Now().Add(time.Hour))
+	r.schedule("e1", "r1", time.Now().Add(10*time.Millisecond))
+	r.schedule("e2", "r1", time.Now().Add(10*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(retried) == 2
+	}, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.ElementsMatch(t, []string{"e1/r1", "e2/r1"}, retried)
+	assert.Empty(t, r.timers)
+}
+
+type fakeRuleSvc struct {
+	locked bool
+	ended  []types.RuleSyncInfo
+	data   []types.RuleSyncData
+}
+
+func (s *fakeRuleSvc) FindAll() ([]types.Rule, error) {
+	return nil, nil
+}
+
+func (s *fakeRuleSvc) SyncStart(after time.Duration, ruleID, engine string, force bool) (time.Duration, *types.RuleSyncInfo, error) {
+	if s.locked {
+		return time.Minute, nil, storage.ErrSyncStorageLocked
+	}
+	info := types.RuleSyncInfo{RuleID: ruleID, Engine: engine}
+	if len(s.ended) > 0 {
+		info = s.ended[len(s.ended)-1]
+	}
+	return 0, &info, nil
+}
+
+func (s *fakeRuleSvc) SyncEnd(ruleSync types.RuleSyncInfo, syncData types.RuleSyncData) error {
+	s.ended = append(s.ended, ruleSync)
+	s.data = append(s.data, syncData)
+	return nil
+}
+
+type failingEngine struct {
+	err error
+}
+
+func (e *failingEngine) Name() string {
+	return "failing"
+}
+
+func (e *failingEngine) Sync(r types.Rule) (interface{}, error) {
+	return nil, e.err
+}
+
+func Test_syncRule_schedulesRetry(t *testing.T) {
+	viper.Set("sync.retry_max_attempts", 5)
+	defer viper.Reset()
+	retried := make(chan string, 1)
+	defer func(fn func(engineName, ruleID string)) { retries.retryFn = fn }(retries.retryFn)
+	retries.retryFn = func(engineName, ruleID string) {
+		retried <- engineName + "/" + ruleID
+	}
+	viper.Set("sync.retry_interval", 10*time.Millisecond)
+	var mu sync.Mutex
+	var notified []types.WebhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event types.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		notified = append(notified, event)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	viper.Set("webhooks", []map[string]interface{}{{"url": srv.URL, "events": []string{"sync.failed"}}})
+	svc := &fakeRuleSvc{}
+	e := &failingEngine{err: errors.New("timeout")}
+	log := logrus.WithField("test", t.Name())
+	r := types.Rule{RuleID: "r1"}
+
+	err := syncRule(log, svc, e, r, syncOpts{})
+	require.Error(t, err)
+	webhook.Wait()
+	mu.Lock()
+	require.Len(t, notified, 1)
+	assert.Equal(t, "failing", notified[0].Engine)
+	assert.Equal(t, "timeout", notified[0].Error)
+	mu.Unlock()
+	require.Len(t, svc.ended, 1)
+	assert.Equal(t, 1, svc.ended[0].Attempts)
+	assert.False(t, svc.ended[0].NextRetryTime.IsZero())
+	assert.False(t, svc.data[0].Permanent)
+	select {
+	case key := <-retried:
+		assert.Equal(t, "failing/r1", key)
+	case <-time.After(time.Second):
+		t.Fatal("retry not scheduled")
+	}
+
+	e.err = errors.WithStack(&external.HTTPError{StatusCode: http.StatusNotFound})
+	err = syncRule(log, svc, e, r, syncOpts{retry: true})
+	require.Error(t, err)
+	assert.Equal(t, 2, svc.ended[1].Attempts)
+	assert.True(t, svc.ended[1].NextRetryTime.IsZero())
+	assert.True(t, svc.data[1].Permanent)
+	webhook.Wait()
+	mu.Lock()
+	assert.Len(t, notified, 1)
+	mu.Unlock()
+
+	svc.locked = true
+	err = syncRule(log, svc, e, r, syncOpts{})
+	assert.Equal(t, ErrSyncSkipped, err)
+	assert.Len(t, svc.ended, 2)
+	svc.locked = false
+
+	e.err = nil
+	err = syncRule(log, svc, e, r, syncOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, svc.ended[2].Attempts)
+	select {
+	case key := <-retried:
+		t.Fatalf("unexpected retry of %s", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -147,6 +147,12 @@ func (e *BaseHTTPClient) DoRequestDataCtx(ctx context.Context, method, path stri
 	return data, nil
 }
 
+// DoRequestCtx returns the response of a successful request, the caller must
+// close its body.
+func (e *BaseHTTPClient) DoRequestCtx(ctx context.Context, method, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return e.doRequest(ctx, method, path, body, headers)
+}
+
 func (e *BaseHTTPClient) DoRequestData(method, path string, body io.Reader, headers map[string]string) ([]byte, error) {
 	return e.DoRequestDataCtx(context.TODO(), method, path, body, headers)
 }
@@ -34,6 +34,7 @@ type TsuruClient interface {
 	JobInfo(jobName string) (*jobTypes.Job, error)
 	AppInfo(appName string) (*app.App, error)
 	PoolInfo(poolName string) (*pool.Pool, error)
+	PoolTeams(poolName string) ([]string, error)
 	Clusters() ([]provTypes.Cluster, error)
 }
 
@@ -136,6 +137,23 @@ func (t *tsuruClient) PoolInfo(poolName string) (*pool.Pool, error) {
 	return data.poolInfo(poolName)
 }
 
+// PoolTeams returns the teams allowed to use the pool, it is empty for public
+// pools.
+func (t *tsuruClient) PoolTeams(poolName string) ([]string, error) {
+	t.Lock()
+	data, ok := t.poolCache[poolName]
+	if !ok {
+		data = &cachedPool{cachedBase: cachedBase{cli: t}}
+		t.poolCache[poolName] = data
+	}
+	t.Unlock()
+	_, err := data.poolInfo(poolName)
+	if err != nil {
+		return nil, err
+	}
+	return data.teams, nil
+}
+
 func (t *tsuruClient) Clusters() ([]provTypes.Cluster, error) {
 	t.Lock()
 	defer t.Unlock()
@@ -239,6 +257,14 @@ func (c *cachedJob) jobInfo(jobName string) (*jobTypes.Job, error) {
 type cachedPool struct {
 	cachedBase
 	result *pool.Pool
+	teams  []string
+}
+
+// poolInfoResult includes the constraints that tsuru adds when encoding a
+// pool, they are not part of pool.Pool.
+type poolInfoResult struct {
+	pool.Pool
+	Allowed map[string][]string `json:"allowed,omitempty"`
 }
 
 func (c *cachedPool) poolInfo(poolName string) (*pool.Pool, error) {
@@ -247,7 +273,7 @@ func (c *cachedPool) poolInfo(poolName string) (*pool.Pool, error) {
 	if c.result != nil {
 		return c.result, nil
 	}
-	var pool pool.Pool
+	var pool poolInfoResult
 	err := c.cli.doRequest("GET", fmt.Sprintf("/pools/%s", poolName), &pool)
 	if err != nil {
 		return nil, err
@@ -255,6 +281,49 @@ func (c *cachedPool) poolInfo(poolName string) (*pool.Pool, error) {
 	if pool.Name == "" {
 		return nil, errors.Errorf("pool %q not found", poolName)
 	}
-	c.result = &pool
+	c.result = &pool.Pool
+	c.teams = pool.Allowed["team"]
 	return c.result, nil
 }
+
+// TsuruUser is the user owning a tsuru token, as returned by /users/info.
+type TsuruUser struct {
+	Email       string
+	Roles       []TsuruRoleInstance
+	Permissions []TsuruPermission
+}
+
+type TsuruRoleInstance struct {
+	Name         string
+	ContextType  string
+	ContextValue string
+}
+
+type TsuruPermission struct {
+	Name         string
+	ContextType  string
+	ContextValue string
+}
+
+// TsuruUserInfo asks tsuru for the user owning token, an invalid token
+// results in an *HTTPError with the status code returned by tsuru.
+func TsuruUserInfo(token string) (*TsuruUser, error) {
+	cli := &BaseHTTPClient{
+		URL:    viper.GetString("tsuru.host"),
+		Token:  token,
+		Logger: logrus.WithField("http-client", "tsuru"),
+	}
+	data, err := cli.DoRequestData(http.MethodGet, "/users/info", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var user TsuruUser
+	err = json.Unmarshal(data, &user)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal data %q", data)
+	}
+	if user.Email == "" {
+		return nil, errors.Errorf("empty user data for token")
+	}
+	return &user, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gc permanently deletes removed rules once every engine synced their
+// removal, rules are otherwise only marked as removed and kept forever.
+package gc
+
+import (
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/storage"
+)
+
+// Options changes how rules are purged. Rules are kept for Retention after
+// their removal and with DryRun nothing is deleted.
+type Options struct {
+	Retention time.Duration
+	DryRun    bool
+}
+
+// Purge permanently deletes the rules removed before now minus
+// opts.Retention whose removal was successfully synced by every engine in
+// engines, along with their syncs and aclapi entries, and returns them.
+func Purge(engines []engine.Engine, now time.Time, opts Options) ([]types.Rule, error) {
+	ruleStor, err := storage.GetRuleStorage()
+	if err != nil {
+		return nil, err
+	}
+	removed := true
+	rules, err := ruleStor.FindAll(storage.FindOpts{Removed: &removed})
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	syncStor, err := storage.GetSyncStorage()
+	if err != nil {
+		return nil, err
+	}
+	ruleIDs := make([]string, len(rules))
+	for i, r := range rules {
+		ruleIDs[i] = r.RuleID
+	}
+	syncs, err := syncStor.Find(storage.SyncFindOpts{RuleIDs: ruleIDs})
+	if err != nil {
+		return nil, err
+	}
+	ruleSyncs := map[string]map[string]types.RuleSyncInfo{}
+	for _, info := range syncs {
+		if ruleSyncs[info.RuleID] == nil {
+			ruleSyncs[info.RuleID] = map[string]types.RuleSyncInfo{}
+		}
+		ruleSyncs[info.RuleID][info.Engine] = info
+	}
+
+	cutoff := now.Add(-opts.Retention)
+	var purged []types.Rule
+	var purgedIDs []string
+	for _, r := range rules {
+		ok, err := purgeable(r, ruleSyncs[r.RuleID], engines, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			purged = append(purged, r)
+			purgedIDs = append(purgedIDs, r.RuleID)
+		}
+	}
+	if opts.DryRun || len(purged) == 0 {
+		return purged, nil
+	}
+
+	// rules go last, a rule left behind by a failure is synced again and
+	// purged by a later run
+	err = syncStor.Purge(purgedIDs)
+	if err != nil {
+		return nil, err
+	}
+	aclapiStor, err := storage.GetACLAPIStorage()
+	if err != nil {
+		return nil, err
+	}
+	err = aclapiStor.Purge(purgedIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = ruleStor.Purge(purgedIDs)
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// purgeable reports whether r was removed before cutoff and its removal was
+// successfully synced by every engine syncing it. Rules removed before the
+// removal time was recorded use their creation time instead.
+func purgeable(r types.Rule, syncs map[string]types.RuleSyncInfo, engines []engine.Engine, cutoff time.Time) (bool, error) {
+	removedAt := r.Created
+	if r.RemovedAt != nil {
+		removedAt = *r.RemovedAt
+	}
+	if removedAt.After(cutoff) {
+		return false, nil
+	}
+	for _, e := range engines {
+		if filterEngine, ok := e.(engine.EngineWithFilter); ok {
+			allowed, err := filterEngine.Allowed(r)
+			if err != nil {
+				return false, err
+			}
+			if !allowed {
+				continue
+			}
+		}
+		latestSync := syncs[e.Name()].LatestSync()
+		if latestSync == nil || !latestSync.Removed || !latestSync.Successful {
+			return false, nil
+		}
+	}
+	return true, nil
+}
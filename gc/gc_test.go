@@ -0,0 +1,140 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-gc")
+}
+
+type fakeEngine struct {
+	name    string
+	skipped map[string]bool
+}
+
+func (e *fakeEngine) Name() string {
+	return e.name
+}
+
+func (e *fakeEngine) Sync(r types.Rule) (interface{}, error) {
+	return nil, nil
+}
+
+type filterEngine struct {
+	fakeEngine
+}
+
+func (e *filterEngine) Allowed(r types.Rule) (bool, error) {
+	return !e.skipped[r.RuleID], nil
+}
+
+func endSync(t *testing.T, ruleID, engineName string, data types.RuleSyncData) {
+	stor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+	_, ruleSync, err := stor.StartSync(0, ruleID, engineName, true)
+	require.NoError(t, err)
+	err = stor.EndSync(*ruleSync, data)
+	require.NoError(t, err)
+}
+
+func TestPurge(t *testing.T) {
+	ruleStor, err := storage.GetRuleStorage()
+	require.NoError(t, err)
+	ruleStor.(interface {
+		ClearAll()
+	}).ClearAll()
+	var rules []*types.Rule
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		rules = append(rules, &types.Rule{
+			RuleID:      id,
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: id + ".com"}},
+		})
+	}
+	err = ruleStor.Save(rules, false)
+	require.NoError(t, err)
+	for _, id := range []string{"r1", "r2", "r3"} {
+		err = ruleStor.Delete(storage.DeleteOpts{ID: id})
+		require.NoError(t, err)
+	}
+	removed := types.RuleSyncData{Removed: true, Successful: true}
+	endSync(t, "r1", "e1", types.RuleSyncData{Successful: true})
+	endSync(t, "r1", "e1", removed)
+	endSync(t, "r1", "e2", removed)
+	endSync(t, "r2", "e1", removed)
+	endSync(t, "r2", "e2", types.RuleSyncData{Removed: true, Error: "unreachable"})
+	endSync(t, "r3", "e1", removed)
+	endSync(t, "r4", "e1", types.RuleSyncData{Successful: true})
+	endSync(t, "r4", "e2", types.RuleSyncData{Successful: true})
+	aclapiStor, err := storage.GetACLAPIStorage()
+	require.NoError(t, err)
+	err = aclapiStor.Add("r1", []storage.ACLIdPair{{NetworkID: "n1", ACLRuleID: "a1"}})
+	require.NoError(t, err)
+
+	// e2 does not sync r3
+	engines := []engine.Engine{
+		&fakeEngine{name: "e1"},
+		&filterEngine{fakeEngine{name: "e2", skipped: map[string]bool{"r3": true}}},
+	}
+	purgedIDs := func(purged []types.Rule) []string {
+		var ids []string
+		for _, r := range purged {
+			ids = append(ids, r.RuleID)
+		}
+		return ids
+	}
+
+	purged, err := Purge(engines, time.Now().UTC(), Options{Retention: time.Hour})
+	require.NoError(t, err)
+	assert.Empty(t, purged, "rules removed within the retention are kept")
+
+	later := time.Now().UTC().Add(2 * time.Hour)
+	purged, err = Purge(engines, later, Options{Retention: time.Hour, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r3"}, purgedIDs(purged))
+	_, err = ruleStor.Find("r1")
+	require.NoError(t, err)
+
+	purged, err = Purge(engines, later, Options{Retention: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r3"}, purgedIDs(purged))
+	_, err = ruleStor.Find("r1")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	_, err = ruleStor.Find("r3")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	_, err = ruleStor.Find("r2")
+	assert.NoError(t, err)
+	_, err = ruleStor.Find("r4")
+	assert.NoError(t, err)
+	_, err = aclapiStor.Find("r1")
+	assert.Equal(t, storage.ErrACLAPISyncedRuleNotFound, err)
+	syncStor, err := storage.GetSyncStorage()
+	require.NoError(t, err)
+	syncs, err := syncStor.Find(storage.SyncFindOpts{RuleIDs: []string{"r1", "r3"}})
+	require.NoError(t, err)
+	assert.Empty(t, syncs)
+
+	purged, err = Purge(engines, later, Options{Retention: time.Hour})
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+}
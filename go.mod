@@ -7,6 +7,7 @@ require (
 	github.com/evanphx/json-patch/v5 v5.6.0
 	github.com/google/gops v0.3.3-0.20171222022621-e09130d89827
 	github.com/labstack/echo v3.2.5+incompatible
+	github.com/lib/pq v1.9.0
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.12.1
@@ -19,9 +20,12 @@ require (
 	github.com/uber/jaeger-client-go v2.25.0+incompatible
 	go.mongodb.org/mongo-driver v1.5.1
 	golang.org/x/oauth2 v0.1.0
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8
+	k8s.io/api v0.23.17
 	k8s.io/apiextensions-apiserver v0.20.6
 	k8s.io/apimachinery v0.23.17
 	k8s.io/client-go v0.23.17
+	sigs.k8s.io/yaml v1.3.0
 )
 
 require (
@@ -108,7 +112,6 @@ require (
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/term v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
-	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
 	golang.org/x/tools v0.6.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
@@ -116,13 +119,11 @@ require (
 	gopkg.in/ini.v1 v1.51.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/api v0.23.17 // indirect
 	k8s.io/klog/v2 v2.60.1 // indirect
 	k8s.io/kube-openapi v0.0.0-20211115234752-e816edb12b65 // indirect
 	k8s.io/utils v0.0.0-20220210201930-3a6ce19ff2f9 // indirect
 	sigs.k8s.io/json v0.0.0-20211020170558-c049b76a60c6 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.2.3 // indirect
-	sigs.k8s.io/yaml v1.3.0 // indirect
 )
 
 replace gopkg.in/ahmetb/go-linq.v3 => github.com/ahmetb/go-linq v3.0.0+incompatible
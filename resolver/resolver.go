@@ -0,0 +1,217 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package resolver tracks the addresses of ExternalDNS rule destinations so
+// engines that only understand addresses can enforce them. Resolved
+// addresses are stored until their TTL expires and rules whose addresses
+// change are reported so they can be synced again.
+package resolver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var logger = logrus.WithField("source", "resolver")
+
+// Address is a resolved address, a zero TTL means the TTL is unknown and
+// resolver.ttl is used instead.
+type Address struct {
+	IP  net.IP
+	TTL time.Duration
+}
+
+type Resolver interface {
+	Resolve(ctx context.Context, name string) ([]Address, error)
+}
+
+// GetResolver returns the resolver used to look up names, tests replace it
+// with a fake resolver.
+var GetResolver = func() Resolver {
+	return &systemResolver{}
+}
+
+// systemResolver uses the system resolver, which does not report TTLs.
+type systemResolver struct{}
+
+func (r *systemResolver) Resolve(ctx context.Context, name string) ([]Address, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Address, len(addrs))
+	for i, addr := range addrs {
+		ret[i] = Address{IP: addr.IP}
+	}
+	return ret, nil
+}
+
+// LookupName returns the name resolved for a rule name, names starting with
+// a dot match a domain and its subdomains but only the domain itself can be
+// resolved.
+func LookupName(name string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(name), "."), ".")
+}
+
+// Lookup returns the stored addresses of name, resolving it again when no
+// address is stored or any of them expired.
+func Lookup(ctx context.Context, name string) ([]storage.StoredIP, error) {
+	stor, err := storage.GetDNSStorage()
+	if err != nil {
+		return nil, err
+	}
+	ips, _, err := refresh(ctx, stor, LookupName(name), time.Now().UTC())
+	return ips, err
+}
+
+// Refresh resolves the expired names of ExternalDNS rules and returns the
+// rules whose addresses changed. Names that fail to resolve keep their
+// previous addresses.
+func Refresh(ctx context.Context, rules []types.Rule) ([]types.Rule, error) {
+	stor, err := storage.GetDNSStorage()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string][]types.Rule{}
+	var names []string
+	for _, r := range rules {
+		if r.Removed || r.Destination.ExternalDNS == nil {
+			continue
+		}
+		name := LookupName(r.Destination.ExternalDNS.Name)
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+		byName[name] = append(byName[name], r)
+	}
+	now := time.Now().UTC()
+	var changed []types.Rule
+	for _, name := range names {
+		_, nameChanged, err := refresh(ctx, stor, name, now)
+		if err != nil {
+			logger.Errorf("unable to resolve %q: %v", name, err)
+			continue
+		}
+		if nameChanged {
+			logger.Infof("addresses of %q changed", name)
+			changed = append(changed, byName[name]...)
+		}
+	}
+	return changed, nil
+}
+
+func refresh(ctx context.Context, stor storage.DNSStorage, name string, now time.Time) ([]storage.StoredIP, bool, error) {
+	stored, err := stor.Find(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if valid(stored, now) {
+		return stored, false, nil
+	}
+	addrs, err := GetResolver().Resolve(ctx, name)
+	if err != nil {
+		return stored, false, err
+	}
+	defaultTTL := viper.GetDuration("resolver.ttl")
+	if defaultTTL <= 0 {
+		defaultTTL = 5 * time.Minute
+	}
+	var ips []storage.StoredIP
+	for _, addr := range addrs {
+		ttl := addr.TTL
+		if ttl <= 0 {
+			ttl = defaultTTL
+		}
+		ips = append(ips, storage.StoredIP{IP: addr.IP, ValidUntil: now.Add(ttl)})
+	}
+	sort.Slice(ips, func(i, j int) bool {
+		return ips[i].IP.String() < ips[j].IP.String()
+	})
+	err = stor.Save(name, ips)
+	if err != nil {
+		return nil, false, err
+	}
+	return ips, !sameIPs(stored, ips), nil
+}
+
+func valid(ips []storage.StoredIP, now time.Time) bool {
+	if len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.ValidUntil.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameIPs(a, b []storage.StoredIP) bool {
+	set := map[string]struct{}{}
+	for _, ip := range a {
+		set[ip.IP.String()] = struct{}{}
+	}
+	other := map[string]struct{}{}
+	for _, ip := range b {
+		if _, ok := set[ip.IP.String()]; !ok {
+			return false
+		}
+		other[ip.IP.String()] = struct{}{}
+	}
+	return len(set) == len(other)
+}
+
+// Networks returns the networks covered by ips in CIDR notation, with
+// wholeNetwork each address is widened to the network configured in
+// resolver.ipv4_prefix or resolver.ipv6_prefix.
+func Networks(ips []storage.StoredIP, wholeNetwork bool) []string {
+	seen := map[string]struct{}{}
+	var networks []string
+	for _, ip := range ips {
+		network := AddressNetwork(ip.IP, wholeNetwork)
+		if network == nil {
+			continue
+		}
+		if _, ok := seen[network.String()]; ok {
+			continue
+		}
+		seen[network.String()] = struct{}{}
+		networks = append(networks, network.String())
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// AddressNetwork returns the network of a single address, see Networks.
+func AddressNetwork(ip net.IP, wholeNetwork bool) *net.IPNet {
+	bits, prefix := 128, 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, prefix = v4, 32, 32
+		if wholeNetwork {
+			prefix = prefixConfig("resolver.ipv4_prefix", 24, bits)
+		}
+	} else if ip.To16() == nil {
+		return nil
+	} else if wholeNetwork {
+		prefix = prefixConfig("resolver.ipv6_prefix", 64, bits)
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+func prefixConfig(key string, defaultPrefix, bits int) int {
+	prefix := viper.GetInt(key)
+	if prefix <= 0 || prefix > bits {
+		return defaultPrefix
+	}
+	return prefix
+}
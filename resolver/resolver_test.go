@@ -0,0 +1,176 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-resolver")
+}
+
+type fakeResolver struct {
+	addrs map[string][]Address
+	err   error
+	calls []string
+}
+
+func (r *fakeResolver) Resolve(ctx context.Context, name string) ([]Address, error) {
+	r.calls = append(r.calls, name)
+	return r.addrs[name], r.err
+}
+
+func setup(t *testing.T) (*fakeResolver, storage.DNSStorage) {
+	stor, err := storage.GetDNSStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	fake := &fakeResolver{addrs: map[string][]Address{}}
+	oldResolver := GetResolver
+	GetResolver = func() Resolver {
+		return fake
+	}
+	t.Cleanup(func() {
+		GetResolver = oldResolver
+	})
+	return fake, stor
+}
+
+func dnsRule(id, name string) types.Rule {
+	return types.Rule{
+		RuleID:      id,
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: name}},
+	}
+}
+
+func ipStrings(ips []storage.StoredIP) []string {
+	var ret []string
+	for _, ip := range ips {
+		ret = append(ret, ip.IP.String())
+	}
+	return ret
+}
+
+func TestRefresh(t *testing.T) {
+	fake, stor := setup(t)
+	fake.addrs["a.com"] = []Address{
+		{IP: net.ParseIP("10.0.0.2"), TTL: time.Hour},
+		{IP: net.ParseIP("10.0.0.1")},
+	}
+	fake.addrs["b.com"] = []Address{{IP: net.ParseIP("10.0.1.1"), TTL: time.Hour}}
+	viper.Set("resolver.ttl", time.Minute)
+	defer viper.Set("resolver.ttl", nil)
+
+	removed := dnsRule("r4", "c.com")
+	removed.Removed = true
+	rules := []types.Rule{
+		dnsRule("r1", "a.com"),
+		dnsRule("r2", ".A.com."),
+		dnsRule("r3", "b.com"),
+		removed,
+		{RuleID: "r5", Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1"}}},
+	}
+	changed, err := Refresh(context.TODO(), rules)
+	require.Nil(t, err)
+	assert.Equal(t, []types.Rule{rules[0], rules[1], rules[2]}, changed)
+	assert.Equal(t, []string{"a.com", "b.com"}, fake.calls)
+
+	ips, err := stor.Find("a.com")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, ipStrings(ips))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), ips[0].ValidUntil, 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), ips[1].ValidUntil, 5*time.Second)
+
+	fake.calls = nil
+	changed, err = Refresh(context.TODO(), rules)
+	require.Nil(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, fake.calls)
+
+	err = stor.Save("a.com", []storage.StoredIP{{IP: net.ParseIP("10.0.0.1"), ValidUntil: time.Now().Add(-time.Second)}})
+	require.Nil(t, err)
+	fake.addrs["a.com"] = []Address{{IP: net.ParseIP("10.0.0.1")}}
+	changed, err = Refresh(context.TODO(), rules)
+	require.Nil(t, err)
+	assert.Empty(t, changed)
+	assert.Equal(t, []string{"a.com"}, fake.calls)
+
+	err = stor.Save("b.com", []storage.StoredIP{{IP: net.ParseIP("10.0.1.1"), ValidUntil: time.Now().Add(-time.Second)}})
+	require.Nil(t, err)
+	fake.addrs["b.com"] = []Address{{IP: net.ParseIP("10.0.1.2")}}
+	changed, err = Refresh(context.TODO(), rules)
+	require.Nil(t, err)
+	assert.Equal(t, []types.Rule{rules[2]}, changed)
+}
+
+func TestRefreshResolveError(t *testing.T) {
+	fake, stor := setup(t)
+	expired := []storage.StoredIP{{IP: net.ParseIP("10.0.0.1"), ValidUntil: time.Now().Add(-time.Second)}}
+	err := stor.Save("a.com", expired)
+	require.Nil(t, err)
+	fake.err = errors.New("no such host")
+
+	changed, err := Refresh(context.TODO(), []types.Rule{dnsRule("r1", "a.com")})
+	require.Nil(t, err)
+	assert.Empty(t, changed)
+	ips, err := stor.Find("a.com")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ips))
+
+	ips, err = Lookup(context.TODO(), "a.com")
+	assert.EqualError(t, err, "no such host")
+	assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ips))
+}
+
+func TestLookup(t *testing.T) {
+	fake, _ := setup(t)
+	fake.addrs["a.com"] = []Address{{IP: net.ParseIP("10.0.0.1")}}
+	ips, err := Lookup(context.TODO(), ".a.com")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ips))
+	ips, err = Lookup(context.TODO(), "a.com")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ips))
+	assert.Equal(t, []string{"a.com"}, fake.calls)
+}
+
+func TestNetworks(t *testing.T) {
+	ips := []storage.StoredIP{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("2001:db8::1")},
+	}
+	assert.Equal(t, []string{"10.0.0.1/32", "10.0.0.2/32", "2001:db8::1/128"}, Networks(ips, false))
+	assert.Equal(t, []string{"10.0.0.0/24", "2001:db8::/64"}, Networks(ips, true))
+
+	viper.Set("resolver.ipv4_prefix", 16)
+	viper.Set("resolver.ipv6_prefix", 48)
+	defer func() {
+		viper.Set("resolver.ipv4_prefix", nil)
+		viper.Set("resolver.ipv6_prefix", nil)
+	}()
+	assert.Equal(t, []string{"10.0.0.0/16", "2001:db8::/48"}, Networks(ips, true))
+	assert.Nil(t, Networks(nil, true))
+}
@@ -0,0 +1,187 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rule
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+)
+
+// newTsuruClient is replaced in tests to return apps the tsuru API rejects.
+var newTsuruClient = external.NewTsuruClient
+
+// CheckApp evaluates whether the app is allowed to reach target, considering
+// rules with the app as source, including the ones derived from service
+// instances, and rules for the pool of the app.
+func (s *ruleServiceImpl) CheckApp(appName string, target types.CheckTarget) (types.CheckResult, error) {
+	rules, err := s.FindBySourceTsuruApp(appName)
+	if err != nil {
+		return types.CheckResult{}, err
+	}
+	appInfo, err := newTsuruClient().AppInfo(appName)
+	if err != nil {
+		return types.CheckResult{}, err
+	}
+	if appInfo.Pool != "" {
+		poolRules, err := s.FindByRule(types.Rule{
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{PoolName: appInfo.Pool},
+			},
+		})
+		if err != nil {
+			return types.CheckResult{}, err
+		}
+		rules = append(rules, poolRules...)
+	}
+	return s.check(fmt.Sprintf("app %q", appName), rules, target)
+}
+
+// CheckJob evaluates whether the job is allowed to reach target.
+func (s *ruleServiceImpl) CheckJob(jobName string, target types.CheckTarget) (types.CheckResult, error) {
+	rules, err := s.FindBySourceTsuruJob(jobName)
+	if err != nil {
+		return types.CheckResult{}, err
+	}
+	return s.check(fmt.Sprintf("job %q", jobName), rules, target)
+}
+
+func (s *ruleServiceImpl) check(source string, rules []types.Rule, target types.CheckTarget) (types.CheckResult, error) {
+	result := Check(source, rules, target, time.Now())
+	if len(result.Matches) == 0 {
+		return result, nil
+	}
+	ruleIDs := make([]string, len(result.Matches))
+	for i, m := range result.Matches {
+		ruleIDs[i] = m.Rule.RuleID
+	}
+	syncs, err := s.FindSyncs(ruleIDs)
+	if err != nil {
+		return types.CheckResult{}, err
+	}
+	latest := map[string]map[string]types.RuleSyncData{}
+	for _, sync := range syncs {
+		data := sync.LatestSync()
+		if data == nil {
+			continue
+		}
+		if latest[sync.RuleID] == nil {
+			latest[sync.RuleID] = map[string]types.RuleSyncData{}
+		}
+		latest[sync.RuleID][sync.Engine] = *data
+	}
+	for i := range result.Matches {
+		result.Matches[i].LatestSyncs = latest[result.Matches[i].Rule.RuleID]
+	}
+	return result, nil
+}
+
+// Check evaluates rules, all of them with the same source, against target.
+// Only ExternalIP and ExternalDNS destinations can be matched against a host,
+// rules with other destinations are ignored.
+func Check(source string, rules []types.Rule, target types.CheckTarget, now time.Time) types.CheckResult {
+	protocol := strings.ToLower(target.Protocol)
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	host := strings.TrimSuffix(strings.ToLower(target.Host), ".")
+	var (
+		result                           types.CheckResult
+		active, hostMatches, unsupported int
+	)
+	for _, r := range rules {
+		if r.Removed || r.Expired(now) {
+			continue
+		}
+		active++
+		var ports types.ProtoPorts
+		switch {
+		case r.Destination.ExternalIP != nil:
+			if !ipMatch(r.Destination.ExternalIP.IP, host) {
+				continue
+			}
+			ports = r.Destination.ExternalIP.Ports
+		case r.Destination.ExternalDNS != nil:
+			if !dnsMatch(r.Destination.ExternalDNS.Name, host) {
+				continue
+			}
+			ports = r.Destination.ExternalDNS.Ports
+		default:
+			unsupported++
+			continue
+		}
+		hostMatches++
+		if !portMatch(ports, protocol, target.Port) {
+			continue
+		}
+		result.Matches = append(result.Matches, types.CheckMatch{Rule: r})
+	}
+	result.Allowed = len(result.Matches) > 0
+	if result.Allowed {
+		return result
+	}
+	switch {
+	case active == 0:
+		result.Reason = fmt.Sprintf("no active rules for %s", source)
+	case hostMatches > 0:
+		port := fmt.Sprintf("%s:%d", protocol, target.Port)
+		if target.Port == 0 {
+			port = protocol
+		}
+		result.Reason = fmt.Sprintf("%d rule(s) allow host %q, but none of them allow %s", hostMatches, target.Host, port)
+	default:
+		result.Reason = fmt.Sprintf("none of the %d active rule(s) for %s allow host %q", active, source, target.Host)
+	}
+	if unsupported > 0 {
+		result.Reason += fmt.Sprintf(", %d rule(s) with app, job, service or rpaas destinations cannot be matched against a host", unsupported)
+	}
+	return result
+}
+
+// ipMatch reports whether host is an IPv4 or IPv6 address contained in
+// ruleIP, which may be an address or a CIDR. IPv6 hosts may be enclosed in
+// brackets.
+func ipMatch(ruleIP, host string) bool {
+	ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if ip == nil {
+		return false
+	}
+	ipNet, err := types.ParseExternalIP(ruleIP)
+	if err != nil {
+		return false
+	}
+	return ipNet.Contains(ip)
+}
+
+// dnsMatch reports whether host matches the rule name, names starting with a
+// dot match the domain itself and all of its subdomains.
+func dnsMatch(name, host string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if strings.HasPrefix(name, ".") {
+		return host == name[1:] || strings.HasSuffix(host, name)
+	}
+	return host == name
+}
+
+// portMatch reports whether ports allow the connection, an empty list allows
+// every port.
+func portMatch(ports types.ProtoPorts, protocol string, port uint16) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		if strings.ToLower(p.Protocol) != protocol {
+			continue
+		}
+		if port == 0 || p.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
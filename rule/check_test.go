@@ -0,0 +1,257 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/tsuru/app"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	ipRule := func(id, ip string, ports ...types.ProtoPort) types.Rule {
+		return types.Rule{
+			RuleID: id,
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: ip, Ports: ports},
+			},
+		}
+	}
+	dnsRule := func(id, name string, ports ...types.ProtoPort) types.Rule {
+		return types.Rule{
+			RuleID: id,
+			Destination: types.RuleType{
+				ExternalDNS: &types.ExternalDNSRule{Name: name, Ports: ports},
+			},
+		}
+	}
+	removed := ipRule("removed", "10.0.0.0/8")
+	removed.Removed = true
+	expired := ipRule("expired", "10.0.0.0/8")
+	expired.ExpiresAt = &past
+	appRule := types.Rule{
+		RuleID: "app",
+		Destination: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app2"},
+		},
+	}
+	tcp443 := types.ProtoPort{Protocol: "tcp", Port: 443}
+	udp53 := types.ProtoPort{Protocol: "UDP", Port: 53}
+	tcpHigh := types.ProtoPort{Protocol: "TCP", Port: 8000, EndPort: 8080}
+
+	tests := []struct {
+		name    string
+		rules   []types.Rule
+		target  types.CheckTarget
+		matches []string
+		reason  string
+	}{
+		{
+			name:    "cidr containment",
+			rules:   []types.Rule{ipRule("r1", "10.1.0.0/16"), ipRule("r2", "10.2.0.0/16")},
+			target:  types.CheckTarget{Host: "10.1.2.3", Port: 80},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "single ip",
+			rules:   []types.Rule{ipRule("r1", "10.1.2.3"), ipRule("r2", "10.1.2.4")},
+			target:  types.CheckTarget{Host: "10.1.2.3"},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "ipv6 cidr containment",
+			rules:   []types.Rule{ipRule("r1", "2001:db8::/64"), ipRule("r2", "10.0.0.0/8")},
+			target:  types.CheckTarget{Host: "2001:DB8::abcd", Port: 80},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "ipv6 address in brackets",
+			rules:   []types.Rule{ipRule("r1", "2001:db8:0::1"), ipRule("r2", "2001:db8::2")},
+			target:  types.CheckTarget{Host: "[2001:db8::1]"},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "exact dns",
+			rules:   []types.Rule{dnsRule("r1", "example.com"), dnsRule("r2", "other.com")},
+			target:  types.CheckTarget{Host: "Example.com."},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "dns suffix",
+			rules:   []types.Rule{dnsRule("r1", ".example.com"), dnsRule("r2", "example.com")},
+			target:  types.CheckTarget{Host: "api.example.com"},
+			matches: []string{"r1"},
+		},
+		{
+			name:    "dns suffix matches domain",
+			rules:   []types.Rule{dnsRule("r1", ".example.com")},
+			target:  types.CheckTarget{Host: "example.com"},
+			matches: []string{"r1"},
+		},
+		{
+			name:   "dns suffix requires label boundary",
+			rules:  []types.Rule{dnsRule("r1", ".example.com")},
+			target: types.CheckTarget{Host: "myexample.com"},
+			reason: `none of the 1 active rule(s) for app "app1" allow host "myexample.com"`,
+		},
+		{
+			name:    "ports",
+			rules:   []types.Rule{ipRule("r1", "10.0.0.1", tcp443), ipRule("r2", "10.0.0.1", udp53)},
+			target:  types.CheckTarget{Host: "10.0.0.1", Port: 53, Protocol: "udp"},
+			matches: []string{"r2"},
+		},
+		{
+			name:    "port range",
+			rules:   []types.Rule{ipRule("r1", "10.0.0.1", tcpHigh), ipRule("r2", "10.0.0.1", tcp443)},
+			target:  types.CheckTarget{Host: "10.0.0.1", Port: 8080},
+			matches: []string{"r1"},
+		},
+		{
+			name:   "port outside range",
+			rules:  []types.Rule{dnsRule("r1", "example.com", tcpHigh)},
+			target: types.CheckTarget{Host: "example.com", Port: 8081},
+			reason: `1 rule(s) allow host "example.com", but none of them allow tcp:8081`,
+		},
+		{
+			name:   "port not allowed",
+			rules:  []types.Rule{dnsRule("r1", "example.com", tcp443)},
+			target: types.CheckTarget{Host: "example.com", Port: 80},
+			reason: `1 rule(s) allow host "example.com", but none of them allow tcp:80`,
+		},
+		{
+			name:   "protocol not allowed",
+			rules:  []types.Rule{dnsRule("r1", "example.com", tcp443)},
+			target: types.CheckTarget{Host: "example.com", Protocol: "udp"},
+			reason: `1 rule(s) allow host "example.com", but none of them allow udp`,
+		},
+		{
+			name:   "no active rules",
+			rules:  []types.Rule{removed, expired},
+			target: types.CheckTarget{Host: "10.0.0.1"},
+			reason: `no active rules for app "app1"`,
+		},
+		{
+			name:   "unsupported destinations",
+			rules:  []types.Rule{appRule, ipRule("r1", "10.0.0.1")},
+			target: types.CheckTarget{Host: "10.0.0.2"},
+			reason: `none of the 2 active rule(s) for app "app1" allow host "10.0.0.2", 1 rule(s) with app, job, service or rpaas destinations cannot be matched against a host`,
+		},
+		{
+			name:   "host name against ip rules",
+			rules:  []types.Rule{ipRule("r1", "10.0.0.0/8")},
+			target: types.CheckTarget{Host: "example.com"},
+			reason: `none of the 1 active rule(s) for app "app1" allow host "example.com"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Check(`app "app1"`, tt.rules, tt.target, now)
+			var matches []string
+			for _, m := range result.Matches {
+				matches = append(matches, m.Rule.RuleID)
+			}
+			assert.Equal(t, tt.matches, matches)
+			assert.Equal(t, len(tt.matches) > 0, result.Allowed)
+			assert.Equal(t, tt.reason, result.Reason)
+		})
+	}
+}
+
+func Test_RuleService_CheckApp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app1":
+			w.Write([]byte(`{"name": "app1", "pool": "p1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer viper.Set("tsuru.host", viper.Get("tsuru.host"))
+	viper.Set("tsuru.host", srv.URL)
+
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	svc := GetService()
+	err = svc.Save([]*types.Rule{
+		{
+			RuleID: "app-rule",
+			Source: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/24", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}},
+			},
+		},
+		{
+			RuleID: "pool-rule",
+			Source: types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p1"}},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/22"},
+			},
+		},
+		{
+			RuleID: "other-pool-rule",
+			Source: types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "p2"}},
+			Destination: types.RuleType{
+				ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/22"},
+			},
+		},
+	}, false)
+	require.Nil(t, err)
+
+	_, rsi, err := svc.SyncStart(time.Minute, "app-rule", "e1", true)
+	require.Nil(t, err)
+	err = svc.SyncEnd(*rsi, types.RuleSyncData{Successful: true, SyncResult: "ok"})
+	require.Nil(t, err)
+
+	result, err := svc.CheckApp("app1", types.CheckTarget{Host: "10.0.0.1", Port: 443})
+	require.Nil(t, err)
+	assert.True(t, result.Allowed)
+	require.Len(t, result.Matches, 2)
+	assert.Equal(t, "app-rule", result.Matches[0].Rule.RuleID)
+	require.Contains(t, result.Matches[0].LatestSyncs, "e1")
+	assert.True(t, result.Matches[0].LatestSyncs["e1"].Successful)
+	assert.Equal(t, "ok", result.Matches[0].LatestSyncs["e1"].SyncResult)
+	assert.Equal(t, "pool-rule", result.Matches[1].Rule.RuleID)
+	assert.Nil(t, result.Matches[1].LatestSyncs)
+
+	result, err = svc.CheckApp("app1", types.CheckTarget{Host: "10.1.0.1"})
+	require.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, `none of the 2 active rule(s) for app "app1" allow host "10.1.0.1"`, result.Reason)
+
+	_, err = svc.CheckApp("app2", types.CheckTarget{Host: "10.0.0.1"})
+	assert.Error(t, err)
+
+	defer func(orig func() external.TsuruClient) { newTsuruClient = orig }(newTsuruClient)
+	newTsuruClient = func() external.TsuruClient {
+		return &noPoolTsuruClient{TsuruClient: external.NewTsuruClient()}
+	}
+	result, err = svc.CheckApp("app1", types.CheckTarget{Host: "10.0.0.1", Port: 443})
+	require.Nil(t, err)
+	require.Len(t, result.Matches, 1)
+	assert.Equal(t, "app-rule", result.Matches[0].Rule.RuleID)
+}
+
+type noPoolTsuruClient struct {
+	external.TsuruClient
+}
+
+func (c *noPoolTsuruClient) AppInfo(appName string) (*app.App, error) {
+	return &app.App{Name: appName}, nil
+}
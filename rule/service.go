@@ -5,7 +5,6 @@
 package rule
 
 import (
-	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
@@ -19,12 +18,20 @@ type RuleService interface {
 	Save(rules []*types.Rule, upsert bool) error
 	FindMetadata(metadata map[string]string) ([]types.Rule, error)
 	FindByRule(rule types.Rule) ([]types.Rule, error)
+	FindPage(filter types.Rule, opts storage.FindOpts) ([]types.Rule, *storage.Cursor, error)
 	FindByID(id string) (types.Rule, error)
 	FindBySourceTsuruApp(appName string) ([]types.Rule, error)
 	FindBySourceTsuruJob(jobName string) ([]types.Rule, error)
 	Delete(id string) error
 	DeleteMetadata(metadata map[string]string) error
+	DeleteExpired(now time.Time) ([]types.Rule, error)
+	Update(id string, update types.RuleUpdate, user string) (types.Rule, error)
+	FindHistory(id string) ([]types.RuleChange, error)
 	FindSyncs(ruleIDFilter []string) ([]types.RuleSyncInfo, error)
+	FindSyncPage(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, *storage.Cursor, error)
+	CheckApp(appName string, target types.CheckTarget) (types.CheckResult, error)
+	CheckJob(jobName string, target types.CheckTarget) (types.CheckResult, error)
+	Watch(opts storage.FindOpts, revision string) (storage.RuleWatch, error)
 }
 
 type EngineRuleService interface {
@@ -95,7 +102,7 @@ func ruleTypeMatch(ruleType types.RuleType, filter types.RuleType) bool {
 		if filter.ExternalDNS.Name != "" && filter.ExternalDNS.Name != ruleType.ExternalDNS.Name {
 			return false
 		}
-		if filter.ExternalDNS.Ports != nil && !reflect.DeepEqual(filter.ExternalDNS.Ports, ruleType.ExternalDNS.Ports) {
+		if filter.ExternalDNS.Ports != nil && !filter.ExternalDNS.Ports.Equals(ruleType.ExternalDNS.Ports) {
 			return false
 		}
 	}
@@ -103,10 +110,10 @@ func ruleTypeMatch(ruleType types.RuleType, filter types.RuleType) bool {
 		if ruleType.ExternalIP == nil {
 			return false
 		}
-		if filter.ExternalIP.IP != "" && filter.ExternalIP.IP != ruleType.ExternalIP.IP {
+		if filter.ExternalIP.IP != "" && types.NormalizeIP(filter.ExternalIP.IP) != types.NormalizeIP(ruleType.ExternalIP.IP) {
 			return false
 		}
-		if filter.ExternalIP.Ports != nil && !reflect.DeepEqual(filter.ExternalIP.Ports, ruleType.ExternalIP.Ports) {
+		if filter.ExternalIP.Ports != nil && !filter.ExternalIP.Ports.Equals(ruleType.ExternalIP.Ports) {
 			return false
 		}
 	}
@@ -154,24 +161,74 @@ func ruleMatch(rule types.Rule, filter types.Rule) bool {
 }
 
 func (s *ruleServiceImpl) FindByRule(filter types.Rule) ([]types.Rule, error) {
+	rules, _, err := s.FindPage(filter, storage.FindOpts{})
+	return rules, err
+}
+
+// FindPage returns the rules matching filter and opts, at most opts.Limit of
+// them, along with the cursor of the next page, nil on the last one. Criteria
+// the storage cannot apply, like ports, are applied to the page found so it
+// may hold fewer rules than the limit.
+func (s *ruleServiceImpl) FindPage(filter types.Rule, opts storage.FindOpts) ([]types.Rule, *storage.Cursor, error) {
 	stor, err := storage.GetRuleStorage()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	allByMetadata, err := stor.FindAll(storage.FindOpts{
-		Metadata: filter.Metadata,
-		Creator:  filter.Creator,
-	})
+	addFilterOpts(filter, &opts)
+	rules, err := stor.FindAll(opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	var next *storage.Cursor
+	if opts.Limit > 0 && len(rules) == opts.Limit {
+		next = storage.RuleCursor(rules[len(rules)-1], opts.Sort)
 	}
 	var ret []types.Rule
-	for _, r := range allByMetadata {
+	for _, r := range rules {
 		if ruleMatch(r, filter) {
 			ret = append(ret, r)
 		}
 	}
-	return ret, nil
+	return ret, next, nil
+}
+
+// addFilterOpts adds to opts the criteria of filter the storage is able to
+// apply.
+func addFilterOpts(filter types.Rule, opts *storage.FindOpts) {
+	if len(filter.Metadata) > 0 {
+		metadata := map[string]string{}
+		for k, v := range opts.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range filter.Metadata {
+			metadata[k] = v
+		}
+		opts.Metadata = metadata
+	}
+	if filter.Creator != "" {
+		opts.Creator = filter.Creator
+	}
+	if src := filter.Source; src.TsuruApp != nil {
+		opts.SourceTsuruApp = src.TsuruApp.AppName
+		opts.SourceTsuruPool = src.TsuruApp.PoolName
+	}
+	if src := filter.Source; src.TsuruJob != nil {
+		opts.SourceTsuruJob = src.TsuruJob.JobName
+	}
+	dst := filter.Destination
+	if dst.ExternalIP != nil {
+		opts.DestinationExternalIP = dst.ExternalIP.IP
+	}
+	if dst.ExternalDNS != nil {
+		opts.DestinationExternalDNS = dst.ExternalDNS.Name
+	}
+	if dst.TsuruApp != nil {
+		opts.DestinationTsuruApp = dst.TsuruApp.AppName
+		opts.DestinationTsuruPool = dst.TsuruApp.PoolName
+	}
+	if dst.TsuruJob != nil {
+		opts.DestinationTsuruJob = dst.TsuruJob.JobName
+	}
 }
 
 func (s *ruleServiceImpl) FindByID(id string) (types.Rule, error) {
@@ -198,6 +255,110 @@ func (s *ruleServiceImpl) Delete(id string) error {
 	return stor.Delete(storage.DeleteOpts{ID: id})
 }
 
+// DeleteExpired removes every rule expired at now and returns them, already
+// marked as removed, so the removal can be synced.
+func (s *ruleServiceImpl) DeleteExpired(now time.Time) ([]types.Rule, error) {
+	stor, err := storage.GetRuleStorage()
+	if err != nil {
+		return nil, err
+	}
+	removed := false
+	rules, err := stor.FindAll(storage.FindOpts{Removed: &removed, ExpiresUntil: now})
+	if err != nil {
+		return nil, err
+	}
+	var expired []types.Rule
+	for _, r := range rules {
+		err = stor.Delete(storage.DeleteOpts{ID: r.RuleID})
+		if err == storage.ErrRuleNotFound {
+			// removed concurrently
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		removedAt := now
+		r.Removed = true
+		r.RemovedAt = &removedAt
+		expired = append(expired, r)
+	}
+	return expired, nil
+}
+
+// Update applies update to the rule keeping its ID, the previous values are
+// recorded in the rule history.
+func (s *ruleServiceImpl) Update(id string, update types.RuleUpdate, user string) (types.Rule, error) {
+	stor, err := storage.GetRuleStorage()
+	if err != nil {
+		return types.Rule{}, err
+	}
+	previous, err := stor.Find(id)
+	if err != nil {
+		return types.Rule{}, err
+	}
+	if previous.Removed {
+		return types.Rule{}, storage.ErrRuleNotFound
+	}
+	r := previous
+	changed := update.Apply(&r)
+	if len(changed) == 0 {
+		return r, nil
+	}
+	err = validateRule(&r)
+	if err != nil {
+		return types.Rule{}, err
+	}
+	if update.ExpiresAt != nil {
+		err = r.ValidateExpiration(time.Now())
+		if err != nil {
+			return types.Rule{}, err
+		}
+	}
+	err = stor.Save([]*types.Rule{&r}, true)
+	if err != nil {
+		return types.Rule{}, err
+	}
+	err = RecordChange(previous, changed, user)
+	if err != nil {
+		return types.Rule{}, err
+	}
+	return r, nil
+}
+
+func (s *ruleServiceImpl) FindHistory(id string) ([]types.RuleChange, error) {
+	stor, err := storage.GetRuleHistoryStorage()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Find(id)
+}
+
+// RecordChange adds an entry to the history of previous with the values
+// replaced by the change.
+func RecordChange(previous types.Rule, changed []string, user string) error {
+	stor, err := storage.GetRuleHistoryStorage()
+	if err != nil {
+		return err
+	}
+	return stor.Add(&types.RuleChange{
+		RuleID:              previous.RuleID,
+		Time:                time.Now().UTC(),
+		User:                user,
+		ChangedFields:       changed,
+		PreviousSource:      previous.Source,
+		PreviousDestination: previous.Destination,
+		PreviousExpiresAt:   previous.ExpiresAt,
+	})
+}
+
+func (s *ruleServiceImpl) Watch(opts storage.FindOpts, revision string) (storage.RuleWatch, error) {
+	stor, err := storage.GetRuleStorage()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Watch(opts, revision)
+}
+
 func (s *ruleServiceImpl) FindSyncs(ruleIDFilter []string) ([]types.RuleSyncInfo, error) {
 	stor, err := storage.GetSyncStorage()
 	if err != nil {
@@ -212,6 +373,24 @@ func (s *ruleServiceImpl) FindSyncs(ruleIDFilter []string) ([]types.RuleSyncInfo
 	return syncs, nil
 }
 
+// FindSyncPage returns the syncs matching opts, at most opts.Limit of them,
+// along with the cursor of the next page, nil on the last one.
+func (s *ruleServiceImpl) FindSyncPage(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, *storage.Cursor, error) {
+	stor, err := storage.GetSyncStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	syncs, err := stor.Find(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var next *storage.Cursor
+	if opts.Limit > 0 && len(syncs) == opts.Limit {
+		next = storage.SyncCursor(syncs[len(syncs)-1])
+	}
+	return syncs, next, nil
+}
+
 var lockUpdaterInterval = 20 * time.Second
 
 type lockUpdater struct {
@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
 	_ "github.com/tsuru/acl-api/storage/mongodb"
 )
 
@@ -232,6 +233,7 @@ func Test_RuleService_Delete(t *testing.T) {
 		rules, err := svc.FindAll()
 		require.Nil(t, err)
 		require.Len(t, rules, 1)
+		require.NotNil(t, rules[0].RemovedAt)
 		assert.Equal(t, []types.Rule{{
 			Removed: true,
 			RuleID:  "1",
@@ -247,8 +249,9 @@ func Test_RuleService_Delete(t *testing.T) {
 					Ports: []types.ProtoPort{},
 				},
 			},
-			Metadata: map[string]string{},
-			Created:  rules[0].Created,
+			Metadata:  map[string]string{},
+			Created:   rules[0].Created,
+			RemovedAt: rules[0].RemovedAt,
 		}}, rules)
 	})
 	t.Run("not found", func(t *testing.T) {
@@ -291,6 +294,7 @@ func Test_RuleService_DeleteMetadata(t *testing.T) {
 		rules, err := svc.FindAll()
 		require.Nil(t, err)
 		require.Len(t, rules, 1)
+		require.NotNil(t, rules[0].RemovedAt)
 		assert.Equal(t, []types.Rule{{
 			Removed: true,
 			RuleID:  "1",
@@ -309,7 +313,8 @@ func Test_RuleService_DeleteMetadata(t *testing.T) {
 			Metadata: map[string]string{
 				"x": "y",
 			},
-			Created: rules[0].Created,
+			Created:   rules[0].Created,
+			RemovedAt: rules[0].RemovedAt,
 		}}, rules)
 	})
 	t.Run("not found", func(t *testing.T) {
@@ -825,3 +830,125 @@ func Test_RuleService_FindByRule(t *testing.T) {
 		require.Len(t, rules, 0)
 	})
 }
+
+func Test_RuleService_DeleteExpired(t *testing.T) {
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+	newRule := func(id string, expiresAt *time.Time) *types.Rule {
+		return &types.Rule{
+			RuleID: id,
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+			},
+			Destination: types.RuleType{
+				ExternalDNS: &types.ExternalDNSRule{Name: "x.com"},
+			},
+			ExpiresAt: expiresAt,
+		}
+	}
+	svc := GetService()
+	err = svc.Save([]*types.Rule{
+		newRule("1", &past),
+		newRule("2", &future),
+		newRule("3", nil),
+		newRule("4", &past),
+	}, false)
+	require.Nil(t, err)
+	err = svc.Delete("4")
+	require.Nil(t, err)
+
+	expired, err := svc.DeleteExpired(now)
+	require.Nil(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "1", expired[0].RuleID)
+	assert.True(t, expired[0].Removed)
+
+	rules, err := svc.FindAll()
+	require.Nil(t, err)
+	removed := map[string]bool{}
+	for _, r := range rules {
+		removed[r.RuleID] = r.Removed
+	}
+	assert.Equal(t, map[string]bool{"1": true, "2": false, "3": false, "4": true}, removed)
+
+	expired, err = svc.DeleteExpired(now)
+	require.Nil(t, err)
+	assert.Len(t, expired, 0)
+}
+
+func Test_RuleService_Update(t *testing.T) {
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	clearer := stor.(interface {
+		ClearAll()
+	})
+	clearer.ClearAll()
+	svc := GetService()
+	r := types.Rule{
+		RuleID: "r1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		Destination: types.RuleType{
+			ExternalDNS: &types.ExternalDNSRule{
+				Name:  "x.com",
+				Ports: types.ProtoPorts{{Protocol: "tcp", Port: 80}},
+			},
+		},
+	}
+	err = svc.Save([]*types.Rule{&r}, false)
+	require.Nil(t, err)
+
+	newDest := types.RuleType{
+		ExternalDNS: &types.ExternalDNSRule{
+			Name:  "x.com",
+			Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+		},
+	}
+	updated, err := svc.Update("r1", types.RuleUpdate{Destination: &newDest}, "me@example.com")
+	require.Nil(t, err)
+	assert.Equal(t, "r1", updated.RuleID)
+	assert.Equal(t, newDest, updated.Destination)
+
+	dbRule, err := svc.FindByID("r1")
+	require.Nil(t, err)
+	assert.Equal(t, newDest, dbRule.Destination)
+	assert.Equal(t, r.Source, dbRule.Source)
+
+	_, err = svc.Update("r1", types.RuleUpdate{Destination: &newDest}, "other@example.com")
+	require.Nil(t, err)
+
+	history, err := svc.FindHistory("r1")
+	require.Nil(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 1, history[0].Version)
+	assert.Equal(t, "me@example.com", history[0].User)
+	assert.Equal(t, []string{"Destination"}, history[0].ChangedFields)
+	assert.Equal(t, r.Destination, history[0].PreviousDestination)
+	assert.Equal(t, r.Source, history[0].PreviousSource)
+
+	invalid := types.RuleType{ExternalDNS: &types.ExternalDNSRule{}}
+	_, err = svc.Update("r1", types.RuleUpdate{Destination: &invalid}, "")
+	assert.Error(t, err)
+	past := time.Now().Add(-time.Hour)
+	_, err = svc.Update("r1", types.RuleUpdate{ExpiresAt: &past}, "")
+	assert.EqualError(t, err, "ExpiresAt must be in the future")
+
+	_, err = svc.Update("r2", types.RuleUpdate{Destination: &newDest}, "")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	err = svc.Delete("r1")
+	require.Nil(t, err)
+	_, err = svc.Update("r1", types.RuleUpdate{Destination: &r.Destination}, "")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+
+	history, err = svc.FindHistory("r1")
+	require.Nil(t, err)
+	assert.Len(t, history, 1)
+}
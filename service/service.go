@@ -7,6 +7,7 @@ package service
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/rule"
@@ -25,11 +26,13 @@ type Service interface {
 	Find(instanceName string) (types.ServiceInstance, error)
 	Delete(instanceName string) error
 	AddRule(instanceName string, r *types.ServiceRule) ([]types.Rule, error)
+	UpdateRule(instanceName string, ruleID string, update types.RuleUpdate, user string) ([]types.Rule, error)
 	RemoveRule(instanceName string, ruleID string) error
 	AddApp(instanceName string, appName string) ([]types.Rule, error)
 	RemoveApp(instanceName string, appName string) error
 	AddJob(instanceName string, appName string) ([]types.Rule, error)
 	RemoveJob(instanceName string, appName string) error
+	SetMetadata(instanceName string, metadata map[string]string) error
 }
 
 type serviceImpl struct{}
@@ -79,6 +82,11 @@ func (s *serviceImpl) AddRule(instanceName string, r *types.ServiceRule) ([]type
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
+	err = r.ValidateExpiration(now)
+	if err != nil {
+		return nil, err
+	}
 	stor, err := storage.GetServiceStorage()
 	if err != nil {
 		return nil, err
@@ -90,7 +98,7 @@ func (s *serviceImpl) AddRule(instanceName string, r *types.ServiceRule) ([]type
 	}
 
 	for _, baseRule := range service.BaseRules {
-		if baseRule.Removed {
+		if baseRule.Removed || baseRule.Expired(now) {
 			continue
 		}
 
@@ -106,6 +114,69 @@ func (s *serviceImpl) AddRule(instanceName string, r *types.ServiceRule) ([]type
 	return syncRules(instanceName)
 }
 
+// UpdateRule changes the destination or expiration of a base rule and
+// re-expands the rules derived from it. The source of base rules is always
+// the bound apps and jobs, so update.Source is ignored.
+func (s *serviceImpl) UpdateRule(instanceName string, ruleID string, update types.RuleUpdate, user string) ([]types.Rule, error) {
+	stor, err := storage.GetServiceStorage()
+	if err != nil {
+		return nil, err
+	}
+	service, err := stor.Find(instanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *types.ServiceRule
+	for i := range service.BaseRules {
+		if service.BaseRules[i].RuleID == ruleID && !service.BaseRules[i].Removed {
+			previous = &service.BaseRules[i]
+			break
+		}
+	}
+	if previous == nil {
+		return nil, storage.ErrRuleNotFound
+	}
+
+	update.Source = nil
+	r := *previous
+	changed := update.Apply(&r.Rule)
+	if len(changed) == 0 {
+		return syncRules(instanceName)
+	}
+	err = r.Destination.Validate()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if update.ExpiresAt != nil {
+		err = r.ValidateExpiration(now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, baseRule := range service.BaseRules {
+		if baseRule.RuleID == ruleID || baseRule.Removed || baseRule.Expired(now) {
+			continue
+		}
+
+		if baseRule.Equals(&r) {
+			return nil, ErrRuleAlreadyExists
+		}
+	}
+
+	err = stor.UpdateRule(instanceName, r)
+	if err != nil {
+		return nil, err
+	}
+	err = rule.RecordChange(previous.Rule, changed, user)
+	if err != nil {
+		return nil, err
+	}
+	return syncRules(instanceName)
+}
+
 func ruleMetadata(baseID, instanceName string) map[string]string {
 	return map[string]string{
 		"owner":         OwnerAclFromHell,
@@ -200,6 +271,14 @@ func (s *serviceImpl) RemoveJob(instanceName string, jobName string) error {
 	return stor.RemoveJob(instanceName, jobName)
 }
 
+func (s *serviceImpl) SetMetadata(instanceName string, metadata map[string]string) error {
+	stor, err := storage.GetServiceStorage()
+	if err != nil {
+		return err
+	}
+	return stor.SetMetadata(instanceName, metadata)
+}
+
 var GetService = func() Service {
 	return &serviceImpl{}
 }
@@ -214,7 +293,13 @@ func expandRules(instanceName string) ([]*types.Rule, error) {
 		return nil, err
 	}
 	var allRules []*types.Rule
+	now := time.Now()
 	for _, r := range instance.BaseRules {
+		if r.Expired(now) {
+			// derived rules are removed by the expiration job and must
+			// not be saved again
+			continue
+		}
 		baseID := r.RuleID
 		for _, appName := range instance.BindApps {
 			appRule := r
@@ -14,6 +14,7 @@ import (
 	"github.com/tsuru/acl-api/api/types"
 	"github.com/tsuru/acl-api/rule"
 	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
 	_ "github.com/tsuru/acl-api/storage/mongodb"
 )
 
@@ -538,3 +539,130 @@ func compareRules(t *testing.T, expected, got []types.Rule) {
 	}
 	assert.Equal(t, expected, got)
 }
+
+func Test_Service_AddAppExpiredRule(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	svc := GetService()
+	err = svc.Create(types.ServiceInstance{InstanceName: "x"})
+	require.Nil(t, err)
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	_, err = svc.AddRule("x", &types.ServiceRule{
+		Rule: types.Rule{
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app2"},
+			},
+			ExpiresAt: &expiresAt,
+		},
+	})
+	require.Nil(t, err)
+	syncedRules, err := svc.AddApp("x", "app1")
+	require.Nil(t, err)
+	require.Len(t, syncedRules, 1)
+	require.NotNil(t, syncedRules[0].ExpiresAt)
+	assert.True(t, expiresAt.Equal(*syncedRules[0].ExpiresAt))
+
+	// expire the base rule in storage, binding another app must not expand it
+	instance, err := stor.Find("x")
+	require.Nil(t, err)
+	baseRule := instance.BaseRules[0]
+	past := time.Now().UTC().Add(-time.Minute)
+	baseRule.ExpiresAt = &past
+	err = stor.RemoveRule("x", baseRule.RuleID)
+	require.Nil(t, err)
+	err = stor.AddRule("x", &baseRule)
+	require.Nil(t, err)
+	syncedRules, err = svc.AddApp("x", "app3")
+	require.Nil(t, err)
+	assert.Len(t, syncedRules, 0)
+
+	_, err = svc.AddRule("x", &types.ServiceRule{
+		Rule: types.Rule{
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app2"},
+			},
+		},
+	})
+	assert.Nil(t, err, "expired rules must not conflict with new ones")
+}
+
+func Test_Service_UpdateRule(t *testing.T) {
+	stor, err := storage.GetServiceStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	svc := GetService()
+	err = svc.Create(types.ServiceInstance{InstanceName: "x"})
+	require.Nil(t, err)
+	baseRule := &types.ServiceRule{
+		Rule: types.Rule{
+			Destination: types.RuleType{
+				ExternalDNS: &types.ExternalDNSRule{
+					Name:  "x.com",
+					Ports: types.ProtoPorts{{Protocol: "tcp", Port: 80}},
+				},
+			},
+		},
+	}
+	_, err = svc.AddRule("x", baseRule)
+	require.Nil(t, err)
+	otherRule := &types.ServiceRule{
+		Rule: types.Rule{
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app2"},
+			},
+		},
+	}
+	_, err = svc.AddRule("x", otherRule)
+	require.Nil(t, err)
+	syncedRules, err := svc.AddApp("x", "app1")
+	require.Nil(t, err)
+	require.Len(t, syncedRules, 2)
+
+	newDest := types.RuleType{
+		ExternalDNS: &types.ExternalDNSRule{
+			Name:  "x.com",
+			Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+		},
+	}
+	syncedRules, err = svc.UpdateRule("x", baseRule.RuleID, types.RuleUpdate{
+		Source:      &types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "ignored"}},
+		Destination: &newDest,
+	}, "me@example.com")
+	require.Nil(t, err)
+	require.Len(t, syncedRules, 2)
+	assert.Equal(t, baseRule.RuleID+"-app1", syncedRules[0].RuleID)
+	assert.Equal(t, newDest, syncedRules[0].Destination)
+
+	ruleSvc := rule.GetService()
+	derived, err := ruleSvc.FindByID(baseRule.RuleID + "-app1")
+	require.Nil(t, err)
+	assert.Equal(t, newDest, derived.Destination)
+	assert.Equal(t, types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}, derived.Source)
+
+	instance, err := svc.Find("x")
+	require.Nil(t, err)
+	assert.Equal(t, newDest, instance.BaseRules[0].Destination)
+	assert.Nil(t, instance.BaseRules[0].Source.TsuruApp)
+
+	history, err := ruleSvc.FindHistory(baseRule.RuleID)
+	require.Nil(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "me@example.com", history[0].User)
+	assert.Equal(t, []string{"Destination"}, history[0].ChangedFields)
+	assert.Equal(t, baseRule.Destination, history[0].PreviousDestination)
+
+	_, err = svc.UpdateRule("x", baseRule.RuleID, types.RuleUpdate{Destination: &otherRule.Destination}, "")
+	assert.Equal(t, ErrRuleAlreadyExists, err)
+	invalid := types.RuleType{ExternalDNS: &types.ExternalDNSRule{}}
+	_, err = svc.UpdateRule("x", baseRule.RuleID, types.RuleUpdate{Destination: &invalid}, "")
+	assert.Error(t, err)
+	_, err = svc.UpdateRule("x", "unknown", types.RuleUpdate{Destination: &newDest}, "")
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	_, err = svc.UpdateRule("y", baseRule.RuleID, types.RuleUpdate{Destination: &newDest}, "")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+}
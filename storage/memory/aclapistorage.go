@@ -0,0 +1,125 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.ACLAPIStorage = &aclapiStorage{}
+
+type aclapiStorage struct {
+	*memoryStorage
+}
+
+type networkLock struct {
+	owner      string
+	expireTime time.Time
+}
+
+func copySyncedRule(r storage.ACLAPISyncedRule) storage.ACLAPISyncedRule {
+	ret := r
+	ret.ACLIds = append([]storage.ACLIdPair{}, r.ACLIds...)
+	return ret
+}
+
+func (s *aclapiStorage) Find(ruleID string) (storage.ACLAPISyncedRule, error) {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.aclapi[ruleID]
+	if !ok {
+		return storage.ACLAPISyncedRule{}, storage.ErrACLAPISyncedRuleNotFound
+	}
+	return copySyncedRule(r), nil
+}
+
+func (s *aclapiStorage) Add(ruleID string, aclIDs []storage.ACLIdPair) error {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.aclapi[ruleID]
+	if !ok {
+		r = storage.ACLAPISyncedRule{RuleID: ruleID}
+	}
+	for _, id := range aclIDs {
+		if !containsPair(r.ACLIds, id) {
+			r.ACLIds = append(r.ACLIds, id)
+		}
+	}
+	s.aclapi[ruleID] = r
+	return nil
+}
+
+func (s *aclapiStorage) Remove(ruleID string, aclIDs []storage.ACLIdPair) error {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.aclapi[ruleID]
+	if !ok {
+		return nil
+	}
+	var remaining []storage.ACLIdPair
+	for _, id := range r.ACLIds {
+		if !containsPair(aclIDs, id) {
+			remaining = append(remaining, id)
+		}
+	}
+	r.ACLIds = remaining
+	s.aclapi[ruleID] = r
+	return nil
+}
+
+func (s *aclapiStorage) FindByACLId(aclID storage.ACLIdPair) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	var ruleIDs []string
+	for ruleID, r := range s.aclapi {
+		if containsPair(r.ACLIds, aclID) {
+			ruleIDs = append(ruleIDs, ruleID)
+		}
+	}
+	sort.Strings(ruleIDs)
+	return ruleIDs, nil
+}
+
+func containsPair(pairs []storage.ACLIdPair, pair storage.ACLIdPair) bool {
+	for _, p := range pairs {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *aclapiStorage) Purge(ruleIDs []string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, id := range ruleIDs {
+		delete(s.aclapi, id)
+	}
+	return nil
+}
+
+func (s *aclapiStorage) LockNetwork(network, owner string, expire time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	l, ok := s.networkLocks[network]
+	if ok && l.owner != owner && l.expireTime.After(now) {
+		return storage.ErrNetworkLocked
+	}
+	s.networkLocks[network] = networkLock{owner: owner, expireTime: now.Add(expire)}
+	return nil
+}
+
+func (s *aclapiStorage) UnlockNetwork(network, owner string) error {
+	s.Lock()
+	defer s.Unlock()
+	if l, ok := s.networkLocks[network]; ok && l.owner == owner {
+		delete(s.networkLocks, network)
+	}
+	return nil
+}
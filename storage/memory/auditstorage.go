@@ -0,0 +1,56 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"sort"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.AuditStorage = &auditStorage{}
+
+type auditStorage struct {
+	*memoryStorage
+}
+
+func (s *auditStorage) Add(entry *types.AuditEntry) error {
+	s.Lock()
+	defer s.Unlock()
+	entry.ID = newID()
+	var stored types.AuditEntry
+	deepCopy(&stored, entry)
+	s.audit = append(s.audit, stored)
+	return nil
+}
+
+func (s *auditStorage) Find(opts storage.AuditFindOpts) ([]types.AuditEntry, error) {
+	s.Lock()
+	defer s.Unlock()
+	entries := []types.AuditEntry{}
+	for _, entry := range s.audit {
+		if (opts.Actor != "" && entry.Actor != opts.Actor) ||
+			(opts.RuleID != "" && entry.RuleID != opts.RuleID) ||
+			(opts.Instance != "" && entry.Instance != opts.Instance) ||
+			(!opts.Since.IsZero() && entry.Time.Before(opts.Since)) ||
+			(!opts.Until.IsZero() && !entry.Time.Before(opts.Until)) {
+			continue
+		}
+		var e types.AuditEntry
+		deepCopy(&e, entry)
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.After(entries[j].Time)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+	return entries, nil
+}
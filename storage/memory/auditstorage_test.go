@@ -0,0 +1,30 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/storage/storagetest"
+)
+
+func TestAuditStorageSuite(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "memory://acltest-pkg-storage")
+	stor, err := storage.GetAuditStorage()
+	require.Nil(t, err)
+	suite.Run(t, &storagetest.AuditStorageSuite{
+		Stor: stor,
+		SetupTestFunc: func() {
+			stor.(interface {
+				ClearAll()
+			}).ClearAll()
+		},
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package memory implements every storage interface in process memory. It is
+// selected with a storage address using the memory:// scheme and is meant for
+// tests and local development, data is lost when the process exits.
+package memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const scheme = "memory://"
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*memoryStorage{}
+
+	idCounter uint32
+)
+
+func init() {
+	nextRuleStorage := storage.GetRuleStorage
+	storage.GetRuleStorage = func() (storage.RuleStorage, error) {
+		if !isMemoryStorage() {
+			return nextRuleStorage()
+		}
+		return &ruleStorage{getStore()}, nil
+	}
+
+	nextServiceStorage := storage.GetServiceStorage
+	storage.GetServiceStorage = func() (storage.ServiceStorage, error) {
+		if !isMemoryStorage() {
+			return nextServiceStorage()
+		}
+		return &serviceStorage{getStore()}, nil
+	}
+
+	nextSyncStorage := storage.GetSyncStorage
+	storage.GetSyncStorage = func() (storage.SyncStorage, error) {
+		if !isMemoryStorage() {
+			return nextSyncStorage()
+		}
+		return &syncStorage{getStore()}, nil
+	}
+
+	nextRuleHistoryStorage := storage.GetRuleHistoryStorage
+	storage.GetRuleHistoryStorage = func() (storage.RuleHistoryStorage, error) {
+		if !isMemoryStorage() {
+			return nextRuleHistoryStorage()
+		}
+		return &ruleHistoryStorage{getStore()}, nil
+	}
+
+	nextAuditStorage := storage.GetAuditStorage
+	storage.GetAuditStorage = func() (storage.AuditStorage, error) {
+		if !isMemoryStorage() {
+			return nextAuditStorage()
+		}
+		return &auditStorage{getStore()}, nil
+	}
+
+	nextWebhookDeliveryStorage := storage.GetWebhookDeliveryStorage
+	storage.GetWebhookDeliveryStorage = func() (storage.WebhookDeliveryStorage, error) {
+		if !isMemoryStorage() {
+			return nextWebhookDeliveryStorage()
+		}
+		return &webhookDeliveryStorage{getStore()}, nil
+	}
+
+	nextACLAPIStorage := storage.GetACLAPIStorage
+	storage.GetACLAPIStorage = func() (storage.ACLAPIStorage, error) {
+		if !isMemoryStorage() {
+			return nextACLAPIStorage()
+		}
+		return &aclapiStorage{getStore()}, nil
+	}
+
+	nextDNSStorage := storage.GetDNSStorage
+	storage.GetDNSStorage = func() (storage.DNSStorage, error) {
+		if !isMemoryStorage() {
+			return nextDNSStorage()
+		}
+		return &dnsStorage{getStore()}, nil
+	}
+
+	nextSyncJobStorage := storage.GetSyncJobStorage
+	storage.GetSyncJobStorage = func() (storage.SyncJobStorage, error) {
+		if !isMemoryStorage() {
+			return nextSyncJobStorage()
+		}
+		return &syncJobStorage{getStore()}, nil
+	}
+}
+
+func isMemoryStorage() bool {
+	return strings.HasPrefix(viper.GetString("storage"), scheme)
+}
+
+// getStore returns the store for the configured address, each distinct
+// address behaves as an isolated database.
+func getStore() *memoryStorage {
+	addr := viper.GetString("storage")
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	stor, ok := stores[addr]
+	if !ok {
+		stor = newMemoryStorage()
+		stores[addr] = stor
+	}
+	return stor
+}
+
+// newID returns a unique hex identifier, identifiers generated later sort
+// after the ones generated before.
+func newID() string {
+	return fmt.Sprintf("%016x%08x", time.Now().UnixNano(), atomic.AddUint32(&idCounter, 1))
+}
+
+type memoryStorage struct {
+	sync.Mutex
+	rules          map[string]types.Rule
+	services       map[string]types.ServiceInstance
+	serviceNames   []string
+	syncs          map[string]*types.RuleSyncInfo
+	aclapi         map[string]storage.ACLAPISyncedRule
+	networkLocks   map[string]networkLock
+	dns            map[string][]storage.StoredIP
+	syncJobs       map[string]types.SyncJob
+	history        map[string][]types.RuleChange
+	audit          []types.AuditEntry
+	deliveries     []types.WebhookDelivery
+	lockExpireTime time.Duration
+
+	// revision is never reset so cursors from before ClearAll are reported
+	// as expired instead of being reused.
+	revision int64
+	events   []ruleEvent
+	changed  chan struct{}
+}
+
+func newMemoryStorage() *memoryStorage {
+	s := &memoryStorage{
+		lockExpireTime: 5 * time.Minute,
+		changed:        make(chan struct{}),
+	}
+	s.reset()
+	return s
+}
+
+func (s *memoryStorage) reset() {
+	s.rules = map[string]types.Rule{}
+	s.services = map[string]types.ServiceInstance{}
+	s.serviceNames = nil
+	s.syncs = map[string]*types.RuleSyncInfo{}
+	s.aclapi = map[string]storage.ACLAPISyncedRule{}
+	s.networkLocks = map[string]networkLock{}
+	s.dns = map[string][]storage.StoredIP{}
+	s.syncJobs = map[string]types.SyncJob{}
+	s.history = map[string][]types.RuleChange{}
+	s.audit = nil
+	s.deliveries = nil
+	s.events = nil
+}
+
+// ClearAll will remove all stored data and must only be used in tests
+func (s *memoryStorage) ClearAll() {
+	s.Lock()
+	defer s.Unlock()
+	s.reset()
+}
+
+// deepCopy copies src into dst so callers never share memory with the
+// stored values.
+func deepCopy(dst, src interface{}) {
+	data, err := json.Marshal(src)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(data, dst)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func copyRule(r types.Rule) types.Rule {
+	var ret types.Rule
+	deepCopy(&ret, r)
+	storage.NormalizeRule(&ret)
+	return ret
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+func TestGetStorageIsolatedByAddress(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "memory://db1")
+	stor1, err := storage.GetServiceStorage()
+	require.NoError(t, err)
+	stor1.(interface {
+		ClearAll()
+	}).ClearAll()
+	viper.Set("storage", "memory://db2")
+	stor2, err := storage.GetServiceStorage()
+	require.NoError(t, err)
+	stor2.(interface {
+		ClearAll()
+	}).ClearAll()
+	err = stor1.Create(types.ServiceInstance{InstanceName: "inst1"})
+	require.NoError(t, err)
+	_, err = stor2.Find("inst1")
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+	_, err = stor1.Find("inst1")
+	assert.NoError(t, err)
+}
+
+func TestGetStorageNotMemory(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "other://localhost")
+	_, err := storage.GetRuleStorage()
+	assert.Error(t, err)
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"net"
+
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.DNSStorage = &dnsStorage{}
+
+type dnsStorage struct {
+	*memoryStorage
+}
+
+func copyStoredIPs(ips []storage.StoredIP) []storage.StoredIP {
+	var ret []storage.StoredIP
+	for _, ip := range ips {
+		ret = append(ret, storage.StoredIP{
+			IP:         append(net.IP{}, ip.IP...),
+			ValidUntil: ip.ValidUntil,
+		})
+	}
+	return ret
+}
+
+func (s *dnsStorage) Find(name string) ([]storage.StoredIP, error) {
+	s.Lock()
+	defer s.Unlock()
+	return copyStoredIPs(s.dns[name]), nil
+}
+
+func (s *dnsStorage) Save(name string, ips []storage.StoredIP) error {
+	s.Lock()
+	defer s.Unlock()
+	s.dns[name] = copyStoredIPs(ips)
+	return nil
+}
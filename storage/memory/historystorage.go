@@ -0,0 +1,38 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.RuleHistoryStorage = &ruleHistoryStorage{}
+
+type ruleHistoryStorage struct {
+	*memoryStorage
+}
+
+func (s *ruleHistoryStorage) Add(change *types.RuleChange) error {
+	s.Lock()
+	defer s.Unlock()
+	change.Version = len(s.history[change.RuleID]) + 1
+	var stored types.RuleChange
+	deepCopy(&stored, change)
+	s.history[change.RuleID] = append(s.history[change.RuleID], stored)
+	return nil
+}
+
+func (s *ruleHistoryStorage) Find(ruleID string) ([]types.RuleChange, error) {
+	s.Lock()
+	defer s.Unlock()
+	changes := []types.RuleChange{}
+	for _, change := range s.history[ruleID] {
+		var c types.RuleChange
+		deepCopy(&c, change)
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
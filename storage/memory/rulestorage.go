@@ -0,0 +1,138 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var (
+	_ storage.RuleStorage    = &ruleStorage{}
+	_ storage.ServiceStorage = &serviceStorage{}
+)
+
+type ruleStorage struct {
+	*memoryStorage
+}
+
+func (s *ruleStorage) Find(id string) (types.Rule, error) {
+	s.Lock()
+	defer s.Unlock()
+	if r, ok := s.rules[id]; ok {
+		return copyRule(r), nil
+	}
+	for _, r := range s.rules {
+		if r.RuleName != "" && r.RuleName == id {
+			return copyRule(r), nil
+		}
+	}
+	return types.Rule{}, storage.ErrRuleNotFound
+}
+
+// nameInUse must be called with the lock held.
+func (s *ruleStorage) nameInUse(r *types.Rule) bool {
+	if r.RuleName == "" {
+		return false
+	}
+	for _, existing := range s.rules {
+		if existing.RuleID != r.RuleID && existing.RuleName == r.RuleName {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ruleStorage) Save(rules []*types.Rule, upsert bool) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now().UTC()
+	for _, r := range rules {
+		if r.RuleID == "" {
+			r.RuleID = newID()
+		}
+		r.Created = now
+	}
+	seen := map[string]struct{}{}
+	for _, r := range rules {
+		if _, ok := s.rules[r.RuleID]; ok && !upsert {
+			return storage.ErrInstanceAlreadyExists
+		}
+		if _, ok := seen[r.RuleID]; ok && !upsert {
+			return storage.ErrInstanceAlreadyExists
+		}
+		seen[r.RuleID] = struct{}{}
+		if s.nameInUse(r) {
+			return storage.ErrInstanceAlreadyExists
+		}
+	}
+	for _, r := range rules {
+		eventType := types.RuleEventUpdated
+		if _, ok := s.rules[r.RuleID]; !ok {
+			eventType = types.RuleEventCreated
+		} else if r.Removed {
+			eventType = types.RuleEventRemoved
+		}
+		s.rules[r.RuleID] = copyRule(*r)
+		s.addRuleEvent(eventType, *r)
+	}
+	return nil
+}
+
+func (s *ruleStorage) FindAll(opts storage.FindOpts) ([]types.Rule, error) {
+	s.Lock()
+	defer s.Unlock()
+	rules := []types.Rule{}
+	for _, r := range s.rules {
+		if opts.Matches(r) {
+			rules = append(rules, copyRule(r))
+		}
+	}
+	storage.SortRules(rules, opts.Sort)
+	if opts.Limit > 0 && len(rules) > opts.Limit {
+		rules = rules[:opts.Limit]
+	}
+	return rules, nil
+}
+
+func (s *ruleStorage) Delete(opts storage.DeleteOpts) error {
+	s.Lock()
+	defer s.Unlock()
+	modified := 0
+	now := time.Now().UTC()
+	for id, r := range s.rules {
+		if opts.ID != "" && id != opts.ID {
+			continue
+		}
+		if !(storage.FindOpts{Metadata: opts.Metadata}).Matches(r) {
+			continue
+		}
+		if r.Removed {
+			continue
+		}
+		r.Removed = true
+		r.RemovedAt = &now
+		s.rules[id] = r
+		s.addRuleEvent(types.RuleEventRemoved, r)
+		modified++
+	}
+	if modified == 0 {
+		return storage.ErrRuleNotFound
+	}
+	return nil
+}
+
+func (s *ruleStorage) Purge(ids []string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, id := range ids {
+		if r, ok := s.rules[id]; ok && r.Removed {
+			delete(s.rules, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+// maxEvents is the number of rule events kept for watches to resume from.
+const maxEvents = 1000
+
+var _ storage.RuleWatch = &ruleWatch{}
+
+type ruleEvent struct {
+	revision int64
+	event    types.RuleEvent
+}
+
+// addRuleEvent must be called with the lock held.
+func (s *memoryStorage) addRuleEvent(eventType types.RuleEventType, r types.Rule) {
+	s.revision++
+	s.events = append(s.events, ruleEvent{
+		revision: s.revision,
+		event: types.RuleEvent{
+			Revision: strconv.FormatInt(s.revision, 10),
+			Type:     eventType,
+			Rule:     copyRule(r),
+		},
+	})
+	if len(s.events) > maxEvents {
+		s.events = s.events[len(s.events)-maxEvents:]
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// expired reports whether events after revision were discarded, it must be
+// called with the lock held.
+func (s *memoryStorage) expired(revision int64) bool {
+	if len(s.events) == 0 {
+		return revision < s.revision
+	}
+	return revision < s.events[0].revision-1
+}
+
+func (s *ruleStorage) Watch(opts storage.FindOpts, revision string) (storage.RuleWatch, error) {
+	s.Lock()
+	defer s.Unlock()
+	w := &ruleWatch{memoryStorage: s.memoryStorage, opts: opts, revision: s.revision}
+	if revision == "" {
+		return w, nil
+	}
+	rev, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil || rev < 0 || rev > s.revision {
+		return nil, storage.ErrInvalidRevision
+	}
+	if s.expired(rev) {
+		return nil, storage.ErrRevisionExpired
+	}
+	w.revision = rev
+	return w, nil
+}
+
+type ruleWatch struct {
+	*memoryStorage
+	opts     storage.FindOpts
+	revision int64
+}
+
+func (w *ruleWatch) Next(ctx context.Context) (types.RuleEvent, error) {
+	for {
+		w.Lock()
+		if w.expired(w.revision) {
+			w.Unlock()
+			return types.RuleEvent{}, storage.ErrRevisionExpired
+		}
+		for _, e := range w.events {
+			if e.revision <= w.revision {
+				continue
+			}
+			w.revision = e.revision
+			if w.opts.Matches(e.event.Rule) {
+				w.Unlock()
+				e.event.Rule = copyRule(e.event.Rule)
+				return e.event, nil
+			}
+		}
+		changed := w.changed
+		w.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return types.RuleEvent{}, ctx.Err()
+		}
+	}
+}
+
+func (w *ruleWatch) Revision() string {
+	w.Lock()
+	defer w.Unlock()
+	return strconv.FormatInt(w.revision, 10)
+}
+
+func (w *ruleWatch) Close() error {
+	return nil
+}
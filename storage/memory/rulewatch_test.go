@@ -0,0 +1,56 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/storage/storagetest"
+)
+
+func TestRuleWatchSuite(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "memory://acltest-pkg-storage")
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	suite.Run(t, &storagetest.RuleWatchSuite{
+		Stor: stor,
+		SetupTestFunc: func() {
+			stor.(interface {
+				ClearAll()
+			}).ClearAll()
+		},
+	})
+}
+
+func TestRuleWatchExpired(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "memory://acltest-watch-expired")
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	w, err := stor.Watch(storage.FindOpts{}, "")
+	require.Nil(t, err)
+	start := w.Revision()
+	for i := 0; i <= maxEvents; i++ {
+		r := types.Rule{
+			RuleID:      fmt.Sprintf("r%d", i),
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "x.com"}},
+		}
+		err = stor.Save([]*types.Rule{&r}, false)
+		require.Nil(t, err)
+	}
+	_, err = w.Next(context.Background())
+	assert.Equal(t, storage.ErrRevisionExpired, err)
+	_, err = stor.Watch(storage.FindOpts{}, start)
+	assert.Equal(t, storage.ErrRevisionExpired, err)
+}
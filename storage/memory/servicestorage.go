@@ -0,0 +1,171 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type serviceStorage struct {
+	*memoryStorage
+}
+
+func copyInstance(instance types.ServiceInstance) types.ServiceInstance {
+	var ret types.ServiceInstance
+	deepCopy(&ret, instance)
+	storage.NormalizeServiceInstance(&ret)
+	return ret
+}
+
+// update applies fn to the stored instance, it must be called without the
+// lock held.
+func (s *serviceStorage) update(instanceName string, fn func(instance *types.ServiceInstance)) error {
+	s.Lock()
+	defer s.Unlock()
+	instance, ok := s.services[instanceName]
+	if !ok {
+		return storage.ErrInstanceNotFound
+	}
+	fn(&instance)
+	s.services[instanceName] = copyInstance(instance)
+	return nil
+}
+
+func (s *serviceStorage) Create(instance types.ServiceInstance) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.services[instance.InstanceName]; ok {
+		return storage.ErrInstanceAlreadyExists
+	}
+	s.services[instance.InstanceName] = copyInstance(instance)
+	s.serviceNames = append(s.serviceNames, instance.InstanceName)
+	return nil
+}
+
+func (s *serviceStorage) Find(instanceName string) (types.ServiceInstance, error) {
+	s.Lock()
+	defer s.Unlock()
+	instance, ok := s.services[instanceName]
+	if !ok {
+		return types.ServiceInstance{}, storage.ErrInstanceNotFound
+	}
+	return copyInstance(instance), nil
+}
+
+func (s *serviceStorage) Delete(instanceName string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.services[instanceName]; !ok {
+		return storage.ErrInstanceNotFound
+	}
+	delete(s.services, instanceName)
+	for i, name := range s.serviceNames {
+		if name == instanceName {
+			s.serviceNames = append(s.serviceNames[:i], s.serviceNames[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *serviceStorage) AddRule(instanceName string, r *types.ServiceRule) error {
+	if r.RuleID == "" {
+		r.RuleID = newID()
+	}
+	r.Created = time.Now().UTC()
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BaseRules = append(instance.BaseRules, *r)
+	})
+}
+
+func (s *serviceStorage) UpdateRule(instanceName string, r types.ServiceRule) error {
+	found := false
+	err := s.update(instanceName, func(instance *types.ServiceInstance) {
+		for i := range instance.BaseRules {
+			if instance.BaseRules[i].RuleID == r.RuleID {
+				instance.BaseRules[i] = r
+				found = true
+			}
+		}
+	})
+	if err == nil && !found {
+		err = storage.ErrRuleNotFound
+	}
+	return err
+}
+
+func (s *serviceStorage) RemoveRule(instanceName string, ruleID string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		var rules []types.ServiceRule
+		for _, r := range instance.BaseRules {
+			if r.RuleID != ruleID {
+				rules = append(rules, r)
+			}
+		}
+		instance.BaseRules = rules
+	})
+}
+
+func addToSet(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func pull(values []string, value string) []string {
+	var ret []string
+	for _, v := range values {
+		if v != value {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (s *serviceStorage) SetMetadata(instanceName string, metadata map[string]string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.Metadata = metadata
+	})
+}
+
+func (s *serviceStorage) AddApp(instanceName string, appName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindApps = addToSet(instance.BindApps, appName)
+	})
+}
+
+func (s *serviceStorage) RemoveApp(instanceName string, appName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindApps = pull(instance.BindApps, appName)
+	})
+}
+
+func (s *serviceStorage) AddJob(instanceName string, jobName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindJobs = addToSet(instance.BindJobs, jobName)
+	})
+}
+
+func (s *serviceStorage) RemoveJob(instanceName string, jobName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindJobs = pull(instance.BindJobs, jobName)
+	})
+}
+
+func (s *serviceStorage) List() ([]types.ServiceInstance, error) {
+	s.Lock()
+	defer s.Unlock()
+	var ret []types.ServiceInstance
+	for _, name := range s.serviceNames {
+		ret = append(ret, copyInstance(s.services[name]))
+	}
+	return ret, nil
+}
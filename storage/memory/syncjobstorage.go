@@ -0,0 +1,69 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.SyncJobStorage = &syncJobStorage{}
+
+type syncJobStorage struct {
+	*memoryStorage
+}
+
+func copySyncJob(job types.SyncJob) types.SyncJob {
+	var ret types.SyncJob
+	deepCopy(&ret, job)
+	return ret
+}
+
+func (s *syncJobStorage) Create(job types.SyncJob) error {
+	s.Lock()
+	defer s.Unlock()
+	s.syncJobs[job.JobID] = copySyncJob(job)
+	return nil
+}
+
+func (s *syncJobStorage) Find(id string) (types.SyncJob, error) {
+	s.Lock()
+	defer s.Unlock()
+	job, ok := s.syncJobs[id]
+	if !ok {
+		return types.SyncJob{}, storage.ErrSyncJobNotFound
+	}
+	return copySyncJob(job), nil
+}
+
+func (s *syncJobStorage) UpdateTarget(jobID string, target types.SyncJobTarget) error {
+	s.Lock()
+	defer s.Unlock()
+	job, ok := s.syncJobs[jobID]
+	if !ok {
+		return storage.ErrSyncJobNotFound
+	}
+	for i, t := range job.Targets {
+		if t.RuleID == target.RuleID && t.Engine == target.Engine {
+			var updated types.SyncJobTarget
+			deepCopy(&updated, target)
+			job.Targets[i] = updated
+		}
+	}
+	return nil
+}
+
+func (s *syncJobStorage) Purge(before time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	for id, job := range s.syncJobs {
+		if job.Created.Before(before) {
+			delete(s.syncJobs, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const maxSyncsPerRule = 10
+
+type syncStorage struct {
+	*memoryStorage
+}
+
+var _ storage.SyncStorage = &syncStorage{}
+
+func syncKey(ruleID, engine string) string {
+	return ruleID + "\x00" + engine
+}
+
+func copySyncInfo(info *types.RuleSyncInfo) types.RuleSyncInfo {
+	ret := *info
+	if info.Syncs != nil {
+		ret.Syncs = make([]types.RuleSyncData, len(info.Syncs))
+		copy(ret.Syncs, info.Syncs)
+	}
+	if info.Drift != nil {
+		drift := copyDrift(*info.Drift)
+		ret.Drift = &drift
+	}
+	return ret
+}
+
+func copyDrift(drift types.RuleDrift) types.RuleDrift {
+	if drift.Differences != nil {
+		drift.Differences = append([]string{}, drift.Differences...)
+	}
+	return drift
+}
+
+func (s *syncStorage) SetLockExpireTime(timeout time.Duration) time.Duration {
+	s.Lock()
+	defer s.Unlock()
+	oldLockExpireTime := s.lockExpireTime
+	s.lockExpireTime = timeout
+	return oldLockExpireTime
+}
+
+func (s *syncStorage) StartSync(after time.Duration, ruleID, engine string, force bool) (time.Duration, *types.RuleSyncInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+	expireTime := s.lockExpireTime
+	if after > expireTime {
+		expireTime = after
+	}
+	now := time.Now().UTC()
+	next := after
+
+	info, ok := s.syncs[syncKey(ruleID, engine)]
+	if !ok {
+		info = &types.RuleSyncInfo{
+			SyncID: newID(),
+			RuleID: ruleID,
+			Engine: engine,
+		}
+		s.syncs[syncKey(ruleID, engine)] = info
+	} else if !force {
+		available := (!info.Running && info.PingTime.Before(now.Add(-after)) && !info.NextRetryTime.After(now)) ||
+			(info.Running && info.PingTime.Before(now.Add(-expireTime)))
+		if !available {
+			if !info.Running {
+				next = after - now.Sub(info.PingTime)
+				if retry := info.NextRetryTime.Sub(now); retry > next {
+					next = retry
+				}
+			}
+			return next, nil, storage.ErrSyncStorageLocked
+		}
+	}
+	info.StartTime = now
+	info.PingTime = now
+	info.Running = true
+	ruleSync := copySyncInfo(info)
+	return next, &ruleSync, nil
+}
+
+func (s *syncStorage) PingSyncs(ruleSyncIDs []string) error {
+	s.Lock()
+	defer s.Unlock()
+	ids := map[string]struct{}{}
+	for _, id := range ruleSyncIDs {
+		ids[id] = struct{}{}
+	}
+	now := time.Now().UTC()
+	for _, info := range s.syncs {
+		if _, ok := ids[info.SyncID]; ok {
+			info.PingTime = now
+		}
+	}
+	return nil
+}
+
+func (s *syncStorage) EndSync(ruleSync types.RuleSyncInfo, syncData types.RuleSyncData) error {
+	s.Lock()
+	defer s.Unlock()
+	info, ok := s.syncs[syncKey(ruleSync.RuleID, ruleSync.Engine)]
+	if !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	info.Running = false
+	info.PingTime = now
+	info.EndTime = now
+	info.Attempts = ruleSync.Attempts
+	info.NextRetryTime = ruleSync.NextRetryTime
+	info.Syncs = append(info.Syncs, syncData)
+	if len(info.Syncs) > maxSyncsPerRule {
+		info.Syncs = info.Syncs[len(info.Syncs)-maxSyncsPerRule:]
+	}
+	return nil
+}
+
+func (s *syncStorage) SetDrift(ruleID, engine string, drift types.RuleDrift) error {
+	s.Lock()
+	defer s.Unlock()
+	info, ok := s.syncs[syncKey(ruleID, engine)]
+	if !ok {
+		return nil
+	}
+	drift = copyDrift(drift)
+	info.Drift = &drift
+	return nil
+}
+
+func (s *syncStorage) Find(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+	syncInfos := []types.RuleSyncInfo{}
+	for _, info := range s.syncs {
+		if !opts.Matches(*info) {
+			continue
+		}
+		syncInfos = append(syncInfos, copySyncInfo(info))
+	}
+	storage.SortSyncs(syncInfos)
+	if opts.Limit > 0 && len(syncInfos) > opts.Limit {
+		syncInfos = syncInfos[:opts.Limit]
+	}
+	return syncInfos, nil
+}
+
+func (s *syncStorage) Purge(ruleIDs []string) error {
+	s.Lock()
+	defer s.Unlock()
+	purged := map[string]struct{}{}
+	for _, id := range ruleIDs {
+		purged[id] = struct{}{}
+	}
+	for key, info := range s.syncs {
+		if _, ok := purged[info.RuleID]; ok {
+			delete(s.syncs, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.WebhookDeliveryStorage = &webhookDeliveryStorage{}
+
+type webhookDeliveryStorage struct {
+	*memoryStorage
+}
+
+func (s *webhookDeliveryStorage) Add(delivery *types.WebhookDelivery) error {
+	s.Lock()
+	defer s.Unlock()
+	delivery.ID = newID()
+	s.deliveries = append(s.deliveries, *delivery)
+	return nil
+}
+
+func (s *webhookDeliveryStorage) Find(opts storage.WebhookDeliveryFindOpts) ([]types.WebhookDelivery, error) {
+	s.Lock()
+	defer s.Unlock()
+	deliveries := []types.WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		d := s.deliveries[i]
+		if (opts.EventID != "" && d.EventID != opts.EventID) ||
+			(opts.EventType != "" && d.EventType != opts.EventType) ||
+			(opts.URL != "" && d.URL != opts.URL) {
+			continue
+		}
+		deliveries = append(deliveries, d)
+		if opts.Limit > 0 && len(deliveries) == opts.Limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
@@ -7,6 +7,7 @@ package mongodb
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/tsuru/acl-api/storage"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,7 +18,8 @@ import (
 var (
 	_ storage.ACLAPIStorage = &aclapiStorage{}
 
-	aclapiOnce sync.Once
+	aclapiOnce      sync.Once
+	networkLockOnce sync.Once
 )
 
 type aclapiStorage struct {
@@ -37,6 +39,19 @@ func (s *aclapiStorage) getACLAPIColl() *mongo.Collection {
 	return coll
 }
 
+func (s *aclapiStorage) getNetworkLockColl() *mongo.Collection {
+	coll := s.getCollection("acl_aclapi_locks")
+	networkLockOnce.Do(func() {
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "network", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		})
+	})
+	return coll
+}
+
 func (s *aclapiStorage) Find(ruleID string) (storage.ACLAPISyncedRule, error) {
 	coll := s.getACLAPIColl()
 	var r storage.ACLAPISyncedRule
@@ -81,3 +96,62 @@ func (s *aclapiStorage) Remove(ruleID string, aclIDs []storage.ACLIdPair) error
 	}
 	return err
 }
+
+func (s *aclapiStorage) FindByACLId(aclID storage.ACLIdPair) ([]string, error) {
+	coll := s.getACLAPIColl()
+	cursor, err := coll.Find(context.TODO(), bson.M{"aclids": aclID}, options.Find().SetSort(bson.M{"ruleid": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var synced []storage.ACLAPISyncedRule
+	err = cursor.All(context.TODO(), &synced)
+	if err != nil {
+		return nil, err
+	}
+	var ruleIDs []string
+	for _, r := range synced {
+		ruleIDs = append(ruleIDs, r.RuleID)
+	}
+	return ruleIDs, nil
+}
+
+func (s *aclapiStorage) Purge(ruleIDs []string) error {
+	if len(ruleIDs) == 0 {
+		return nil
+	}
+	coll := s.getACLAPIColl()
+	_, err := coll.DeleteMany(context.TODO(), bson.M{"ruleid": bson.M{"$in": ruleIDs}})
+	return err
+}
+
+func (s *aclapiStorage) LockNetwork(network, owner string, expire time.Duration) error {
+	coll := s.getNetworkLockColl()
+	now := time.Now().UTC()
+	_, err := coll.UpdateOne(context.TODO(),
+		bson.M{
+			"network": network,
+			"$or": []bson.M{
+				{"owner": owner},
+				{"expiretime": bson.M{"$lt": now}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"network":    network,
+				"owner":      owner,
+				"expiretime": now.Add(expire),
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		err = storage.ErrNetworkLocked
+	}
+	return err
+}
+
+func (s *aclapiStorage) UnlockNetwork(network, owner string) error {
+	coll := s.getNetworkLockColl()
+	_, err := coll.DeleteOne(context.TODO(), bson.M{"network": network, "owner": owner})
+	return err
+}
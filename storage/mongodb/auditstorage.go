@@ -0,0 +1,82 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ storage.AuditStorage = &auditStorage{}
+
+	auditOnce sync.Once
+)
+
+type auditStorage struct {
+	*mongoStorage
+}
+
+func (s *auditStorage) getAuditColl() *mongo.Collection {
+	coll := s.getCollection("acl_audit")
+	auditOnce.Do(func() {
+		coll.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "time", Value: -1}, {Key: "id", Value: -1}}},
+			{Keys: bson.D{{Key: "ruleid", Value: 1}, {Key: "time", Value: -1}}},
+			{Keys: bson.D{{Key: "instance", Value: 1}, {Key: "time", Value: -1}}},
+			{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+		})
+	})
+	return coll
+}
+
+func (s *auditStorage) Add(entry *types.AuditEntry) error {
+	entry.ID = newID()
+	_, err := s.getAuditColl().InsertOne(context.TODO(), entry)
+	return err
+}
+
+func (s *auditStorage) Find(opts storage.AuditFindOpts) ([]types.AuditEntry, error) {
+	query := bson.M{}
+	if opts.Actor != "" {
+		query["actor"] = opts.Actor
+	}
+	if opts.RuleID != "" {
+		query["ruleid"] = opts.RuleID
+	}
+	if opts.Instance != "" {
+		query["instance"] = opts.Instance
+	}
+	timeQuery := bson.M{}
+	if !opts.Since.IsZero() {
+		timeQuery["$gte"] = opts.Since
+	}
+	if !opts.Until.IsZero() {
+		timeQuery["$lt"] = opts.Until
+	}
+	if len(timeQuery) > 0 {
+		query["time"] = timeQuery
+	}
+	findOpts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}, {Key: "id", Value: -1}})
+	if opts.Limit > 0 {
+		findOpts.SetLimit(int64(opts.Limit))
+	}
+	cur, err := s.getAuditColl().Find(context.TODO(), query, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []types.AuditEntry{}
+	err = cur.All(context.TODO(), &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
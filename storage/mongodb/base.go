@@ -30,14 +30,7 @@ func init() {
 
 	createConn := func() (stor *mongoStorage, err error) {
 		once.Do(func() {
-			var addr string
-
-			// compability with https://github.com/globocom/database-as-a-service
-			addr = viper.GetString("dbaas_mongodb_endpoint")
-
-			if addr == "" {
-				addr = viper.GetString("storage")
-			}
+			addr := mongoAddr()
 
 			var cs connstring.ConnString
 			cs, err = connstring.ParseAndValidate(addr)
@@ -70,7 +63,11 @@ func init() {
 		return &mongoStorage{client: client, database: database}, nil
 	}
 
+	nextRuleStorage := storage.GetRuleStorage
 	storage.GetRuleStorage = func() (storage.RuleStorage, error) {
+		if !isMongoStorage() {
+			return nextRuleStorage()
+		}
 		stor, err := createConn()
 		if err != nil {
 			return nil, err
@@ -78,7 +75,11 @@ func init() {
 		return &ruleStorage{stor}, nil
 	}
 
+	nextServiceStorage := storage.GetServiceStorage
 	storage.GetServiceStorage = func() (storage.ServiceStorage, error) {
+		if !isMongoStorage() {
+			return nextServiceStorage()
+		}
 		stor, err := createConn()
 		if err != nil {
 			return nil, err
@@ -86,7 +87,11 @@ func init() {
 		return &serviceStorage{stor}, nil
 	}
 
+	nextSyncStorage := storage.GetSyncStorage
 	storage.GetSyncStorage = func() (storage.SyncStorage, error) {
+		if !isMongoStorage() {
+			return nextSyncStorage()
+		}
 		stor, err := createConn()
 		if err != nil {
 			return nil, err
@@ -94,13 +99,93 @@ func init() {
 		return &syncStorage{stor}, nil
 	}
 
+	nextRuleHistoryStorage := storage.GetRuleHistoryStorage
+	storage.GetRuleHistoryStorage = func() (storage.RuleHistoryStorage, error) {
+		if !isMongoStorage() {
+			return nextRuleHistoryStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleHistoryStorage{stor}, nil
+	}
+
+	nextAuditStorage := storage.GetAuditStorage
+	storage.GetAuditStorage = func() (storage.AuditStorage, error) {
+		if !isMongoStorage() {
+			return nextAuditStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &auditStorage{stor}, nil
+	}
+
+	nextWebhookDeliveryStorage := storage.GetWebhookDeliveryStorage
+	storage.GetWebhookDeliveryStorage = func() (storage.WebhookDeliveryStorage, error) {
+		if !isMongoStorage() {
+			return nextWebhookDeliveryStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &webhookDeliveryStorage{stor}, nil
+	}
+
+	nextACLAPIStorage := storage.GetACLAPIStorage
 	storage.GetACLAPIStorage = func() (storage.ACLAPIStorage, error) {
+		if !isMongoStorage() {
+			return nextACLAPIStorage()
+		}
 		stor, err := createConn()
 		if err != nil {
 			return nil, err
 		}
 		return &aclapiStorage{stor}, nil
 	}
+
+	nextDNSStorage := storage.GetDNSStorage
+	storage.GetDNSStorage = func() (storage.DNSStorage, error) {
+		if !isMongoStorage() {
+			return nextDNSStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &dnsStorage{stor}, nil
+	}
+
+	nextSyncJobStorage := storage.GetSyncJobStorage
+	storage.GetSyncJobStorage = func() (storage.SyncJobStorage, error) {
+		if !isMongoStorage() {
+			return nextSyncJobStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &syncJobStorage{stor}, nil
+	}
+}
+
+func mongoAddr() string {
+	// compability with https://github.com/globocom/database-as-a-service
+	addr := viper.GetString("dbaas_mongodb_endpoint")
+	if addr == "" {
+		addr = viper.GetString("storage")
+	}
+	return addr
+}
+
+// isMongoStorage reports whether the configured storage address must be
+// handled by this package, other addresses are delegated to the storage
+// previously registered.
+func isMongoStorage() bool {
+	return strings.HasPrefix(mongoAddr(), "mongodb")
 }
 
 func newID() string {
@@ -24,3 +24,11 @@ func TestGetRuleStorageInvalidURL(t *testing.T) {
 	_, err := storage.GetRuleStorage()
 	assert.NotNil(t, err)
 }
+
+func TestGetRuleStorageInvalidMongoURL(t *testing.T) {
+	once = sync.Once{}
+	defer viper.Set("storage", viper.Get("storage"))
+	viper.Set("storage", "mongodb://localhost:invalid-port")
+	_, err := storage.GetRuleStorage()
+	assert.NotNil(t, err)
+}
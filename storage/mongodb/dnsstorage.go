@@ -0,0 +1,79 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ storage.DNSStorage = &dnsStorage{}
+
+	dnsOnce sync.Once
+)
+
+type dnsStorage struct {
+	*mongoStorage
+}
+
+// storedIPDoc keeps addresses as strings so they are readable in the
+// database.
+type storedIPDoc struct {
+	IP         string
+	ValidUntil time.Time
+}
+
+type dnsDoc struct {
+	Name string
+	IPs  []storedIPDoc
+}
+
+func (s *dnsStorage) getDNSColl() *mongo.Collection {
+	coll := s.getCollection("acl_dns")
+	dnsOnce.Do(func() {
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "name", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		})
+	})
+	return coll
+}
+
+func (s *dnsStorage) Find(name string) ([]storage.StoredIP, error) {
+	coll := s.getDNSColl()
+	var doc dnsDoc
+	err := coll.FindOne(context.TODO(), bson.M{"name": name}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ips []storage.StoredIP
+	for _, ip := range doc.IPs {
+		ips = append(ips, storage.StoredIP{IP: net.ParseIP(ip.IP), ValidUntil: ip.ValidUntil.UTC()})
+	}
+	return ips, nil
+}
+
+func (s *dnsStorage) Save(name string, ips []storage.StoredIP) error {
+	coll := s.getDNSColl()
+	doc := dnsDoc{Name: name, IPs: []storedIPDoc{}}
+	for _, ip := range ips {
+		doc.IPs = append(doc.IPs, storedIPDoc{IP: ip.IP.String(), ValidUntil: ip.ValidUntil})
+	}
+	_, err := coll.ReplaceOne(context.TODO(), bson.M{"name": name}, doc, options.Replace().SetUpsert(true))
+	return err
+}
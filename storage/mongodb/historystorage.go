@@ -0,0 +1,80 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ storage.RuleHistoryStorage = &ruleHistoryStorage{}
+
+	historyOnce sync.Once
+)
+
+// maxVersionAttempts limits how many times Add retries when a concurrent
+// change takes the version it tried to insert.
+const maxVersionAttempts = 5
+
+type ruleHistoryStorage struct {
+	*mongoStorage
+}
+
+func (s *ruleHistoryStorage) getHistoryColl() *mongo.Collection {
+	coll := s.getCollection("acl_rule_history")
+	historyOnce.Do(func() {
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "ruleid", Value: 1},
+				{Key: "version", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		})
+	})
+	return coll
+}
+
+func (s *ruleHistoryStorage) Add(change *types.RuleChange) error {
+	coll := s.getHistoryColl()
+	for i := 0; i < maxVersionAttempts; i++ {
+		var last types.RuleChange
+		err := coll.FindOne(context.TODO(), bson.M{"ruleid": change.RuleID},
+			options.FindOne().SetSort(bson.M{"version": -1})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		change.Version = last.Version + 1
+		_, err = coll.InsertOne(context.TODO(), change)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return errors.Errorf("unable to add history entry for rule %q, too many concurrent changes", change.RuleID)
+}
+
+func (s *ruleHistoryStorage) Find(ruleID string) ([]types.RuleChange, error) {
+	coll := s.getHistoryColl()
+	cur, err := coll.Find(context.TODO(), bson.M{"ruleid": ruleID}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+	changes := []types.RuleChange{}
+	err = cur.All(context.TODO(), &changes)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
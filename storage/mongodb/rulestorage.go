@@ -33,6 +33,8 @@ type rule struct {
 	Metadata    map[string]string
 	Created     time.Time
 	Creator     string
+	ExpiresAt   *time.Time `bson:",omitempty"`
+	RemovedAt   *time.Time `bson:",omitempty"`
 }
 
 type ruleStorage struct {
@@ -63,6 +65,28 @@ func (s *ruleStorage) getRulesColl() *mongo.Collection {
 			},
 			Options: options.Index(),
 		})
+
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "destination.externalip.ip", Value: 1},
+			},
+			Options: options.Index(),
+		})
+
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "destination.externaldns.name", Value: 1},
+			},
+			Options: options.Index(),
+		})
+
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "created", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+			Options: options.Index(),
+		})
 	})
 
 	return coll
@@ -147,7 +171,72 @@ func (s *ruleStorage) FindAll(opts storage.FindOpts) ([]types.Rule, error) {
 		query["source.tsurujob.jobname"] = opts.SourceTsuruJob
 	}
 
-	cur, err := coll.Find(context.TODO(), query, options.Find().SetSort(bson.M{"_id": 1}))
+	if opts.SourceTsuruPool != "" {
+		query["source.tsuruapp.poolname"] = opts.SourceTsuruPool
+	}
+
+	if opts.DestinationExternalIP != "" {
+		query["destination.externalip.ip"] = bson.M{"$in": storage.ExternalIPVariants(opts.DestinationExternalIP)}
+	}
+
+	if opts.DestinationExternalDNS != "" {
+		query["destination.externaldns.name"] = opts.DestinationExternalDNS
+	}
+
+	if opts.DestinationTsuruApp != "" {
+		query["destination.tsuruapp.appname"] = opts.DestinationTsuruApp
+	}
+
+	if opts.DestinationTsuruJob != "" {
+		query["destination.tsurujob.jobname"] = opts.DestinationTsuruJob
+	}
+
+	if opts.DestinationTsuruPool != "" {
+		query["destination.tsuruapp.poolname"] = opts.DestinationTsuruPool
+	}
+
+	if opts.Removed != nil {
+		query["removed"] = *opts.Removed
+	}
+
+	created := bson.M{}
+	if !opts.CreatedSince.IsZero() {
+		created["$gte"] = opts.CreatedSince
+	}
+	if !opts.CreatedUntil.IsZero() {
+		created["$lt"] = opts.CreatedUntil
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+	if !opts.ExpiresUntil.IsZero() {
+		query["expiresat"] = bson.M{"$lte": opts.ExpiresUntil}
+	}
+
+	order := 1
+	cmp := "$gt"
+	if opts.Sort.Descending() {
+		order = -1
+		cmp = "$lt"
+	}
+	sort := bson.D{{Key: "_id", Value: order}}
+	if opts.Sort.ByCreated() {
+		sort = bson.D{{Key: "created", Value: order}, {Key: "_id", Value: order}}
+		if opts.After != nil {
+			query["$or"] = bson.A{
+				bson.M{"created": bson.M{cmp: opts.After.Time}},
+				bson.M{"created": opts.After.Time, "_id": bson.M{cmp: opts.After.ID}},
+			}
+		}
+	} else if opts.After != nil {
+		query["_id"] = bson.M{cmp: opts.After.ID}
+	}
+	findOpts := options.Find().SetSort(sort)
+	if opts.Limit > 0 {
+		findOpts = findOpts.SetLimit(int64(opts.Limit))
+	}
+
+	cur, err := coll.Find(context.TODO(), query, findOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +263,7 @@ func (s *ruleStorage) Delete(opts storage.DeleteOpts) error {
 	change, err := coll.UpdateMany(
 		context.TODO(),
 		query,
-		bson.M{"$set": bson.M{"removed": true}},
+		bson.M{"$set": bson.M{"removed": true, "removedat": time.Now().UTC()}},
 	)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -187,3 +276,15 @@ func (s *ruleStorage) Delete(opts storage.DeleteOpts) error {
 	}
 	return nil
 }
+
+func (s *ruleStorage) Purge(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	coll := s.getRulesColl()
+	_, err := coll.DeleteMany(context.TODO(), bson.M{
+		"_id":     bson.M{"$in": ids},
+		"removed": true,
+	})
+	return err
+}
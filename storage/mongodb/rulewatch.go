@@ -0,0 +1,122 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	codeChangeStreamFatalError  = 280
+	codeChangeStreamHistoryLost = 286
+)
+
+// watchMaxAwaitTime bounds how long each poll of the change stream waits
+// for new events, allowing Next to notice a done context.
+var watchMaxAwaitTime = time.Second
+
+var _ storage.RuleWatch = &ruleWatch{}
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	FullDocument  *rule  `bson:"fullDocument"`
+}
+
+// Watch uses a change stream on the rules collection, revisions are the
+// change stream resume tokens. Change streams require MongoDB to run as a
+// replica set.
+func (s *ruleStorage) Watch(opts storage.FindOpts, revision string) (storage.RuleWatch, error) {
+	csOpts := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetMaxAwaitTime(watchMaxAwaitTime)
+	if revision != "" {
+		if _, err := hex.DecodeString(revision); err != nil {
+			return nil, storage.ErrInvalidRevision
+		}
+		csOpts.SetResumeAfter(bson.M{"_data": revision})
+	}
+	cs, err := s.getRulesColl().Watch(context.TODO(), mongo.Pipeline{}, csOpts)
+	if err != nil {
+		return nil, watchError(err)
+	}
+	return &ruleWatch{cs: cs, opts: opts, revision: revision}, nil
+}
+
+func watchError(err error) error {
+	if serverErr, ok := err.(mongo.ServerError); ok &&
+		(serverErr.HasErrorCode(codeChangeStreamHistoryLost) || serverErr.HasErrorCode(codeChangeStreamFatalError)) {
+		return storage.ErrRevisionExpired
+	}
+	return err
+}
+
+type ruleWatch struct {
+	cs       *mongo.ChangeStream
+	opts     storage.FindOpts
+	revision string
+}
+
+func (w *ruleWatch) Next(ctx context.Context) (types.RuleEvent, error) {
+	for {
+		if !w.cs.TryNext(ctx) {
+			if err := w.cs.Err(); err != nil {
+				return types.RuleEvent{}, watchError(err)
+			}
+			if err := ctx.Err(); err != nil {
+				return types.RuleEvent{}, err
+			}
+			w.updateRevision()
+			continue
+		}
+		w.updateRevision()
+		var change changeEvent
+		err := w.cs.Decode(&change)
+		if err != nil {
+			return types.RuleEvent{}, err
+		}
+		if change.FullDocument == nil {
+			// deleted documents, rules are only marked as removed
+			continue
+		}
+		event := types.RuleEvent{
+			Revision: w.revision,
+			Type:     types.RuleEventUpdated,
+			Rule:     types.Rule(*change.FullDocument),
+		}
+		if change.OperationType == "insert" {
+			event.Type = types.RuleEventCreated
+		} else if event.Rule.Removed {
+			event.Type = types.RuleEventRemoved
+		}
+		if w.opts.Matches(event.Rule) {
+			return event, nil
+		}
+	}
+}
+
+func (w *ruleWatch) updateRevision() {
+	if data, ok := w.cs.ResumeToken().Lookup("_data").StringValueOK(); ok {
+		w.revision = data
+	}
+}
+
+func (w *ruleWatch) Revision() string {
+	if w.revision == "" {
+		w.updateRevision()
+	}
+	return w.revision
+}
+
+func (w *ruleWatch) Close() error {
+	return w.cs.Close(context.TODO())
+}
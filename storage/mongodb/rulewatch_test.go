@@ -0,0 +1,45 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/storage"
+	"github.com/tsuru/acl-api/storage/storagetest"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// codeNotReplicaSet is returned when change streams are opened on a
+// standalone server.
+const codeNotReplicaSet = 40573
+
+func TestRuleWatchSuite(t *testing.T) {
+	defer viper.Set("storage", viper.Get("storage"))
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-storage")
+	stor, err := storage.GetRuleStorage()
+	require.Nil(t, err)
+	w, err := stor.Watch(storage.FindOpts{}, "")
+	if serverErr, ok := err.(mongo.ServerError); ok && serverErr.HasErrorCode(codeNotReplicaSet) {
+		t.Skip("change streams require a replica set")
+	}
+	require.Nil(t, err)
+	w.Close()
+	suite.Run(t, &storagetest.RuleWatchSuite{
+		Stor: stor,
+		SetupTestFunc: func() {
+			stor.(interface {
+				ClearAll()
+			}).ClearAll()
+		},
+	})
+}
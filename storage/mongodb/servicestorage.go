@@ -89,6 +89,20 @@ func (s *serviceStorage) AddRule(instanceName string, r *types.ServiceRule) erro
 	return err
 }
 
+func (s *serviceStorage) SetMetadata(instanceName string, metadata map[string]string) error {
+	coll := s.getServiceColl()
+	result, err := coll.UpdateOne(context.TODO(), bson.M{"instancename": instanceName}, bson.M{
+		"$set": bson.M{"metadata": metadata},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return storage.ErrInstanceNotFound
+	}
+	return nil
+}
+
 func (s *serviceStorage) AddApp(instanceName string, appName string) error {
 	coll := s.getServiceColl()
 	_, err := coll.UpdateOne(context.TODO(), bson.M{"instancename": instanceName}, bson.M{
@@ -111,6 +125,30 @@ func (s *serviceStorage) AddJob(instanceName string, jobName string) error {
 	return err
 }
 
+func (s *serviceStorage) UpdateRule(instanceName string, r types.ServiceRule) error {
+	coll := s.getServiceColl()
+	result, err := coll.UpdateOne(context.TODO(), bson.M{
+		"instancename":          instanceName,
+		"baserules.rule.ruleid": r.RuleID,
+	}, bson.M{
+		"$set": bson.M{"baserules.$": r},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := coll.CountDocuments(context.TODO(), bson.M{"instancename": instanceName})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return storage.ErrInstanceNotFound
+		}
+		return storage.ErrRuleNotFound
+	}
+	return nil
+}
+
 func (s *serviceStorage) RemoveRule(instanceName string, ruleID string) error {
 	coll := s.getServiceColl()
 	_, err := coll.UpdateOne(context.TODO(), bson.M{"instancename": instanceName}, bson.M{
@@ -0,0 +1,118 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	_ storage.SyncJobStorage = &syncJobStorage{}
+
+	syncJobOnce sync.Once
+)
+
+type syncJobStorage struct {
+	*mongoStorage
+}
+
+type syncJobDoc struct {
+	JobID   string `bson:"_id"`
+	Created time.Time
+	Creator string
+	Force   bool
+	Targets []types.SyncJobTarget
+}
+
+func (s *syncJobStorage) getSyncJobColl() *mongo.Collection {
+	coll := s.getCollection("acl_sync_jobs")
+	syncJobOnce.Do(func() {
+		coll.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "created", Value: 1},
+			},
+		})
+	})
+	return coll
+}
+
+func (s *syncJobStorage) Create(job types.SyncJob) error {
+	coll := s.getSyncJobColl()
+	_, err := coll.InsertOne(context.TODO(), syncJobDoc{
+		JobID:   job.JobID,
+		Created: job.Created,
+		Creator: job.Creator,
+		Force:   job.Force,
+		Targets: job.Targets,
+	})
+	return err
+}
+
+func (s *syncJobStorage) Find(id string) (types.SyncJob, error) {
+	coll := s.getSyncJobColl()
+	var doc syncJobDoc
+	err := coll.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return types.SyncJob{}, storage.ErrSyncJobNotFound
+		}
+		return types.SyncJob{}, err
+	}
+	job := types.SyncJob{
+		JobID:   doc.JobID,
+		Created: doc.Created.UTC(),
+		Creator: doc.Creator,
+		Force:   doc.Force,
+		Targets: doc.Targets,
+	}
+	for i := range job.Targets {
+		if t := job.Targets[i].StartTime; t != nil {
+			utc := t.UTC()
+			job.Targets[i].StartTime = &utc
+		}
+		if t := job.Targets[i].EndTime; t != nil {
+			utc := t.UTC()
+			job.Targets[i].EndTime = &utc
+		}
+	}
+	return job, nil
+}
+
+func (s *syncJobStorage) UpdateTarget(jobID string, target types.SyncJobTarget) error {
+	coll := s.getSyncJobColl()
+	result, err := coll.UpdateOne(context.TODO(), bson.M{
+		"_id": jobID,
+		"targets": bson.M{"$elemMatch": bson.M{
+			"ruleid": target.RuleID,
+			"engine": target.Engine,
+		}},
+	}, bson.M{"$set": bson.M{"targets.$": target}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := coll.CountDocuments(context.TODO(), bson.M{"_id": jobID})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return storage.ErrSyncJobNotFound
+		}
+	}
+	return nil
+}
+
+func (s *syncJobStorage) Purge(before time.Time) error {
+	coll := s.getSyncJobColl()
+	_, err := coll.DeleteMany(context.TODO(), bson.M{"created": bson.M{"$lt": before}})
+	return err
+}
@@ -52,14 +52,17 @@ func (s *syncStorage) SetLockExpireTime(timeout time.Duration) time.Duration {
 }
 
 type ruleSyncInfo struct {
-	SyncID    string `bson:"_id,omitempty"`
-	RuleID    string
-	Engine    string
-	StartTime time.Time
-	EndTime   time.Time
-	PingTime  time.Time
-	Running   bool
-	Syncs     []types.RuleSyncData
+	SyncID        string `bson:"_id,omitempty"`
+	RuleID        string
+	Engine        string
+	StartTime     time.Time
+	EndTime       time.Time
+	PingTime      time.Time
+	Running       bool
+	Syncs         []types.RuleSyncData
+	Attempts      int
+	NextRetryTime time.Time
+	Drift         *types.RuleDrift
 }
 
 func (s *syncStorage) StartSync(after time.Duration, ruleID, engine string, force bool) (time.Duration, *types.RuleSyncInfo, error) {
@@ -78,8 +81,9 @@ func (s *syncStorage) StartSync(after time.Duration, ruleID, engine string, forc
 	if !force {
 		query["$or"] = []bson.M{
 			{
-				"pingtime": bson.M{"$lt": now.Add(-after)},
-				"running":  false,
+				"pingtime":      bson.M{"$lt": now.Add(-after)},
+				"running":       false,
+				"nextretrytime": bson.M{"$not": bson.M{"$gt": now}},
 			},
 			{
 				"pingtime": bson.M{"$lt": now.Add(-expireTime)},
@@ -114,6 +118,9 @@ func (s *syncStorage) StartSync(after time.Duration, ruleID, engine string, forc
 			}).Decode(&findResult)
 			if !findResult.PingTime.IsZero() {
 				next = after - time.Now().UTC().Sub(findResult.PingTime)
+				if retry := findResult.NextRetryTime.Sub(time.Now().UTC()); retry > next {
+					next = retry
+				}
 			}
 		}
 		return next, nil, err
@@ -143,9 +150,11 @@ func (s *syncStorage) EndSync(ruleSync types.RuleSyncInfo, syncData types.RuleSy
 		"engine": ruleSync.Engine,
 	}, bson.M{
 		"$set": bson.M{
-			"running":  false,
-			"pingtime": now,
-			"endtime":  now,
+			"running":       false,
+			"pingtime":      now,
+			"endtime":       now,
+			"attempts":      ruleSync.Attempts,
+			"nextretrytime": ruleSync.NextRetryTime,
 		},
 		"$push": bson.M{
 			"syncs": bson.D{
@@ -157,6 +166,16 @@ func (s *syncStorage) EndSync(ruleSync types.RuleSyncInfo, syncData types.RuleSy
 	return err
 }
 
+func (s *syncStorage) SetDrift(ruleID, engine string, drift types.RuleDrift) error {
+	coll := s.getSyncColl()
+	drift.CheckTime = drift.CheckTime.UTC()
+	_, err := coll.UpdateOne(context.TODO(), bson.M{
+		"ruleid": ruleID,
+		"engine": engine,
+	}, bson.M{"$set": bson.M{"drift": drift}})
+	return err
+}
+
 func (s *syncStorage) Find(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, error) {
 	coll := s.getSyncColl()
 	filter := bson.M{}
@@ -166,7 +185,16 @@ func (s *syncStorage) Find(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, err
 	if opts.RuleIDs != nil {
 		filter["ruleid"] = bson.M{"$in": opts.RuleIDs}
 	}
-	findOpts := options.Find().SetSort(bson.M{"starttime": -1})
+	if opts.Drifted {
+		filter["drift.drifted"] = true
+	}
+	if opts.After != nil {
+		filter["$or"] = bson.A{
+			bson.M{"starttime": bson.M{"$lt": opts.After.Time}},
+			bson.M{"starttime": opts.After.Time, "_id": bson.M{"$lt": opts.After.ID}},
+		}
+	}
+	findOpts := options.Find().SetSort(bson.D{{Key: "starttime", Value: -1}, {Key: "_id", Value: -1}})
 	if opts.Limit > 0 {
 		findOpts = findOpts.SetLimit(int64(opts.Limit))
 	}
@@ -185,3 +213,12 @@ func (s *syncStorage) Find(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, err
 	}
 	return syncInfos, nil
 }
+
+func (s *syncStorage) Purge(ruleIDs []string) error {
+	if len(ruleIDs) == 0 {
+		return nil
+	}
+	coll := s.getSyncColl()
+	_, err := coll.DeleteMany(context.TODO(), bson.M{"ruleid": bson.M{"$in": ruleIDs}})
+	return err
+}
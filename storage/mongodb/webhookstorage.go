@@ -0,0 +1,70 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mongodb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	_ storage.WebhookDeliveryStorage = &webhookDeliveryStorage{}
+
+	webhookOnce sync.Once
+)
+
+type webhookDeliveryStorage struct {
+	*mongoStorage
+}
+
+func (s *webhookDeliveryStorage) getDeliveriesColl() *mongo.Collection {
+	coll := s.getCollection("acl_webhook_deliveries")
+	webhookOnce.Do(func() {
+		coll.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+			{Keys: bson.D{{Key: "id", Value: -1}}},
+			{Keys: bson.D{{Key: "eventid", Value: 1}}},
+		})
+	})
+	return coll
+}
+
+func (s *webhookDeliveryStorage) Add(delivery *types.WebhookDelivery) error {
+	delivery.ID = newID()
+	_, err := s.getDeliveriesColl().InsertOne(context.TODO(), delivery)
+	return err
+}
+
+func (s *webhookDeliveryStorage) Find(opts storage.WebhookDeliveryFindOpts) ([]types.WebhookDelivery, error) {
+	query := bson.M{}
+	if opts.EventID != "" {
+		query["eventid"] = opts.EventID
+	}
+	if opts.EventType != "" {
+		query["eventtype"] = opts.EventType
+	}
+	if opts.URL != "" {
+		query["url"] = opts.URL
+	}
+	findOpts := options.Find().SetSort(bson.M{"id": -1})
+	if opts.Limit > 0 {
+		findOpts.SetLimit(int64(opts.Limit))
+	}
+	cur, err := s.getDeliveriesColl().Find(context.TODO(), query, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []types.WebhookDelivery{}
+	err = cur.All(context.TODO(), &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
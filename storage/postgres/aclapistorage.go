@@ -0,0 +1,160 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.ACLAPIStorage = &aclapiStorage{}
+
+type aclapiStorage struct {
+	*postgresStorage
+}
+
+func (s *aclapiStorage) Find(ruleID string) (storage.ACLAPISyncedRule, error) {
+	var data []byte
+	err := s.db.QueryRowContext(context.TODO(), `SELECT acl_ids FROM acl_aclapi WHERE rule_id = $1`, ruleID).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = storage.ErrACLAPISyncedRuleNotFound
+		}
+		return storage.ACLAPISyncedRule{}, err
+	}
+	syncedRule := storage.ACLAPISyncedRule{RuleID: ruleID}
+	err = json.Unmarshal(data, &syncedRule.ACLIds)
+	if err != nil {
+		return storage.ACLAPISyncedRule{}, err
+	}
+	return syncedRule, nil
+}
+
+// update applies fn to the pairs stored for ruleID while holding the row
+// lock, when upsert is set a missing row is created.
+func (s *aclapiStorage) update(ruleID string, upsert bool, fn func(ids []storage.ACLIdPair) []storage.ACLIdPair) error {
+	ctx := context.TODO()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if upsert {
+			_, err := tx.ExecContext(ctx, `INSERT INTO acl_aclapi (rule_id) VALUES ($1) ON CONFLICT DO NOTHING`, ruleID)
+			if err != nil {
+				return err
+			}
+		}
+		var data []byte
+		err := tx.QueryRowContext(ctx, `SELECT acl_ids FROM acl_aclapi WHERE rule_id = $1 FOR UPDATE`, ruleID).Scan(&data)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		var ids []storage.ACLIdPair
+		err = json.Unmarshal(data, &ids)
+		if err != nil {
+			return err
+		}
+		ids = fn(ids)
+		if ids == nil {
+			ids = []storage.ACLIdPair{}
+		}
+		value, err := jsonValue(ids)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE acl_aclapi SET acl_ids = $1 WHERE rule_id = $2`, value, ruleID)
+		return err
+	})
+}
+
+func (s *aclapiStorage) Add(ruleID string, aclIDs []storage.ACLIdPair) error {
+	return s.update(ruleID, true, func(ids []storage.ACLIdPair) []storage.ACLIdPair {
+		for _, id := range aclIDs {
+			if !containsPair(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	})
+}
+
+func (s *aclapiStorage) Remove(ruleID string, aclIDs []storage.ACLIdPair) error {
+	return s.update(ruleID, false, func(ids []storage.ACLIdPair) []storage.ACLIdPair {
+		var remaining []storage.ACLIdPair
+		for _, id := range ids {
+			if !containsPair(aclIDs, id) {
+				remaining = append(remaining, id)
+			}
+		}
+		return remaining
+	})
+}
+
+func (s *aclapiStorage) FindByACLId(aclID storage.ACLIdPair) ([]string, error) {
+	value, err := jsonValue([]storage.ACLIdPair{aclID})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(context.TODO(), `SELECT rule_id FROM acl_aclapi WHERE acl_ids @> $1 ORDER BY rule_id`, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ruleIDs []string
+	for rows.Next() {
+		var ruleID string
+		err = rows.Scan(&ruleID)
+		if err != nil {
+			return nil, err
+		}
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+	return ruleIDs, rows.Err()
+}
+
+func containsPair(pairs []storage.ACLIdPair, pair storage.ACLIdPair) bool {
+	for _, p := range pairs {
+		if p == pair {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *aclapiStorage) Purge(ruleIDs []string) error {
+	if len(ruleIDs) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_aclapi WHERE rule_id = ANY($1)`, pq.Array(ruleIDs))
+	return err
+}
+
+func (s *aclapiStorage) LockNetwork(network, owner string, expire time.Duration) error {
+	now := time.Now().UTC()
+	result, err := s.db.ExecContext(context.TODO(), `INSERT INTO acl_aclapi_locks (network, owner, expire_time) VALUES ($1, $2, $3)
+		ON CONFLICT (network) DO UPDATE SET owner = EXCLUDED.owner, expire_time = EXCLUDED.expire_time
+		WHERE acl_aclapi_locks.owner = EXCLUDED.owner OR acl_aclapi_locks.expire_time < $4`, network, owner, now.Add(expire), now)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNetworkLocked
+	}
+	return nil
+}
+
+func (s *aclapiStorage) UnlockNetwork(network, owner string) error {
+	_, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_aclapi_locks WHERE network = $1 AND owner = $2`, network, owner)
+	return err
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.AuditStorage = &auditStorage{}
+
+type auditStorage struct {
+	*postgresStorage
+}
+
+func (s *auditStorage) Add(entry *types.AuditEntry) error {
+	entry.ID = newID()
+	data, err := jsonValue(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.TODO(), `INSERT INTO acl_audit (id, time, actor, rule_id, instance, data)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb)`, entry.ID, entry.Time, entry.Actor, entry.RuleID, entry.Instance, data)
+	return err
+}
+
+func (s *auditStorage) Find(opts storage.AuditFindOpts) ([]types.AuditEntry, error) {
+	f := &filter{}
+	if opts.Actor != "" {
+		f.add("actor = %s", opts.Actor)
+	}
+	if opts.RuleID != "" {
+		f.add("rule_id = %s", opts.RuleID)
+	}
+	if opts.Instance != "" {
+		f.add("instance = %s", opts.Instance)
+	}
+	if !opts.Since.IsZero() {
+		f.add("time >= %s", opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		f.add("time < %s", opts.Until)
+	}
+	query := `SELECT data FROM acl_audit` + f.where() + ` ORDER BY time DESC, id DESC`
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+	rows, err := s.db.QueryContext(context.TODO(), query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []types.AuditEntry{}
+	for rows.Next() {
+		var (
+			data  []byte
+			entry types.AuditEntry
+		)
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package postgres implements every storage interface on top of a PostgreSQL
+// database. It is selected with a storage address using the postgres:// or
+// postgresql:// schemes, the schema is created and migrated on the first
+// connection.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/storage"
+)
+
+// once global is only reset in tests
+var once sync.Once
+
+var idCounter uint32
+
+func init() {
+	var db *sql.DB
+
+	createConn := func() (stor *postgresStorage, err error) {
+		once.Do(func() {
+			db, err = sql.Open("postgres", viper.GetString("storage"))
+			if err != nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			err = db.PingContext(ctx)
+			if err != nil {
+				db.Close()
+				return
+			}
+			err = migrate(ctx, db)
+			if err != nil {
+				db.Close()
+			}
+		})
+		if err != nil {
+			once = sync.Once{}
+			return nil, err
+		}
+		return &postgresStorage{db: db}, nil
+	}
+
+	nextRuleStorage := storage.GetRuleStorage
+	storage.GetRuleStorage = func() (storage.RuleStorage, error) {
+		if !isPostgresStorage() {
+			return nextRuleStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleStorage{stor}, nil
+	}
+
+	nextServiceStorage := storage.GetServiceStorage
+	storage.GetServiceStorage = func() (storage.ServiceStorage, error) {
+		if !isPostgresStorage() {
+			return nextServiceStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &serviceStorage{stor}, nil
+	}
+
+	nextSyncStorage := storage.GetSyncStorage
+	storage.GetSyncStorage = func() (storage.SyncStorage, error) {
+		if !isPostgresStorage() {
+			return nextSyncStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &syncStorage{stor}, nil
+	}
+
+	nextRuleHistoryStorage := storage.GetRuleHistoryStorage
+	storage.GetRuleHistoryStorage = func() (storage.RuleHistoryStorage, error) {
+		if !isPostgresStorage() {
+			return nextRuleHistoryStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &ruleHistoryStorage{stor}, nil
+	}
+
+	nextAuditStorage := storage.GetAuditStorage
+	storage.GetAuditStorage = func() (storage.AuditStorage, error) {
+		if !isPostgresStorage() {
+			return nextAuditStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &auditStorage{stor}, nil
+	}
+
+	nextWebhookDeliveryStorage := storage.GetWebhookDeliveryStorage
+	storage.GetWebhookDeliveryStorage = func() (storage.WebhookDeliveryStorage, error) {
+		if !isPostgresStorage() {
+			return nextWebhookDeliveryStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &webhookDeliveryStorage{stor}, nil
+	}
+
+	nextACLAPIStorage := storage.GetACLAPIStorage
+	storage.GetACLAPIStorage = func() (storage.ACLAPIStorage, error) {
+		if !isPostgresStorage() {
+			return nextACLAPIStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &aclapiStorage{stor}, nil
+	}
+
+	nextDNSStorage := storage.GetDNSStorage
+	storage.GetDNSStorage = func() (storage.DNSStorage, error) {
+		if !isPostgresStorage() {
+			return nextDNSStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &dnsStorage{stor}, nil
+	}
+
+	nextSyncJobStorage := storage.GetSyncJobStorage
+	storage.GetSyncJobStorage = func() (storage.SyncJobStorage, error) {
+		if !isPostgresStorage() {
+			return nextSyncJobStorage()
+		}
+		stor, err := createConn()
+		if err != nil {
+			return nil, err
+		}
+		return &syncJobStorage{stor}, nil
+	}
+}
+
+func isPostgresStorage() bool {
+	addr := viper.GetString("storage")
+	return strings.HasPrefix(addr, "postgres://") || strings.HasPrefix(addr, "postgresql://")
+}
+
+// newID returns a unique hex identifier, identifiers generated later sort
+// after the ones generated before.
+func newID() string {
+	return fmt.Sprintf("%016x%08x", time.Now().UnixNano(), atomic.AddUint32(&idCounter, 1))
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// jsonValue encodes v to be stored in a jsonb column, it is sent as text so
+// the driver never mistakes it for bytea.
+func jsonValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+type postgresStorage struct {
+	db *sql.DB
+}
+
+// withTx runs fn inside a transaction, committing it when fn succeeds.
+func (s *postgresStorage) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClearAll will remove all stored data and must only be used in tests
+func (s *postgresStorage) ClearAll() {
+	s.db.Exec(`TRUNCATE ` + strings.Join(dataTables, ", "))
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.DNSStorage = &dnsStorage{}
+
+type dnsStorage struct {
+	*postgresStorage
+}
+
+func (s *dnsStorage) Find(name string) ([]storage.StoredIP, error) {
+	var data []byte
+	err := s.db.QueryRowContext(context.TODO(), `SELECT ips FROM acl_dns WHERE name = $1`, name).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ips []storage.StoredIP
+	err = json.Unmarshal(data, &ips)
+	return ips, err
+}
+
+func (s *dnsStorage) Save(name string, ips []storage.StoredIP) error {
+	if ips == nil {
+		ips = []storage.StoredIP{}
+	}
+	data, err := jsonValue(ips)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.TODO(), `INSERT INTO acl_dns (name, ips) VALUES ($1, $2::jsonb)
+		ON CONFLICT (name) DO UPDATE SET ips = EXCLUDED.ips`, name, data)
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.RuleHistoryStorage = &ruleHistoryStorage{}
+
+// maxVersionAttempts limits how many times Add retries when a concurrent
+// change takes the version it tried to insert.
+const maxVersionAttempts = 5
+
+type ruleHistoryStorage struct {
+	*postgresStorage
+}
+
+func (s *ruleHistoryStorage) Add(change *types.RuleChange) error {
+	data, err := jsonValue(change)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxVersionAttempts; i++ {
+		err = s.db.QueryRowContext(context.TODO(), `INSERT INTO acl_rule_history (rule_id, version, data)
+			SELECT $1::text, COALESCE(MAX(version), 0) + 1, $2::jsonb FROM acl_rule_history WHERE rule_id = $1
+			RETURNING version`, change.RuleID, data).Scan(&change.Version)
+		if !isUniqueViolation(err) {
+			return err
+		}
+	}
+	return errors.Errorf("unable to add history entry for rule %q, too many concurrent changes", change.RuleID)
+}
+
+func (s *ruleHistoryStorage) Find(ruleID string) ([]types.RuleChange, error) {
+	rows, err := s.db.QueryContext(context.TODO(), `SELECT version, data FROM acl_rule_history
+		WHERE rule_id = $1 ORDER BY version`, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []types.RuleChange{}
+	for rows.Next() {
+		var (
+			version int
+			data    []byte
+			change  types.RuleChange
+		)
+		err = rows.Scan(&version, &data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &change)
+		if err != nil {
+			return nil, err
+		}
+		change.Version = version
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// migrationsLockID is the advisory lock key held while migrations run so
+// concurrent processes starting together do not apply them twice.
+const migrationsLockID = 0x61636c617069
+
+// dataTables lists every table holding application data.
+var dataTables = []string{
+	"acl_rules",
+	"acl_services",
+	"acl_rule_sync",
+	"acl_aclapi",
+	"acl_aclapi_locks",
+	"acl_rule_history",
+	"acl_rule_events",
+	"acl_audit",
+	"acl_webhook_deliveries",
+	"acl_dns",
+	"acl_sync_jobs",
+	"acl_sync_job_targets",
+}
+
+// migrations are applied in order and each one exactly once, existing entries
+// must never be changed, new schema changes are appended to the list.
+var migrations = []string{
+	`CREATE TABLE acl_rules (
+		id          text PRIMARY KEY,
+		name        text UNIQUE,
+		source      jsonb NOT NULL,
+		destination jsonb NOT NULL,
+		removed     boolean NOT NULL DEFAULT false,
+		metadata    jsonb NOT NULL DEFAULT '{}',
+		created     timestamptz NOT NULL,
+		creator     text NOT NULL DEFAULT ''
+	);
+	CREATE INDEX acl_rules_source_app_idx ON acl_rules ((source->'TsuruApp'->>'AppName'));
+	CREATE INDEX acl_rules_source_job_idx ON acl_rules ((source->'TsuruJob'->>'JobName'));
+	CREATE INDEX acl_rules_metadata_idx ON acl_rules USING gin (metadata);
+
+	CREATE TABLE acl_services (
+		seq           bigserial,
+		instance_name text PRIMARY KEY,
+		data          jsonb NOT NULL
+	);
+
+	CREATE TABLE acl_rule_sync (
+		id         text PRIMARY KEY,
+		rule_id    text NOT NULL,
+		engine     text NOT NULL,
+		start_time timestamptz NOT NULL,
+		end_time   timestamptz,
+		ping_time  timestamptz NOT NULL,
+		running    boolean NOT NULL,
+		syncs      jsonb NOT NULL DEFAULT '[]',
+		UNIQUE (rule_id, engine)
+	);
+	CREATE INDEX acl_rule_sync_start_time_idx ON acl_rule_sync (start_time DESC);
+
+	CREATE TABLE acl_aclapi (
+		rule_id text PRIMARY KEY,
+		acl_ids jsonb NOT NULL DEFAULT '[]'
+	);`,
+	`ALTER TABLE acl_rules ADD COLUMN expires_at timestamptz;
+	CREATE INDEX acl_rules_expires_at_idx ON acl_rules (expires_at) WHERE expires_at IS NOT NULL;`,
+	`CREATE TABLE acl_rule_history (
+		rule_id text NOT NULL,
+		version integer NOT NULL,
+		data    jsonb NOT NULL,
+		PRIMARY KEY (rule_id, version)
+	);`,
+	`CREATE TABLE acl_rule_events (
+		revision bigserial PRIMARY KEY,
+		type     text NOT NULL,
+		rule     jsonb NOT NULL
+	);`,
+	`CREATE TABLE acl_audit (
+		id       text PRIMARY KEY,
+		time     timestamptz NOT NULL,
+		actor    text NOT NULL,
+		rule_id  text NOT NULL,
+		instance text NOT NULL,
+		data     jsonb NOT NULL
+	);
+	CREATE INDEX acl_audit_time_idx ON acl_audit (time DESC, id DESC);
+	CREATE INDEX acl_audit_rule_id_idx ON acl_audit (rule_id, time DESC) WHERE rule_id <> '';
+	CREATE INDEX acl_audit_instance_idx ON acl_audit (instance, time DESC) WHERE instance <> '';
+	CREATE INDEX acl_audit_actor_idx ON acl_audit (actor, time DESC);`,
+	`CREATE TABLE acl_webhook_deliveries (
+		id         text PRIMARY KEY,
+		event_id   text NOT NULL,
+		event_type text NOT NULL,
+		url        text NOT NULL,
+		data       jsonb NOT NULL
+	);
+	CREATE INDEX acl_webhook_deliveries_event_id_idx ON acl_webhook_deliveries (event_id);`,
+	`CREATE TABLE acl_dns (
+		name text PRIMARY KEY,
+		ips  jsonb NOT NULL DEFAULT '[]'
+	);`,
+	`CREATE INDEX acl_rules_destination_ip_idx ON acl_rules ((destination->'ExternalIP'->>'IP'));
+	CREATE INDEX acl_rules_destination_dns_idx ON acl_rules ((destination->'ExternalDNS'->>'Name'));
+	CREATE INDEX acl_rules_created_idx ON acl_rules (created, id);
+	CREATE INDEX acl_rule_sync_start_time_id_idx ON acl_rule_sync (start_time DESC, id DESC);`,
+	`ALTER TABLE acl_rules ADD COLUMN removed_at timestamptz;`,
+	`ALTER TABLE acl_rule_sync ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+	ALTER TABLE acl_rule_sync ADD COLUMN next_retry_time timestamptz;`,
+	`CREATE TABLE acl_sync_jobs (
+		id      text PRIMARY KEY,
+		created timestamptz NOT NULL,
+		creator text NOT NULL DEFAULT '',
+		force   boolean NOT NULL DEFAULT false
+	);
+	CREATE INDEX acl_sync_jobs_created_idx ON acl_sync_jobs (created);
+	CREATE TABLE acl_sync_job_targets (
+		job_id     text NOT NULL REFERENCES acl_sync_jobs (id) ON DELETE CASCADE,
+		position   integer NOT NULL,
+		rule_id    text NOT NULL,
+		engine     text NOT NULL,
+		status     text NOT NULL,
+		error      text NOT NULL DEFAULT '',
+		start_time timestamptz,
+		end_time   timestamptz,
+		PRIMARY KEY (job_id, rule_id, engine)
+	);`,
+	`ALTER TABLE acl_rule_sync ADD COLUMN drift jsonb;`,
+	`CREATE TABLE acl_aclapi_locks (
+		network     text PRIMARY KEY,
+		owner       text NOT NULL,
+		expire_time timestamptz NOT NULL
+	);`,
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationsLockID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS acl_schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	var current int
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM acl_schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		_, err = tx.ExecContext(ctx, migrations[i])
+		if err != nil {
+			return errors.Wrapf(err, "unable to apply migration %d", version)
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO acl_schema_migrations (version) VALUES ($1)`, version)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var (
+	_ storage.RuleStorage    = &ruleStorage{}
+	_ storage.ServiceStorage = &serviceStorage{}
+)
+
+const ruleColumns = `id, name, source, destination, removed, metadata, created, creator, expires_at, removed_at`
+
+type ruleStorage struct {
+	*postgresStorage
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRule(row rowScanner) (types.Rule, error) {
+	var (
+		r                             types.Rule
+		name                          sql.NullString
+		expiresAt, removedAt          sql.NullTime
+		source, destination, metadata []byte
+	)
+	err := row.Scan(&r.RuleID, &name, &source, &destination, &r.Removed, &metadata, &r.Created, &r.Creator, &expiresAt, &removedAt)
+	if err != nil {
+		return r, err
+	}
+	r.RuleName = name.String
+	r.Created = r.Created.UTC()
+	if expiresAt.Valid {
+		expires := expiresAt.Time.UTC()
+		r.ExpiresAt = &expires
+	}
+	if removedAt.Valid {
+		removed := removedAt.Time.UTC()
+		r.RemovedAt = &removed
+	}
+	err = json.Unmarshal(source, &r.Source)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(destination, &r.Destination)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(metadata, &r.Metadata)
+	if err != nil {
+		return r, err
+	}
+	storage.NormalizeRule(&r)
+	return r, nil
+}
+
+func (s *ruleStorage) Find(id string) (types.Rule, error) {
+	row := s.db.QueryRowContext(context.TODO(), `SELECT `+ruleColumns+` FROM acl_rules
+		WHERE id = $1 OR name = $1 ORDER BY id = $1 DESC LIMIT 1`, id)
+	r, err := scanRule(row)
+	if err == sql.ErrNoRows {
+		return types.Rule{}, storage.ErrRuleNotFound
+	}
+	return r, err
+}
+
+func ruleArgs(r *types.Rule) ([]interface{}, error) {
+	source, err := jsonValue(r.Source)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := jsonValue(r.Destination)
+	if err != nil {
+		return nil, err
+	}
+	metadata := r.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadataValue, err := jsonValue(metadata)
+	if err != nil {
+		return nil, err
+	}
+	name := sql.NullString{String: r.RuleName, Valid: r.RuleName != ""}
+	var expiresAt sql.NullTime
+	if r.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *r.ExpiresAt, Valid: true}
+	}
+	var removedAt sql.NullTime
+	if r.RemovedAt != nil {
+		removedAt = sql.NullTime{Time: *r.RemovedAt, Valid: true}
+	}
+	return []interface{}{r.RuleID, name, source, destination, r.Removed, metadataValue, r.Created, r.Creator, expiresAt, removedAt}, nil
+}
+
+func (s *ruleStorage) Save(rules []*types.Rule, upsert bool) error {
+	query := `INSERT INTO acl_rules AS r (` + ruleColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	if upsert {
+		query += ` ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			source = EXCLUDED.source,
+			destination = EXCLUDED.destination,
+			removed = EXCLUDED.removed,
+			metadata = EXCLUDED.metadata,
+			created = EXCLUDED.created,
+			creator = EXCLUDED.creator,
+			expires_at = EXCLUDED.expires_at,
+			removed_at = EXCLUDED.removed_at`
+	}
+	// xmax is only zero for rows inserted by the statement
+	query += ` RETURNING (r.xmax = 0)`
+	now := time.Now().UTC()
+	for _, r := range rules {
+		if r.RuleID == "" {
+			r.RuleID = newID()
+		}
+		r.Created = now
+	}
+	err := s.withTx(context.TODO(), func(tx *sql.Tx) error {
+		err := lockRuleEvents(tx)
+		if err != nil {
+			return err
+		}
+		events := make([]types.RuleEvent, len(rules))
+		for i, r := range rules {
+			args, err := ruleArgs(r)
+			if err != nil {
+				return err
+			}
+			var inserted bool
+			err = tx.QueryRowContext(context.TODO(), query, args...).Scan(&inserted)
+			if err != nil {
+				return err
+			}
+			events[i] = types.RuleEvent{Type: types.RuleEventUpdated, Rule: *r}
+			if inserted {
+				events[i].Type = types.RuleEventCreated
+			} else if r.Removed {
+				events[i].Type = types.RuleEventRemoved
+			}
+		}
+		return addRuleEvents(tx, events)
+	})
+	if isUniqueViolation(err) {
+		return storage.ErrInstanceAlreadyExists
+	}
+	return err
+}
+
+// filter accumulates the conditions and arguments of a WHERE clause.
+type filter struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends cond replacing each %s with the placeholder of the matching
+// arg.
+func (f *filter) add(cond string, args ...interface{}) {
+	placeholders := make([]interface{}, len(args))
+	for i, arg := range args {
+		f.args = append(f.args, arg)
+		placeholders[i] = fmt.Sprintf("$%d", len(f.args))
+	}
+	f.conds = append(f.conds, fmt.Sprintf(cond, placeholders...))
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+func ruleFilter(metadata map[string]string) (*filter, error) {
+	f := &filter{}
+	if len(metadata) > 0 {
+		data, err := jsonValue(metadata)
+		if err != nil {
+			return nil, err
+		}
+		f.add("metadata @> %s::jsonb", data)
+	}
+	return f, nil
+}
+
+func (s *ruleStorage) FindAll(opts storage.FindOpts) ([]types.Rule, error) {
+	f, err := ruleFilter(opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Creator != "" {
+		f.add("creator = %s", opts.Creator)
+	}
+	if opts.SourceTsuruApp != "" {
+		f.add("source->'TsuruApp'->>'AppName' = %s", opts.SourceTsuruApp)
+	}
+	if opts.SourceTsuruJob != "" {
+		f.add("source->'TsuruJob'->>'JobName' = %s", opts.SourceTsuruJob)
+	}
+	if opts.SourceTsuruPool != "" {
+		f.add("source->'TsuruApp'->>'PoolName' = %s", opts.SourceTsuruPool)
+	}
+	if opts.DestinationExternalIP != "" {
+		f.add("destination->'ExternalIP'->>'IP' = ANY(%s)", pq.Array(storage.ExternalIPVariants(opts.DestinationExternalIP)))
+	}
+	if opts.DestinationExternalDNS != "" {
+		f.add("destination->'ExternalDNS'->>'Name' = %s", opts.DestinationExternalDNS)
+	}
+	if opts.DestinationTsuruApp != "" {
+		f.add("destination->'TsuruApp'->>'AppName' = %s", opts.DestinationTsuruApp)
+	}
+	if opts.DestinationTsuruJob != "" {
+		f.add("destination->'TsuruJob'->>'JobName' = %s", opts.DestinationTsuruJob)
+	}
+	if opts.DestinationTsuruPool != "" {
+		f.add("destination->'TsuruApp'->>'PoolName' = %s", opts.DestinationTsuruPool)
+	}
+	if opts.Removed != nil {
+		f.add("removed = %s", *opts.Removed)
+	}
+	if !opts.CreatedSince.IsZero() {
+		f.add("created >= %s", opts.CreatedSince)
+	}
+	if !opts.CreatedUntil.IsZero() {
+		f.add("created < %s", opts.CreatedUntil)
+	}
+	if !opts.ExpiresUntil.IsZero() {
+		f.add("expires_at <= %s", opts.ExpiresUntil)
+	}
+	cmp, order := ">", "ASC"
+	if opts.Sort.Descending() {
+		cmp, order = "<", "DESC"
+	}
+	orderBy := ` ORDER BY id ` + order
+	if opts.Sort.ByCreated() {
+		orderBy = ` ORDER BY created ` + order + `, id ` + order
+		if opts.After != nil {
+			f.add("(created, id) "+cmp+" (%s, %s)", opts.After.Time, opts.After.ID)
+		}
+	} else if opts.After != nil {
+		f.add("id "+cmp+" %s", opts.After.ID)
+	}
+	query := `SELECT ` + ruleColumns + ` FROM acl_rules` + f.where() + orderBy
+	if opts.Limit > 0 {
+		f.args = append(f.args, opts.Limit)
+		query += ` LIMIT $` + strconv.Itoa(len(f.args))
+	}
+	rows, err := s.db.QueryContext(context.TODO(), query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []types.Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *ruleStorage) Delete(opts storage.DeleteOpts) error {
+	f, err := ruleFilter(opts.Metadata)
+	if err != nil {
+		return err
+	}
+	if opts.ID != "" {
+		f.add("id = %s", opts.ID)
+	}
+	f.conds = append(f.conds, "NOT removed")
+	return s.withTx(context.TODO(), func(tx *sql.Tx) error {
+		err := lockRuleEvents(tx)
+		if err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(context.TODO(), `UPDATE acl_rules SET removed = true, removed_at = now()`+f.where()+` RETURNING `+ruleColumns, f.args...)
+		if err != nil {
+			return err
+		}
+		var events []types.RuleEvent
+		for rows.Next() {
+			r, err := scanRule(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			events = append(events, types.RuleEvent{Type: types.RuleEventRemoved, Rule: r})
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return storage.ErrRuleNotFound
+		}
+		return addRuleEvents(tx, events)
+	})
+}
+
+func (s *ruleStorage) Purge(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_rules WHERE id = ANY($1) AND removed`, pq.Array(ids))
+	return err
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const (
+	// ruleEventsLockID is the advisory lock key held by transactions
+	// changing rules, it makes revisions visible in the order they are
+	// assigned so watches never skip an event.
+	ruleEventsLockID = 0x61636c6576
+
+	// maxEvents is the number of rule events kept for watches to resume
+	// from.
+	maxEvents = 10000
+
+	watchBatchSize = 100
+)
+
+// watchPollInterval is how often watches look for new events.
+var watchPollInterval = time.Second
+
+var _ storage.RuleWatch = &ruleWatch{}
+
+func lockRuleEvents(tx *sql.Tx) error {
+	_, err := tx.ExecContext(context.TODO(), `SELECT pg_advisory_xact_lock($1)`, ruleEventsLockID)
+	return err
+}
+
+// addRuleEvents records events and discards the ones older than maxEvents,
+// it must be called after lockRuleEvents.
+func addRuleEvents(tx *sql.Tx, events []types.RuleEvent) error {
+	var revision int64
+	for _, e := range events {
+		data, err := jsonValue(e.Rule)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(context.TODO(), `INSERT INTO acl_rule_events (type, rule)
+			VALUES ($1, $2::jsonb) RETURNING revision`, string(e.Type), data).Scan(&revision)
+		if err != nil {
+			return err
+		}
+	}
+	if revision <= maxEvents {
+		return nil
+	}
+	_, err := tx.ExecContext(context.TODO(), `DELETE FROM acl_rule_events WHERE revision <= $1`, revision-maxEvents)
+	return err
+}
+
+func (s *ruleStorage) latestRevision(ctx context.Context) (int64, error) {
+	var revision int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(revision), 0) FROM acl_rule_events`).Scan(&revision)
+	return revision, err
+}
+
+func (s *ruleStorage) Watch(opts storage.FindOpts, revision string) (storage.RuleWatch, error) {
+	latest, err := s.latestRevision(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	w := &ruleWatch{ruleStorage: s, opts: opts, revision: latest, fetched: latest}
+	if revision == "" {
+		return w, nil
+	}
+	rev, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil || rev < 0 || rev > latest {
+		return nil, storage.ErrInvalidRevision
+	}
+	if rev < latest-maxEvents {
+		return nil, storage.ErrRevisionExpired
+	}
+	w.revision = rev
+	w.fetched = rev
+	return w, nil
+}
+
+type watchedEvent struct {
+	revision int64
+	event    types.RuleEvent
+}
+
+type ruleWatch struct {
+	*ruleStorage
+	opts     storage.FindOpts
+	revision int64
+	fetched  int64
+	pending  []watchedEvent
+}
+
+func (w *ruleWatch) Next(ctx context.Context) (types.RuleEvent, error) {
+	for {
+		for len(w.pending) > 0 {
+			e := w.pending[0]
+			w.pending = w.pending[1:]
+			w.revision = e.revision
+			if w.opts.Matches(e.event.Rule) {
+				return e.event, nil
+			}
+		}
+		err := w.fetch(ctx)
+		if err != nil {
+			return types.RuleEvent{}, err
+		}
+		if len(w.pending) > 0 {
+			continue
+		}
+		select {
+		case <-time.After(watchPollInterval):
+		case <-ctx.Done():
+			return types.RuleEvent{}, ctx.Err()
+		}
+	}
+}
+
+func (w *ruleWatch) fetch(ctx context.Context) error {
+	latest, err := w.latestRevision(ctx)
+	if err != nil {
+		return err
+	}
+	if w.fetched < latest-maxEvents {
+		return storage.ErrRevisionExpired
+	}
+	rows, err := w.db.QueryContext(ctx, `SELECT revision, type, rule FROM acl_rule_events
+		WHERE revision > $1 ORDER BY revision LIMIT $2`, w.fetched, watchBatchSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			e         watchedEvent
+			eventType string
+			data      []byte
+		)
+		err = rows.Scan(&e.revision, &eventType, &data)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &e.event.Rule)
+		if err != nil {
+			return err
+		}
+		storage.NormalizeRule(&e.event.Rule)
+		e.event.Rule.Created = e.event.Rule.Created.UTC()
+		e.event.Type = types.RuleEventType(eventType)
+		e.event.Revision = strconv.FormatInt(e.revision, 10)
+		w.pending = append(w.pending, e)
+		w.fetched = e.revision
+	}
+	return rows.Err()
+}
+
+func (w *ruleWatch) Revision() string {
+	return strconv.FormatInt(w.revision, 10)
+}
+
+func (w *ruleWatch) Close() error {
+	return nil
+}
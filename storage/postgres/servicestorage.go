@@ -0,0 +1,203 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type serviceStorage struct {
+	*postgresStorage
+}
+
+func decodeInstance(data []byte) (types.ServiceInstance, error) {
+	var instance types.ServiceInstance
+	err := json.Unmarshal(data, &instance)
+	if err != nil {
+		return instance, err
+	}
+	storage.NormalizeServiceInstance(&instance)
+	return instance, nil
+}
+
+// update applies fn to the stored instance while holding its row lock.
+func (s *serviceStorage) update(instanceName string, fn func(instance *types.ServiceInstance)) error {
+	ctx := context.TODO()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		var data []byte
+		err := tx.QueryRowContext(ctx, `SELECT data FROM acl_services WHERE instance_name = $1 FOR UPDATE`, instanceName).Scan(&data)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return storage.ErrInstanceNotFound
+			}
+			return err
+		}
+		instance, err := decodeInstance(data)
+		if err != nil {
+			return err
+		}
+		fn(&instance)
+		value, err := jsonValue(instance)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE acl_services SET data = $1 WHERE instance_name = $2`, value, instanceName)
+		return err
+	})
+}
+
+func (s *serviceStorage) Create(instance types.ServiceInstance) error {
+	data, err := jsonValue(instance)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.TODO(), `INSERT INTO acl_services (instance_name, data) VALUES ($1, $2)`, instance.InstanceName, data)
+	if isUniqueViolation(err) {
+		return storage.ErrInstanceAlreadyExists
+	}
+	return err
+}
+
+func (s *serviceStorage) Find(instanceName string) (types.ServiceInstance, error) {
+	var data []byte
+	err := s.db.QueryRowContext(context.TODO(), `SELECT data FROM acl_services WHERE instance_name = $1`, instanceName).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = storage.ErrInstanceNotFound
+		}
+		return types.ServiceInstance{}, err
+	}
+	return decodeInstance(data)
+}
+
+func (s *serviceStorage) Delete(instanceName string) error {
+	result, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_services WHERE instance_name = $1`, instanceName)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrInstanceNotFound
+	}
+	return nil
+}
+
+func (s *serviceStorage) AddRule(instanceName string, r *types.ServiceRule) error {
+	if r.RuleID == "" {
+		r.RuleID = newID()
+	}
+	r.Created = time.Now().UTC()
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BaseRules = append(instance.BaseRules, *r)
+	})
+}
+
+func (s *serviceStorage) UpdateRule(instanceName string, r types.ServiceRule) error {
+	found := false
+	err := s.update(instanceName, func(instance *types.ServiceInstance) {
+		for i := range instance.BaseRules {
+			if instance.BaseRules[i].RuleID == r.RuleID {
+				instance.BaseRules[i] = r
+				found = true
+			}
+		}
+	})
+	if err == nil && !found {
+		err = storage.ErrRuleNotFound
+	}
+	return err
+}
+
+func (s *serviceStorage) RemoveRule(instanceName string, ruleID string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		rules := []types.ServiceRule{}
+		for _, r := range instance.BaseRules {
+			if r.RuleID != ruleID {
+				rules = append(rules, r)
+			}
+		}
+		instance.BaseRules = rules
+	})
+}
+
+func addToSet(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func pull(values []string, value string) []string {
+	ret := []string{}
+	for _, v := range values {
+		if v != value {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (s *serviceStorage) SetMetadata(instanceName string, metadata map[string]string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.Metadata = metadata
+	})
+}
+
+func (s *serviceStorage) AddApp(instanceName string, appName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindApps = addToSet(instance.BindApps, appName)
+	})
+}
+
+func (s *serviceStorage) RemoveApp(instanceName string, appName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindApps = pull(instance.BindApps, appName)
+	})
+}
+
+func (s *serviceStorage) AddJob(instanceName string, jobName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindJobs = addToSet(instance.BindJobs, jobName)
+	})
+}
+
+func (s *serviceStorage) RemoveJob(instanceName string, jobName string) error {
+	return s.update(instanceName, func(instance *types.ServiceInstance) {
+		instance.BindJobs = pull(instance.BindJobs, jobName)
+	})
+}
+
+func (s *serviceStorage) List() ([]types.ServiceInstance, error) {
+	rows, err := s.db.QueryContext(context.TODO(), `SELECT data FROM acl_services ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var instances []types.ServiceInstance
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		instance, err := decodeInstance(data)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, rows.Err()
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.SyncJobStorage = &syncJobStorage{}
+
+type syncJobStorage struct {
+	*postgresStorage
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+func (s *syncJobStorage) Create(job types.SyncJob) error {
+	return s.withTx(context.TODO(), func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(context.TODO(), `INSERT INTO acl_sync_jobs (id, created, creator, force)
+			VALUES ($1, $2, $3, $4)`, job.JobID, job.Created, job.Creator, job.Force)
+		if err != nil {
+			return err
+		}
+		for i, t := range job.Targets {
+			_, err = tx.ExecContext(context.TODO(), `INSERT INTO acl_sync_job_targets
+				(job_id, position, rule_id, engine, status, error, start_time, end_time)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				job.JobID, i, t.RuleID, t.Engine, t.Status, t.Error, nullTime(t.StartTime), nullTime(t.EndTime))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *syncJobStorage) Find(id string) (types.SyncJob, error) {
+	var job types.SyncJob
+	err := s.db.QueryRowContext(context.TODO(), `SELECT id, created, creator, force FROM acl_sync_jobs WHERE id = $1`, id).
+		Scan(&job.JobID, &job.Created, &job.Creator, &job.Force)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return job, storage.ErrSyncJobNotFound
+		}
+		return job, err
+	}
+	job.Created = job.Created.UTC()
+	rows, err := s.db.QueryContext(context.TODO(), `SELECT rule_id, engine, status, error, start_time, end_time
+		FROM acl_sync_job_targets WHERE job_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return job, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			t                  types.SyncJobTarget
+			startTime, endTime sql.NullTime
+		)
+		err = rows.Scan(&t.RuleID, &t.Engine, &t.Status, &t.Error, &startTime, &endTime)
+		if err != nil {
+			return job, err
+		}
+		t.StartTime = timePtr(startTime)
+		t.EndTime = timePtr(endTime)
+		job.Targets = append(job.Targets, t)
+	}
+	return job, rows.Err()
+}
+
+func (s *syncJobStorage) UpdateTarget(jobID string, target types.SyncJobTarget) error {
+	result, err := s.db.ExecContext(context.TODO(), `UPDATE acl_sync_job_targets SET
+			status = $1,
+			error = $2,
+			start_time = $3,
+			end_time = $4
+		WHERE job_id = $5 AND rule_id = $6 AND engine = $7`,
+		target.Status, target.Error, nullTime(target.StartTime), nullTime(target.EndTime), jobID, target.RuleID, target.Engine)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var exists bool
+		err = s.db.QueryRowContext(context.TODO(), `SELECT EXISTS (SELECT 1 FROM acl_sync_jobs WHERE id = $1)`, jobID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return storage.ErrSyncJobNotFound
+		}
+	}
+	return nil
+}
+
+func (s *syncJobStorage) Purge(before time.Time) error {
+	_, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_sync_jobs WHERE created < $1`, before)
+	return err
+}
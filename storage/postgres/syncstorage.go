@@ -0,0 +1,201 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var lockExpireTime = 5 * time.Minute
+
+const (
+	syncColumns     = `id, rule_id, engine, start_time, end_time, ping_time, running, syncs, attempts, next_retry_time, drift`
+	maxSyncsPerRule = 10
+)
+
+type syncStorage struct {
+	*postgresStorage
+}
+
+var _ storage.SyncStorage = &syncStorage{}
+
+func (s *syncStorage) SetLockExpireTime(timeout time.Duration) time.Duration {
+	oldLockExpireTime := lockExpireTime
+	lockExpireTime = timeout
+	return oldLockExpireTime
+}
+
+func scanSyncInfo(row rowScanner) (types.RuleSyncInfo, error) {
+	var (
+		info          types.RuleSyncInfo
+		endTime       sql.NullTime
+		nextRetryTime sql.NullTime
+		syncs         []byte
+		drift         []byte
+	)
+	err := row.Scan(&info.SyncID, &info.RuleID, &info.Engine, &info.StartTime, &endTime, &info.PingTime, &info.Running, &syncs, &info.Attempts, &nextRetryTime, &drift)
+	if err != nil {
+		return info, err
+	}
+	info.StartTime = info.StartTime.UTC()
+	info.PingTime = info.PingTime.UTC()
+	if endTime.Valid {
+		info.EndTime = endTime.Time.UTC()
+	}
+	if nextRetryTime.Valid {
+		info.NextRetryTime = nextRetryTime.Time.UTC()
+	}
+	err = json.Unmarshal(syncs, &info.Syncs)
+	if err != nil {
+		return info, err
+	}
+	if len(info.Syncs) == 0 {
+		info.Syncs = nil
+	}
+	if drift != nil {
+		err = json.Unmarshal(drift, &info.Drift)
+		if err != nil {
+			return info, err
+		}
+		info.Drift.CheckTime = info.Drift.CheckTime.UTC()
+	}
+	return info, nil
+}
+
+// StartSync acquires the lock with a single upsert, the conflicting row is
+// locked by PostgreSQL while the availability condition is evaluated so only
+// one of concurrent callers is able to update it.
+func (s *syncStorage) StartSync(after time.Duration, ruleID, engine string, force bool) (time.Duration, *types.RuleSyncInfo, error) {
+	expireTime := lockExpireTime
+	if after > expireTime {
+		expireTime = after
+	}
+	now := time.Now().UTC()
+
+	row := s.db.QueryRowContext(context.TODO(), `INSERT INTO acl_rule_sync AS s (id, rule_id, engine, start_time, ping_time, running)
+		VALUES ($1, $2, $3, $4, $4, true)
+		ON CONFLICT (rule_id, engine) DO UPDATE SET
+			start_time = EXCLUDED.start_time,
+			ping_time = EXCLUDED.ping_time,
+			running = true
+		WHERE $5 OR (NOT s.running AND s.ping_time < $6 AND (s.next_retry_time IS NULL OR s.next_retry_time <= $4)) OR (s.running AND s.ping_time < $7)
+		RETURNING `+syncColumns, newID(), ruleID, engine, now, force, now.Add(-after), now.Add(-expireTime))
+
+	next := after
+	info, err := scanSyncInfo(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = storage.ErrSyncStorageLocked
+			var (
+				pingTime      time.Time
+				nextRetryTime sql.NullTime
+			)
+			s.db.QueryRowContext(context.TODO(), `SELECT ping_time, next_retry_time FROM acl_rule_sync
+				WHERE rule_id = $1 AND engine = $2 AND NOT running`, ruleID, engine).Scan(&pingTime, &nextRetryTime)
+			if !pingTime.IsZero() {
+				next = after - time.Now().UTC().Sub(pingTime)
+				if retry := nextRetryTime.Time.Sub(time.Now().UTC()); nextRetryTime.Valid && retry > next {
+					next = retry
+				}
+			}
+		}
+		return next, nil, err
+	}
+	return next, &info, nil
+}
+
+func (s *syncStorage) PingSyncs(ruleSyncIDs []string) error {
+	_, err := s.db.ExecContext(context.TODO(), `UPDATE acl_rule_sync SET ping_time = $1 WHERE id = ANY($2)`,
+		time.Now().UTC(), pq.Array(ruleSyncIDs))
+	return err
+}
+
+func (s *syncStorage) EndSync(ruleSync types.RuleSyncInfo, syncData types.RuleSyncData) error {
+	data, err := jsonValue([]types.RuleSyncData{syncData})
+	if err != nil {
+		return err
+	}
+	var nextRetryTime sql.NullTime
+	if !ruleSync.NextRetryTime.IsZero() {
+		nextRetryTime = sql.NullTime{Time: ruleSync.NextRetryTime, Valid: true}
+	}
+	_, err = s.db.ExecContext(context.TODO(), `UPDATE acl_rule_sync SET
+			running = false,
+			ping_time = $1,
+			end_time = $1,
+			attempts = $6,
+			next_retry_time = $7,
+			syncs = (
+				SELECT COALESCE(jsonb_agg(e ORDER BY n), '[]')
+				FROM (
+					SELECT e, n FROM jsonb_array_elements(syncs || $2::jsonb) WITH ORDINALITY AS t(e, n)
+					ORDER BY n DESC LIMIT $3
+				) AS latest
+			)
+		WHERE rule_id = $4 AND engine = $5`, time.Now().UTC(), data, maxSyncsPerRule, ruleSync.RuleID, ruleSync.Engine,
+		ruleSync.Attempts, nextRetryTime)
+	return err
+}
+
+func (s *syncStorage) SetDrift(ruleID, engine string, drift types.RuleDrift) error {
+	data, err := jsonValue(drift)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.TODO(), `UPDATE acl_rule_sync SET drift = $1 WHERE rule_id = $2 AND engine = $3`,
+		data, ruleID, engine)
+	return err
+}
+
+func (s *syncStorage) Find(opts storage.SyncFindOpts) ([]types.RuleSyncInfo, error) {
+	f := &filter{}
+	if opts.Engines != nil {
+		f.add("engine = ANY(%s)", pq.Array(opts.Engines))
+	}
+	if opts.RuleIDs != nil {
+		f.add("rule_id = ANY(%s)", pq.Array(opts.RuleIDs))
+	}
+	if opts.Drifted {
+		f.add("(drift->>'Drifted')::boolean")
+	}
+	if opts.After != nil {
+		f.add("(start_time, id) < (%s, %s)", opts.After.Time, opts.After.ID)
+	}
+	query := `SELECT ` + syncColumns + ` FROM acl_rule_sync` + f.where() + ` ORDER BY start_time DESC, id DESC`
+	if opts.Limit > 0 {
+		f.args = append(f.args, opts.Limit)
+		query += ` LIMIT $` + strconv.Itoa(len(f.args))
+	}
+	rows, err := s.db.QueryContext(context.TODO(), query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	syncInfos := []types.RuleSyncInfo{}
+	for rows.Next() {
+		info, err := scanSyncInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		syncInfos = append(syncInfos, info)
+	}
+	return syncInfos, rows.Err()
+}
+
+func (s *syncStorage) Purge(ruleIDs []string) error {
+	if len(ruleIDs) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(context.TODO(), `DELETE FROM acl_rule_sync WHERE rule_id = ANY($1)`, pq.Array(ruleIDs))
+	return err
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var _ storage.WebhookDeliveryStorage = &webhookDeliveryStorage{}
+
+type webhookDeliveryStorage struct {
+	*postgresStorage
+}
+
+func (s *webhookDeliveryStorage) Add(delivery *types.WebhookDelivery) error {
+	delivery.ID = newID()
+	data, err := jsonValue(delivery)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(context.TODO(), `INSERT INTO acl_webhook_deliveries (id, event_id, event_type, url, data)
+		VALUES ($1, $2, $3, $4, $5::jsonb)`, delivery.ID, delivery.EventID, delivery.EventType, delivery.URL, data)
+	return err
+}
+
+func (s *webhookDeliveryStorage) Find(opts storage.WebhookDeliveryFindOpts) ([]types.WebhookDelivery, error) {
+	f := &filter{}
+	if opts.EventID != "" {
+		f.add("event_id = %s", opts.EventID)
+	}
+	if opts.EventType != "" {
+		f.add("event_type = %s", opts.EventType)
+	}
+	if opts.URL != "" {
+		f.add("url = %s", opts.URL)
+	}
+	query := `SELECT data FROM acl_webhook_deliveries` + f.where() + ` ORDER BY id DESC`
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit)
+	}
+	rows, err := s.db.QueryContext(context.TODO(), query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []types.WebhookDelivery{}
+	for rows.Next() {
+		var (
+			data     []byte
+			delivery types.WebhookDelivery
+		)
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &delivery)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
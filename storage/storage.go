@@ -5,7 +5,10 @@
 package storage
 
 import (
+	"context"
 	"net"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,6 +24,12 @@ var (
 	ErrSyncStorageLocked = errors.New("sync already locked")
 
 	ErrACLAPISyncedRuleNotFound = errors.New("aclapi synced rule not found")
+	ErrNetworkLocked            = errors.New("network already locked")
+
+	ErrInvalidRevision = errors.New("invalid watch revision")
+	ErrRevisionExpired = errors.New("watch revision is no longer available")
+
+	ErrSyncJobNotFound = errors.New("sync job not found")
 )
 
 type ServiceStorage interface {
@@ -29,11 +38,13 @@ type ServiceStorage interface {
 	Find(instanceName string) (types.ServiceInstance, error)
 	Delete(instanceName string) error
 	AddRule(instanceName string, r *types.ServiceRule) error
+	UpdateRule(instanceName string, r types.ServiceRule) error
 	RemoveRule(instanceName string, ruleID string) error
 	AddApp(instanceName string, appName string) error
 	RemoveApp(instanceName string, appName string) error
 	AddJob(instanceName string, jobName string) error
 	RemoveJob(instanceName string, jobName string) error
+	SetMetadata(instanceName string, metadata map[string]string) error
 }
 
 type DeleteOpts struct {
@@ -41,17 +52,281 @@ type DeleteOpts struct {
 	Metadata map[string]string
 }
 
+// FindOpts selects the rules returned by RuleStorage.FindAll, empty fields
+// are not used as criteria. Rules are created from CreatedSince, inclusive,
+// until CreatedUntil, exclusive, ExpiresUntil selects rules expiring up to
+// it, inclusive, and Removed selects rules by their removed flag when not
+// nil.
 type FindOpts struct {
 	Metadata map[string]string
 	Creator  string
 
-	SourceTsuruApp string
-	SourceTsuruJob string
+	SourceTsuruApp  string
+	SourceTsuruJob  string
+	SourceTsuruPool string
+
+	DestinationExternalIP  string
+	DestinationExternalDNS string
+	DestinationTsuruApp    string
+	DestinationTsuruJob    string
+	DestinationTsuruPool   string
+
+	Removed      *bool
+	CreatedSince time.Time
+	CreatedUntil time.Time
+	ExpiresUntil time.Time
+
+	// Sort is the order of the rules, by id when empty. After skips the
+	// rules up to the cursor in that order and Limit caps the number of
+	// rules returned, they are used to list rules one page at a time.
+	Sort  RuleSort
+	After *Cursor
+	Limit int
+}
+
+// RuleSort is the order of the rules returned by RuleStorage.FindAll, ties
+// are broken by the rule id.
+type RuleSort string
+
+const (
+	RuleSortID          RuleSort = "id"
+	RuleSortIDDesc      RuleSort = "-id"
+	RuleSortCreated     RuleSort = "created"
+	RuleSortCreatedDesc RuleSort = "-created"
+)
+
+// Valid reports whether s is one of the known orders or empty.
+func (s RuleSort) Valid() bool {
+	switch s {
+	case "", RuleSortID, RuleSortIDDesc, RuleSortCreated, RuleSortCreatedDesc:
+		return true
+	}
+	return false
+}
+
+// Descending reports whether s is a descending order.
+func (s RuleSort) Descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// ByCreated reports whether s orders by creation time.
+func (s RuleSort) ByCreated() bool {
+	return s == RuleSortCreated || s == RuleSortCreatedDesc
+}
+
+// Cursor is the position of the last item of a page in a listing order, the
+// next page starts right after it. Time is only used by orders by time.
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// RuleCursor returns the cursor pointing at r in the order s.
+func RuleCursor(r types.Rule, s RuleSort) *Cursor {
+	c := &Cursor{ID: r.RuleID}
+	if s.ByCreated() {
+		c.Time = r.Created
+	}
+	return c
+}
+
+// SyncCursor returns the cursor pointing at info in the order of
+// SyncStorage.Find.
+func SyncCursor(info types.RuleSyncInfo) *Cursor {
+	return &Cursor{Time: info.StartTime, ID: info.SyncID}
+}
+
+// afterCursor reports whether an item with t and id comes after c, in
+// ascending order or in descending order when desc is set.
+func afterCursor(c *Cursor, t time.Time, id string, desc bool) bool {
+	if c == nil {
+		return true
+	}
+	if !t.Equal(c.Time) {
+		return t.After(c.Time) != desc
+	}
+	if desc {
+		return id < c.ID
+	}
+	return id > c.ID
+}
+
+// ExternalIPVariants returns the ways ip may have been stored, as given, as
+// its canonical network and as a bare address for single host networks.
+func ExternalIPVariants(ip string) []string {
+	ip = strings.TrimSpace(ip)
+	variants := []string{ip}
+	ipNet, err := types.ParseExternalIP(ip)
+	if err != nil {
+		return variants
+	}
+	add := func(v string) {
+		for _, existing := range variants {
+			if existing == v {
+				return
+			}
+		}
+		variants = append(variants, v)
+	}
+	add(ipNet.String())
+	if ones, bits := ipNet.Mask.Size(); ones == bits {
+		add(ipNet.IP.String())
+	}
+	return variants
+}
+
+// Matches reports whether r satisfies every criteria in opts, it must be kept
+// in sync with the queries built by each storage implementation. Limit is
+// not considered.
+func (opts FindOpts) Matches(r types.Rule) bool {
+	for k, v := range opts.Metadata {
+		if r.Metadata[k] != v {
+			return false
+		}
+	}
+	if opts.Creator != "" && r.Creator != opts.Creator {
+		return false
+	}
+	if opts.SourceTsuruApp != "" && (r.Source.TsuruApp == nil || r.Source.TsuruApp.AppName != opts.SourceTsuruApp) {
+		return false
+	}
+	if opts.SourceTsuruJob != "" && (r.Source.TsuruJob == nil || r.Source.TsuruJob.JobName != opts.SourceTsuruJob) {
+		return false
+	}
+	if opts.SourceTsuruPool != "" && (r.Source.TsuruApp == nil || r.Source.TsuruApp.PoolName != opts.SourceTsuruPool) {
+		return false
+	}
+	dst := r.Destination
+	if opts.DestinationExternalIP != "" && (dst.ExternalIP == nil || !contains(ExternalIPVariants(opts.DestinationExternalIP), dst.ExternalIP.IP)) {
+		return false
+	}
+	if opts.DestinationExternalDNS != "" && (dst.ExternalDNS == nil || dst.ExternalDNS.Name != opts.DestinationExternalDNS) {
+		return false
+	}
+	if opts.DestinationTsuruApp != "" && (dst.TsuruApp == nil || dst.TsuruApp.AppName != opts.DestinationTsuruApp) {
+		return false
+	}
+	if opts.DestinationTsuruJob != "" && (dst.TsuruJob == nil || dst.TsuruJob.JobName != opts.DestinationTsuruJob) {
+		return false
+	}
+	if opts.DestinationTsuruPool != "" && (dst.TsuruApp == nil || dst.TsuruApp.PoolName != opts.DestinationTsuruPool) {
+		return false
+	}
+	if opts.Removed != nil && r.Removed != *opts.Removed {
+		return false
+	}
+	if !opts.CreatedSince.IsZero() && r.Created.Before(opts.CreatedSince) {
+		return false
+	}
+	if !opts.CreatedUntil.IsZero() && !r.Created.Before(opts.CreatedUntil) {
+		return false
+	}
+	if !opts.ExpiresUntil.IsZero() && !r.Expired(opts.ExpiresUntil) {
+		return false
+	}
+	if opts.After != nil {
+		var t time.Time
+		if opts.Sort.ByCreated() {
+			t = r.Created
+		}
+		if !afterCursor(opts.After, t, r.RuleID, opts.Sort.Descending()) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortRules sorts rules in the order s.
+func SortRules(rules []types.Rule, s RuleSort) {
+	desc := s.Descending()
+	sort.Slice(rules, func(i, j int) bool {
+		if s.ByCreated() && !rules[i].Created.Equal(rules[j].Created) {
+			return rules[i].Created.Before(rules[j].Created) != desc
+		}
+		return (rules[i].RuleID < rules[j].RuleID) != desc
+	})
+}
+
+// SortSyncs sorts syncs in the order of SyncStorage.Find, newest first.
+func SortSyncs(syncs []types.RuleSyncInfo) {
+	sort.Slice(syncs, func(i, j int) bool {
+		if !syncs[i].StartTime.Equal(syncs[j].StartTime) {
+			return syncs[i].StartTime.After(syncs[j].StartTime)
+		}
+		return syncs[i].SyncID > syncs[j].SyncID
+	})
+}
+
+// Matches reports whether info satisfies every criteria in opts, Limit is
+// not considered.
+func (opts SyncFindOpts) Matches(info types.RuleSyncInfo) bool {
+	if opts.Engines != nil && !contains(opts.Engines, info.Engine) {
+		return false
+	}
+	if opts.RuleIDs != nil && !contains(opts.RuleIDs, info.RuleID) {
+		return false
+	}
+	if opts.Drifted && (info.Drift == nil || !info.Drift.Drifted) {
+		return false
+	}
+	return afterCursor(opts.After, info.StartTime, info.SyncID, true)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeRule replaces nil collections in r with empty ones, which is how
+// rules are decoded from MongoDB. Storages that do not get this behavior from
+// their encoding must call it before returning rules.
+func NormalizeRule(r *types.Rule) {
+	if r.Metadata == nil {
+		r.Metadata = map[string]string{}
+	}
+	normalizeRuleType(&r.Source)
+	normalizeRuleType(&r.Destination)
+}
+
+func normalizeRuleType(rt *types.RuleType) {
+	if rt.ExternalDNS != nil && rt.ExternalDNS.Ports == nil {
+		rt.ExternalDNS.Ports = types.ProtoPorts{}
+	}
+	if rt.ExternalIP != nil && rt.ExternalIP.Ports == nil {
+		rt.ExternalIP.Ports = types.ProtoPorts{}
+	}
 }
 
+// NormalizeServiceInstance is the equivalent of NormalizeRule for service
+// instances.
+func NormalizeServiceInstance(instance *types.ServiceInstance) {
+	if instance.BindApps == nil {
+		instance.BindApps = []string{}
+	}
+	if instance.BindJobs == nil {
+		instance.BindJobs = []string{}
+	}
+	if instance.BaseRules == nil {
+		instance.BaseRules = []types.ServiceRule{}
+	}
+	for i := range instance.BaseRules {
+		NormalizeRule(&instance.BaseRules[i].Rule)
+	}
+}
+
+// SyncFindOpts selects the syncs returned by SyncStorage.Find, newest first
+// with ties broken by the sync id. After skips the syncs up to the cursor.
 type SyncFindOpts struct {
 	RuleIDs []string
 	Engines []string
+	// Drifted restricts the syncs to the ones whose latest drift check found
+	// differences.
+	Drifted bool
+	After   *Cursor
 	Limit   int
 }
 
@@ -61,6 +336,11 @@ type SyncStorage interface {
 	PingSyncs(ruleSyncIDs []string) error
 	EndSync(ruleSync types.RuleSyncInfo, syncData types.RuleSyncData) error
 	SetLockExpireTime(timeout time.Duration) time.Duration
+	// SetDrift stores the result of the latest drift check of a rule in an
+	// engine, rules never synced in the engine are ignored.
+	SetDrift(ruleID, engine string, drift types.RuleDrift) error
+	// Purge permanently deletes the syncs of the rules with the given ids.
+	Purge(ruleIDs []string) error
 }
 
 type RuleStorage interface {
@@ -68,6 +348,60 @@ type RuleStorage interface {
 	Save(rules []*types.Rule, upsert bool) error
 	FindAll(opts FindOpts) ([]types.Rule, error)
 	Delete(opts DeleteOpts) error
+	Watch(opts FindOpts, revision string) (RuleWatch, error)
+	// Purge permanently deletes the removed rules with the given ids, rules
+	// not removed are kept.
+	Purge(ids []string) error
+}
+
+// RuleWatch streams the changes to rules matching the options given to
+// RuleStorage.Watch, starting after the revision given or, when it is empty,
+// after the latest change.
+type RuleWatch interface {
+	// Next blocks until the next event is available or ctx is done.
+	Next(ctx context.Context) (types.RuleEvent, error)
+	// Revision returns the position of the watch, the revision of the last
+	// event returned by Next or the starting position.
+	Revision() string
+	Close() error
+}
+
+// RuleHistoryStorage keeps the changes made to each rule, Add assigns the
+// next version for the rule to change.Version.
+type RuleHistoryStorage interface {
+	Add(change *types.RuleChange) error
+	Find(ruleID string) ([]types.RuleChange, error)
+}
+
+type AuditFindOpts struct {
+	Actor    string
+	RuleID   string
+	Instance string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// AuditStorage keeps the audit log, Add assigns entry.ID and Find returns the
+// newest entries first. Entries are found from Since, inclusive, until Until,
+// exclusive, zero times are not used as bounds.
+type AuditStorage interface {
+	Add(entry *types.AuditEntry) error
+	Find(opts AuditFindOpts) ([]types.AuditEntry, error)
+}
+
+type WebhookDeliveryFindOpts struct {
+	EventID   string
+	EventType types.WebhookEventType
+	URL       string
+	Limit     int
+}
+
+// WebhookDeliveryStorage keeps the log of webhook deliveries, Add assigns
+// delivery.ID and Find returns the newest deliveries first.
+type WebhookDeliveryStorage interface {
+	Add(delivery *types.WebhookDelivery) error
+	Find(opts WebhookDeliveryFindOpts) ([]types.WebhookDelivery, error)
 }
 
 type ACLAPISyncedRule struct {
@@ -84,6 +418,17 @@ type ACLAPIStorage interface {
 	Find(ruleID string) (ACLAPISyncedRule, error)
 	Add(ruleID string, aclIDs []ACLIdPair) error
 	Remove(ruleID string, aclIDs []ACLIdPair) error
+	// FindByACLId returns the ids of the rules holding aclID, the firewall
+	// API returns the same id for identical ACLs.
+	FindByACLId(aclID ACLIdPair) ([]string, error)
+	// Purge permanently deletes the entries of the rules with the given ids.
+	Purge(ruleIDs []string) error
+	// LockNetwork takes the lock of network for owner, shared by every
+	// process using the storage, until it is unlocked or expire passes. It
+	// returns ErrNetworkLocked while another owner holds the lock.
+	LockNetwork(network, owner string, expire time.Duration) error
+	// UnlockNetwork releases the lock of network if owner holds it.
+	UnlockNetwork(network, owner string) error
 }
 
 type StoredIP struct {
@@ -91,6 +436,24 @@ type StoredIP struct {
 	ValidUntil time.Time
 }
 
+// DNSStorage keeps the addresses resolved for ExternalDNS names, Save
+// replaces every address stored for name and Find returns no addresses for
+// names never saved.
+type DNSStorage interface {
+	Find(name string) ([]StoredIP, error)
+	Save(name string, ips []StoredIP) error
+}
+
+// SyncJobStorage keeps the sync jobs, UpdateTarget replaces the target of
+// the job with the same rule and engine.
+type SyncJobStorage interface {
+	Create(job types.SyncJob) error
+	Find(id string) (types.SyncJob, error)
+	UpdateTarget(jobID string, target types.SyncJobTarget) error
+	// Purge permanently deletes the jobs created before the given time.
+	Purge(before time.Time) error
+}
+
 var GetSyncStorage = func() (SyncStorage, error) {
 	return nil, errors.New("no sync storage imported")
 }
@@ -99,6 +462,18 @@ var GetRuleStorage = func() (RuleStorage, error) {
 	return nil, errors.New("no rule storage imported")
 }
 
+var GetRuleHistoryStorage = func() (RuleHistoryStorage, error) {
+	return nil, errors.New("no rule history storage imported")
+}
+
+var GetAuditStorage = func() (AuditStorage, error) {
+	return nil, errors.New("no audit storage imported")
+}
+
+var GetWebhookDeliveryStorage = func() (WebhookDeliveryStorage, error) {
+	return nil, errors.New("no webhook delivery storage imported")
+}
+
 var GetServiceStorage = func() (ServiceStorage, error) {
 	return nil, errors.New("no service storage imported")
 }
@@ -106,3 +481,11 @@ var GetServiceStorage = func() (ServiceStorage, error) {
 var GetACLAPIStorage = func() (ACLAPIStorage, error) {
 	return nil, errors.New("no acl api storage imported")
 }
+
+var GetDNSStorage = func() (DNSStorage, error) {
+	return nil, errors.New("no dns storage imported")
+}
+
+var GetSyncJobStorage = func() (SyncJobStorage, error) {
+	return nil, errors.New("no sync job storage imported")
+}
@@ -5,6 +5,8 @@
 package storagetest
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -85,8 +87,72 @@ func (s *ACLAPIStorageSuite) TestRemove() {
 	assert.Equal(t, expected, dbRule)
 }
 
+func (s *ACLAPIStorageSuite) TestFindByACLId() {
+	t := s.T()
+	err := s.Stor.Add("r2", []storage.ACLIdPair{{ACLRuleID: "ar1", NetworkID: "n1"}})
+	require.NoError(t, err)
+	err = s.Stor.Add("r1", []storage.ACLIdPair{
+		{ACLRuleID: "ar1", NetworkID: "n1"},
+		{ACLRuleID: "ar2", NetworkID: "n1"},
+	})
+	require.NoError(t, err)
+	err = s.Stor.Add("r3", []storage.ACLIdPair{{ACLRuleID: "ar1", NetworkID: "n2"}})
+	require.NoError(t, err)
+	ruleIDs, err := s.Stor.FindByACLId(storage.ACLIdPair{ACLRuleID: "ar1", NetworkID: "n1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r2"}, ruleIDs)
+	err = s.Stor.Remove("r1", []storage.ACLIdPair{{ACLRuleID: "ar1", NetworkID: "n1"}})
+	require.NoError(t, err)
+	ruleIDs, err = s.Stor.FindByACLId(storage.ACLIdPair{ACLRuleID: "ar1", NetworkID: "n1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r2"}, ruleIDs)
+	ruleIDs, err = s.Stor.FindByACLId(storage.ACLIdPair{ACLRuleID: "ar3", NetworkID: "n1"})
+	require.NoError(t, err)
+	assert.Len(t, ruleIDs, 0)
+}
+
 func (s *ACLAPIStorageSuite) TestFind() {
 	t := s.T()
 	_, err := s.Stor.Find("r1")
 	require.Equal(t, storage.ErrACLAPISyncedRuleNotFound, err)
 }
+
+func (s *ACLAPIStorageSuite) TestPurge() {
+	t := s.T()
+	for _, ruleID := range []string{"r1", "r2", "r3"} {
+		err := s.Stor.Add(ruleID, []storage.ACLIdPair{{ACLRuleID: "ar1", NetworkID: "n1"}})
+		require.NoError(t, err)
+	}
+	err := s.Stor.Purge([]string{"r1", "r3", "r4"})
+	require.NoError(t, err)
+	_, err = s.Stor.Find("r1")
+	assert.Equal(t, storage.ErrACLAPISyncedRuleNotFound, err)
+	_, err = s.Stor.Find("r3")
+	assert.Equal(t, storage.ErrACLAPISyncedRuleNotFound, err)
+	_, err = s.Stor.Find("r2")
+	assert.NoError(t, err)
+	err = s.Stor.Purge(nil)
+	assert.NoError(t, err)
+}
+
+func (s *ACLAPIStorageSuite) TestLockNetwork() {
+	t := s.T()
+	err := s.Stor.LockNetwork("n1", "owner1", time.Minute)
+	require.NoError(t, err)
+	err = s.Stor.LockNetwork("n1", "owner2", time.Minute)
+	assert.Equal(t, storage.ErrNetworkLocked, err)
+	err = s.Stor.LockNetwork("n2", "owner2", time.Minute)
+	assert.NoError(t, err)
+	err = s.Stor.LockNetwork("n1", "owner1", time.Minute)
+	assert.NoError(t, err)
+	err = s.Stor.UnlockNetwork("n1", "owner2")
+	require.NoError(t, err)
+	err = s.Stor.LockNetwork("n1", "owner2", time.Minute)
+	assert.Equal(t, storage.ErrNetworkLocked, err)
+	err = s.Stor.UnlockNetwork("n1", "owner1")
+	require.NoError(t, err)
+	err = s.Stor.LockNetwork("n1", "owner2", -time.Second)
+	assert.NoError(t, err)
+	err = s.Stor.LockNetwork("n1", "owner3", time.Minute)
+	assert.NoError(t, err, "expired locks are taken over")
+}
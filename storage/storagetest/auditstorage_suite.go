@@ -0,0 +1,95 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type AuditStorageSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.AuditStorage
+}
+
+func (s *AuditStorageSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *AuditStorageSuite) addEntries() []types.AuditEntry {
+	t := s.T()
+	ts := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	entries := []types.AuditEntry{
+		{Time: ts, Action: types.AuditRuleCreate, Actor: "user1", RuleID: "r1", After: json.RawMessage(`{"RuleID":"r1"}`)},
+		{Time: ts.Add(time.Minute), Action: types.AuditServiceCreate, Actor: "user2", Instance: "i1", EventID: "ev1"},
+		{Time: ts.Add(2 * time.Minute), Action: types.AuditServiceBindApp, Actor: "user2", Instance: "i1", Target: "app1"},
+		{Time: ts.Add(3 * time.Minute), Action: types.AuditRuleDelete, Actor: "user1", RuleID: "r1", RequestID: "req1", Before: json.RawMessage(`{"RuleID":"r1"}`)},
+	}
+	for i := range entries {
+		err := s.Stor.Add(&entries[i])
+		require.Nil(t, err)
+		require.NotEmpty(t, entries[i].ID)
+	}
+	return entries
+}
+
+func auditIDs(entries []types.AuditEntry) []string {
+	var ids []string
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func (s *AuditStorageSuite) TestAddFind() {
+	t := s.T()
+	entries := s.addEntries()
+	found, err := s.Stor.Find(storage.AuditFindOpts{})
+	require.Nil(t, err)
+	require.Len(t, found, 4)
+	assert.Equal(t, []string{entries[3].ID, entries[2].ID, entries[1].ID, entries[0].ID}, auditIDs(found))
+	assert.True(t, entries[3].Time.Equal(found[0].Time))
+	assert.Equal(t, types.AuditRuleDelete, found[0].Action)
+	assert.Equal(t, "user1", found[0].Actor)
+	assert.Equal(t, "r1", found[0].RuleID)
+	assert.Equal(t, "req1", found[0].RequestID)
+	assert.JSONEq(t, `{"RuleID":"r1"}`, string(found[0].Before))
+	assert.Empty(t, found[0].After)
+	assert.Equal(t, "app1", found[1].Target)
+	assert.Equal(t, "ev1", found[2].EventID)
+	assert.JSONEq(t, `{"RuleID":"r1"}`, string(found[3].After))
+}
+
+func (s *AuditStorageSuite) TestFindFilters() {
+	t := s.T()
+	entries := s.addEntries()
+	ts := entries[0].Time
+	tests := []struct {
+		opts     storage.AuditFindOpts
+		expected []string
+	}{
+		{opts: storage.AuditFindOpts{Actor: "user1"}, expected: []string{entries[3].ID, entries[0].ID}},
+		{opts: storage.AuditFindOpts{RuleID: "r1"}, expected: []string{entries[3].ID, entries[0].ID}},
+		{opts: storage.AuditFindOpts{Instance: "i1"}, expected: []string{entries[2].ID, entries[1].ID}},
+		{opts: storage.AuditFindOpts{Since: ts.Add(time.Minute)}, expected: []string{entries[3].ID, entries[2].ID, entries[1].ID}},
+		{opts: storage.AuditFindOpts{Until: ts.Add(time.Minute)}, expected: []string{entries[0].ID}},
+		{opts: storage.AuditFindOpts{Since: ts.Add(time.Minute), Until: ts.Add(3 * time.Minute), Actor: "user2"}, expected: []string{entries[2].ID, entries[1].ID}},
+		{opts: storage.AuditFindOpts{Limit: 1}, expected: []string{entries[3].ID}},
+		{opts: storage.AuditFindOpts{Actor: "user3"}},
+	}
+	for _, tt := range tests {
+		found, err := s.Stor.Find(tt.opts)
+		require.Nil(t, err)
+		assert.NotNil(t, found)
+		assert.Equal(t, tt.expected, auditIDs(found), "%#v", tt.opts)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"net"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type DNSStorageSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.DNSStorage
+}
+
+func (s *DNSStorageSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *DNSStorageSuite) TestSaveFind() {
+	t := s.T()
+	ips, err := s.Stor.Find("a.com")
+	require.Nil(t, err)
+	assert.Empty(t, ips)
+
+	validUntil := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	err = s.Stor.Save("a.com", []storage.StoredIP{
+		{IP: net.ParseIP("10.0.0.1"), ValidUntil: validUntil},
+		{IP: net.ParseIP("2001:db8::1"), ValidUntil: validUntil.Add(time.Minute)},
+	})
+	require.Nil(t, err)
+	err = s.Stor.Save("b.com", []storage.StoredIP{
+		{IP: net.ParseIP("10.0.0.2"), ValidUntil: validUntil},
+	})
+	require.Nil(t, err)
+
+	ips, err = s.Stor.Find("a.com")
+	require.Nil(t, err)
+	require.Len(t, ips, 2)
+	assert.True(t, ips[0].IP.Equal(net.ParseIP("10.0.0.1")))
+	assert.True(t, ips[0].ValidUntil.Equal(validUntil))
+	assert.True(t, ips[1].IP.Equal(net.ParseIP("2001:db8::1")))
+	assert.True(t, ips[1].ValidUntil.Equal(validUntil.Add(time.Minute)))
+
+	err = s.Stor.Save("a.com", []storage.StoredIP{
+		{IP: net.ParseIP("10.0.0.3"), ValidUntil: validUntil},
+	})
+	require.Nil(t, err)
+	ips, err = s.Stor.Find("a.com")
+	require.Nil(t, err)
+	require.Len(t, ips, 1)
+	assert.True(t, ips[0].IP.Equal(net.ParseIP("10.0.0.3")))
+
+	err = s.Stor.Save("a.com", nil)
+	require.Nil(t, err)
+	ips, err = s.Stor.Find("a.com")
+	require.Nil(t, err)
+	assert.Empty(t, ips)
+
+	ips, err = s.Stor.Find("b.com")
+	require.Nil(t, err)
+	require.Len(t, ips, 1)
+	assert.True(t, ips[0].IP.Equal(net.ParseIP("10.0.0.2")))
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type RuleHistoryStorageSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.RuleHistoryStorage
+}
+
+func (s *RuleHistoryStorageSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *RuleHistoryStorageSuite) TestAddFind() {
+	t := s.T()
+	ts := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	change1 := &types.RuleChange{
+		RuleID:        "r1",
+		Time:          ts,
+		User:          "user1@example.com",
+		ChangedFields: []string{"Destination"},
+		PreviousSource: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+		},
+		PreviousDestination: types.RuleType{
+			ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1", Ports: types.ProtoPorts{{Protocol: "TCP", Port: 80}}},
+		},
+	}
+	err := s.Stor.Add(change1)
+	require.Nil(t, err)
+	assert.Equal(t, 1, change1.Version)
+	change2 := &types.RuleChange{
+		RuleID:            "r1",
+		Time:              ts.Add(time.Hour),
+		User:              "user2@example.com",
+		ChangedFields:     []string{"ExpiresAt"},
+		PreviousExpiresAt: &ts,
+	}
+	err = s.Stor.Add(change2)
+	require.Nil(t, err)
+	assert.Equal(t, 2, change2.Version)
+	err = s.Stor.Add(&types.RuleChange{RuleID: "r2", Time: ts, ChangedFields: []string{"Source"}})
+	require.Nil(t, err)
+
+	changes, err := s.Stor.Find("r1")
+	require.Nil(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, *change1, changes[0])
+	assert.Equal(t, 2, changes[1].Version)
+	assert.Equal(t, "user2@example.com", changes[1].User)
+	assert.Equal(t, []string{"ExpiresAt"}, changes[1].ChangedFields)
+	require.NotNil(t, changes[1].PreviousExpiresAt)
+	assert.True(t, ts.Equal(*changes[1].PreviousExpiresAt))
+
+	changes, err = s.Stor.Find("r2")
+	require.Nil(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, 1, changes[0].Version)
+
+	changes, err = s.Stor.Find("r3")
+	require.Nil(t, err)
+	assert.Len(t, changes, 0)
+}
+
+func (s *RuleHistoryStorageSuite) TestAddConcurrent() {
+	t := s.T()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Stor.Add(&types.RuleChange{RuleID: "r1", ChangedFields: []string{"Source"}})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	changes, err := s.Stor.Find("r1")
+	require.Nil(t, err)
+	require.Len(t, changes, 3)
+	for i, c := range changes {
+		assert.Equal(t, i+1, c.Version)
+	}
+}
@@ -5,6 +5,8 @@
 package storagetest
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -192,6 +194,44 @@ func (s *RuleStorageSuite) TestFindSourceTsuruJob() {
 
 }
 
+func (s *RuleStorageSuite) TestFindSourceTsuruPool() {
+	r1 := types.Rule{
+		RuleID: "1",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				PoolName: "pool1",
+			},
+		},
+		Destination: types.RuleType{
+			ExternalDNS: &types.ExternalDNSRule{
+				Name: "x.com",
+			},
+		},
+	}
+	r2 := types.Rule{
+		RuleID: "2",
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{
+				AppName: "app1",
+			},
+		},
+		Destination: types.RuleType{
+			ExternalDNS: &types.ExternalDNSRule{
+				Name: "x.com",
+			},
+		},
+	}
+	err := s.Stor.Save([]*types.Rule{&r1, &r2}, false)
+	require.Nil(s.T(), err)
+	rule, err := s.Stor.FindAll(storage.FindOpts{
+		SourceTsuruPool: "pool1",
+	})
+
+	s.Require().NoError(err)
+	s.Len(rule, 1)
+	s.Equal("1", rule[0].RuleID)
+}
+
 func (s *RuleStorageSuite) TestDelete() {
 	r := types.Rule{
 		RuleID: "1",
@@ -212,6 +252,7 @@ func (s *RuleStorageSuite) TestDelete() {
 	require.Nil(s.T(), err)
 	rule, err := s.Stor.Find("1")
 	require.Nil(s.T(), err)
+	require.NotNil(s.T(), rule.RemovedAt)
 	assert.Equal(s.T(), types.Rule{
 		Removed: true,
 		RuleID:  "1",
@@ -227,8 +268,9 @@ func (s *RuleStorageSuite) TestDelete() {
 				Ports: []types.ProtoPort{},
 			},
 		},
-		Metadata: map[string]string{},
-		Created:  rule.Created,
+		Metadata:  map[string]string{},
+		Created:   rule.Created,
+		RemovedAt: rule.RemovedAt,
 	}, rule)
 }
 
@@ -255,6 +297,7 @@ func (s *RuleStorageSuite) TestDeleteMetadata() {
 	require.Nil(s.T(), err)
 	rule, err := s.Stor.Find("x")
 	require.Nil(s.T(), err)
+	require.NotNil(s.T(), rule.RemovedAt)
 	assert.Equal(s.T(), types.Rule{
 		Removed:  true,
 		RuleID:   "x",
@@ -271,7 +314,8 @@ func (s *RuleStorageSuite) TestDeleteMetadata() {
 				Ports: []types.ProtoPort{},
 			},
 		},
-		Created: rule.Created,
+		Created:   rule.Created,
+		RemovedAt: rule.RemovedAt,
 	}, rule)
 }
 
@@ -302,6 +346,7 @@ func (s *RuleStorageSuite) TestDeleteMetadataMultiple() {
 	require.Nil(s.T(), err)
 	rule, err := s.Stor.Find("x")
 	require.Nil(s.T(), err)
+	require.NotNil(s.T(), rule.RemovedAt)
 	assert.Equal(s.T(), types.Rule{
 		Removed:  true,
 		RuleID:   "x",
@@ -318,7 +363,8 @@ func (s *RuleStorageSuite) TestDeleteMetadataMultiple() {
 				Ports: []types.ProtoPort{},
 			},
 		},
-		Created: rule.Created,
+		Created:   rule.Created,
+		RemovedAt: rule.RemovedAt,
 	}, rule)
 	rule, err = s.Stor.Find("y")
 	require.Nil(s.T(), err)
@@ -334,3 +380,189 @@ func (s *RuleStorageSuite) TestFindNotFound() {
 	_, err := s.Stor.Find("1")
 	require.Equal(s.T(), storage.ErrRuleNotFound, err)
 }
+
+func (s *RuleStorageSuite) TestSaveExpiresAt() {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	rules := []*types.Rule{
+		{
+			RuleID: "1",
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+			},
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app2"},
+			},
+			ExpiresAt: &expiresAt,
+		},
+		{
+			RuleID: "2",
+			Source: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app1"},
+			},
+			Destination: types.RuleType{
+				TsuruApp: &types.TsuruAppRule{AppName: "app3"},
+			},
+		},
+	}
+	err := s.Stor.Save(rules, false)
+	require.Nil(s.T(), err)
+	rule, err := s.Stor.Find("1")
+	require.Nil(s.T(), err)
+	require.NotNil(s.T(), rule.ExpiresAt)
+	assert.True(s.T(), expiresAt.Equal(*rule.ExpiresAt))
+	rule, err = s.Stor.Find("2")
+	require.Nil(s.T(), err)
+	assert.Nil(s.T(), rule.ExpiresAt)
+}
+
+func (s *RuleStorageSuite) TestFindDestination() {
+	rules := []*types.Rule{
+		{RuleID: "1", Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1"}}},
+		{RuleID: "2", Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/24"}}},
+		{RuleID: "3", Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}}},
+		{RuleID: "4", Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1", PoolName: "pool1"}}},
+		{RuleID: "5", Destination: types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}}},
+	}
+	for _, r := range rules {
+		r.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "src"}}
+	}
+	err := s.Stor.Save(rules, false)
+	s.Require().NoError(err)
+	err = s.Stor.Delete(storage.DeleteOpts{ID: "5"})
+	s.Require().NoError(err)
+	removed, notRemoved := true, false
+	tests := []struct {
+		opts     storage.FindOpts
+		expected []string
+	}{
+		{opts: storage.FindOpts{DestinationExternalIP: "10.0.0.1"}, expected: []string{"1"}},
+		{opts: storage.FindOpts{DestinationExternalIP: "10.0.0.1/32"}, expected: []string{"1"}},
+		{opts: storage.FindOpts{DestinationExternalIP: "10.0.0.0/24"}, expected: []string{"2"}},
+		{opts: storage.FindOpts{DestinationExternalIP: "10.0.0.2"}},
+		{opts: storage.FindOpts{DestinationExternalDNS: "a.com"}, expected: []string{"3"}},
+		{opts: storage.FindOpts{DestinationTsuruApp: "app1"}, expected: []string{"4"}},
+		{opts: storage.FindOpts{DestinationTsuruPool: "pool1"}, expected: []string{"4"}},
+		{opts: storage.FindOpts{DestinationTsuruJob: "job1"}, expected: []string{"5"}},
+		{opts: storage.FindOpts{Removed: &removed}, expected: []string{"5"}},
+		{opts: storage.FindOpts{Removed: &notRemoved}, expected: []string{"1", "2", "3", "4"}},
+		{opts: storage.FindOpts{SourceTsuruApp: "src", DestinationTsuruJob: "job1", Removed: &notRemoved}},
+	}
+	for i, tt := range tests {
+		found, err := s.Stor.FindAll(tt.opts)
+		s.Require().NoError(err)
+		var ids []string
+		for _, r := range found {
+			ids = append(ids, r.RuleID)
+		}
+		s.Equal(tt.expected, ids, "test %d", i)
+	}
+}
+
+func (s *RuleStorageSuite) TestFindPage() {
+	newRule := func(id string) *types.Rule {
+		return &types.Rule{
+			RuleID:      id,
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: id + ".com"}},
+		}
+	}
+	err := s.Stor.Save([]*types.Rule{newRule("a"), newRule("c")}, false)
+	s.Require().NoError(err)
+	time.Sleep(10 * time.Millisecond)
+	b := newRule("b")
+	err = s.Stor.Save([]*types.Rule{b}, false)
+	s.Require().NoError(err)
+	time.Sleep(10 * time.Millisecond)
+	err = s.Stor.Save([]*types.Rule{newRule("d")}, false)
+	s.Require().NoError(err)
+
+	tests := []struct {
+		sort     storage.RuleSort
+		expected [][]string
+	}{
+		{sort: "", expected: [][]string{{"a", "b"}, {"c", "d"}, nil}},
+		{sort: storage.RuleSortIDDesc, expected: [][]string{{"d", "c"}, {"b", "a"}, nil}},
+		{sort: storage.RuleSortCreated, expected: [][]string{{"a", "c"}, {"b", "d"}, nil}},
+		{sort: storage.RuleSortCreatedDesc, expected: [][]string{{"d", "b"}, {"c", "a"}, nil}},
+	}
+	for _, tt := range tests {
+		opts := storage.FindOpts{Sort: tt.sort, Limit: 2}
+		for i, expected := range tt.expected {
+			found, err := s.Stor.FindAll(opts)
+			s.Require().NoError(err)
+			var ids []string
+			for _, r := range found {
+				ids = append(ids, r.RuleID)
+			}
+			s.Equal(expected, ids, "sort %q page %d", tt.sort, i)
+			if len(found) > 0 {
+				opts.After = storage.RuleCursor(found[len(found)-1], tt.sort)
+			}
+		}
+	}
+
+	stored, err := s.Stor.Find("b")
+	s.Require().NoError(err)
+	found, err := s.Stor.FindAll(storage.FindOpts{CreatedSince: stored.Created})
+	s.Require().NoError(err)
+	s.Len(found, 2)
+	s.Equal("b", found[0].RuleID)
+	s.Equal("d", found[1].RuleID)
+	found, err = s.Stor.FindAll(storage.FindOpts{CreatedUntil: stored.Created})
+	s.Require().NoError(err)
+	s.Len(found, 2)
+	s.Equal("a", found[0].RuleID)
+	s.Equal("c", found[1].RuleID)
+}
+
+func (s *RuleStorageSuite) TestFindAllExpiresUntil() {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	rules := []*types.Rule{
+		{RuleID: "a", ExpiresAt: &past},
+		{RuleID: "b", ExpiresAt: &now},
+		{RuleID: "c", ExpiresAt: &future},
+		{RuleID: "d"},
+	}
+	for _, r := range rules {
+		r.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}
+		r.Destination = types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.com"}}
+	}
+	err := s.Stor.Save(rules, false)
+	s.Require().NoError(err)
+	found, err := s.Stor.FindAll(storage.FindOpts{ExpiresUntil: now})
+	s.Require().NoError(err)
+	var ids []string
+	for _, r := range found {
+		ids = append(ids, r.RuleID)
+	}
+	s.Equal([]string{"a", "b"}, ids)
+}
+
+func (s *RuleStorageSuite) TestPurge() {
+	var rules []*types.Rule
+	for _, id := range []string{"1", "2", "3"} {
+		rules = append(rules, &types.Rule{
+			RuleID:      id,
+			Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+			Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "x.com"}},
+		})
+	}
+	err := s.Stor.Save(rules, false)
+	s.Require().NoError(err)
+	err = s.Stor.Delete(storage.DeleteOpts{ID: "1"})
+	s.Require().NoError(err)
+	err = s.Stor.Delete(storage.DeleteOpts{ID: "2"})
+	s.Require().NoError(err)
+
+	err = s.Stor.Purge([]string{"1", "3", "4"})
+	s.Require().NoError(err)
+	_, err = s.Stor.Find("1")
+	s.Equal(storage.ErrRuleNotFound, err)
+	_, err = s.Stor.Find("2")
+	s.NoError(err, "removed rules not purged are kept")
+	_, err = s.Stor.Find("3")
+	s.NoError(err, "rules not removed are kept")
+	err = s.Stor.Purge(nil)
+	s.NoError(err)
+}
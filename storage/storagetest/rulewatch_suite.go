@@ -0,0 +1,131 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type RuleWatchSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.RuleStorage
+}
+
+func (s *RuleWatchSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *RuleWatchSuite) next(w storage.RuleWatch) types.RuleEvent {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	event, err := w.Next(ctx)
+	s.Require().NoError(err)
+	return event
+}
+
+func appRule(id, appName string) *types.Rule {
+	return &types.Rule{
+		RuleID: id,
+		Source: types.RuleType{
+			TsuruApp: &types.TsuruAppRule{AppName: appName},
+		},
+		Destination: types.RuleType{
+			ExternalDNS: &types.ExternalDNSRule{Name: "x.com"},
+		},
+		Metadata: map[string]string{"app": appName},
+	}
+}
+
+func (s *RuleWatchSuite) TestWatchEvents() {
+	w, err := s.Stor.Watch(storage.FindOpts{}, "")
+	s.Require().NoError(err)
+	defer w.Close()
+
+	r := appRule("r1", "app1")
+	err = s.Stor.Save([]*types.Rule{r}, false)
+	s.Require().NoError(err)
+	r.Destination.ExternalDNS.Name = "y.com"
+	err = s.Stor.Save([]*types.Rule{r}, true)
+	s.Require().NoError(err)
+	err = s.Stor.Delete(storage.DeleteOpts{ID: "r1"})
+	s.Require().NoError(err)
+
+	created := s.next(w)
+	s.Equal(types.RuleEventCreated, created.Type)
+	s.Equal("r1", created.Rule.RuleID)
+	s.Equal("x.com", created.Rule.Destination.ExternalDNS.Name)
+	s.Equal(map[string]string{"app": "app1"}, created.Rule.Metadata)
+	s.NotEmpty(created.Revision)
+
+	updated := s.next(w)
+	s.Equal(types.RuleEventUpdated, updated.Type)
+	s.Equal("y.com", updated.Rule.Destination.ExternalDNS.Name)
+	s.NotEqual(created.Revision, updated.Revision)
+
+	removed := s.next(w)
+	s.Equal(types.RuleEventRemoved, removed.Type)
+	s.Equal("r1", removed.Rule.RuleID)
+	s.True(removed.Rule.Removed)
+	s.Equal(removed.Revision, w.Revision())
+}
+
+func (s *RuleWatchSuite) TestWatchFilter() {
+	w, err := s.Stor.Watch(storage.FindOpts{SourceTsuruApp: "app2"}, "")
+	s.Require().NoError(err)
+	defer w.Close()
+	metaWatch, err := s.Stor.Watch(storage.FindOpts{Metadata: map[string]string{"app": "app1"}}, "")
+	s.Require().NoError(err)
+	defer metaWatch.Close()
+
+	err = s.Stor.Save([]*types.Rule{appRule("r1", "app1")}, false)
+	s.Require().NoError(err)
+	err = s.Stor.Save([]*types.Rule{appRule("r2", "app2")}, false)
+	s.Require().NoError(err)
+
+	event := s.next(w)
+	s.Equal("r2", event.Rule.RuleID)
+	event = s.next(metaWatch)
+	s.Equal("r1", event.Rule.RuleID)
+}
+
+func (s *RuleWatchSuite) TestWatchResume() {
+	w, err := s.Stor.Watch(storage.FindOpts{}, "")
+	s.Require().NoError(err)
+	defer w.Close()
+
+	err = s.Stor.Save([]*types.Rule{appRule("r1", "app1")}, false)
+	s.Require().NoError(err)
+	err = s.Stor.Save([]*types.Rule{appRule("r2", "app1")}, false)
+	s.Require().NoError(err)
+	first := s.next(w)
+	s.Equal("r1", first.Rule.RuleID)
+
+	resumed, err := s.Stor.Watch(storage.FindOpts{}, first.Revision)
+	s.Require().NoError(err)
+	defer resumed.Close()
+	event := s.next(resumed)
+	s.Equal("r2", event.Rule.RuleID)
+}
+
+func (s *RuleWatchSuite) TestWatchInvalidRevision() {
+	_, err := s.Stor.Watch(storage.FindOpts{}, "not-a-revision")
+	s.Equal(storage.ErrInvalidRevision, err)
+}
+
+func (s *RuleWatchSuite) TestWatchContextDone() {
+	w, err := s.Stor.Watch(storage.FindOpts{}, "")
+	s.Require().NoError(err)
+	defer w.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = w.Next(ctx)
+	s.Error(err)
+}
@@ -107,6 +107,42 @@ func (s *ServiceStorageSuite) TestRemoveRule() {
 	}, dbSi)
 }
 
+func (s *ServiceStorageSuite) TestUpdateRule() {
+	t := s.T()
+	err := s.Stor.Create(types.ServiceInstance{InstanceName: "inst1"})
+	require.Nil(t, err)
+	err = s.Stor.AddRule("inst1", &types.ServiceRule{Rule: types.Rule{RuleID: "rule1"}})
+	require.Nil(t, err)
+	err = s.Stor.AddRule("inst1", &types.ServiceRule{Rule: types.Rule{RuleID: "rule2"}})
+	require.Nil(t, err)
+	dbSi, err := s.Stor.Find("inst1")
+	require.NoError(t, err)
+	updated := dbSi.BaseRules[0]
+	updated.Destination = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}}
+	err = s.Stor.UpdateRule("inst1", updated)
+	require.Nil(t, err)
+	dbSi, err = s.Stor.Find("inst1")
+	require.NoError(t, err)
+	assert.Equal(t, types.ServiceInstance{
+		InstanceName: "inst1",
+		BindApps:     []string{},
+		BindJobs:     []string{},
+		BaseRules: []types.ServiceRule{
+			{Rule: types.Rule{
+				RuleID:      "rule1",
+				Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}},
+				Metadata:    map[string]string{},
+				Created:     dbSi.BaseRules[0].Created,
+			}},
+			{Rule: types.Rule{RuleID: "rule2", Metadata: map[string]string{}, Created: dbSi.BaseRules[1].Created}},
+		},
+	}, dbSi)
+	err = s.Stor.UpdateRule("inst1", types.ServiceRule{Rule: types.Rule{RuleID: "rule3"}})
+	assert.Equal(t, storage.ErrRuleNotFound, err)
+	err = s.Stor.UpdateRule("inst2", updated)
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+}
+
 func (s *ServiceStorageSuite) TestAddApp() {
 	t := s.T()
 	si := types.ServiceInstance{
@@ -131,6 +167,33 @@ func (s *ServiceStorageSuite) TestAddApp() {
 	}, dbSi)
 }
 
+func (s *ServiceStorageSuite) TestSetMetadata() {
+	t := s.T()
+	err := s.Stor.Create(types.ServiceInstance{
+		InstanceName: "inst1",
+		Metadata:     map[string]string{"a": "1"},
+	})
+	require.Nil(t, err)
+	dbSi, err := s.Stor.Find("inst1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, dbSi.Metadata)
+	err = s.Stor.SetMetadata("inst1", map[string]string{"b": "2"})
+	require.Nil(t, err)
+	err = s.Stor.AddApp("inst1", "app1")
+	require.Nil(t, err)
+	dbSi, err = s.Stor.Find("inst1")
+	require.NoError(t, err)
+	assert.Equal(t, types.ServiceInstance{
+		InstanceName: "inst1",
+		BindApps:     []string{"app1"},
+		BindJobs:     []string{},
+		BaseRules:    []types.ServiceRule{},
+		Metadata:     map[string]string{"b": "2"},
+	}, dbSi)
+	err = s.Stor.SetMetadata("inst2", map[string]string{"b": "2"})
+	assert.Equal(t, storage.ErrInstanceNotFound, err)
+}
+
 func (s *ServiceStorageSuite) TestAddJob() {
 	t := s.T()
 	si := types.ServiceInstance{
@@ -0,0 +1,114 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type SyncJobStorageSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.SyncJobStorage
+}
+
+func (s *SyncJobStorageSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *SyncJobStorageSuite) TestCreateFind() {
+	t := s.T()
+	_, err := s.Stor.Find("j1")
+	require.Equal(t, storage.ErrSyncJobNotFound, err)
+
+	created := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	job := types.SyncJob{
+		JobID:   "j1",
+		Created: created,
+		Creator: "admin",
+		Force:   true,
+		Targets: []types.SyncJobTarget{
+			{RuleID: "r1", Engine: "e1", Status: types.SyncJobQueued},
+			{RuleID: "r1", Engine: "e2", Status: types.SyncJobQueued},
+		},
+	}
+	err = s.Stor.Create(job)
+	require.Nil(t, err)
+	err = s.Stor.Create(types.SyncJob{JobID: "j2", Created: created})
+	require.Nil(t, err)
+
+	found, err := s.Stor.Find("j1")
+	require.Nil(t, err)
+	assert.Equal(t, job, found)
+	found, err = s.Stor.Find("j2")
+	require.Nil(t, err)
+	assert.Equal(t, "j2", found.JobID)
+	assert.Empty(t, found.Targets)
+}
+
+func (s *SyncJobStorageSuite) TestUpdateTarget() {
+	t := s.T()
+	created := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	err := s.Stor.Create(types.SyncJob{
+		JobID:   "j1",
+		Created: created,
+		Targets: []types.SyncJobTarget{
+			{RuleID: "r1", Engine: "e1", Status: types.SyncJobQueued},
+			{RuleID: "r1", Engine: "e2", Status: types.SyncJobQueued},
+			{RuleID: "r2", Engine: "e1", Status: types.SyncJobQueued},
+		},
+	})
+	require.Nil(t, err)
+
+	start := created.Add(time.Second)
+	end := created.Add(2 * time.Second)
+	err = s.Stor.UpdateTarget("j1", types.SyncJobTarget{RuleID: "r1", Engine: "e2", Status: types.SyncJobRunning, StartTime: &start})
+	require.Nil(t, err)
+	err = s.Stor.UpdateTarget("j1", types.SyncJobTarget{RuleID: "r2", Engine: "e1", Status: types.SyncJobDone, Error: "timeout", StartTime: &start, EndTime: &end})
+	require.Nil(t, err)
+	err = s.Stor.UpdateTarget("j404", types.SyncJobTarget{RuleID: "r1", Engine: "e1", Status: types.SyncJobDone})
+	require.Equal(t, storage.ErrSyncJobNotFound, err)
+
+	job, err := s.Stor.Find("j1")
+	require.Nil(t, err)
+	require.Len(t, job.Targets, 3)
+	assert.Equal(t, types.SyncJobTarget{RuleID: "r1", Engine: "e1", Status: types.SyncJobQueued}, job.Targets[0])
+	assert.Equal(t, types.SyncJobRunning, job.Targets[1].Status)
+	require.NotNil(t, job.Targets[1].StartTime)
+	assert.True(t, start.Equal(*job.Targets[1].StartTime))
+	assert.Nil(t, job.Targets[1].EndTime)
+	assert.Equal(t, types.SyncJobDone, job.Targets[2].Status)
+	assert.Equal(t, "timeout", job.Targets[2].Error)
+	require.NotNil(t, job.Targets[2].EndTime)
+	assert.True(t, end.Equal(*job.Targets[2].EndTime))
+}
+
+func (s *SyncJobStorageSuite) TestPurge() {
+	t := s.T()
+	created := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	for i, id := range []string{"j1", "j2", "j3"} {
+		err := s.Stor.Create(types.SyncJob{
+			JobID:   id,
+			Created: created.Add(time.Duration(i) * time.Hour),
+			Targets: []types.SyncJobTarget{{RuleID: "r1", Engine: "e1", Status: types.SyncJobDone}},
+		})
+		require.Nil(t, err)
+	}
+	err := s.Stor.Purge(created.Add(time.Hour))
+	require.Nil(t, err)
+	_, err = s.Stor.Find("j1")
+	assert.Equal(t, storage.ErrSyncJobNotFound, err)
+	for _, id := range []string{"j2", "j3"} {
+		job, err := s.Stor.Find(id)
+		require.Nil(t, err)
+		assert.Len(t, job.Targets, 1)
+	}
+}
@@ -86,6 +86,77 @@ func (s *SyncStorageSuite) TestStartSyncNext() {
 	require.Nil(t, err)
 }
 
+func (s *SyncStorageSuite) TestStartSyncRetry() {
+	t := s.T()
+	_, rs, err := s.Stor.StartSync(0, "r1", "e1", false)
+	require.Nil(t, err)
+	retryTime := time.Now().UTC().Add(500 * time.Millisecond).Truncate(time.Millisecond)
+	rs.Attempts = 2
+	rs.NextRetryTime = retryTime
+	err = s.Stor.EndSync(*rs, types.RuleSyncData{Error: "timeout"})
+	require.Nil(t, err)
+	syncs, err := s.Stor.Find(storage.SyncFindOpts{RuleIDs: []string{"r1"}})
+	require.Nil(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, 2, syncs[0].Attempts)
+	assert.True(t, retryTime.Equal(syncs[0].NextRetryTime))
+	next, _, err := s.Stor.StartSync(0, "r1", "e1", false)
+	require.Equal(t, storage.ErrSyncStorageLocked, err)
+	assertDuration(t, 500*time.Millisecond, next)
+	_, rs, err = s.Stor.StartSync(0, "r1", "e1", true)
+	require.Nil(t, err)
+	assert.Equal(t, 2, rs.Attempts)
+	rs.Attempts = 0
+	rs.NextRetryTime = time.Time{}
+	err = s.Stor.EndSync(*rs, types.RuleSyncData{Successful: true})
+	require.Nil(t, err)
+	_, rs, err = s.Stor.StartSync(0, "r1", "e1", false)
+	require.Nil(t, err)
+	assert.Equal(t, 0, rs.Attempts)
+	assert.True(t, rs.NextRetryTime.IsZero())
+}
+
+func (s *SyncStorageSuite) TestSetDrift() {
+	t := s.T()
+	for _, ruleID := range []string{"r1", "r2"} {
+		_, rs, err := s.Stor.StartSync(0, ruleID, "e1", false)
+		require.Nil(t, err)
+		err = s.Stor.EndSync(*rs, types.RuleSyncData{Successful: true})
+		require.Nil(t, err)
+	}
+	checkTime := time.Now().UTC().Truncate(time.Millisecond)
+	drift := types.RuleDrift{CheckTime: checkTime, Drifted: true, Differences: []string{"annotation outdated"}}
+	err := s.Stor.SetDrift("r1", "e1", drift)
+	require.Nil(t, err)
+	err = s.Stor.SetDrift("r2", "e1", types.RuleDrift{CheckTime: checkTime})
+	require.Nil(t, err)
+	err = s.Stor.SetDrift("r3", "e1", drift)
+	require.Nil(t, err)
+
+	syncs, err := s.Stor.Find(storage.SyncFindOpts{Drifted: true})
+	require.Nil(t, err)
+	require.Len(t, syncs, 1)
+	assert.Equal(t, "r1", syncs[0].RuleID)
+	require.NotNil(t, syncs[0].Drift)
+	assert.True(t, checkTime.Equal(syncs[0].Drift.CheckTime))
+	syncs[0].Drift.CheckTime = checkTime
+	assert.Equal(t, drift, *syncs[0].Drift)
+
+	syncs, err = s.Stor.Find(storage.SyncFindOpts{RuleIDs: []string{"r2"}})
+	require.Nil(t, err)
+	require.Len(t, syncs, 1)
+	require.NotNil(t, syncs[0].Drift)
+	assert.False(t, syncs[0].Drift.Drifted)
+
+	_, rs, err := s.Stor.StartSync(0, "r1", "e1", true)
+	require.Nil(t, err)
+	err = s.Stor.EndSync(*rs, types.RuleSyncData{Successful: true})
+	require.Nil(t, err)
+	syncs, err = s.Stor.Find(storage.SyncFindOpts{Drifted: true})
+	require.Nil(t, err)
+	assert.Len(t, syncs, 1)
+}
+
 func (s *SyncStorageSuite) TestStartExpireEndEnd() {
 	t := s.T()
 	defer s.Stor.SetLockExpireTime(s.Stor.SetLockExpireTime(700 * time.Millisecond))
@@ -264,3 +335,46 @@ func (s *SyncStorageSuite) TestEndSyncOnlyLatest10Syncs() {
 	assert.Equal(t, "something-2", ruleSyncs[0].Syncs[0].SyncResult)
 	assert.Equal(t, "something-11", ruleSyncs[0].Syncs[9].SyncResult)
 }
+
+func (s *SyncStorageSuite) TestFindPage() {
+	for _, ruleID := range []string{"r1", "r2", "r3"} {
+		_, ruleSync, err := s.Stor.StartSync(0, ruleID, "e1", false)
+		s.Require().NoError(err)
+		err = s.Stor.EndSync(*ruleSync, types.RuleSyncData{Successful: true})
+		s.Require().NoError(err)
+		time.Sleep(10 * time.Millisecond)
+	}
+	syncs, err := s.Stor.Find(storage.SyncFindOpts{Limit: 2})
+	s.Require().NoError(err)
+	s.Require().Len(syncs, 2)
+	s.Equal("r3", syncs[0].RuleID)
+	s.Equal("r2", syncs[1].RuleID)
+	syncs, err = s.Stor.Find(storage.SyncFindOpts{Limit: 2, After: storage.SyncCursor(syncs[1])})
+	s.Require().NoError(err)
+	s.Require().Len(syncs, 1)
+	s.Equal("r1", syncs[0].RuleID)
+	syncs, err = s.Stor.Find(storage.SyncFindOpts{Limit: 2, After: storage.SyncCursor(syncs[0])})
+	s.Require().NoError(err)
+	s.Empty(syncs)
+}
+
+func (s *SyncStorageSuite) TestPurge() {
+	for _, ruleID := range []string{"r1", "r2"} {
+		for _, engine := range []string{"e1", "e2"} {
+			_, ruleSync, err := s.Stor.StartSync(0, ruleID, engine, false)
+			s.Require().NoError(err)
+			err = s.Stor.EndSync(*ruleSync, types.RuleSyncData{Successful: true})
+			s.Require().NoError(err)
+		}
+	}
+	err := s.Stor.Purge([]string{"r1", "r3"})
+	s.Require().NoError(err)
+	syncs, err := s.Stor.Find(storage.SyncFindOpts{})
+	s.Require().NoError(err)
+	s.Require().Len(syncs, 2)
+	for _, info := range syncs {
+		s.Equal("r2", info.RuleID)
+	}
+	err = s.Stor.Purge(nil)
+	s.NoError(err)
+}
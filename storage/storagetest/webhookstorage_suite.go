@@ -0,0 +1,70 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storagetest
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+)
+
+type WebhookDeliveryStorageSuite struct {
+	suite.Suite
+	SetupTestFunc func()
+	Stor          storage.WebhookDeliveryStorage
+}
+
+func (s *WebhookDeliveryStorageSuite) SetupTest() {
+	s.SetupTestFunc()
+}
+
+func (s *WebhookDeliveryStorageSuite) TestAddFind() {
+	t := s.T()
+	ts := time.Date(2023, 7, 10, 15, 0, 0, 0, time.UTC)
+	deliveries := []types.WebhookDelivery{
+		{EventID: "ev1", EventType: types.WebhookRuleCreated, URL: "http://hook1", Time: ts, Attempts: 1, Successful: true, StatusCode: 200},
+		{EventID: "ev1", EventType: types.WebhookRuleCreated, URL: "http://hook2", Time: ts, Attempts: 3, StatusCode: 500, Error: "invalid status code 500"},
+		{EventID: "ev2", EventType: types.WebhookSyncFailed, URL: "http://hook1", Time: ts.Add(time.Minute), Attempts: 1, Successful: true, StatusCode: 204},
+	}
+	for i := range deliveries {
+		err := s.Stor.Add(&deliveries[i])
+		require.Nil(t, err)
+		require.NotEmpty(t, deliveries[i].ID)
+	}
+
+	found, err := s.Stor.Find(storage.WebhookDeliveryFindOpts{})
+	require.Nil(t, err)
+	require.Len(t, found, 3)
+	assert.Equal(t, deliveries[2].ID, found[0].ID)
+	assert.Equal(t, deliveries[0].ID, found[2].ID)
+	assert.True(t, ts.Equal(found[1].Time))
+	found[1].Time = deliveries[1].Time
+	assert.Equal(t, deliveries[1], found[1])
+
+	tests := []struct {
+		opts     storage.WebhookDeliveryFindOpts
+		expected []string
+	}{
+		{opts: storage.WebhookDeliveryFindOpts{EventID: "ev1"}, expected: []string{deliveries[1].ID, deliveries[0].ID}},
+		{opts: storage.WebhookDeliveryFindOpts{EventType: types.WebhookSyncFailed}, expected: []string{deliveries[2].ID}},
+		{opts: storage.WebhookDeliveryFindOpts{URL: "http://hook1"}, expected: []string{deliveries[2].ID, deliveries[0].ID}},
+		{opts: storage.WebhookDeliveryFindOpts{Limit: 2}, expected: []string{deliveries[2].ID, deliveries[1].ID}},
+		{opts: storage.WebhookDeliveryFindOpts{EventID: "ev3"}},
+	}
+	for _, tt := range tests {
+		found, err = s.Stor.Find(tt.opts)
+		require.Nil(t, err)
+		assert.NotNil(t, found)
+		var ids []string
+		for _, d := range found {
+			ids = append(ids, d.ID)
+		}
+		assert.Equal(t, tt.expected, ids, "%#v", tt.opts)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package syncjob runs rule syncs triggered by requests in the background,
+// tracking the progress of each rule in each engine in storage so any API
+// replica is able to report it.
+package syncjob
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/storage"
+)
+
+var logger = logrus.WithField("source", "syncjob")
+
+func newJobID() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// Start stores a job for the sync of rules in every enabled engine and syncs
+// them in the background, the returned channel is closed once the job is
+// done.
+func Start(rules []types.Rule, force bool, creator string) (types.SyncJob, <-chan struct{}, error) {
+	stor, err := storage.GetSyncJobStorage()
+	if err != nil {
+		return types.SyncJob{}, nil, err
+	}
+	seen := map[string]struct{}{}
+	var unique []types.Rule
+	for _, r := range rules {
+		if _, ok := seen[r.RuleID]; ok {
+			continue
+		}
+		seen[r.RuleID] = struct{}{}
+		unique = append(unique, r)
+	}
+	job := types.SyncJob{
+		JobID:   newJobID(),
+		Created: time.Now().UTC(),
+		Creator: creator,
+		Force:   force,
+		Targets: []types.SyncJobTarget{},
+	}
+	for _, e := range engine.Enabled() {
+		for _, r := range unique {
+			job.Targets = append(job.Targets, types.SyncJobTarget{
+				RuleID: r.RuleID,
+				Engine: e.Name(),
+				Status: types.SyncJobQueued,
+			})
+		}
+	}
+	err = stor.Create(job)
+	if err != nil {
+		return types.SyncJob{}, nil, err
+	}
+	job.Summarize()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		engine.SyncRulesWithListener(unique, force, newTracker(job.JobID, stor))
+	}()
+	return job, done, nil
+}
+
+// Find returns the job with the given id and its current status.
+func Find(id string) (types.SyncJob, error) {
+	stor, err := storage.GetSyncJobStorage()
+	if err != nil {
+		return types.SyncJob{}, err
+	}
+	job, err := stor.Find(id)
+	if err != nil {
+		return job, err
+	}
+	if job.Targets == nil {
+		job.Targets = []types.SyncJobTarget{}
+	}
+	job.Summarize()
+	return job, nil
+}
+
+// Purge deletes the jobs created before the given time.
+func Purge(before time.Time) error {
+	stor, err := storage.GetSyncJobStorage()
+	if err != nil {
+		return err
+	}
+	return stor.Purge(before)
+}
+
+// tracker updates the targets of a job as the engines sync its rules.
+type tracker struct {
+	jobID string
+	stor  storage.SyncJobStorage
+
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+var _ engine.SyncListener = &tracker{}
+
+func newTracker(jobID string, stor storage.SyncJobStorage) *tracker {
+	return &tracker{
+		jobID:   jobID,
+		stor:    stor,
+		started: map[string]time.Time{},
+	}
+}
+
+func (t *tracker) SyncStarted(ruleID, engineName string) {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	t.started[ruleID+"\x00"+engineName] = now
+	t.mu.Unlock()
+	t.update(types.SyncJobTarget{
+		RuleID:    ruleID,
+		Engine:    engineName,
+		Status:    types.SyncJobRunning,
+		StartTime: &now,
+	})
+}
+
+func (t *tracker) SyncEnded(ruleID, engineName string, err error) {
+	now := time.Now().UTC()
+	target := types.SyncJobTarget{
+		RuleID:  ruleID,
+		Engine:  engineName,
+		Status:  types.SyncJobDone,
+		EndTime: &now,
+	}
+	t.mu.Lock()
+	if start, ok := t.started[ruleID+"\x00"+engineName]; ok {
+		target.StartTime = &start
+	}
+	t.mu.Unlock()
+	switch {
+	case err == engine.ErrSyncSkipped:
+		target.Status = types.SyncJobSkipped
+	case err != nil:
+		target.Error = err.Error()
+	}
+	t.update(target)
+}
+
+func (t *tracker) update(target types.SyncJobTarget) {
+	err := t.stor.UpdateTarget(t.jobID, target)
+	if err != nil {
+		logger.WithField("jobid", t.jobID).Errorf("unable to update sync job target %s/%s: %v", target.RuleID, target.Engine, err)
+	}
+}
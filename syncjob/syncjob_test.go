@@ -0,0 +1,105 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package syncjob
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/engine"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-syncjob")
+	engine.EnableEngine(func() engine.Engine {
+		return &fakeEngine{}
+	})
+}
+
+type fakeEngine struct{}
+
+func (e *fakeEngine) Name() string {
+	return "fake"
+}
+
+func (e *fakeEngine) Sync(r types.Rule) (interface{}, error) {
+	if r.RuleID == "bad" {
+		return nil, errors.New("sync failed")
+	}
+	return "ok", nil
+}
+
+func TestStart(t *testing.T) {
+	stor, err := storage.GetSyncJobStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	rules := []types.Rule{{RuleID: "r1"}, {RuleID: "bad"}, {RuleID: "r1"}}
+
+	job, done, err := Start(rules, true, "admin")
+	require.Nil(t, err)
+	assert.NotEmpty(t, job.JobID)
+	assert.Equal(t, "admin", job.Creator)
+	assert.True(t, job.Force)
+	assert.Equal(t, types.SyncJobQueued, job.Status)
+	require.Len(t, job.Targets, 2)
+	<-done
+
+	job, err = Find(job.JobID)
+	require.Nil(t, err)
+	assert.Equal(t, types.SyncJobDone, job.Status)
+	assert.Equal(t, 1, job.Failed)
+	require.Len(t, job.Targets, 2)
+	for _, target := range job.Targets {
+		assert.Equal(t, "fake", target.Engine)
+		assert.Equal(t, types.SyncJobDone, target.Status)
+		assert.NotNil(t, target.StartTime)
+		assert.NotNil(t, target.EndTime)
+	}
+	assert.Equal(t, "r1", job.Targets[0].RuleID)
+	assert.Empty(t, job.Targets[0].Error)
+	assert.Equal(t, "bad", job.Targets[1].RuleID)
+	assert.Contains(t, job.Targets[1].Error, "sync failed")
+
+	_, err = Find("invalid")
+	assert.Equal(t, storage.ErrSyncJobNotFound, err)
+}
+
+func TestStartSkipped(t *testing.T) {
+	stor, err := storage.GetSyncJobStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	syncStor, err := storage.GetSyncStorage()
+	require.Nil(t, err)
+	_, _, err = syncStor.StartSync(0, "busy", "fake", false)
+	require.Nil(t, err)
+
+	job, done, err := Start([]types.Rule{{RuleID: "busy"}}, false, "admin")
+	require.Nil(t, err)
+	<-done
+
+	job, err = Find(job.JobID)
+	require.Nil(t, err)
+	assert.Equal(t, types.SyncJobDone, job.Status)
+	assert.Equal(t, 0, job.Failed)
+	require.Len(t, job.Targets, 1)
+	assert.Equal(t, types.SyncJobSkipped, job.Targets[0].Status)
+	assert.Empty(t, job.Targets[0].Error)
+}
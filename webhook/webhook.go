@@ -0,0 +1,208 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook notifies external systems about rule changes and sync
+// failures. Webhooks are configured in the webhooks setting, each event is
+// delivered in background, retried with exponential backoff and the outcome
+// is stored in the delivery log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/external"
+	"github.com/tsuru/acl-api/storage"
+)
+
+const (
+	EventHeader     = "X-Acl-Api-Event"
+	DeliveryHeader  = "X-Acl-Api-Delivery"
+	SignatureHeader = "X-Acl-Api-Signature"
+
+	defaultMaxAttempts = 5
+	maxRetryInterval   = time.Minute
+)
+
+var (
+	logger = logrus.WithField("source", "webhook")
+
+	pending sync.WaitGroup
+)
+
+// Hook is a webhook configuration, it receives every event when Events is
+// empty.
+type Hook struct {
+	URL    string
+	Secret string
+	Events []types.WebhookEventType
+}
+
+func (h Hook) accepts(eventType types.WebhookEventType) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func configuredHooks() ([]Hook, error) {
+	var hooks []Hook
+	err := viper.UnmarshalKey("webhooks", &hooks)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid webhooks config")
+	}
+	return hooks, nil
+}
+
+// Notify sends event to every webhook interested in its type, it returns
+// without waiting for the deliveries.
+func Notify(event types.WebhookEvent) {
+	hooks, err := configuredHooks()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("unable to encode event %v: %v", event.Type, err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hook.accepts(event.Type) {
+			continue
+		}
+		pending.Add(1)
+		go func(hook Hook) {
+			defer pending.Done()
+			deliver(hook, event, body)
+		}(hook)
+	}
+}
+
+// Wait blocks until all the deliveries in progress are finished.
+func Wait() {
+	pending.Wait()
+}
+
+// Sign returns the value of the signature header for body, the hex encoded
+// HMAC-SHA256 of the body using the webhook secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() string {
+	var buf [16]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func deliver(hook Hook, event types.WebhookEvent, body []byte) types.WebhookDelivery {
+	log := logger.WithFields(logrus.Fields{"url": hook.URL, "event": event.Type, "eventid": event.ID})
+	delivery := types.WebhookDelivery{
+		EventID:   event.ID,
+		EventType: event.Type,
+		URL:       hook.URL,
+	}
+	err := send(hook, event, body, &delivery)
+	delivery.Time = time.Now().UTC()
+	delivery.Successful = err == nil
+	if err != nil {
+		delivery.Error = err.Error()
+		log.Errorf("unable to deliver webhook after %d attempts: %v", delivery.Attempts, err)
+	}
+	stor, err := storage.GetWebhookDeliveryStorage()
+	if err == nil {
+		err = stor.Add(&delivery)
+	}
+	if err != nil {
+		log.Errorf("unable to store webhook delivery: %v", err)
+	}
+	return delivery
+}
+
+// send posts body to the webhook until it succeeds, a non retryable error is
+// returned or webhook.max_attempts is reached.
+func send(hook Hook, event types.WebhookEvent, body []byte, delivery *types.WebhookDelivery) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid webhook url")
+	}
+	cli := &external.BaseHTTPClient{
+		URL:     u.Scheme + "://" + u.Host,
+		Timeout: viper.GetDuration("webhook.timeout"),
+		Logger:  logger,
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		EventHeader:    string(event.Type),
+		DeliveryHeader: event.ID,
+	}
+	if hook.Secret != "" {
+		headers[SignatureHeader] = Sign(hook.Secret, body)
+	}
+	maxAttempts := viper.GetInt("webhook.max_attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	interval := viper.GetDuration("webhook.retry_interval")
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		delivery.Attempts++
+		delivery.StatusCode = 0
+		var rsp *http.Response
+		rsp, err = cli.DoRequestCtx(context.TODO(), http.MethodPost, u.RequestURI(), bytes.NewReader(body), headers)
+		if err == nil {
+			delivery.StatusCode = rsp.StatusCode
+			io.Copy(ioutil.Discard, rsp.Body)
+			rsp.Body.Close()
+			return nil
+		}
+		httpErr, isHTTPErr := errors.Cause(err).(*external.HTTPError)
+		if isHTTPErr {
+			delivery.StatusCode = httpErr.StatusCode
+		}
+		retryable := !isHTTPErr || httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+		if !retryable || delivery.Attempts >= maxAttempts {
+			return err
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
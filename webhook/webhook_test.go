@@ -0,0 +1,170 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/storage"
+	_ "github.com/tsuru/acl-api/storage/memory"
+	_ "github.com/tsuru/acl-api/storage/mongodb"
+)
+
+func init() {
+	viper.AutomaticEnv()
+	storagePath := viper.GetString("storage")
+	if storagePath == "" {
+		storagePath = "mongodb://localhost"
+	}
+	viper.Set("storage", storagePath+"/acltest-pkg-webhook")
+}
+
+type receiver struct {
+	sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	body, _ := ioutil.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func setup(t *testing.T, hooks []map[string]interface{}) storage.WebhookDeliveryStorage {
+	stor, err := storage.GetWebhookDeliveryStorage()
+	require.Nil(t, err)
+	stor.(interface {
+		ClearAll()
+	}).ClearAll()
+	viper.Set("webhooks", hooks)
+	viper.Set("webhook.retry_interval", time.Millisecond)
+	viper.Set("webhook.max_attempts", 3)
+	t.Cleanup(func() {
+		viper.Set("webhooks", nil)
+	})
+	return stor
+}
+
+func TestNotify(t *testing.T) {
+	rcv := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	stor := setup(t, []map[string]interface{}{
+		{"url": srv.URL + "/hook?team=a", "secret": "s3cret"},
+	})
+
+	Notify(types.WebhookEvent{
+		Type:  types.WebhookRuleCreated,
+		Actor: "me@example.com",
+		Rule:  &types.Rule{RuleID: "r1"},
+	})
+	Wait()
+
+	require.Len(t, rcv.requests, 3)
+	req := rcv.requests[2]
+	assert.Equal(t, "/hook?team=a", req.URL.RequestURI())
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "rule.created", req.Header.Get(EventHeader))
+	assert.Equal(t, Sign("s3cret", rcv.bodies[2]), req.Header.Get(SignatureHeader))
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, req.Header.Get(SignatureHeader))
+	var event types.WebhookEvent
+	err := json.Unmarshal(rcv.bodies[2], &event)
+	require.Nil(t, err)
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, event.ID, req.Header.Get(DeliveryHeader))
+	assert.Equal(t, "me@example.com", event.Actor)
+	assert.Equal(t, "r1", event.Rule.RuleID)
+	assert.WithinDuration(t, time.Now(), event.Time, time.Minute)
+	assert.Equal(t, rcv.bodies[0], rcv.bodies[2])
+
+	deliveries, err := stor.Find(storage.WebhookDeliveryFindOpts{})
+	require.Nil(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, event.ID, deliveries[0].EventID)
+	assert.Equal(t, types.WebhookRuleCreated, deliveries[0].EventType)
+	assert.Equal(t, srv.URL+"/hook?team=a", deliveries[0].URL)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.True(t, deliveries[0].Successful)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+	assert.Empty(t, deliveries[0].Error)
+}
+
+func TestNotifyFailures(t *testing.T) {
+	rcv := &receiver{statuses: []int{
+		http.StatusBadRequest,
+		http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway,
+	}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	stor := setup(t, []map[string]interface{}{
+		{"url": srv.URL + "/not-retried", "events": []string{"sync.failed"}},
+	})
+
+	Notify(types.WebhookEvent{Type: types.WebhookSyncFailed, Engine: "network-policy", Error: "boom"})
+	Wait()
+	require.Len(t, rcv.requests, 1)
+	assert.Empty(t, rcv.requests[0].Header.Get(SignatureHeader))
+
+	viper.Set("webhooks", []map[string]interface{}{{"url": srv.URL + "/retried"}})
+	Notify(types.WebhookEvent{Type: types.WebhookSyncFailed})
+	Wait()
+	require.Len(t, rcv.requests, 4)
+
+	deliveries, err := stor.Find(storage.WebhookDeliveryFindOpts{})
+	require.Nil(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, srv.URL+"/retried", deliveries[0].URL)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.False(t, deliveries[0].Successful)
+	assert.Equal(t, http.StatusBadGateway, deliveries[0].StatusCode)
+	assert.Contains(t, deliveries[0].Error, "invalid status code 502")
+	assert.Equal(t, srv.URL+"/not-retried", deliveries[1].URL)
+	assert.Equal(t, 1, deliveries[1].Attempts)
+	assert.False(t, deliveries[1].Successful)
+	assert.Equal(t, http.StatusBadRequest, deliveries[1].StatusCode)
+}
+
+func TestNotifyEventFilter(t *testing.T) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	setup(t, []map[string]interface{}{
+		{"url": srv.URL + "/rules", "events": []string{"rule.created", "rule.removed"}},
+		{"url": srv.URL + "/all"},
+	})
+
+	Notify(types.WebhookEvent{Type: types.WebhookServiceBound, Instance: "i1", App: "app1"})
+	Notify(types.WebhookEvent{Type: types.WebhookRuleRemoved})
+	Wait()
+
+	paths := map[string]int{}
+	for _, r := range rcv.requests {
+		paths[r.URL.Path+" "+r.Header.Get(EventHeader)]++
+	}
+	assert.Equal(t, map[string]int{
+		"/all service.bound":  1,
+		"/all rule.removed":   1,
+		"/rules rule.removed": 1,
+	}, paths)
+}